@@ -0,0 +1,374 @@
+// Command migrate moves the fleet data of a machines.json file (MemoryStore)
+// into the Postgres store (STORE_BACKEND=postgres), and back.
+//
+//	migrate -file ./data/machines.json -dry-run   # what would change
+//	migrate -file ./data/machines.json            # upsert, then verify
+//	migrate -file ./data/machines.json -resume    # continue an interrupted run
+//	migrate -file ./data/machines.json -verify    # compare counts and checksums
+//	migrate -export ./data/machines.rollback.json # database -> JSON
+//	migrate -hash-credentials -dry-run            # es_machine credentials left in clear
+//	migrate -hash-credentials -backup ./es_machine.credentials.json
+//
+// The database is configured with the DB_* variables of the server, and the
+// credentials of legacy files are sealed with its CREDENTIAL_KEY (none kept if unset).
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/jmoiron/sqlx"
+)
+
+// migrationState records the progress of a run, for -resume
+type migrationState struct {
+	Source       string         `json:"source"`
+	SourceSHA256 string         `json:"source_sha256"`
+	Done         map[string]int `json:"done"` // Kind -> entities upserted, in migration order
+	Completed    bool           `json:"completed"`
+}
+
+func main() {
+	file := flag.String("file", "./data/machines.json", "PersistenceData file to migrate")
+	dryRun := flag.Bool("dry-run", false, "report what would change, write nothing")
+	resume := flag.Bool("resume", false, "continue the run recorded in the state file")
+	statePath := flag.String("state", "", "progress file (default <file>.migrate-state)")
+	batch := flag.Int("batch", 200, "entities per transaction")
+	verifyOnly := flag.Bool("verify", false, "only compare the file with the database")
+	export := flag.String("export", "", "write the database to this JSON file and exit")
+	force := flag.Bool("force", false, "with -export or -backup, overwrite an existing file")
+	hashCredentials := flag.Bool("hash-credentials", false, "hash the credentials left in es_machine, clear pkey, and exit")
+	backup := flag.String("backup", "", "with -hash-credentials, where to save the rows before the change (required)")
+	flag.Parse()
+
+	if *statePath == "" {
+		*statePath = *file + ".migrate-state"
+	}
+	if *batch <= 0 {
+		*batch = 200
+	}
+
+	db, err := connect()
+	if err != nil {
+		log.Fatalf("database: %v", err)
+	}
+	defer db.Close()
+	store := data.NewDatabaseStore(db)
+
+	if *export != "" {
+		if err := exportFile(store, *export, *force); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		return
+	}
+
+	// Files written before the keys were hashed, and legacy rows, hold the
+	// credentials: seal them with the key of the server
+	var sealer *data.CredentialSealer
+	if secret := os.Getenv("CREDENTIAL_KEY"); secret != "" {
+		if sealer, err = data.NewCredentialSealer(secret); err != nil {
+			log.Fatalf("credentials: %v", err)
+		}
+	} else {
+		log.Println("WARNING: CREDENTIAL_KEY is not set: the credentials of legacy files will not be kept for the reveal")
+	}
+
+	if *hashCredentials {
+		if err := hashKeys(store, sealer, *dryRun, *backup, *force); err != nil {
+			log.Fatalf("hash-credentials: %v", err)
+		}
+		return
+	}
+
+	raw, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("source: %v", err)
+	}
+	sum := sha256.Sum256(raw)
+	sourceSHA := hex.EncodeToString(sum[:])
+	pd, err := data.ReadPersistenceFile(*file, sealer)
+	if err != nil {
+		log.Fatalf("source: %v", err)
+	}
+	log.Printf("Source %s: %d machines, %d gateways, %d subscribers, %d newsletters (sha256 %s)",
+		*file, len(pd.Machines), len(pd.Gateways), len(pd.Subscribers), len(pd.Newsletters), sourceSHA[:12])
+
+	switch {
+	case *verifyOnly:
+		if !verify(store, pd) {
+			os.Exit(1)
+		}
+	case *dryRun:
+		if err := plan(store, pd); err != nil {
+			log.Fatalf("dry-run: %v", err)
+		}
+	default:
+		state := &migrationState{Source: *file, SourceSHA256: sourceSHA, Done: map[string]int{}}
+		if *resume {
+			if state, err = loadState(*statePath, sourceSHA); err != nil {
+				log.Fatalf("resume: %v", err)
+			}
+		}
+		if err := migrate(store, pd, state, *statePath, *batch); err != nil {
+			log.Fatalf("migrate: %v (run again with -resume to continue)", err)
+		}
+		if !verify(store, pd) {
+			os.Exit(1)
+		}
+		state.Completed = true
+		if err := saveState(*statePath, state); err != nil {
+			log.Printf("WARNING: failed to save state: %v", err)
+		}
+	}
+}
+
+func connect() (*sqlx.DB, error) {
+	if os.Getenv("DB_HOST") == "" {
+		return nil, errors.New("DB_HOST is not set")
+	}
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"))
+	return sqlx.Connect("postgres", dsn)
+}
+
+// migrate upserts each kind in batches, recording progress after each one
+func migrate(store *data.DatabaseStore, pd *data.PersistenceData, state *migrationState, statePath string, batch int) error {
+	if err := store.EnsureTableExists(); err != nil {
+		return err
+	}
+
+	machines := data.MachineRecords(pd)
+	gateways := data.SortedGateways(pd)
+	newsletters := data.SortedNewsletters(pd)
+	totals := map[string]int{
+		data.KindMachines:    len(machines),
+		data.KindGateways:    len(gateways),
+		data.KindSubscribers: len(pd.Subscribers),
+		data.KindNewsletters: len(newsletters),
+	}
+
+	for _, kind := range data.MigrationKinds {
+		start := state.Done[kind]
+		if start >= totals[kind] {
+			log.Printf("%s: %d/%d already migrated", kind, start, totals[kind])
+		}
+		for i := start; i < totals[kind]; i += batch {
+			end := min(i+batch, totals[kind])
+			var err error
+			switch kind {
+			case data.KindMachines:
+				err = store.ImportMachines(machines[i:end])
+			case data.KindGateways:
+				err = store.ImportGateways(gateways[i:end])
+			case data.KindSubscribers:
+				err = store.ImportSubscribers(pd.Subscribers[i:end])
+			case data.KindNewsletters:
+				err = store.ImportNewsletters(newsletters[i:end])
+			}
+			if err != nil {
+				return fmt.Errorf("%s %d-%d: %w", kind, i, end, err)
+			}
+			state.Done[kind] = end
+			if err := saveState(statePath, state); err != nil {
+				return err
+			}
+			log.Printf("%s: %d/%d", kind, end, totals[kind])
+		}
+		if kind == data.KindMachines {
+			if err := store.SyncMachineSequence(pd.NextID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hashKeys replaces the credentials left in es_machine (legacy server, rows
+// imported by hand) by their hash and clears the activation keys, in one
+// transaction. The rows are first saved to backup, the only way back.
+func hashKeys(store *data.DatabaseStore, sealer *data.CredentialSealer, dryRun bool, backup string, force bool) error {
+	rows, err := store.ClearCredentials()
+	if err != nil {
+		return err
+	}
+	conflicts, err := store.CredentialKeyConflicts(rows)
+	if err != nil {
+		return err
+	}
+	var hash, keys int
+	for _, r := range rows {
+		if !r.Hashed() {
+			hash++
+		}
+		if r.Pkey != nil && *r.Pkey != "" {
+			keys++
+		}
+		if other, ok := conflicts[r.ID]; ok {
+			log.Printf("Machine %d (%s): its key is already used by machine %d; merge or delete one of them", r.ID, r.NoSerie, other)
+		}
+	}
+	log.Printf("%d credentials to hash, %d activation keys to clear, %d conflicts", hash, keys, len(conflicts))
+	if dryRun || len(rows) == 0 {
+		return nil
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("%d conflicts, nothing changed", len(conflicts))
+	}
+
+	if backup == "" {
+		return errors.New("-backup is required: the change cannot be undone without it")
+	}
+	if _, err := os.Stat(backup); err == nil && !force {
+		return fmt.Errorf("%s exists (use -force to overwrite)", backup)
+	}
+	raw, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Holds the credentials in clear: readable by the owner only
+	if err := os.WriteFile(backup, raw, 0600); err != nil {
+		return err
+	}
+	log.Printf("Saved %d rows to %s", len(rows), backup)
+
+	// sealed_credential is missing from tables of the legacy server
+	if err := store.EnsureTableExists(); err != nil {
+		return err
+	}
+	if err := store.HashCredentialKeys(rows, sealer); err != nil {
+		return fmt.Errorf("%v; nothing changed", err)
+	}
+	log.Printf("Hashed %d credentials, cleared %d activation keys", hash, keys)
+	return nil
+}
+
+// plan compares the file with the database without writing anything
+func plan(store *data.DatabaseStore, pd *data.PersistenceData) error {
+	current := &data.PersistenceData{}
+	exists, err := store.TablesExist()
+	if err != nil {
+		return err
+	}
+	if exists {
+		if current, err = store.Export(); err != nil {
+			return err
+		}
+	} else {
+		log.Println("Tables do not exist yet: they would be created")
+	}
+
+	want, have := data.Digests(pd), data.Digests(current)
+	for _, kind := range data.MigrationKinds {
+		var created, changed, same int
+		for key, d := range want[kind] {
+			switch cur, ok := have[kind][key]; {
+			case !ok:
+				created++
+			case cur != d:
+				changed++
+			default:
+				same++
+			}
+		}
+		log.Printf("%s: %d to insert, %d to update, %d unchanged, %d only in the database",
+			kind, created, changed, same, len(have[kind])-changed-same)
+	}
+	return nil
+}
+
+// verify compares counts and checksums of the file with the database. Entities
+// only in the database (e.g. registered since) are reported but accepted.
+func verify(store *data.DatabaseStore, pd *data.PersistenceData) bool {
+	current, err := store.Export()
+	if err != nil {
+		log.Printf("verify: %v", err)
+		return false
+	}
+
+	want, have := data.Digests(pd), data.Digests(current)
+	ok := true
+	for _, kind := range data.MigrationKinds {
+		matched := make(map[string]string, len(want[kind]))
+		var missing, different int
+		for key, d := range want[kind] {
+			cur, found := have[kind][key]
+			switch {
+			case !found:
+				missing++
+			case cur != d:
+				different++
+			}
+			if found {
+				matched[key] = cur
+			}
+		}
+		fileSum, dbSum := data.SetChecksum(want[kind]), data.SetChecksum(matched)
+		status := "OK"
+		if missing > 0 || different > 0 {
+			status = "MISMATCH"
+			ok = false
+		}
+		log.Printf("verify %s: %s file=%d db=%d missing=%d different=%d extra=%d checksum file=%s db=%s",
+			kind, status, len(want[kind]), len(have[kind]), missing, different, len(have[kind])-len(matched),
+			fileSum[:12], dbSum[:12])
+	}
+	return ok
+}
+
+// exportFile writes the database in the machines.json format, for a rollback
+// to STORE_BACKEND=file
+func exportFile(store *data.DatabaseStore, path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s exists (use -force to overwrite)", path)
+	}
+	pd, err := store.Export()
+	if err != nil {
+		return err
+	}
+	if err := data.WritePersistenceFile(path, pd); err != nil {
+		return err
+	}
+	digests := data.Digests(pd)
+	for _, kind := range data.MigrationKinds {
+		log.Printf("export %s: %d (checksum %s)", kind, len(digests[kind]), data.SetChecksum(digests[kind])[:12])
+	}
+	log.Printf("Wrote %s", path)
+	return nil
+}
+
+func loadState(path, sourceSHA string) (*migrationState, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state migrationState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return nil, err
+	}
+	if state.SourceSHA256 != sourceSHA {
+		return nil, fmt.Errorf("%s was recorded for another version of %s: run without -resume", path, state.Source)
+	}
+	if state.Done == nil {
+		state.Done = map[string]int{}
+	}
+	return &state, nil
+}
+
+func saveState(path string, state *migrationState) error {
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
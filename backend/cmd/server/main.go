@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/essensys-hub/essensys-support-site/backend/internal/api"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/catalog"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/events"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/geo"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/metrics"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/middleware"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
 
 	
 	"fmt"
@@ -24,17 +36,42 @@ func main() {
 	if err := validateSecrets(); err != nil {
 		log.Fatalf("config: %v", err)
 	}
+	if err := validateFrontendURL(); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	credentials, err := newCredentialSealer()
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if credentials == nil {
+		log.Println("WARNING: CREDENTIAL_KEY is not set: machine credentials are not kept and the reveal is disabled")
+	}
 
-	// 1. Init Store (File-based persistence)
-	store := data.NewMemoryStore("./data/machines.json")
+	// 1. Init Stores (File-based persistence, the fleet store is selected below)
+	eventBus := events.NewBus() // Live admin stream, fed by the stores
+	actionStore := data.NewFileActionStore("./data/actions.json")
+	profileStore := data.NewFileCollectionProfileStore("./data/collection_profiles.json")
+	registrationStore := data.NewFileRegistrationStore("./data/registrations.json", envInt("REGISTRATION_PENDING_MAX", 500), credentials)
+	registrationStore.Events = eventBus
+	firmwareStore := data.NewFileFirmwareStore("./data/firmware.json", "./data/firmware", data.FirmwarePolicy{
+		MaxAttempts:     envInt("FIRMWARE_MAX_ATTEMPTS", data.DefaultFirmwarePolicy.MaxAttempts),
+		OfferTimeout:    envDuration("FIRMWARE_OFFER_TIMEOUT", data.DefaultFirmwarePolicy.OfferTimeout),
+		DownloadTimeout: envDuration("FIRMWARE_DOWNLOAD_TIMEOUT", data.DefaultFirmwarePolicy.DownloadTimeout),
+		InstallTimeout:  envDuration("FIRMWARE_INSTALL_TIMEOUT", data.DefaultFirmwarePolicy.InstallTimeout),
+	})
     
 
 
 	// 2. Init Router
+	proxies, err := middleware.ParseTrustedProxies(envListDefault("TRUSTED_PROXIES", "127.0.0.1,::1"))
+	if err != nil {
+		log.Fatalf("config: TRUSTED_PROXIES: %v", err)
+	}
 	r := chi.NewRouter()
-    r.Use(chimiddleware.RealIP) // Must be before Logger to fix IP in logs
+	r.Use(proxies.RealIP) // Must be before Logger to fix IP in logs
 	r.Use(chimiddleware.Logger)
 	r.Use(chimiddleware.Recoverer)
+	r.Use(middleware.HTTPMetricsMiddleware)
 
 	// 1b. Init Postgres (User Store)
 	dbHost := os.Getenv("DB_HOST")
@@ -45,6 +82,14 @@ func main() {
 
     var userStore data.UserStore
     var auditStore data.AuditStore
+    var telemetryStore data.TelemetryStore // Postgres, or ./data/telemetry.json for a single instance
+    var gatewayMetricsStore data.GatewayMetricsStore = data.NewMemoryGatewayMetricsStore() // Idem
+    var presenceStore data.PresenceStore = data.NewMemoryPresenceStore() // Idem
+    var sessionStore data.SessionStore = data.NewMemorySessionStore() // Idem
+    var emailTokenStore data.EmailTokenStore = data.NewMemoryEmailTokenStore() // Idem
+    var twoFactorStore data.TwoFactorStore = data.NewMemoryTwoFactorStore() // Idem
+    var loginThrottleStore data.LoginThrottleStore // Postgres, or ./data/login_throttles.json for a single instance
+    var db *sqlx.DB
 
     // Only connect if DB envs are set (Graceful degradation or Fatal?)
     // For now, let's try to connect if configured.
@@ -52,13 +97,14 @@ func main() {
         dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", 
             dbHost, dbPort, dbUser, dbPass, dbName)
         
-        db, err := sqlx.Connect("postgres", dsn)
+        conn, err := sqlx.Connect("postgres", dsn)
         if err != nil {
              log.Printf("WARNING: Failed to connect to database: %v. User Registration will fail.", err)
              userStore = &data.PostgresUserStore{} // Empty struct or nil handling? 
              auditStore = &data.PostgresAuditStore{}
         } else {
              log.Println("Connected to PostgreSQL")
+             db = conn
              uStore := data.NewPostgresUserStore(db)
              if err := uStore.EnsureTableExists(); err != nil {
                  log.Fatalf("Failed to init user table: %v", err)
@@ -66,32 +112,253 @@ func main() {
              userStore = uStore
 
              aStore := data.NewPostgresAuditStore(db)
+             aStore.Events = eventBus
              if err := aStore.EnsureTableExists(); err != nil {
                  log.Fatalf("Failed to init audit table: %v", err)
              }
              auditStore = aStore
+
+             tStore := data.NewPostgresTelemetryStore(db)
+             if err := tStore.EnsureTableExists(); err != nil {
+                 log.Fatalf("Failed to init telemetry table: %v", err)
+             }
+             telemetryStore = tStore
+
+             gmStore := data.NewPostgresGatewayMetricsStore(db)
+             if err := gmStore.EnsureTableExists(); err != nil {
+                 log.Fatalf("Failed to init gateway metrics table: %v", err)
+             }
+             gatewayMetricsStore = gmStore
+
+             pStore := data.NewPostgresPresenceStore(db)
+             if err := pStore.EnsureTableExists(); err != nil {
+                 log.Fatalf("Failed to init presence table: %v", err)
+             }
+             presenceStore = pStore
+
+             sStore := data.NewPostgresSessionStore(db)
+             if err := sStore.EnsureTableExists(); err != nil {
+                 log.Fatalf("Failed to init session tables: %v", err)
+             }
+             sessionStore = sStore
+
+             etStore := data.NewPostgresEmailTokenStore(db)
+             if err := etStore.EnsureTableExists(); err != nil {
+                 log.Fatalf("Failed to init e-mail token table: %v", err)
+             }
+             emailTokenStore = etStore
+
+             tfStore := data.NewPostgresTwoFactorStore(db)
+             if err := tfStore.EnsureTableExists(); err != nil {
+                 log.Fatalf("Failed to init 2FA tables: %v", err)
+             }
+             twoFactorStore = tfStore
+
+             ltStore := data.NewPostgresLoginThrottleStore(db)
+             if err := ltStore.EnsureTableExists(); err != nil {
+                 log.Fatalf("Failed to init login throttle table: %v", err)
+             }
+             loginThrottleStore = ltStore
         }
     } else {
         log.Println("WARNING: DB configuration missing. User Store disable.")
     }
+    if loginThrottleStore == nil {
+        log.Println("WARNING: Login lockouts kept in ./data/login_throttles.json, not shared with other instances. Configure the database to run several instances.")
+        loginThrottleStore = data.NewFileLoginThrottleStore("./data/login_throttles.json")
+    }
+    var telemetryFile *data.FileTelemetryStore
+    if telemetryStore == nil {
+        telemetryFile = data.NewFileTelemetryStore("./data/telemetry.json", envInt("TELEMETRY_MEMORY_MAX_POINTS", data.DefaultTelemetryMemoryPoints))
+        telemetryStore = telemetryFile
+    }
+
+	// 1c. Geolocation of machine and gateway IPs (admin map)
+	geoResolver := geo.NewResolver(newGeoLocator(), envInt("GEO_WORKERS", 2), envInt("GEO_QUEUE_SIZE", 1000))
+
+	// 1d. Fleet store: STORE_BACKEND=file (./data/machines.json, default) or postgres
+	var store data.Store
+	var memStore *data.MemoryStore // Flushed at shutdown
+	switch backend := os.Getenv("STORE_BACKEND"); backend {
+	case "postgres":
+		// No fallback to the file: machines would be split between two stores
+		if db == nil {
+			log.Fatalf("config: STORE_BACKEND=postgres requires a database connection (DB_HOST)")
+		}
+		dbStore := data.NewDatabaseStore(db)
+		dbStore.Geo = geoResolver
+		dbStore.Events = eventBus
+		if err := dbStore.EnsureTableExists(); err != nil {
+			log.Fatalf("Failed to init machine tables: %v", err)
+		}
+		// Hashing them is an explicit, backed-up step: cmd/migrate -hash-credentials
+		if n, err := dbStore.CountClearCredentials(); err != nil {
+			log.Fatalf("Failed to check machine credentials: %v", err)
+		} else if n > 0 {
+			log.Fatalf("config: es_machine holds the credentials of %d machines in clear; run `go run ./cmd/migrate -hash-credentials -backup <file>` first", n)
+		}
+		store = dbStore
+		log.Println("Fleet store: PostgreSQL")
+	case "", "file":
+		memStore = data.NewMemoryStore("./data/machines.json", data.PersistenceOptions{
+			FlushInterval:  envDuration("STORE_FLUSH_INTERVAL", data.DefaultPersistenceOptions.FlushInterval),
+			Backups:        envInt("STORE_BACKUPS", data.DefaultPersistenceOptions.Backups),
+			BackupInterval: envDuration("STORE_BACKUP_INTERVAL", data.DefaultPersistenceOptions.BackupInterval),
+			Sealer:         credentials,
+		})
+		memStore.Geo = geoResolver
+		memStore.Events = eventBus
+		store = memStore
+		log.Println("Fleet store: ./data/machines.json")
+	default:
+		log.Fatalf("config: invalid STORE_BACKEND=%q (file or postgres)", backend)
+	}
 
 	// 3. API Routes with Auth
 	apiRouter := api.NewRouter(store, userStore, auditStore)
+	apiRouter.ActionStore = actionStore
+	apiRouter.Credentials = credentials
+	apiRouter.TelemetryStore = telemetryStore
+	apiRouter.GatewayMetricsStore = gatewayMetricsStore
+	apiRouter.Presence = data.NewPresenceTracker(store, presenceStore, models.PresencePolicy{
+		MachineInterval: envDuration("PRESENCE_MACHINE_INTERVAL", 10*time.Second),
+		GatewayInterval: envDuration("PRESENCE_GATEWAY_INTERVAL", time.Minute),
+		LateAfter:       envInt("PRESENCE_LATE_AFTER", 3),
+		OfflineAfter:    envInt("PRESENCE_OFFLINE_AFTER", 10),
+	})
+	apiRouter.ProfileStore = profileStore
+	apiRouter.FirmwareStore = firmwareStore
+	apiRouter.RegistrationStore = registrationStore
+	apiRouter.Events = eventBus
+	apiRouter.EventsMaxStreams = envInt("EVENTS_MAX_STREAMS", api.DefaultEventStreams)
+	apiRouter.FirmwareBlockSize = envInt("FIRMWARE_BLOCK_SIZE", api.DefaultFirmwareBlockSize)
+	apiRouter.Sessions = sessionStore
+	apiRouter.AccessTokenTTL = envDuration("ACCESS_TOKEN_TTL", api.DefaultAccessTokenTTL)
+	apiRouter.RefreshTokenTTL = envDuration("REFRESH_TOKEN_TTL", api.DefaultRefreshTokenTTL)
+	apiRouter.EmailTokens = emailTokenStore
+	apiRouter.PasswordResetTTL = envDuration("PASSWORD_RESET_TTL", api.DefaultPasswordResetTTL)
+	resetWindow := envDuration("PASSWORD_RESET_RATE_WINDOW", time.Hour)
+	apiRouter.PasswordResetIPLimit = middleware.NewRateLimiter(envInt("PASSWORD_RESET_RATE_PER_IP", 10), resetWindow)
+	apiRouter.PasswordResetEmailLimit = middleware.NewRateLimiter(envInt("PASSWORD_RESET_RATE_PER_EMAIL", 3), resetWindow)
+	apiRouter.EmailVerificationTTL = envDuration("EMAIL_VERIFICATION_TTL", api.DefaultEmailVerificationTTL)
+	apiRouter.VerificationLimit = middleware.NewRateLimiter(envInt("EMAIL_VERIFICATION_RATE_PER_USER", 3), time.Hour)
+	apiRouter.TwoFactor = twoFactorStore
+	// 2FA: TWO_FACTOR_POLICY=optional|required_privileged
+	apiRouter.TwoFactorPolicy = os.Getenv("TWO_FACTOR_POLICY")
+	switch apiRouter.TwoFactorPolicy {
+	case "":
+		apiRouter.TwoFactorPolicy = models.TwoFactorOptional
+	case models.TwoFactorOptional, models.TwoFactorRequiredPrivileged:
+	default:
+		// A typo must not silently drop the requirement
+		log.Printf("Unknown 2FA policy %q, using %s", apiRouter.TwoFactorPolicy, models.TwoFactorRequiredPrivileged)
+		apiRouter.TwoFactorPolicy = models.TwoFactorRequiredPrivileged
+	}
+	apiRouter.TwoFactorLimit = middleware.NewRateLimiter(envInt("TWO_FACTOR_RATE_PER_USER", 5), 5*time.Minute)
+	log.Printf("2FA policy: %s", apiRouter.TwoFactorPolicy)
+
+	// Failed password logins: lockout per account and per IP, doubled at each new lockout
+	loginWindow := envDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	loginLockout := envDuration("LOGIN_LOCKOUT", 5*time.Minute)
+	loginLockoutMax := envDuration("LOGIN_LOCKOUT_MAX", 24*time.Hour)
+	apiRouter.LoginGuard = middleware.NewLoginGuard(loginThrottleStore,
+		models.LoginThrottlePolicy{MaxFailures: envInt("LOGIN_MAX_FAILURES_PER_ACCOUNT", 5), Window: loginWindow, Lockout: loginLockout, MaxLockout: loginLockoutMax},
+		models.LoginThrottlePolicy{MaxFailures: envInt("LOGIN_MAX_FAILURES_PER_IP", 20), Window: loginWindow, Lockout: loginLockout, MaxLockout: loginLockoutMax})
+
+	// Table d'Echange reference (docs/TableReference.json)
+	catalogPath := os.Getenv("CATALOG_PATH")
+	if catalogPath == "" {
+		catalogPath = "../docs/TableReference.json"
+	}
+	if registry, err := catalog.NewRegistry(catalogPath); err != nil {
+		log.Printf("WARNING: Failed to load catalog %s: %v. Decoding disabled.", catalogPath, err)
+	} else {
+		log.Printf("Loaded catalog %s (version %s, %d keys)", catalogPath, registry.Current().Version, len(registry.Current().Keys))
+		apiRouter.Catalog = registry
+	}
+
+	// Alert rules on presence, gateway metrics and machine values (./data/alerts.json)
+	alertEngine := data.NewAlertEngine(store, data.NewFileAlertStore("./data/alerts.json"), apiRouter.Presence.Policy)
+	alertEngine.Catalog = apiRouter.Catalog
+	alertEngine.Notify = api.SendEmail
+	alertEngine.Recipients = envList("ALERT_EMAILS")
+	if len(alertEngine.Recipients) == 0 {
+		alertEngine.Recipients = envList("ADMIN_EMAILS")
+	}
+	alertEngine.DefaultResolveAfter = envDuration("ALERT_RESOLVE_AFTER", alertEngine.DefaultResolveAfter)
+	alertEngine.FlapWindow = envDuration("ALERT_FLAP_WINDOW", alertEngine.FlapWindow)
+	apiRouter.Alerts = alertEngine
+
+	go data.RunTelemetryRetention(telemetryStore, models.TelemetryRetention{
+		Retention:          envDuration("TELEMETRY_RETENTION", 90*24*time.Hour),
+		RawRetention:       envDuration("TELEMETRY_RAW_RETENTION", 7*24*time.Hour),
+		DownsampleInterval: envDuration("TELEMETRY_DOWNSAMPLE_INTERVAL", time.Hour),
+	}, time.Hour)
+	go data.RunGatewayMetricsRetention(gatewayMetricsStore, models.GatewayMetricsRetention{
+		RawRetention:     envDuration("GATEWAY_METRICS_RAW_RETENTION", 48*time.Hour),
+		FiveMinRetention: envDuration("GATEWAY_METRICS_5M_RETENTION", 30*24*time.Hour),
+		HourlyRetention:  envDuration("GATEWAY_METRICS_1H_RETENTION", 365*24*time.Hour),
+	}, 5*time.Minute)
+	go data.RunPresenceTracker(apiRouter.Presence, envDuration("PRESENCE_SWEEP_INTERVAL", 15*time.Second),
+		envDuration("PRESENCE_RETENTION", 90*24*time.Hour))
+	go data.RunAlertEngine(alertEngine, envDuration("ALERT_EVAL_INTERVAL", 30*time.Second),
+		envDuration("ALERT_RETENTION", 30*24*time.Hour))
+	go data.RunSessionPruner(sessionStore, envDuration("SESSION_RETENTION", 30*24*time.Hour), time.Hour)
+	go data.RunEmailTokenPruner(emailTokenStore, 24*time.Hour, time.Hour)
+	go data.RunLoginThrottlePruner(loginThrottleStore, loginWindow+loginLockoutMax, time.Hour)
 	
+	// Unknown machines: REGISTRATION_POLICY=open|quarantine|closed. Open by
+	// default, as before the policy existed; quarantine is recommended.
+	registrationPolicy := os.Getenv("REGISTRATION_POLICY")
+	if registrationPolicy == "" {
+		registrationPolicy = models.RegistrationOpen
+	}
+	registrationGuard := middleware.NewRegistrationGuard(registrationPolicy, registrationStore,
+		envInt("REGISTRATION_RATE_PER_IP", 5),
+		envInt("REGISTRATION_RATE_GLOBAL", 60),
+		envDuration("REGISTRATION_RATE_WINDOW", time.Hour))
+	registrationGuard.Sealer = credentials
+	log.Printf("Unknown machine registration policy: %s", registrationGuard.Policy)
+
+	// Gateway reports: GATEWAY_AUTH_POLICY=open|quarantine|enforce
+	gatewayPolicy := os.Getenv("GATEWAY_AUTH_POLICY")
+	if gatewayPolicy == "" {
+		gatewayPolicy = models.GatewayAuthQuarantine
+	}
+	gatewayGuard := middleware.NewGatewayGuard(gatewayPolicy,
+		data.NewFileGatewayIdentityStore("./data/gateway_identities.json"),
+		envInt("GATEWAY_QUARANTINE_MAX", 500))
+	apiRouter.GatewayGuard = gatewayGuard
+	apiRouter.GatewayTokenGrace = envDuration("GATEWAY_TOKEN_GRACE", 24*time.Hour)
+	log.Printf("Gateway report policy: %s", gatewayGuard.Policy)
+	// The history of enrolled gateways is keyed by their ID
+	if err := data.AdoptEnrolledGatewayMetrics(gatewayMetricsStore, gatewayGuard.Identities); err != nil {
+		log.Printf("WARNING: Failed to move the metrics history of enrolled gateways: %v", err)
+	}
+
+	// Prometheus scrape endpoint, protected by METRICS_TOKEN (see validateSecrets)
+	apiRouter.RegisterMetrics(metrics.Default)
+	r.With(middleware.MetricsTokenMiddleware(os.Getenv("METRICS_TOKEN"))).Get("/metrics", metrics.Handler().ServeHTTP)
+
 	r.Route("/api", func(r chi.Router) {
         // 1a. IoT Routes - Strict Auth
 		r.Group(func(r chi.Router) {
-            r.Use(middleware.BasicAuthMiddleware(store, true))
+            r.Use(middleware.BasicAuthMiddlewareWithGuard(store, registrationGuard, true))
 		    r.Post("/mystatus", apiRouter.HandleMyStatus)
 		    r.Get("/myactions", apiRouter.HandleMyActions)
+		    r.Post("/done/{guid}", apiRouter.HandleActionDone)
+		    r.Post("/getversioncontent/{index}", apiRouter.HandleGetVersionContent)
+		    r.Post("/endversioncontent", apiRouter.HandleEndVersionContent)
         })
 
         // 1b. IoT Routes - Optional Auth (Public Access allowed)
         r.Group(func(r chi.Router) {
-            r.Use(middleware.BasicAuthMiddleware(store, false))
+            r.Use(middleware.BasicAuthMiddlewareWithGuard(store, registrationGuard, false))
 		    r.Get("/serverinfos", apiRouter.HandleServerInfos)
-		    r.Post("/infos", apiRouter.HandleGatewayInfos)
         })
+
+        // 1c. Gateway reports - Bearer token issued at enrollment
+        r.With(middleware.GatewayAuthMiddleware(gatewayGuard)).Post("/infos", apiRouter.HandleGatewayInfos)
         
         // 3. Newsletter (Public)
         r.Post("/newsletter/subscribe", apiRouter.HandleSubscribe)
@@ -106,7 +373,12 @@ func main() {
             // Email Auth
             r.Post("/auth/register", apiRouter.HandleRegister)
             r.Post("/auth/login", apiRouter.HandleLogin)
-            r.Post("/auth/logout", apiRouter.HandleLogout) // New
+            r.Post("/auth/login/2fa", apiRouter.HandleLoginTwoFactor) // Second step, with the challenge of /auth/login
+            r.Post("/auth/refresh", apiRouter.HandleRefreshToken)
+            r.Post("/auth/logout", apiRouter.HandleLogout) // Revokes the session of the token, even expired
+            r.Post("/auth/password/forgot", apiRouter.HandleForgotPassword)
+            r.Post("/auth/password/reset", apiRouter.HandleResetPassword)
+            r.Post("/auth/verify-email", apiRouter.HandleVerifyEmail)
             
             // Apple OAuth
             r.Get("/auth/apple/login", apiRouter.HandleAppleLogin)
@@ -117,22 +389,98 @@ func main() {
             
             // 3. User Profile Routes (Any Logged In User)
             r.Group(func(r chi.Router) {
-                r.Use(middleware.UserTokenMiddlewareWithStore(userStore))
+                r.Use(middleware.UserTokenMiddlewareWithStore(userStore, sessionStore))
+                r.Post("/auth/logout-all", apiRouter.HandleLogoutAll)
+                r.Get("/auth/sessions", apiRouter.HandleGetSessions)
+                r.Delete("/auth/sessions/{id}", apiRouter.HandleRevokeSession)
                 r.Get("/profile", apiRouter.HandleGetProfile)
                 r.Put("/profile", apiRouter.HandleUpdateProfile) // Edit
                 r.Delete("/profile", apiRouter.HandleDeleteProfile) // Delete
                 r.Get("/profile/export", apiRouter.HandleExportProfile) // Export
-                r.Get("/devices/nearby", apiRouter.HandleGetNearbyDevices)
-                r.Put("/profile/links", apiRouter.HandleUpdateProfileLinks)
+                r.Post("/auth/verify-email/resend", apiRouter.HandleResendVerification)
+                r.Get("/auth/2fa", apiRouter.HandleGetTwoFactor)
+                r.Post("/auth/2fa/setup", apiRouter.HandleSetupTwoFactor)
+                r.Post("/auth/2fa/confirm", apiRouter.HandleConfirmTwoFactor)
+                r.Post("/auth/2fa/disable", apiRouter.HandleDisableTwoFactor)
+                r.Post("/auth/2fa/recovery-codes", apiRouter.HandleRegenerateRecoveryCodes)
+
+                // Devices: verified e-mail only
+                r.Group(func(r chi.Router) {
+                    r.Use(middleware.RequireVerifiedEmail(userStore))
+                    r.Get("/devices/nearby", apiRouter.HandleGetNearbyDevices)
+                    r.Put("/profile/links", apiRouter.HandleUpdateProfileLinks)
+                    r.Get("/catalog", apiRouter.HandleGetCatalog)
+                })
             })
             
             // Protected Admin endpoints
             r.Group(func(r chi.Router) {
-                r.Use(middleware.AdminTokenMiddlewareWithStore(userStore))
+                r.Use(middleware.AdminTokenMiddlewareWithStore(userStore, sessionStore, apiRouter.TwoFactorPolicy))
                 r.Get("/admin/stats", apiRouter.HandleAdminStats)
                 r.Get("/admin/audit", apiRouter.HandleGetAuditLogs) // New
                 r.Get("/admin/machines", apiRouter.HandleAdminMachines)
                 r.Get("/admin/gateways", apiRouter.HandleAdminGateways)
+                r.Get("/admin/machines/export", apiRouter.HandleAdminExportMachines)
+                r.Get("/admin/gateways/export", apiRouter.HandleAdminExportGateways)
+                r.Get("/admin/gateways/metrics", apiRouter.HandleAdminGatewayFleetMetrics)
+                r.Get("/admin/gateways/{hostname}/metrics", apiRouter.HandleAdminGatewayMetrics)
+                r.Get("/admin/gateways/enrollments", apiRouter.HandleAdminGetGatewayIdentities)
+                r.Post("/admin/gateways/enrollments", apiRouter.HandleAdminEnrollGateway)
+                r.Post("/admin/gateways/enrollments/{id}/rotate", apiRouter.HandleAdminRotateGatewayToken)
+                r.Post("/admin/gateways/enrollments/{id}/revoke", apiRouter.HandleAdminRevokeGateway)
+                r.Get("/admin/gateways/quarantine", apiRouter.HandleAdminGetGatewayQuarantine)
+                r.Get("/admin/presence/{kind}/{id}", apiRouter.HandleAdminPresenceHistory)
+                r.Get("/admin/events", apiRouter.HandleAdminEvents) // Server-Sent Events
+
+                // Fleet health alerts
+                r.Get("/admin/alerts", apiRouter.HandleAdminGetAlerts)
+                r.Get("/admin/alerts/rules", apiRouter.HandleAdminGetAlertRules)
+                r.Post("/admin/alerts/rules", apiRouter.HandleAdminCreateAlertRule)
+                r.Put("/admin/alerts/rules/{id}", apiRouter.HandleAdminUpdateAlertRule)
+                r.Delete("/admin/alerts/rules/{id}", apiRouter.HandleAdminDeleteAlertRule)
+                r.Get("/admin/alerts/{id}", apiRouter.HandleAdminGetAlert)
+                r.Post("/admin/alerts/{id}/acknowledge", apiRouter.HandleAdminAcknowledgeAlert)
+                r.Post("/admin/alerts/{id}/resolve", apiRouter.HandleAdminResolveAlert)
+
+                // Machine lifecycle
+                r.Get("/admin/machines/{id}", apiRouter.HandleAdminGetMachine)
+                r.Delete("/admin/machines/{id}", apiRouter.HandleAdminDeleteMachine)
+                r.Post("/admin/machines/{id}/activate", apiRouter.HandleAdminActivateMachine)
+                r.Post("/admin/machines/{id}/deactivate", apiRouter.HandleAdminDeactivateMachine)
+                r.Put("/admin/machines/{id}/serial", apiRouter.HandleAdminRenameMachine)
+                r.Put("/admin/machines/{id}/alarm", apiRouter.HandleAdminSetMachineAlarm)
+                r.Post("/admin/machines/{id}/credentials/reveal", apiRouter.HandleAdminRevealCredentials)
+                r.Get("/admin/registrations", apiRouter.HandleAdminGetRegistrations)
+                r.Post("/admin/registrations/{id}/approve", apiRouter.HandleAdminApproveRegistration)
+                r.Post("/admin/registrations/{id}/reject", apiRouter.HandleAdminRejectRegistration)
+                r.Delete("/admin/registrations/{id}", apiRouter.HandleAdminDeleteRegistration)
+
+                // Legacy Action Queue (GET /api/myactions)
+                r.Get("/admin/machines/{id}/actions", apiRouter.HandleAdminGetMachineActions)
+                r.Post("/admin/machines/{id}/actions", apiRouter.HandleAdminEnqueueMachineAction)
+                r.Delete("/admin/machines/{id}/actions/{guid}", apiRouter.HandleAdminCancelMachineAction)
+                r.Get("/admin/machines/{id}/telemetry", apiRouter.HandleAdminMachineTelemetry)
+                r.Get("/admin/machines/{id}/state", apiRouter.HandleAdminMachineState)
+                r.Post("/admin/catalog/reload", apiRouter.HandleAdminReloadCatalog)
+
+                // Collection Profiles (GET /api/serverinfos "infos")
+                r.Get("/admin/collection-profiles", apiRouter.HandleAdminGetCollectionProfiles)
+                r.Post("/admin/collection-profiles", apiRouter.HandleAdminCreateCollectionProfile)
+                r.Get("/admin/collection-profiles/{id}", apiRouter.HandleAdminGetCollectionProfile)
+                r.Put("/admin/collection-profiles/{id}", apiRouter.HandleAdminUpdateCollectionProfile)
+                r.Delete("/admin/collection-profiles/{id}", apiRouter.HandleAdminDeleteCollectionProfile)
+                r.Get("/admin/machines/{id}/collection-profile", apiRouter.HandleAdminMachineCollection)
+
+                // Firmware releases and rollout campaigns
+                r.Get("/admin/firmware", apiRouter.HandleAdminGetFirmware)
+                r.Post("/admin/firmware", apiRouter.HandleAdminUploadFirmware)
+                r.Delete("/admin/firmware/{version}", apiRouter.HandleAdminDeleteFirmware)
+                r.Get("/admin/firmware/campaigns", apiRouter.HandleAdminGetCampaigns)
+                r.Post("/admin/firmware/campaigns", apiRouter.HandleAdminCreateCampaign)
+                r.Get("/admin/firmware/campaigns/{id}", apiRouter.HandleAdminGetCampaign)
+                r.Put("/admin/firmware/campaigns/{id}", apiRouter.HandleAdminUpdateCampaign)
+                r.Post("/admin/firmware/campaigns/{id}/status", apiRouter.HandleAdminSetCampaignStatus)
+                r.Put("/admin/machines/{id}/tags", apiRouter.HandleAdminSetMachineTags)
                 r.Get("/admin/subscribers", apiRouter.HandleAdminSubscribers)
                 r.Post("/admin/subscribers", apiRouter.HandleAdminAddSubscriber)
                 r.Delete("/admin/subscribers", apiRouter.HandleDeleteSubscriber)
@@ -146,12 +494,19 @@ func main() {
 
                 // User Management
                 r.Get("/admin/users", apiRouter.HandleAdminGetUsers)
+                r.Get("/admin/users/export", apiRouter.HandleAdminExportUsers)
                 r.Post("/admin/users", apiRouter.HandleAdminCreateUser)
                 r.Put("/admin/users/{id}/role", apiRouter.HandleAdminUpdateUserRole)
                 r.Put("/admin/users/{id}/links", apiRouter.HandleAdminUpdateUserLinks)
                 r.Post("/admin/users/{id}/forbid", apiRouter.HandleAdminForbidUser)
                 r.Post("/admin/users/{id}/unforbid", apiRouter.HandleAdminUnforbidUser)
                 r.Delete("/admin/users/{id}", apiRouter.HandleAdminDeleteUser)
+                r.Post("/admin/users/{id}/verification", apiRouter.HandleAdminResendVerification)
+                r.Post("/admin/users/{id}/verify", apiRouter.HandleAdminVerifyUser)
+                r.Post("/admin/users/{id}/2fa/reset", apiRouter.HandleAdminResetTwoFactor)
+                r.Post("/admin/users/{id}/unlock", apiRouter.HandleAdminUnlockUser)
+                r.Get("/admin/lockouts", apiRouter.HandleAdminGetLockouts)
+                r.Delete("/admin/lockouts/ip/{ip}", apiRouter.HandleAdminUnlockIP)
             })
         })
 	})
@@ -161,9 +516,34 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Listening on port %s", port)
-	if err := http.ListenAndServe(":"+port, r); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	srv.RegisterOnShutdown(eventBus.Close) // End the event streams, Shutdown waits for them
+	go func() {
+		log.Printf("Listening on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop on SIGINT/SIGTERM: finish requests, then write pending store changes
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	log.Println("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	if memStore != nil {
+		if err := memStore.Close(); err != nil {
+			log.Printf("Failed to flush ./data/machines.json: %v", err)
+		}
+	}
+	if telemetryFile != nil {
+		if err := telemetryFile.Close(); err != nil {
+			log.Printf("Failed to flush ./data/telemetry.json: %v", err)
+		}
 	}
 }
 
@@ -177,14 +557,16 @@ var insecureSecrets = map[string]bool{
 	"changeme_random_secret":                true,
 	"changeme":                              true,
 	"1234567890":                            true,
+	"change_me_metrics_token":               true, // .env.template
 }
 
-// validateSecrets fails closed: it returns an error when JWT_SECRET or
-// ADMIN_TOKEN is missing, too short, or set to a known-insecure placeholder, so
-// the service never runs with guessable credentials.
+// validateSecrets fails closed: it returns an error when JWT_SECRET,
+// ADMIN_TOKEN or METRICS_TOKEN is missing, too short, or set to a
+// known-insecure placeholder, so the service never runs with guessable
+// credentials nor an open /metrics.
 func validateSecrets() error {
 	const minLen = 16
-	for _, name := range []string{"JWT_SECRET", "ADMIN_TOKEN"} {
+	for _, name := range []string{"JWT_SECRET", "ADMIN_TOKEN", "METRICS_TOKEN"} {
 		v := os.Getenv(name)
 		if insecureSecrets[v] {
 			return fmt.Errorf("%s is missing or set to a known-insecure default; set a strong unique value", name)
@@ -195,3 +577,111 @@ func validateSecrets() error {
 	}
 	return nil
 }
+
+// newCredentialSealer seals the machine credentials kept for the audited
+// reveal with CREDENTIAL_KEY; nil (nothing kept, reveal disabled) if unset.
+// Changing it makes the credentials sealed before unrecoverable; the machines
+// still authenticate.
+func newCredentialSealer() (*data.CredentialSealer, error) {
+	secret := os.Getenv("CREDENTIAL_KEY")
+	if secret == "" {
+		return nil, nil
+	}
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("CREDENTIAL_KEY is too short (%d chars); require at least 16", len(secret))
+	}
+	if secret == os.Getenv("JWT_SECRET") {
+		return nil, fmt.Errorf("CREDENTIAL_KEY must differ from JWT_SECRET")
+	}
+	return data.NewCredentialSealer(secret)
+}
+
+// validateFrontendURL requires FRONTEND_URL: the links e-mailed to users
+// (password reset, e-mail verification, lockout) and the 2FA redirects are
+// built from it, never from the Host header of a request.
+func validateFrontendURL() error {
+	v := os.Getenv("FRONTEND_URL")
+	if v == "" {
+		return fmt.Errorf("FRONTEND_URL is missing; set the public URL of the site (e.g. https://mon.essensys.fr/)")
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("FRONTEND_URL %q is not an absolute http(s) URL", v)
+	}
+	return nil
+}
+
+// newGeoLocator builds the provider selected by GEO_PROVIDER=http|mmdb|none
+func newGeoLocator() geo.GeoLocator {
+	switch provider := os.Getenv("GEO_PROVIDER"); provider {
+	case "", "http":
+		return geo.NewHTTPLocator(os.Getenv("GEO_HTTP_URL"),
+			envInt("GEO_HTTP_RATE", 40),
+			envDuration("GEO_CACHE_TTL", 24*time.Hour),
+			envInt("GEO_CACHE_SIZE", 10000))
+	case "mmdb":
+		path := os.Getenv("GEO_MMDB_PATH")
+		locator, err := geo.NewMMDBLocator(path, os.Getenv("GEO_LANGUAGE"))
+		if err != nil {
+			log.Printf("WARNING: Failed to load geolocation database %q: %v. Geolocation disabled.", path, err)
+			return geo.NoopLocator{}
+		}
+		log.Printf("Loaded geolocation database %s", path)
+		return locator
+	case "none":
+		return geo.NoopLocator{}
+	default:
+		log.Printf("config: invalid GEO_PROVIDER=%q, geolocation disabled", provider)
+		return geo.NoopLocator{}
+	}
+}
+
+// envDuration reads a Go duration (e.g. "720h", "5m") from the environment,
+// falling back to def when unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("config: invalid %s=%q, using %s", name, v, def)
+		return def
+	}
+	return d
+}
+
+// envInt reads an integer from the environment, falling back to def when unset
+// or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("config: invalid %s=%q, using %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// envList reads a comma-separated list from the environment
+func envList(name string) []string {
+	return envListDefault(name, "")
+}
+
+// envListDefault reads a comma-separated list from the environment, def when unset
+func envListDefault(name, def string) []string {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		v = def
+	}
+	var list []string
+	for _, v := range strings.Split(v, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -19,11 +19,14 @@ var (
 type adminAction string
 
 const (
-	actionUpdateRole  adminAction = "update_role"
-	actionUpdateLinks adminAction = "update_links"
-	actionForbid      adminAction = "forbid"
-	actionUnforbid    adminAction = "unforbid"
-	actionDelete      adminAction = "delete"
+	actionUpdateRole     adminAction = "update_role"
+	actionUpdateLinks    adminAction = "update_links"
+	actionForbid         adminAction = "forbid"
+	actionUnforbid       adminAction = "unforbid"
+	actionDelete         adminAction = "delete"
+	actionVerifyEmail    adminAction = "verify_email" // Resend the link or mark verified
+	actionResetTwoFactor adminAction = "reset_2fa"
+	actionUnlock         adminAction = "unlock" // Login lockout
 )
 
 type adminUserStore interface {
@@ -74,7 +77,7 @@ func authorizeAdminTarget(users adminUserStore, caller *models.User, targetUserI
 					return nil, errAuthzLastAdmin
 				}
 			}
-		case actionForbid, actionUnforbid:
+		case actionForbid, actionUnforbid, actionResetTwoFactor:
 			if caller.ID == target.ID {
 				return nil, errAuthzSelfAction
 			}
@@ -114,4 +117,59 @@ func writeAuthzError(w http.ResponseWriter, err error) {
 	}
 }
 
+// adminCaller resolves the user behind an admin request. Requests made with the
+// static ADMIN_TOKEN carry no user and are treated as a global admin (nil, true).
+func (rt *Router) adminCaller(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	email, _ := r.Context().Value("user_email").(string)
+	if email == "" {
+		return nil, true
+	}
+	if rt.UserStore == nil {
+		http.Error(w, "User Store not initialized", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	caller, err := rt.UserStore.GetUserByEmail(email)
+	if err != nil || caller == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return caller, true
+}
+
+// auditActor returns the user ID and name to record for an admin caller
+func auditActor(caller *models.User) (int, string) {
+	if caller == nil {
+		return 0, "system"
+	}
+	return caller.ID, caller.Email
+}
+
+func isGlobalCaller(caller *models.User) bool {
+	return caller == nil || caller.Role == models.RoleAdminGlobal
+}
+
+// canViewMachine: global admins and support see every machine, local admins only their own
+func canViewMachine(caller *models.User, machineID int) bool {
+	if isGlobalCaller(caller) || caller.Role == models.RoleSupport {
+		return true
+	}
+	return caller.Role == models.RoleAdminLocal && caller.LinkedMachineID != nil && *caller.LinkedMachineID == machineID
+}
+
+// canManageMachine: support is read-only on machines
+func canManageMachine(caller *models.User, machineID int) bool {
+	if isGlobalCaller(caller) {
+		return true
+	}
+	return caller.Role == models.RoleAdminLocal && caller.LinkedMachineID != nil && *caller.LinkedMachineID == machineID
+}
+
+// canViewGateway: global admins and support see every gateway, local admins only their own
+func canViewGateway(caller *models.User, hostname string) bool {
+	if isGlobalCaller(caller) || caller.Role == models.RoleSupport {
+		return true
+	}
+	return caller.Role == models.RoleAdminLocal && caller.LinkedGatewayID != nil && *caller.LinkedGatewayID == hostname
+}
+
 var _ adminUserStore = (*data.PostgresUserStore)(nil)
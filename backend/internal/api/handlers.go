@@ -2,20 +2,57 @@ package api
 
 import (
 	"encoding/json"
+    "errors"
     "net"
 	"log"
 	"net/http"
     "time"
 
+	"github.com/essensys-hub/essensys-support-site/backend/internal/catalog"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/events"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/metrics"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/middleware"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
 )
 
 type Router struct {
 	Store      data.Store
 	UserStore  data.UserStore
 	AuditStore data.AuditStore
+
+	// Optional subsystems, wired in cmd/server/main.go
+	ActionStore    data.ActionStore
+	TelemetryStore data.TelemetryStore
+	GatewayMetricsStore data.GatewayMetricsStore
+	Presence       *data.PresenceTracker
+	Alerts         *data.AlertEngine
+	Events         *events.Bus // Live stream of GET /api/admin/events
+	EventsMaxStreams int
+	Catalog        *catalog.Registry
+	ProfileStore   data.CollectionProfileStore
+	FirmwareStore  data.FirmwareStore
+	RegistrationStore data.RegistrationStore
+	GatewayGuard      *middleware.GatewayGuard // Gateway tokens and policy of POST /api/infos
+	GatewayTokenGrace time.Duration            // Validity of a gateway token after its rotation
+	// FirmwareBlockSize is the /api/getversioncontent block size (DefaultFirmwareBlockSize if 0)
+	FirmwareBlockSize int
+	Sessions          data.SessionStore // Login sessions and refresh tokens (nil: 24h tokens, no refresh)
+	AccessTokenTTL    time.Duration     // DefaultAccessTokenTTL if 0
+	RefreshTokenTTL   time.Duration     // DefaultRefreshTokenTTL if 0
+	EmailTokens       data.EmailTokenStore // Password reset links
+	PasswordResetTTL  time.Duration        // DefaultPasswordResetTTL if 0
+	// Password reset requests per IP (both endpoints) and per account; nil: unlimited
+	PasswordResetIPLimit    *middleware.RateLimiter
+	PasswordResetEmailLimit *middleware.RateLimiter
+	EmailVerificationTTL    time.Duration          // DefaultEmailVerificationTTL if 0
+	VerificationLimit       *middleware.RateLimiter // Verification links resent per user; nil: unlimited
+	TwoFactor               data.TwoFactorStore     // TOTP enrolments (nil: 2FA unavailable)
+	TwoFactorPolicy         string                  // models.TwoFactorOptional or models.TwoFactorRequiredPrivileged
+	TwoFactorLimit          *middleware.RateLimiter // Code attempts per user; nil: unlimited
+	LoginGuard              *middleware.LoginGuard  // Failed password lockouts; nil: unlimited
+	Credentials             *data.CredentialSealer  // Opens the machine credentials revealed to admins; nil: none
 }
 
 func NewRouter(store data.Store, userStore data.UserStore, auditStore data.AuditStore) *Router {
@@ -31,7 +68,7 @@ func (rt *Router) LogAudit(userID int, username, action, resourceType, resourceI
 	if rt.AuditStore == nil {
 		return
 	}
-	log := &models.AuditLog{
+	entry := &models.AuditLog{
 		UserID:       userID,
 		Username:     username,
 		Action:       action,
@@ -41,9 +78,10 @@ func (rt *Router) LogAudit(userID int, username, action, resourceType, resourceI
 		Details:      details,
 		CreatedAt:    time.Now(),
 	}
-	if err := rt.AuditStore.CreateAuditLog(log); err != nil {
-        // Don't crash on audit fail, just log to stderr
-        // log.Printf("AUDIT FAIL: %v", err)
+	if err := rt.AuditStore.CreateAuditLog(entry); err != nil {
+		// Don't fail the audited action, but make the loss visible
+		metrics.AuditWriteFailures.Inc()
+		log.Printf("[AUDIT] Failed to write %s on %s %s: %v", action, resourceType, resourceID, err)
 	}
 }
 
@@ -54,8 +92,33 @@ func (rt *Router) HandleServerInfos(w http.ResponseWriter, r *http.Request) {
 
 	response := models.ServerInfosResponse{
 		IsConnected: false, // Always false in passive mode (no user interaction needed)
-		Infos:       []int{363, 349, 350, 351, 352, 353, 11, 920}, // Indices to collect
-		NewVersion:  "no", // No firmware update for now
+		Infos:       models.DefaultCollectionKeys, // Indices to collect
+		NewVersion:  "no", // Replaced by the target of a rollout campaign, if any
+	}
+
+	machine, _ := r.Context().Value(middleware.MachineKey).(*models.Machine)
+
+	// Indices come from the collection profile of the machine (anonymous: fleet default)
+	if rt.ProfileStore != nil {
+		machineID, version := 0, ""
+		if machine != nil {
+			machineID, version = machine.ID, machine.Version
+		}
+		if eff, err := rt.ProfileStore.ResolveCollection(machineID, version); err != nil {
+			log.Printf("[API] Failed to resolve collection profile for %s: %v", clientID, err)
+		} else {
+			response.Infos = eff.Keys
+		}
+	}
+
+	// Firmware rollout: only authenticated machines with a known version
+	if rt.FirmwareStore != nil && machine != nil {
+		if target, err := rt.FirmwareStore.OfferUpdate(machine); err != nil {
+			log.Printf("[API] Failed to resolve firmware update for %s: %v", clientID, err)
+		} else if target != "" {
+			log.Printf("[API] Offering firmware %s to %s (current %s)", target, clientID, machine.Version)
+			response.NewVersion = target
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -80,21 +143,84 @@ func (rt *Router) HandleMyStatus(w http.ResponseWriter, r *http.Request) {
         log.Printf("[API] Failed to save data: %v", err)
     }
 
+    machineID, hasMachine := r.Context().Value(middleware.MachineIDKey).(int)
+
+    // Record the firmware version and close a pending update that reached it
+    if hasMachine && payload.Version != "" {
+        if err := rt.Store.SetMachineVersion(machineID, payload.Version); err != nil {
+            log.Printf("[API] Failed to record version for %s: %v", clientID, err)
+        }
+        if rt.FirmwareStore != nil {
+            if err := rt.FirmwareStore.RecordVersion(machineID, payload.Version); err != nil {
+                log.Printf("[API] Failed to update firmware rollout for %s: %v", clientID, err)
+            }
+        }
+    }
+
+    // Record history (every sample, see TelemetryStore)
+    if hasMachine && rt.TelemetryStore != nil {
+        if err := rt.TelemetryStore.RecordSamples(machineID, time.Now(), payload.EK); err != nil {
+            log.Printf("[API] Failed to record telemetry for %s: %v", clientID, err)
+        }
+    }
+
+    // Confirm queued actions whose values are now reported by the machine
+    if rt.ActionStore != nil {
+        if n, err := rt.ActionStore.ConfirmActions(clientID, payload.EK); err != nil {
+            log.Printf("[API] Failed to confirm actions for %s: %v", clientID, err)
+        } else if n > 0 {
+            log.Printf("[API] %d action(s) confirmed for %s", n, clientID)
+        }
+    }
+
 	// Return 201 Created (Empty Body)
 	w.WriteHeader(http.StatusCreated)
 }
 
-// GET /api/myactions (Not Implemented / Empty)
+// GET /api/myactions
 func (rt *Router) HandleMyActions(w http.ResponseWriter, r *http.Request) {
-	// Passive Mode: We never send actions.
-	// We can return 404 or an empty list. 
-    // Returning empty JSON list is safer for client compatibility if it insists on parsing JSON.
-    // Or just 200 OK with empty body if client ignores it.
-    // Spec says "Not Implemented", let's return 200 OK with empty JSON object or just 200.
-    
-    // For safety against legacy client parsing crashes:
+    clientID, _ := r.Context().Value(middleware.ClientIDKey).(string)
+
+    // An empty queue answers "{}": safe for legacy clients that insist on parsing JSON.
+    response := models.MyActionsResponse{}
+    if rt.ActionStore != nil {
+        actions, err := rt.ActionStore.NextActions(clientID)
+        if err != nil {
+            log.Printf("[API] Failed to load actions for %s: %v", clientID, err)
+        }
+        for _, a := range actions {
+            response.Actions = append(response.Actions, models.LegacyAction{GUID: a.GUID, Params: a.Params})
+        }
+        if len(response.Actions) > 0 {
+            log.Printf("[API] Delivering %d action(s) to %s", len(response.Actions), clientID)
+        }
+    }
+
     w.Header().Set("Content-Type", "application/json")
-    w.Write([]byte("{}")) 
+    json.NewEncoder(w).Encode(response)
+}
+
+// POST /api/done/{guid}
+func (rt *Router) HandleActionDone(w http.ResponseWriter, r *http.Request) {
+    clientID, _ := r.Context().Value(middleware.ClientIDKey).(string)
+    guid := chi.URLParam(r, "guid")
+
+    if rt.ActionStore != nil {
+        err := rt.ActionStore.AcknowledgeAction(clientID, guid)
+        if errors.Is(err, data.ErrActionNotFound) {
+            http.Error(w, "Not Found", http.StatusNotFound)
+            return
+        }
+        if err != nil {
+            // Expired or cancelled: still answer 201 so the client stops retrying
+            log.Printf("[API] Late acknowledgement of %s by %s: %v", guid, clientID, err)
+        } else {
+            log.Printf("[API] Action %s acknowledged by %s", guid, clientID)
+        }
+    }
+
+    // Legacy client expects "201 Created"
+    w.WriteHeader(http.StatusCreated)
 }
 
 // POST /api/infos
@@ -124,12 +250,48 @@ func (rt *Router) HandleGatewayInfos(w http.ResponseWriter, r *http.Request) {
     
     payload.LastSeen = time.Now()
 
+    // Identity: the token decides which gateway is written, never the body
+    payload.GatewayID = ""
+    if rt.GatewayGuard != nil {
+        identity := middleware.GatewayIdentityFromContext(r.Context())
+        if identity == nil && payload.Hostname == "" {
+            http.Error(w, "Bad Request: hostname required", http.StatusBadRequest)
+            return
+        }
+        err := rt.GatewayGuard.Check(identity, payload.Hostname, payload.IP)
+        switch {
+        case errors.Is(err, middleware.ErrGatewayQuarantined):
+            // Not applied; accepted so that legacy gateways do not retry in a loop
+            http.Error(w, "Gateway not enrolled, report quarantined", http.StatusAccepted)
+            return
+        case errors.Is(err, middleware.ErrGatewayUnauthenticated):
+            http.Error(w, "Unauthorized", http.StatusUnauthorized)
+            return
+        case errors.Is(err, middleware.ErrGatewayMismatch):
+            http.Error(w, "Forbidden: token issued for another gateway", http.StatusForbidden)
+            return
+        case err != nil:
+            log.Printf("[API] Gateway check failed: %v", err)
+            http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+            return
+        }
+        if identity != nil {
+            payload.Hostname = identity.Hostname
+            payload.GatewayID = identity.ID
+        }
+    }
+
     // Save to Store
     if err := rt.Store.SaveGateway(&payload); err != nil {
         log.Printf("[API] Failed to save gateway: %v", err)
     } else {
         log.Printf("[API] Gateway Update: %s (%s)", payload.Hostname, payload.IP)
     }
+    if rt.GatewayMetricsStore != nil && payload.Hostname != "" {
+        if err := rt.GatewayMetricsStore.RecordGatewayMetrics(payload.Key(), payload.MetricsPoint()); err != nil {
+            log.Printf("[API] Failed to record gateway metrics: %v", err)
+        }
+    }
     
     // Return 200 OK
     w.WriteHeader(http.StatusOK)
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultActionTTL = 24 * time.Hour
+	maxActionTTL     = 7 * 24 * time.Hour
+	maxActionParams  = 64
+)
+
+// machineFromParam resolves the {id} URL parameter to a machine the caller may see
+func (rt *Router) machineFromParam(w http.ResponseWriter, r *http.Request, caller *models.User) (*models.MachineDetail, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid Machine ID", http.StatusBadRequest)
+		return nil, false
+	}
+	if !canViewMachine(caller, id) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return nil, false
+	}
+	machines, err := rt.Store.GetMachines()
+	if err != nil {
+		log.Printf("[API] Failed to get machines: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	for _, m := range machines {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	http.Error(w, "Machine not found", http.StatusNotFound)
+	return nil, false
+}
+
+// validateActionParams checks that every write targets a Table d'Echange index
+// with a byte value, the only thing the legacy client can apply.
+func validateActionParams(params []models.ExchangeKeyValue) error {
+	if len(params) == 0 {
+		return errors.New("params are required")
+	}
+	if len(params) > maxActionParams {
+		return fmt.Errorf("too many params (max %d)", maxActionParams)
+	}
+	for _, p := range params {
+		if p.K <= 0 || p.K > 0xFFFF {
+			return fmt.Errorf("invalid key %d", p.K)
+		}
+		v, err := strconv.Atoi(p.V)
+		if err != nil || v < 0 || v > 255 {
+			return fmt.Errorf("invalid value %q for key %d (expected 0-255)", p.V, p.K)
+		}
+	}
+	return nil
+}
+
+// GET /api/admin/machines/{id}/actions
+func (rt *Router) HandleAdminGetMachineActions(w http.ResponseWriter, r *http.Request) {
+	if rt.ActionStore == nil {
+		http.Error(w, "Action Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	machine, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+
+	actions, err := rt.ActionStore.GetActions(machine.NoSerie)
+	if err != nil {
+		log.Printf("[API] Failed to get actions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actions)
+}
+
+// POST /api/admin/machines/{id}/actions
+func (rt *Router) HandleAdminEnqueueMachineAction(w http.ResponseWriter, r *http.Request) {
+	if rt.ActionStore == nil {
+		http.Error(w, "Action Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	machine, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+	if !canManageMachine(caller, machine.ID) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	var req models.EnqueueActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := validateActionParams(req.Params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl := defaultActionTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl > maxActionTTL {
+		ttl = maxActionTTL
+	}
+
+	actorID, actorName := auditActor(caller)
+	now := time.Now()
+	action := &models.MachineAction{
+		NoSerie:   machine.NoSerie,
+		Params:    req.Params,
+		CreatedBy: actorName,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := rt.ActionStore.EnqueueAction(action); err != nil {
+		log.Printf("[API] Failed to enqueue action: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	params, _ := json.Marshal(action.Params)
+	rt.LogAudit(actorID, actorName, "ENQUEUE_ACTION", "MACHINE", strconv.Itoa(machine.ID), getIP(r),
+		"Queued action "+action.GUID+" for "+machine.NoSerie+": "+string(params))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(action)
+}
+
+// DELETE /api/admin/machines/{id}/actions/{guid}
+func (rt *Router) HandleAdminCancelMachineAction(w http.ResponseWriter, r *http.Request) {
+	if rt.ActionStore == nil {
+		http.Error(w, "Action Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	machine, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+	if !canManageMachine(caller, machine.ID) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	guid := chi.URLParam(r, "guid")
+	err := rt.ActionStore.CancelAction(machine.NoSerie, guid)
+	switch {
+	case errors.Is(err, data.ErrActionNotFound):
+		http.Error(w, "Action not found", http.StatusNotFound)
+		return
+	case errors.Is(err, data.ErrActionClosed):
+		http.Error(w, "Conflict: action is no longer pending", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[API] Failed to cancel action: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "CANCEL_ACTION", "MACHINE", strconv.Itoa(machine.ID), getIP(r),
+		"Cancelled action "+guid+" for "+machine.NoSerie)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -3,13 +3,16 @@ package api
 import (
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
     "strconv"
+    "strings"
     "time"
 
     "github.com/go-chi/chi/v5"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/gatewayrules"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
     "golang.org/x/crypto/bcrypt"
@@ -43,35 +46,150 @@ func (rt *Router) HandleAdminStats(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if rt.Presence != nil {
+		machines, gateways, err := rt.Presence.Counts(time.Now())
+		if err != nil {
+			log.Printf("[API] Failed to count presence: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		stats.ConnectedClients = machines.Online + machines.Late
+		stats.ConnectedGateways = gateways.Online + gateways.Late
+		stats.MachinesPresence = &machines
+		stats.GatewaysPresence = &gateways
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
-// GET /api/admin/machines
+// maxFleetPage caps ?limit= of the machine and gateway lists
+const maxFleetPage = 1000
+
+// fleetQuery reads the filters, sort and page of GET /api/admin/machines and
+// /gateways: q, active, presence, version, country, sort, order, cursor, limit
+func (rt *Router) fleetQuery(w http.ResponseWriter, r *http.Request, kind string) (models.FleetQuery, bool) {
+	params := r.URL.Query()
+	q := models.FleetQuery{
+		Search:  strings.TrimSpace(params.Get("q")),
+		Version: params.Get("version"),
+		Country: params.Get("country"),
+		Sort:    params.Get("sort"),
+		Cursor:  params.Get("cursor"),
+	}
+	if v := params.Get("active"); v != "" && kind == models.PresenceMachine {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid 'active' (true or false)", http.StatusBadRequest)
+			return q, false
+		}
+		q.Active = &active
+	}
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		http.Error(w, "Invalid 'order' (asc or desc)", http.StatusBadRequest)
+		return q, false
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid 'limit'", http.StatusBadRequest)
+			return q, false
+		}
+		q.Limit = min(limit, maxFleetPage)
+	}
+
+	// Presence states are ranges of LastSeen, see PresencePolicy.State
+	if v := params.Get("presence"); v != "" {
+		if rt.Presence == nil {
+			http.Error(w, "Presence tracking not initialized", http.StatusBadRequest)
+			return q, false
+		}
+		policy := rt.Presence.Policy
+		now := time.Now()
+		late := now.Add(-time.Duration(policy.LateAfter) * policy.Interval(kind))
+		offline := now.Add(-time.Duration(policy.OfflineAfter) * policy.Interval(kind))
+		switch v {
+		case models.PresenceOnline:
+			q.SeenAfter = late
+		case models.PresenceLate:
+			q.SeenAfter, q.SeenBefore = offline, late
+		case models.PresenceOffline:
+			q.SeenBefore = offline
+		case "up": // Online or late
+			q.SeenAfter = offline
+		default:
+			http.Error(w, "Invalid 'presence' (online, late, offline or up)", http.StatusBadRequest)
+			return q, false
+		}
+	}
+	return q, true
+}
+
+// writePageHeaders sends the total and the next cursor of a page: the body
+// stays the list of records
+func writePageHeaders(w http.ResponseWriter, total int, next string) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+}
+
+// GET /api/admin/machines?q=&active=&presence=&version=&country=&sort=&order=&cursor=&limit=
 func (rt *Router) HandleAdminMachines(w http.ResponseWriter, r *http.Request) {
-	machines, err := rt.Store.GetMachines()
+	q, ok := rt.fleetQuery(w, r, models.PresenceMachine)
+	if !ok {
+		return
+	}
+	page, err := rt.Store.QueryMachines(q)
+	if errors.Is(err, data.ErrInvalidFleetQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("[API] Failed to get machines: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	writePageHeaders(w, page.Total, page.NextCursor)
+
+	if rt.Presence != nil {
+		rt.writeMachinesWithPresence(w, r, page.Items)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(machines)
+	json.NewEncoder(w).Encode(page.Items)
 }
 
-// GET /api/admin/gateways
+// GET /api/admin/gateways?q=&presence=&country=&sort=&order=&cursor=&limit=
 func (rt *Router) HandleAdminGateways(w http.ResponseWriter, r *http.Request) {
-    gateways, err := rt.Store.GetGateways()
+    q, ok := rt.fleetQuery(w, r, models.PresenceGateway)
+    if !ok {
+        return
+    }
+    page, err := rt.Store.QueryGateways(q)
+    if errors.Is(err, data.ErrInvalidFleetQuery) {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
     if err != nil {
         log.Printf("[API] Failed to get gateways: %v", err)
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
+    writePageHeaders(w, page.Total, page.NextCursor)
+
+    if rt.Presence != nil {
+        rt.writeGatewaysWithPresence(w, r, page.Items)
+        return
+    }
 
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(gateways)
+    json.NewEncoder(w).Encode(page.Items)
 }
 
 // GET /api/admin/users
@@ -116,6 +234,23 @@ func (rt *Router) HandleAdminGetUsers(w http.ResponseWriter, r *http.Request) {
         users = []*models.User{}
     }
 
+    // Show the login lockouts, lifted with POST /api/admin/users/{id}/unlock
+    if lockouts, err := rt.LoginGuard.Lockouts(time.Now()); err != nil {
+        log.Printf("[API] Failed to get lockouts: %v", err)
+    } else if len(lockouts) > 0 {
+        lockedUntil := make(map[string]time.Time)
+        for _, l := range lockouts {
+            if l.Kind == models.LoginThrottleAccount {
+                lockedUntil[l.Value] = l.LockedUntil
+            }
+        }
+        for _, u := range users {
+            if until, ok := lockedUntil[strings.ToLower(u.Email)]; ok {
+                u.LockedUntil = &until
+            }
+        }
+    }
+
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(users)
 }
@@ -161,6 +296,8 @@ func (rt *Router) HandleAdminUpdateUserRole(w http.ResponseWriter, r *http.Reque
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
+    // Tokens carry the role: log the user out so that the next login gets the new one
+    rt.revokeUserSessions(targetUserID, models.SessionRoleChanged)
 
     // Audit Log
     detail := "Updated role for user " + idStr + " to " + req.Role
@@ -241,6 +378,11 @@ func (rt *Router) HandleAdminCreateUser(w http.ResponseWriter, r *http.Request)
     // Audit Log
     rt.LogAudit(adminID, adminEmail, "CREATE_USER", "USER", user.Email, getIP(r), "Created user by admin")
 
+    // The user proves the address like at registration (or an admin marks it verified)
+    if err := rt.sendEmailVerification(user); err != nil {
+        log.Printf("[API] Failed to create verification link for user %d: %v", user.ID, err)
+    }
+
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(map[string]string{"message": "User created successfully"})
 }
@@ -313,6 +455,7 @@ func (rt *Router) HandleAdminForbidUser(w http.ResponseWriter, r *http.Request)
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
+    rt.revokeUserSessions(target.ID, models.SessionForbidden)
     rt.LogAudit(caller.ID, caller.Email, "FORBID_USER", "USER", idStr, getIP(r), "Forbidden user "+target.Email)
     w.WriteHeader(http.StatusNoContent)
 }
@@ -343,6 +486,8 @@ func (rt *Router) HandleAdminDeleteUser(w http.ResponseWriter, r *http.Request)
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
+    rt.revokeUserSessions(target.ID, models.SessionUserDeleted)
+    rt.deleteTwoFactor(target.ID)
     rt.LogAudit(caller.ID, caller.Email, "DELETE_USER", "USER", idStr, getIP(r), "Deleted user "+target.Email)
     w.WriteHeader(http.StatusNoContent)
 }
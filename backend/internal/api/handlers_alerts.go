@@ -0,0 +1,376 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+var alertMetrics = map[string]bool{"cpu": true, "memory": true, "disk": true, "client_count": true}
+
+// alertsAdmin checks the engine and the caller: every admin sees the alerts of
+// the resources it can see, only admin_global may change the rules
+func (rt *Router) alertsAdmin(w http.ResponseWriter, r *http.Request, writeRules bool) (*models.User, bool) {
+	if rt.Alerts == nil {
+		http.Error(w, "Alerting not initialized", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return nil, false
+	}
+	if writeRules && !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return nil, false
+	}
+	return caller, true
+}
+
+// canViewAlert: the caller can see the machine or gateway of the alert
+func canViewAlert(caller *models.User, a *models.Alert) bool {
+	if a.Kind == models.PresenceGateway {
+		return canViewGateway(caller, a.ResourceID)
+	}
+	id, err := strconv.Atoi(a.ResourceID)
+	return err == nil && canViewMachine(caller, id)
+}
+
+// alertFromParam loads the {id} alert, 404 when the caller cannot see it
+func (rt *Router) alertFromParam(w http.ResponseWriter, r *http.Request, caller *models.User) (*models.Alert, bool) {
+	a, err := rt.Alerts.Alerts.GetAlert(chi.URLParam(r, "id"))
+	if errors.Is(err, data.ErrAlertNotFound) || (err == nil && !canViewAlert(caller, a)) {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return a, true
+}
+
+// GET /api/admin/alerts?status=active|open|acknowledged|resolved&kind=machine|gateway&resource=ID&rule=ID
+func (rt *Router) HandleAdminGetAlerts(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.alertsAdmin(w, r, false)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	filter := models.AlertFilter{
+		Status:     q.Get("status"),
+		Kind:       q.Get("kind"),
+		ResourceID: q.Get("resource"),
+		RuleID:     q.Get("rule"),
+	}
+	switch filter.Status {
+	case "", "active", models.AlertOpen, models.AlertAcknowledged, models.AlertResolved:
+	default:
+		http.Error(w, "Invalid 'status' (active, open, acknowledged, resolved)", http.StatusBadRequest)
+		return
+	}
+
+	alerts, err := rt.Alerts.Alerts.GetAlerts(filter)
+	if err != nil {
+		log.Printf("[API] Failed to get alerts: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	visible := make([]*models.Alert, 0, len(alerts))
+	for _, a := range alerts {
+		if canViewAlert(caller, a) {
+			visible = append(visible, a)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+// GET /api/admin/alerts/{id}
+func (rt *Router) HandleAdminGetAlert(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.alertsAdmin(w, r, false)
+	if !ok {
+		return
+	}
+	a, ok := rt.alertFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// POST /api/admin/alerts/{id}/acknowledge
+// Body (optional): {"note": "..."}
+func (rt *Router) HandleAdminAcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	rt.handleAlertTransition(w, r, "ACK_ALERT")
+}
+
+// POST /api/admin/alerts/{id}/resolve
+// Body (optional): {"note": "..."}. The alert opens again if its condition still holds.
+func (rt *Router) HandleAdminResolveAlert(w http.ResponseWriter, r *http.Request) {
+	rt.handleAlertTransition(w, r, "RESOLVE_ALERT")
+}
+
+func (rt *Router) handleAlertTransition(w http.ResponseWriter, r *http.Request, action string) {
+	caller, ok := rt.alertsAdmin(w, r, false)
+	if !ok {
+		return
+	}
+	a, ok := rt.alertFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+	var req models.AlertNoteRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > 1000 {
+		http.Error(w, "note is too long (1000 chars max)", http.StatusBadRequest)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	var err error
+	if action == "ACK_ALERT" {
+		a, err = rt.Alerts.Acknowledge(a.ID, actorName, req.Note)
+	} else {
+		a, err = rt.Alerts.Resolve(a.ID, actorName, req.Note)
+	}
+	if errors.Is(err, data.ErrAlertResolved) {
+		http.Error(w, "Alert already resolved", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to update alert: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	details := a.RuleName + " on " + a.Kind + " " + a.Resource
+	if req.Note != "" {
+		details += ": " + req.Note
+	}
+	rt.LogAudit(actorID, actorName, action, "ALERT", a.ID, getIP(r), details)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// GET /api/admin/alerts/rules
+func (rt *Router) HandleAdminGetAlertRules(w http.ResponseWriter, r *http.Request) {
+	if _, ok := rt.alertsAdmin(w, r, false); !ok {
+		return
+	}
+	rules, err := rt.Alerts.Alerts.GetRules()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// POST /api/admin/alerts/rules
+func (rt *Router) HandleAdminCreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.alertsAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	var rule models.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	rule.ID = ""
+	if msg := rt.validateAlertRule(&rule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if err := rt.Alerts.Alerts.SaveRule(&rule); err != nil {
+		log.Printf("[API] Failed to create alert rule: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "CREATE_ALERT_RULE", "ALERT_RULE", rule.ID, getIP(r), describeAlertRule(&rule))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// PUT /api/admin/alerts/rules/{id}
+func (rt *Router) HandleAdminUpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.alertsAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	if _, err := rt.Alerts.Alerts.GetRule(id); errors.Is(err, data.ErrAlertRuleNotFound) {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var rule models.AlertRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	rule.ID = id
+	if msg := rt.validateAlertRule(&rule); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if err := rt.Alerts.Alerts.SaveRule(&rule); err != nil {
+		log.Printf("[API] Failed to update alert rule: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "UPDATE_ALERT_RULE", "ALERT_RULE", rule.ID, getIP(r), describeAlertRule(&rule))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DELETE /api/admin/alerts/rules/{id}
+// The active alerts of the rule are resolved on the next evaluation.
+func (rt *Router) HandleAdminDeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.alertsAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	rule, err := rt.Alerts.Alerts.GetRule(id)
+	if errors.Is(err, data.ErrAlertRuleNotFound) {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rules, err := rt.Alerts.Alerts.GetRules()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for _, other := range rules {
+		if other.Overrides == id {
+			http.Error(w, "Alert rule is overridden by "+other.ID+": delete the override first", http.StatusConflict)
+			return
+		}
+	}
+	if err := rt.Alerts.Alerts.DeleteRule(id); err != nil {
+		log.Printf("[API] Failed to delete alert rule: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "DELETE_ALERT_RULE", "ALERT_RULE", id, getIP(r), describeAlertRule(rule))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateAlertRule normalizes rule and returns what is wrong with it, "" when valid
+func (rt *Router) validateAlertRule(rule *models.AlertRule) string {
+	rule.Name = strings.TrimSpace(rule.Name)
+	rule.Target = strings.TrimSpace(rule.Target)
+	if rule.Name == "" || len(rule.Name) > 100 {
+		return "name is required (100 chars max)"
+	}
+	kind, ok := models.AlertRuleKinds[rule.Condition]
+	if !ok {
+		return "Invalid condition (machine_offline, machine_value, gateway_offline, gateway_service_down, gateway_metric)"
+	}
+	switch rule.Severity {
+	case "":
+		rule.Severity = models.AlertWarning
+	case models.AlertInfo, models.AlertWarning, models.AlertCritical:
+	default:
+		return "Invalid severity (info, warning, critical)"
+	}
+	if rule.ForSeconds < 0 || rule.ResolveAfterSeconds < 0 {
+		return "for_seconds and resolve_after_seconds must be positive"
+	}
+
+	switch rule.Condition {
+	case models.AlertMachineOffline, models.AlertGatewayOffline:
+		if rule.State != "" && rule.State != models.PresenceLate && rule.State != models.PresenceOffline {
+			return "Invalid state (late, offline)"
+		}
+	case models.AlertGatewayMetric:
+		if !alertMetrics[rule.Metric] {
+			return "Invalid metric (cpu, memory, disk, client_count)"
+		}
+		if !data.AlertOperators[rule.Operator] {
+			return "Invalid operator (gt, gte, lt, lte, eq, ne)"
+		}
+	case models.AlertMachineValue:
+		if rule.Key < 0 {
+			return "Invalid key"
+		}
+		if rule.Value != "" {
+			if rule.Operator == "" {
+				rule.Operator = "eq"
+			}
+			if rule.Operator != "eq" && rule.Operator != "ne" {
+				return "Text values only compare with eq or ne"
+			}
+		} else if !data.AlertOperators[rule.Operator] {
+			return "Invalid operator (gt, gte, lt, lte, eq, ne)"
+		}
+	}
+
+	if kind == models.PresenceMachine && rule.Target != "" {
+		if _, err := strconv.Atoi(rule.Target); err != nil {
+			return "target of a machine rule is the machine ID"
+		}
+	}
+	if rule.Overrides != "" {
+		if rule.Target == "" {
+			return "An override needs a target"
+		}
+		base, err := rt.Alerts.Alerts.GetRule(rule.Overrides)
+		if err != nil {
+			return "overrides: rule not found"
+		}
+		if base.Target != "" || base.Kind() != kind || base.ID == rule.ID {
+			return "overrides must reference a global rule of the same kind"
+		}
+	}
+	for _, to := range rule.Recipients {
+		if !strings.Contains(to, "@") {
+			return "Invalid recipient " + to
+		}
+	}
+	return ""
+}
+
+// describeAlertRule summarizes a rule for the audit log
+func describeAlertRule(rule *models.AlertRule) string {
+	s := rule.Name + " (" + rule.Condition + ", " + rule.Severity
+	if rule.Target != "" {
+		s += ", target " + rule.Target
+	}
+	if !rule.Enabled {
+		s += ", disabled"
+	}
+	return s + ")"
+}
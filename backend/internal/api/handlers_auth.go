@@ -14,6 +14,11 @@ import (
 
 // HandleRegister handles email/password registration
 func (router *Router) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	// The account is only usable once the e-mailed link is followed
+	if _, err := frontendURL(); err != nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
 	var req models.RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -66,15 +71,8 @@ func (router *Router) HandleRegister(w http.ResponseWriter, r *http.Request) {
         for _, m := range machines {
             if m.IP == userIP {
                 user.LinkedMachineID = &m.ID
-                
-                // Check if this machine already has a local admin
-                hasAdmin, err := router.UserStore.HasLocalAdmin(m.ID)
-                if err == nil && !hasAdmin {
-                    log.Printf("[Register] First user for machine %d (%s). Promoting to AdminLocal.", m.ID, m.NoSerie)
-                    role = models.RoleAdminLocal
-                } else {
-                    log.Printf("[Register] User joined machine %d as GuestLocal.", m.ID)
-                }
+                // Promoted to AdminLocal once the e-mail is verified, if the machine has none
+                log.Printf("[Register] User joined machine %d (%s) as GuestLocal.", m.ID, m.NoSerie)
                 break // Only link to first matching machine
             }
         }
@@ -95,9 +93,14 @@ func (router *Router) HandleRegister(w http.ResponseWriter, r *http.Request) {
     // Audit Log
     router.LogAudit(user.ID, user.Email, "REGISTER", "USER", "", getIP(r), "Registration successful")
 
+    // The account is restricted until the e-mail is verified
+    if err := router.sendEmailVerification(user); err != nil {
+        log.Printf("[Register] Failed to create verification link for user %d: %v", user.ID, err)
+    }
+
 	// Successful registration
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "User registered successfully. Check your e-mail to verify your address."})
 }
 
 // HandleLogin handles email/password login
@@ -108,6 +111,19 @@ func (router *Router) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Locked account or IP: refused before the password is even checked
+	wait, err := router.LoginGuard.Check(req.Email, getIP(r), time.Now())
+	if err != nil {
+		log.Printf("[Login] Failed to check lockout: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		router.LogAudit(0, req.Email, "LOGIN_FAILED", "USER", "", getIP(r), "Locked after repeated failures")
+		writeLoginLocked(w, wait)
+		return
+	}
+
 	user, err := router.UserStore.GetUserByEmail(req.Email)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -115,6 +131,7 @@ func (router *Router) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	if user == nil {
         router.LogAudit(0, req.Email, "LOGIN_FAILED", "USER", "", getIP(r), "Invalid credentials (user not found)")
+        router.recordLoginFailure(r, req.Email, nil)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
@@ -133,74 +150,65 @@ func (router *Router) HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
         router.LogAudit(user.ID, user.Email, "LOGIN_FAILED", "USER", "", getIP(r), "Invalid credentials (password mismatch)")
+        router.recordLoginFailure(r, req.Email, user)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	// Right password: the failures of the account are forgotten
+	if err := router.LoginGuard.UnlockAccount(req.Email); err != nil {
+		log.Printf("[Login] Failed to reset login failures of user %d: %v", user.ID, err)
+	}
+
+	// Second step when 2FA is enabled: the code comes with the challenge to
+	// POST /api/auth/login/2fa
+	challenge, err := router.pendingTwoFactor(user)
+	if err != nil {
+		log.Printf("[Login] Failed to check 2FA of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if challenge != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.TwoFactorChallenge{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+			ExpiresIn:         int(twoFactorChallengeTTL.Seconds()),
+		})
+		return
+	}
+
 	// Update Last Login
 	router.UserStore.UpdateLastLogin(user.ID)
 
     // Audit Log
     router.LogAudit(user.ID, user.Email, "LOGIN", "USER", "", getIP(r), "Login successful")
 
-	// Generate JWT
-	expirationTime := time.Now().Add(24 * time.Hour)
-	tokenString, err := GenerateJWT(user.Email, user.Role, expirationTime)
+	// Open a session: short-lived access token and rotating refresh token
+	tokens, err := router.startSession(r, user, false)
 	if err != nil {
+		log.Printf("[Login] Failed to open session: %v", err)
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
 	// Return Token match response format
     w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-        "token": tokenString,
-        "user": models.UserResponse{
-            ID:        user.ID,
-            Email:     user.Email,
-            Role:      user.Role,
-            FirstName: user.FirstName,
-            LastName:  user.LastName,
-            Provider:  user.Provider,
-        },
-    })
+	json.NewEncoder(w).Encode(loginResponse(user, tokens))
 }
 
-// HandleLogout handles logging of logouts (Client should trigger this)
-func (router *Router) HandleLogout(w http.ResponseWriter, r *http.Request) {
-    // We expect a valid token in context if auth middleware is used, or we just log "Logout attempt" if not.
-    // Since logout clears token client-side, this call is made just before clearing.
-    // If we use middleware, we know who it is.
-    // If not, we can try to parse header manually if middleware not applied to this route?
-    // Let's assume this route is protected or expects header.
-    
-    // Actually, middleware puts fields in context. If not protected, context is empty.
-    // We should probably protect it with UserTokenMiddleware.
-    
-    // For now, let's try to extract from context (assuming middleware is applied)
-    // There is no easy way to get UserID from standard Claims without looking at how Middleware sets it.
-    // Our middleware (in other files) sets "user" or "email" in context?
-    // Let's check middleware later, but typical usage:
-    // We'll read from Body if we want user details, or trust the token.
-    
-    // Simplified: Just 200 OK.
-    // BUT we need to audit.
-    // Let's rely on middleware to populate context, OR decode token manually here if needed.
-    // For this impl, I'll assume we add it to a protected group.
-    
-    // Placeholder implementation, to be enhanced if context has user info.
-    w.WriteHeader(http.StatusOK)
-}
-
-
-// GenerateJWT creates a new token for the given user
-func GenerateJWT(email, role string, expirationTime time.Time) (string, error) {
+// GenerateJWT creates a new access token for the given user. sessionID is
+// its "sid" claim, checked by the middlewares against the session store.
+func GenerateJWT(email, role, sessionID string, expirationTime time.Time) (string, error) {
 	claims := jwt.MapClaims{
 		"sub":  email,
 		"role": role,
 		"exp":  expirationTime.Unix(),
 		"iss":  "essensys-backend",
 	}
+	if sessionID != "" {
+		claims["sid"] = sessionID
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(getJWTKey())
 }
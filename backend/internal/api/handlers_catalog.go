@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/catalog"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// GET /api/catalog
+func (rt *Router) HandleGetCatalog(w http.ResponseWriter, r *http.Request) {
+	if rt.Catalog == nil {
+		http.Error(w, "Catalog not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	c := rt.Catalog.Current()
+
+	etag := `"` + c.Version + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// POST /api/admin/catalog/reload
+func (rt *Router) HandleAdminReloadCatalog(w http.ResponseWriter, r *http.Request) {
+	if rt.Catalog == nil {
+		http.Error(w, "Catalog not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	previous := rt.Catalog.Current().Version
+	c, err := rt.Catalog.Reload()
+	if err != nil {
+		log.Printf("[API] Catalog reload failed: %v", err)
+		http.Error(w, "Catalog reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "RELOAD_CATALOG", "CATALOG", c.Version, getIP(r), "Catalog reloaded ("+previous+" -> "+c.Version+")")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version":          c.Version,
+		"previous_version": previous,
+		"loaded_at":        c.LoadedAt,
+		"keys":             len(c.Keys),
+	})
+}
+
+// GET /api/admin/machines/{id}/state
+func (rt *Router) HandleAdminMachineState(w http.ResponseWriter, r *http.Request) {
+	if rt.Catalog == nil {
+		http.Error(w, "Catalog not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	machine, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+
+	snapshot, err := rt.lastSnapshot(machine)
+	if err != nil {
+		log.Printf("[API] Failed to load snapshot for %s: %v", machine.NoSerie, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		MachineID int       `json:"machine_id"`
+		NoSerie   string    `json:"no_serie"`
+		LastSeen  time.Time `json:"last_seen"`
+		*catalog.DecodedState
+	}{
+		MachineID:    machine.ID,
+		NoSerie:      machine.NoSerie,
+		LastSeen:     machine.LastSeen,
+		DecodedState: rt.Catalog.Current().Decode(snapshot),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// lastSnapshot returns the last values reported by a machine, rebuilding them
+// from the telemetry history when the store holds no live snapshot
+func (rt *Router) lastSnapshot(machine *models.MachineDetail) ([]models.ExchangeKeyValue, error) {
+	snapshot, err := rt.Store.GetClientData(machine.NoSerie)
+	if err != nil || len(snapshot) > 0 || rt.TelemetryStore == nil {
+		return snapshot, err
+	}
+	return rt.TelemetryStore.LatestSamples(machine.ID)
+}
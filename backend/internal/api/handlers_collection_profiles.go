@@ -0,0 +1,249 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxCollectionKeys bounds the "infos" list: the legacy client accepts at most
+// 30 indices (see essensys-client.md)
+const maxCollectionKeys = 30
+
+// validateProfileRequest normalises the request and checks it against the fleet
+func (rt *Router) validateProfileRequest(req *models.CollectionProfileRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+
+	seen := map[int]bool{}
+	keys := make([]int, 0, len(req.Keys))
+	for _, k := range req.Keys {
+		if k <= 0 || k > 0xFFFF {
+			return fmt.Errorf("invalid key %d", k)
+		}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("at least one key is required")
+	}
+	if len(keys) > maxCollectionKeys {
+		return fmt.Errorf("too many keys (max %d)", maxCollectionKeys)
+	}
+	req.Keys = keys
+
+	versions := make([]string, 0, len(req.FirmwareVersions))
+	for _, v := range req.FirmwareVersions {
+		if v = strings.TrimSpace(v); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	req.FirmwareVersions = versions
+
+	if len(req.MachineIDs) > 0 {
+		machines, err := rt.Store.GetMachines()
+		if err != nil {
+			return err
+		}
+		known := map[int]bool{}
+		for _, m := range machines {
+			known[m.ID] = true
+		}
+		for _, id := range req.MachineIDs {
+			if !known[id] {
+				return fmt.Errorf("unknown machine %d", id)
+			}
+		}
+	}
+	return nil
+}
+
+// GET /api/admin/collection-profiles
+func (rt *Router) HandleAdminGetCollectionProfiles(w http.ResponseWriter, r *http.Request) {
+	if rt.ProfileStore == nil {
+		http.Error(w, "Profile Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	profiles, err := rt.ProfileStore.GetProfiles()
+	if err != nil {
+		log.Printf("[API] Failed to get collection profiles: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profiles)
+}
+
+// GET /api/admin/collection-profiles/{id}
+func (rt *Router) HandleAdminGetCollectionProfile(w http.ResponseWriter, r *http.Request) {
+	if rt.ProfileStore == nil {
+		http.Error(w, "Profile Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	p, err := rt.ProfileStore.GetProfile(chi.URLParam(r, "id"))
+	if errors.Is(err, data.ErrProfileNotFound) {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// POST /api/admin/collection-profiles
+func (rt *Router) HandleAdminCreateCollectionProfile(w http.ResponseWriter, r *http.Request) {
+	rt.saveCollectionProfile(w, r, "")
+}
+
+// PUT /api/admin/collection-profiles/{id}
+func (rt *Router) HandleAdminUpdateCollectionProfile(w http.ResponseWriter, r *http.Request) {
+	rt.saveCollectionProfile(w, r, chi.URLParam(r, "id"))
+}
+
+func (rt *Router) saveCollectionProfile(w http.ResponseWriter, r *http.Request, id string) {
+	if rt.ProfileStore == nil {
+		http.Error(w, "Profile Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	var req models.CollectionProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := rt.validateProfileRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	now := time.Now()
+	p := &models.CollectionProfile{CreatedBy: actorName, CreatedAt: now}
+	action := "CREATE_COLLECTION_PROFILE"
+	if id != "" {
+		existing, err := rt.ProfileStore.GetProfile(id)
+		if errors.Is(err, data.ErrProfileNotFound) {
+			http.Error(w, "Profile not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		p = existing
+		action = "UPDATE_COLLECTION_PROFILE"
+	}
+	p.Name = req.Name
+	p.Description = req.Description
+	p.Keys = req.Keys
+	p.MachineIDs = req.MachineIDs
+	p.FirmwareVersions = req.FirmwareVersions
+	p.IsDefault = req.IsDefault
+	p.ExpiresAt = req.ExpiresAt
+	p.UpdatedAt = now
+
+	if err := rt.ProfileStore.SaveProfile(p); err != nil {
+		log.Printf("[API] Failed to save collection profile: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	keys, _ := json.Marshal(p.Keys)
+	rt.LogAudit(actorID, actorName, action, "COLLECTION_PROFILE", p.ID, getIP(r), "Profile '"+p.Name+"' keys "+string(keys))
+
+	w.Header().Set("Content-Type", "application/json")
+	if id == "" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(p)
+}
+
+// DELETE /api/admin/collection-profiles/{id}
+func (rt *Router) HandleAdminDeleteCollectionProfile(w http.ResponseWriter, r *http.Request) {
+	if rt.ProfileStore == nil {
+		http.Error(w, "Profile Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	err := rt.ProfileStore.DeleteProfile(id)
+	if errors.Is(err, data.ErrProfileNotFound) {
+		http.Error(w, "Profile not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "DELETE_COLLECTION_PROFILE", "COLLECTION_PROFILE", id, getIP(r), "Deleted collection profile")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/admin/machines/{id}/collection-profile
+func (rt *Router) HandleAdminMachineCollection(w http.ResponseWriter, r *http.Request) {
+	if rt.ProfileStore == nil {
+		http.Error(w, "Profile Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	detail, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+
+	eff, err := rt.ProfileStore.ResolveCollection(detail.ID, rt.machineVersion(detail.ID))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eff)
+}
+
+// machineVersion returns the firmware version last reported by a machine, if known
+func (rt *Router) machineVersion(machineID int) string {
+	m, err := rt.Store.GetMachineByID(machineID)
+	if err != nil || m == nil {
+		return ""
+	}
+	return m.Version
+}
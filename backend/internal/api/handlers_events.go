@@ -0,0 +1,157 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/events"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+const (
+	eventStreamBuffer    = 256              // Events queued per stream before dropping
+	eventStreamKeepAlive = 15 * time.Second // Comment line sent when idle, for proxies
+)
+
+// DefaultEventStreams is the number of concurrent streams when EventsMaxStreams is 0
+const DefaultEventStreams = 100
+
+// eventVisible applies the scope of the admin APIs to an event: admin_local
+// only sees its machine and gateway, audit entries follow GET /api/admin/audit
+func eventVisible(caller *models.User, e events.Event) bool {
+	switch {
+	case e.Type == events.Audit:
+		if isGlobalCaller(caller) {
+			return true
+		}
+		return caller.Role == models.RoleAdminLocal && caller.LinkedMachineID != nil && e.MachineID != 0 && *caller.LinkedMachineID == e.MachineID
+	case e.Gateway != "":
+		return canViewGateway(caller, e.Gateway)
+	case e.MachineID != 0:
+		return canViewMachine(caller, e.MachineID)
+	default: // Registration queue
+		return isGlobalCaller(caller) || caller.Role == models.RoleSupport
+	}
+}
+
+// recheckEventCaller checks again, for a running stream, what the admin
+// middleware checked when it opened: the account, its role and its session.
+// It returns the caller as it is now, nil for the static admin token.
+func (rt *Router) recheckEventCaller(r *http.Request, caller *models.User, now time.Time) (*models.User, bool) {
+	if caller == nil {
+		return nil, true
+	}
+	user, err := rt.UserStore.GetUserByEmail(caller.Email)
+	if err != nil {
+		log.Printf("[EVENTS] Failed to check user %d: %v", caller.ID, err)
+		return nil, false
+	}
+	if user == nil || user.ID != caller.ID || models.IsUserForbidden(user) || !models.IsUserVerified(user) {
+		return nil, false
+	}
+	if user.Role != models.RoleAdminGlobal && user.Role != models.RoleAdminLocal && user.Role != models.RoleSupport {
+		return nil, false
+	}
+	if rt.Sessions == nil {
+		return user, true
+	}
+	sid, _ := r.Context().Value("session_id").(string)
+	session, err := rt.Sessions.GetSession(sid)
+	if err != nil || session.UserID != user.ID || !session.Active(now) {
+		return nil, false
+	}
+	if !session.TwoFactor && models.IsTwoFactorRequired(rt.TwoFactorPolicy, user.Role) {
+		return nil, false
+	}
+	return user, true
+}
+
+// GET /api/admin/events?types=machine.heartbeat,gateway.report
+// Server-Sent Events: one "event: <type>" per change, data is the JSON event.
+// A "dropped" event reports the events lost by a client that did not keep up.
+// The caller is checked again at each keep-alive: a "closed" event ends the
+// stream once its session or its admin role is gone.
+func (rt *Router) HandleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	if rt.Events == nil {
+		http.Error(w, "Event stream not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	maxStreams := rt.EventsMaxStreams
+	if maxStreams <= 0 {
+		maxStreams = DefaultEventStreams
+	}
+	if rt.Events.Subscribers() >= maxStreams {
+		http.Error(w, "Too many event streams", http.StatusServiceUnavailable)
+		return
+	}
+
+	var types map[string]bool
+	if v := r.URL.Query().Get("types"); v != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+	// The scope follows the role of the caller, read again at each keep-alive
+	var current atomic.Pointer[models.User]
+	current.Store(caller)
+	sub := rt.Events.Subscribe(func(e events.Event) bool {
+		return (types == nil || types[e.Type]) && eventVisible(current.Load(), e)
+	}, eventStreamBuffer)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: do not buffer the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	if err := rc.Flush(); err != nil {
+		log.Printf("[EVENTS] Streaming not supported: %v", err)
+		return
+	}
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case now := <-keepAlive.C:
+			user, ok := rt.recheckEventCaller(r, caller, now)
+			if !ok {
+				fmt.Fprint(w, "event: closed\ndata: {\"reason\":\"unauthorized\"}\n\n")
+				rc.Flush()
+				return
+			}
+			current.Store(user)
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case e, ok := <-sub.C:
+			if !ok {
+				return // Shutdown
+			}
+			if n := sub.Dropped(); n > 0 {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", n)
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("[EVENTS] Failed to encode %s event: %v", e.Type, err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// usersByEmail is a UserStore that only answers GetUserByEmail
+type usersByEmail struct {
+	data.UserStore
+	users map[string]*models.User
+}
+
+func (s usersByEmail) GetUserByEmail(email string) (*models.User, error) {
+	if u, ok := s.users[email]; ok {
+		c := *u
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func TestRecheckEventCaller(t *testing.T) {
+	now := time.Now()
+	verified := now.Add(-time.Hour)
+	admin := &models.User{ID: 1, Email: "admin@example.com", Role: models.RoleAdminGlobal, VerifiedAt: &verified}
+	users := usersByEmail{users: map[string]*models.User{admin.Email: admin}}
+	sessions := data.NewMemorySessionStore()
+	if err := sessions.CreateSession(&models.Session{ID: "s1", UserID: 1, ExpiresAt: now.Add(time.Hour)}, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	rt := &Router{UserStore: users, Sessions: sessions}
+	r := httptest.NewRequest("GET", "/api/admin/events", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "session_id", "s1"))
+
+	if _, ok := rt.recheckEventCaller(r, admin, now); !ok {
+		t.Fatal("valid caller refused")
+	}
+	if _, ok := rt.recheckEventCaller(r, nil, now); !ok {
+		t.Error("static admin token refused")
+	}
+
+	// Demoted: the stream takes the new scope
+	admin.Role = models.RoleSupport
+	if u, ok := rt.recheckEventCaller(r, admin, now); !ok || u.Role != models.RoleSupport {
+		t.Errorf("recheckEventCaller() = %v, %v, want the support caller", u, ok)
+	}
+	admin.Role = models.RoleUser
+	if _, ok := rt.recheckEventCaller(r, admin, now); ok {
+		t.Error("caller without an admin role kept")
+	}
+	admin.Role = models.RoleAdminGlobal
+
+	rt.TwoFactorPolicy = models.TwoFactorRequiredPrivileged
+	if _, ok := rt.recheckEventCaller(r, admin, now); ok {
+		t.Error("session without 2FA kept under required_privileged")
+	}
+	rt.TwoFactorPolicy = models.TwoFactorOptional
+
+	if err := sessions.RevokeSession("s1", models.SessionLogout, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rt.recheckEventCaller(r, admin, now); ok {
+		t.Error("revoked session kept")
+	}
+}
@@ -0,0 +1,466 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// Export formats
+const (
+	exportCSV     = "csv"
+	exportJSONL   = "jsonl"
+	exportGeoJSON = "geojson" // Machines and gateways, located records only
+)
+
+// exportPageSize is the number of records read from the store at a time
+const exportPageSize = 500
+
+// exporter streams records in one format: CSV rows, JSON lines or the
+// features of a GeoJSON FeatureCollection
+type exporter struct {
+	w       io.Writer
+	rc      *http.ResponseController
+	format  string
+	csv     *csv.Writer
+	written int
+}
+
+// exportFormat reads ?format=, csv by default; geojson needs located records
+func exportFormat(w http.ResponseWriter, r *http.Request, geo bool) (string, bool) {
+	switch format := r.URL.Query().Get("format"); {
+	case format == "":
+		return exportCSV, true
+	case format == exportCSV, format == exportJSONL, format == exportGeoJSON && geo:
+		return format, true
+	case geo:
+		http.Error(w, "Invalid 'format' (csv, jsonl or geojson)", http.StatusBadRequest)
+	default:
+		http.Error(w, "Invalid 'format' (csv or jsonl)", http.StatusBadRequest)
+	}
+	return "", false
+}
+
+// newExporter sends the headers of the attachment name_YYYYMMDD.<format>;
+// columns is the CSV header
+func newExporter(w http.ResponseWriter, name, format string, columns []string) *exporter {
+	contentType := "text/csv; charset=utf-8"
+	switch format {
+	case exportJSONL:
+		contentType = "application/x-ndjson"
+	case exportGeoJSON:
+		contentType = "application/geo+json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"essensys_%s_%s.%s\"", name, time.Now().Format("20060102"), format))
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: do not buffer the stream
+	e := &exporter{w: w, rc: http.NewResponseController(w), format: format}
+	switch format {
+	case exportCSV:
+		e.csv = csv.NewWriter(w)
+		e.csv.Write(columns)
+	case exportGeoJSON:
+		io.WriteString(w, `{"type":"FeatureCollection","features":[`)
+	}
+	return e
+}
+
+// geoFeature is a GeoJSON Point feature
+type geoFeature struct {
+	Type       string      `json:"type"`
+	Geometry   geoPoint    `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+type geoPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // Longitude first
+}
+
+// write exports one record: row in CSV, item in JSONL and GeoJSON. Records
+// without coordinates are left out of GeoJSON.
+func (e *exporter) write(item interface{}, row []string, lat, lon float64) error {
+	switch e.format {
+	case exportCSV:
+		if err := e.csv.Write(row); err != nil {
+			return err
+		}
+	case exportJSONL:
+		b, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if _, err := e.w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	case exportGeoJSON:
+		if lat == 0 && lon == 0 {
+			return nil
+		}
+		b, err := json.Marshal(geoFeature{
+			Type:       "Feature",
+			Geometry:   geoPoint{Type: "Point", Coordinates: [2]float64{lon, lat}},
+			Properties: item,
+		})
+		if err != nil {
+			return err
+		}
+		if e.written > 0 {
+			io.WriteString(e.w, ",")
+		}
+		if _, err := e.w.Write(b); err != nil {
+			return err
+		}
+	}
+	e.written++
+	return nil
+}
+
+// flush sends what was written so far, once per page
+func (e *exporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return e.rc.Flush()
+}
+
+func (e *exporter) close() error {
+	if e.format == exportGeoJSON {
+		io.WriteString(e.w, "]}")
+	}
+	return e.flush()
+}
+
+// csvText keeps a spreadsheet from reading a text cell as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func csvFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// exportFilters describes the query of an export for the audit log
+func exportFilters(r *http.Request) string {
+	params := r.URL.Query()
+	params.Del("format")
+	params.Del("cursor")
+	params.Del("limit")
+	if len(params) == 0 {
+		return "none"
+	}
+	return params.Encode()
+}
+
+// auditExport records an export once streamed, or the part sent before err
+func (rt *Router) auditExport(r *http.Request, caller *models.User, action, resource, format string, n int, err error) {
+	details := fmt.Sprintf("Exported %d records as %s (filters: %s)", n, format, exportFilters(r))
+	if err != nil {
+		log.Printf("[API] Export %s interrupted: %v", strings.ToLower(resource), err)
+		details += ", interrupted"
+	}
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, action, resource, "", getIP(r), details)
+}
+
+// fleetExportCaller allows exports of the whole fleet to global admins and support
+func (rt *Router) fleetExportCaller(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !isGlobalCaller(caller) && caller.Role != models.RoleSupport {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return nil, false
+	}
+	return caller, true
+}
+
+// machineExport is an exported machine: no credential data
+type machineExport struct {
+	ID             int       `json:"id"`
+	NoSerie        string    `json:"no_serie"`
+	Version        string    `json:"version"`
+	IsActive       bool      `json:"is_active"`
+	AutoriseAlarme bool      `json:"autorise_alarme"`
+	Tags           []string  `json:"tags"`
+	IP             string    `json:"ip"`
+	LastSeen       time.Time `json:"last_seen"`
+	Presence       string    `json:"presence,omitempty"`
+	Country        string    `json:"country"`
+	GeoLocation    string    `json:"geo_location"`
+	Lat            float64   `json:"lat"`
+	Lon            float64   `json:"lon"`
+}
+
+var machineExportColumns = []string{"id", "no_serie", "version", "is_active", "autorise_alarme", "tags", "ip", "last_seen", "presence", "country", "geo_location", "lat", "lon"}
+
+func (m *machineExport) row() []string {
+	return []string{
+		strconv.Itoa(m.ID), csvText(m.NoSerie), csvText(m.Version), strconv.FormatBool(m.IsActive), strconv.FormatBool(m.AutoriseAlarme),
+		csvText(strings.Join(m.Tags, ";")), csvText(m.IP), csvTime(m.LastSeen), m.Presence, csvText(m.Country), csvText(m.GeoLocation),
+		csvFloat(m.Lat), csvFloat(m.Lon),
+	}
+}
+
+// presenceState is the current state of a resource, empty without presence tracking
+func (rt *Router) presenceState(kind string, lastSeen, now time.Time) string {
+	if rt.Presence == nil {
+		return ""
+	}
+	state, _ := rt.Presence.Policy.State(kind, lastSeen, now)
+	return state
+}
+
+// GET /api/admin/machines/export?format=csv|jsonl|geojson with the filters and
+// sort of GET /api/admin/machines
+func (rt *Router) HandleAdminExportMachines(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.fleetExportCaller(w, r)
+	if !ok {
+		return
+	}
+	format, ok := exportFormat(w, r, true)
+	if !ok {
+		return
+	}
+	q, ok := rt.fleetQuery(w, r, models.PresenceMachine)
+	if !ok {
+		return
+	}
+	q.Cursor, q.Limit = "", exportPageSize
+
+	// The first page is read before the headers, to answer errors with a status
+	page, err := rt.Store.QueryMachines(q)
+	if errors.Is(err, data.ErrInvalidFleetQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to export machines: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	e := newExporter(w, "machines", format, machineExportColumns)
+
+	now := time.Now()
+	for err == nil {
+		for _, d := range page.Items {
+			m := &machineExport{
+				ID: d.ID, NoSerie: d.NoSerie, Version: d.Version, IsActive: d.IsActive, AutoriseAlarme: d.AutoriseAlarme,
+				Tags: d.Tags, IP: d.IP, LastSeen: d.LastSeen, Presence: rt.presenceState(models.PresenceMachine, d.LastSeen, now),
+				Country: d.Country, GeoLocation: d.GeoLocation, Lat: d.Lat, Lon: d.Lon,
+			}
+			if err = e.write(m, m.row(), m.Lat, m.Lon); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = e.flush()
+		}
+		if err != nil || page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+		page, err = rt.Store.QueryMachines(q)
+	}
+	if err == nil {
+		err = e.close()
+	}
+	rt.auditExport(r, caller, "EXPORT_MACHINES", "MACHINE", e.format, e.written, err)
+}
+
+// gatewayExport is an exported gateway: the last report, summarized
+type gatewayExport struct {
+	Hostname    string          `json:"hostname"`
+	GatewayID   string          `json:"gateway_id,omitempty"`
+	IP          string          `json:"ip"`
+	LastSeen    time.Time       `json:"last_seen"`
+	Presence    string          `json:"presence,omitempty"`
+	CPU         float64         `json:"cpu_usage_percent"`
+	Memory      float64         `json:"memory_percent"`
+	Disk        float64         `json:"disk_percent"`
+	ClientCount int             `json:"client_count"`
+	Services    map[string]bool `json:"services"`
+	Country     string          `json:"country"`
+	GeoLocation string          `json:"geo_location"`
+	Lat         float64         `json:"lat"`
+	Lon         float64         `json:"lon"`
+}
+
+var gatewayExportColumns = []string{"hostname", "gateway_id", "ip", "last_seen", "presence", "cpu_usage_percent", "memory_percent", "disk_percent", "client_count", "services_down", "country", "geo_location", "lat", "lon"}
+
+func (g *gatewayExport) row() []string {
+	var down []string
+	for name, up := range g.Services {
+		if !up {
+			down = append(down, name)
+		}
+	}
+	sort.Strings(down)
+	return []string{
+		csvText(g.Hostname), csvText(g.GatewayID), csvText(g.IP), csvTime(g.LastSeen), g.Presence,
+		csvFloat(g.CPU), csvFloat(g.Memory), csvFloat(g.Disk), strconv.Itoa(g.ClientCount), csvText(strings.Join(down, ";")),
+		csvText(g.Country), csvText(g.GeoLocation), csvFloat(g.Lat), csvFloat(g.Lon),
+	}
+}
+
+// GET /api/admin/gateways/export?format=csv|jsonl|geojson with the filters and
+// sort of GET /api/admin/gateways
+func (rt *Router) HandleAdminExportGateways(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.fleetExportCaller(w, r)
+	if !ok {
+		return
+	}
+	format, ok := exportFormat(w, r, true)
+	if !ok {
+		return
+	}
+	q, ok := rt.fleetQuery(w, r, models.PresenceGateway)
+	if !ok {
+		return
+	}
+	q.Cursor, q.Limit = "", exportPageSize
+
+	page, err := rt.Store.QueryGateways(q)
+	if errors.Is(err, data.ErrInvalidFleetQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to export gateways: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	e := newExporter(w, "gateways", format, gatewayExportColumns)
+
+	now := time.Now()
+	for err == nil {
+		for _, gw := range page.Items {
+			point := gw.MetricsPoint()
+			g := &gatewayExport{
+				Hostname: gw.Hostname, GatewayID: gw.GatewayID, IP: gw.IP, LastSeen: gw.LastSeen,
+				Presence: rt.presenceState(models.PresenceGateway, gw.LastSeen, now),
+				CPU:      point.CPU, Memory: point.Memory, Disk: point.Disk, ClientCount: gw.ClientCount, Services: gw.Services,
+				Country: gw.Country, GeoLocation: gw.GeoLocation, Lat: gw.Lat, Lon: gw.Lon,
+			}
+			if err = e.write(g, g.row(), g.Lat, g.Lon); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = e.flush()
+		}
+		if err != nil || page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+		page, err = rt.Store.QueryGateways(q)
+	}
+	if err == nil {
+		err = e.close()
+	}
+	rt.auditExport(r, caller, "EXPORT_GATEWAYS", "GATEWAY", e.format, e.written, err)
+}
+
+var userExportColumns = []string{"id", "email", "role", "first_name", "last_name", "provider", "created_at", "last_login", "forbidden_at", "linked_machine_id", "linked_gateway_id", "verified_at"}
+
+func userExportRow(u *models.User) []string {
+	row := []string{
+		strconv.Itoa(u.ID), csvText(u.Email), u.Role, csvText(u.FirstName), csvText(u.LastName), u.Provider,
+		csvTime(u.CreatedAt), csvTime(u.LastLogin), "", "", "", "",
+	}
+	if u.ForbiddenAt != nil {
+		row[8] = csvTime(*u.ForbiddenAt)
+	}
+	if u.LinkedMachineID != nil {
+		row[9] = strconv.Itoa(*u.LinkedMachineID)
+	}
+	if u.LinkedGatewayID != nil {
+		row[10] = csvText(*u.LinkedGatewayID)
+	}
+	if u.VerifiedAt != nil {
+		row[11] = csvTime(*u.VerifiedAt)
+	}
+	return row
+}
+
+// GET /api/admin/users/export?format=csv|jsonl&q=&role=
+// Same scope as GET /api/admin/users: an admin_local exports the users of its machine
+func (rt *Router) HandleAdminExportUsers(w http.ResponseWriter, r *http.Request) {
+	if rt.UserStore == nil {
+		http.Error(w, "User Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	format, ok := exportFormat(w, r, false)
+	if !ok {
+		return
+	}
+
+	var users []*models.User
+	var err error
+	switch {
+	case isGlobalCaller(caller):
+		users, err = rt.UserStore.GetAllUsers()
+	case caller.Role == models.RoleAdminLocal:
+		if caller.LinkedMachineID != nil {
+			users, err = rt.UserStore.GetUsersByMachineID(*caller.LinkedMachineID)
+		}
+	default:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to export users: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	search := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	role := r.URL.Query().Get("role")
+	e := newExporter(w, "users", format, userExportColumns)
+	for _, u := range users {
+		if role != "" && u.Role != role {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(u.Email+" "+u.FirstName+" "+u.LastName), search) {
+			continue
+		}
+		if err = e.write(u, userExportRow(u), 0, 0); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = e.close()
+	}
+	rt.auditExport(r, caller, "EXPORT_USERS", "USER", e.format, e.written, err)
+}
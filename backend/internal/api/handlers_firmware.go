@@ -0,0 +1,454 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/middleware"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// DefaultFirmwareBlockSize is the size of one /api/getversioncontent block
+	DefaultFirmwareBlockSize = 1024
+	maxFirmwareUpload        = 16 << 20
+	maxMachineTags           = 16
+)
+
+// ============================================================================
+// Legacy download protocol (see essensys-client.md, "Download firmware")
+// ============================================================================
+
+// POST /api/getversioncontent/{index}
+func (rt *Router) HandleGetVersionContent(w http.ResponseWriter, r *http.Request) {
+	clientID, _ := r.Context().Value(middleware.ClientIDKey).(string)
+	machineID, ok := r.Context().Value(middleware.MachineIDKey).(int)
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if !ok || err != nil || index < 0 || rt.FirmwareStore == nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	version, block, err := rt.FirmwareStore.ServeBlock(machineID, index, rt.firmwareBlockSize())
+	if errors.Is(err, data.ErrNoUpdate) || errors.Is(err, data.ErrBlockOutOfRange) {
+		log.Printf("[API] Firmware block %d requested by %s: %v", index, clientID, err)
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to read firmware %s block %d: %v", version, index, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if index == 0 {
+		log.Printf("[API] Firmware %s download started by %s", version, clientID)
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(block)))
+	w.Write(block)
+}
+
+// POST /api/endversioncontent
+func (rt *Router) HandleEndVersionContent(w http.ResponseWriter, r *http.Request) {
+	clientID, _ := r.Context().Value(middleware.ClientIDKey).(string)
+	machineID, ok := r.Context().Value(middleware.MachineIDKey).(int)
+
+	if ok && rt.FirmwareStore != nil {
+		if err := rt.FirmwareStore.RecordEnd(machineID); err != nil {
+			log.Printf("[API] Unexpected end of firmware download from %s: %v", clientID, err)
+		} else {
+			log.Printf("[API] Firmware download completed by %s, waiting for reboot", clientID)
+		}
+	}
+
+	// Legacy client expects "201 Created"
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (rt *Router) firmwareBlockSize() int {
+	if rt.FirmwareBlockSize > 0 {
+		return rt.FirmwareBlockSize
+	}
+	return DefaultFirmwareBlockSize
+}
+
+// ============================================================================
+// Admin: releases
+// ============================================================================
+
+// firmwareAdmin checks the store and returns the caller; mutations need admin_global
+func (rt *Router) firmwareAdmin(w http.ResponseWriter, r *http.Request, write bool) (*models.User, bool) {
+	if rt.FirmwareStore == nil {
+		http.Error(w, "Firmware Store not initialized", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return nil, false
+	}
+	if write && !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return nil, false
+	}
+	return caller, true
+}
+
+// GET /api/admin/firmware
+func (rt *Router) HandleAdminGetFirmware(w http.ResponseWriter, r *http.Request) {
+	if _, ok := rt.firmwareAdmin(w, r, false); !ok {
+		return
+	}
+	releases, err := rt.FirmwareStore.GetReleases()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(releases)
+}
+
+// POST /api/admin/firmware (multipart: version, notes, optional sha256, file)
+func (rt *Router) HandleAdminUploadFirmware(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.firmwareAdmin(w, r, true)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFirmwareUpload+1<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	n, ok := models.ParseFirmwareVersion(r.FormValue("version"))
+	if !ok {
+		http.Error(w, "version must look like V123", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxFirmwareUpload {
+		http.Error(w, "firmware image too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rel := &models.FirmwareRelease{
+		Version:    fmt.Sprintf("V%d", n),
+		Filename:   header.Filename,
+		SHA256:     strings.ToLower(strings.TrimSpace(r.FormValue("sha256"))),
+		Notes:      r.FormValue("notes"),
+		UploadedBy: actorName,
+		UploadedAt: time.Now(),
+	}
+	err = rt.FirmwareStore.AddRelease(rel, file)
+	if errors.Is(err, data.ErrReleaseExists) {
+		http.Error(w, "Release already exists", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, data.ErrInvalidImage) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to store firmware %s: %v", rel.Version, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	rt.LogAudit(actorID, actorName, "UPLOAD_FIRMWARE", "FIRMWARE", rel.Version, getIP(r),
+		fmt.Sprintf("%s (%d bytes, sha256 %s)", rel.Filename, rel.Size, rel.SHA256))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rel)
+}
+
+// DELETE /api/admin/firmware/{version}
+func (rt *Router) HandleAdminDeleteFirmware(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.firmwareAdmin(w, r, true)
+	if !ok {
+		return
+	}
+
+	version := chi.URLParam(r, "version")
+	err := rt.FirmwareStore.DeleteRelease(version)
+	switch {
+	case errors.Is(err, data.ErrReleaseNotFound):
+		http.Error(w, "Release not found", http.StatusNotFound)
+		return
+	case errors.Is(err, data.ErrReleaseInUse):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "DELETE_FIRMWARE", "FIRMWARE", version, getIP(r), "Deleted firmware release")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ============================================================================
+// Admin: campaigns
+// ============================================================================
+
+// cleanList trims, drops empty entries and duplicates
+func cleanList(in []string) []string {
+	out := make([]string, 0, len(in))
+	seen := map[string]bool{}
+	for _, s := range in {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func validateCampaignRequest(req *models.FirmwareCampaignRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return errors.New("name is required")
+	}
+	n, ok := models.ParseFirmwareVersion(req.TargetVersion)
+	if !ok {
+		return errors.New("target_version must look like V123")
+	}
+	req.TargetVersion = fmt.Sprintf("V%d", n)
+	if req.Percentage < 0 || req.Percentage > 100 {
+		return errors.New("percentage must be between 0 and 100")
+	}
+	if req.MaxFailures < 0 {
+		return errors.New("max_failures must be positive")
+	}
+	req.Tags = cleanList(req.Tags)
+	req.Serials = cleanList(req.Serials)
+	if req.Percentage == 0 && len(req.Tags) == 0 && len(req.Serials) == 0 {
+		return errors.New("a percentage, tags or serials are required")
+	}
+	return nil
+}
+
+// GET /api/admin/firmware/campaigns
+func (rt *Router) HandleAdminGetCampaigns(w http.ResponseWriter, r *http.Request) {
+	if _, ok := rt.firmwareAdmin(w, r, false); !ok {
+		return
+	}
+	campaigns, err := rt.FirmwareStore.GetCampaigns()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(campaigns)
+}
+
+// GET /api/admin/firmware/campaigns/{id}
+func (rt *Router) HandleAdminGetCampaign(w http.ResponseWriter, r *http.Request) {
+	if _, ok := rt.firmwareAdmin(w, r, false); !ok {
+		return
+	}
+	progress, err := rt.FirmwareStore.GetCampaign(chi.URLParam(r, "id"))
+	if errors.Is(err, data.ErrCampaignNotFound) {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
+
+// POST /api/admin/firmware/campaigns
+func (rt *Router) HandleAdminCreateCampaign(w http.ResponseWriter, r *http.Request) {
+	rt.saveCampaign(w, r, "")
+}
+
+// PUT /api/admin/firmware/campaigns/{id}
+func (rt *Router) HandleAdminUpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	rt.saveCampaign(w, r, chi.URLParam(r, "id"))
+}
+
+func (rt *Router) saveCampaign(w http.ResponseWriter, r *http.Request, id string) {
+	caller, ok := rt.firmwareAdmin(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req models.FirmwareCampaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	if err := validateCampaignRequest(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	now := time.Now()
+	c := &models.FirmwareCampaign{CreatedBy: actorName, CreatedAt: now}
+	action := "CREATE_FIRMWARE_CAMPAIGN"
+	if id != "" {
+		existing, err := rt.FirmwareStore.GetCampaign(id)
+		if errors.Is(err, data.ErrCampaignNotFound) {
+			http.Error(w, "Campaign not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		c = existing.FirmwareCampaign
+		if c.Status == models.CampaignCompleted || c.Status == models.CampaignCancelled {
+			http.Error(w, "Campaign is closed", http.StatusConflict)
+			return
+		}
+		if c.Status != models.CampaignDraft && c.TargetVersion != req.TargetVersion {
+			http.Error(w, "target_version can only change while the campaign is a draft", http.StatusConflict)
+			return
+		}
+		action = "UPDATE_FIRMWARE_CAMPAIGN"
+	}
+	c.Name = req.Name
+	c.TargetVersion = req.TargetVersion
+	c.Percentage = req.Percentage
+	c.Tags = req.Tags
+	c.Serials = req.Serials
+	c.MaxFailures = req.MaxFailures
+	c.UpdatedAt = now
+
+	err := rt.FirmwareStore.SaveCampaign(c)
+	if errors.Is(err, data.ErrReleaseNotFound) {
+		http.Error(w, "Unknown target_version", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to save firmware campaign: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	rt.LogAudit(actorID, actorName, action, "FIRMWARE_CAMPAIGN", c.ID, getIP(r),
+		fmt.Sprintf("Campaign '%s' -> %s (%d%%, tags %v, serials %v)", c.Name, c.TargetVersion, c.Percentage, c.Tags, c.Serials))
+
+	w.Header().Set("Content-Type", "application/json")
+	if id == "" {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(c)
+}
+
+// campaignTransitions lists the allowed status changes
+var campaignTransitions = map[string][]string{
+	models.CampaignDraft:  {models.CampaignActive, models.CampaignCancelled},
+	models.CampaignActive: {models.CampaignPaused, models.CampaignCompleted, models.CampaignCancelled},
+	models.CampaignPaused: {models.CampaignActive, models.CampaignCompleted, models.CampaignCancelled},
+}
+
+// POST /api/admin/firmware/campaigns/{id}/status
+func (rt *Router) HandleAdminSetCampaignStatus(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.firmwareAdmin(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	c, err := rt.FirmwareStore.GetCampaign(id)
+	if errors.Is(err, data.ErrCampaignNotFound) {
+		http.Error(w, "Campaign not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	allowed := false
+	for _, s := range campaignTransitions[c.Status] {
+		allowed = allowed || s == req.Status
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("Cannot move campaign from %s to %s", c.Status, req.Status), http.StatusConflict)
+		return
+	}
+	if err := rt.FirmwareStore.SetCampaignStatus(id, req.Status); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "SET_FIRMWARE_CAMPAIGN_STATUS", "FIRMWARE_CAMPAIGN", id, getIP(r),
+		fmt.Sprintf("Campaign '%s': %s -> %s", c.Name, c.Status, req.Status))
+
+	c.Status = req.Status
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// PUT /api/admin/machines/{id}/tags
+func (rt *Router) HandleAdminSetMachineTags(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+	detail, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	tags := cleanList(req.Tags)
+	if len(tags) > maxMachineTags {
+		http.Error(w, fmt.Sprintf("too many tags (max %d)", maxMachineTags), http.StatusBadRequest)
+		return
+	}
+	if err := rt.Store.SetMachineTags(detail.ID, tags); err != nil {
+		log.Printf("[API] Failed to set tags of machine %d: %v", detail.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "SET_MACHINE_TAGS", "MACHINE", strconv.Itoa(detail.ID), getIP(r),
+		fmt.Sprintf("Tags of %s: %v", detail.NoSerie, tags))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+}
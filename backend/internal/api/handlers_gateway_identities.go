@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// gatewayEnrollmentAdmin checks the guard and the caller: every admin may list
+// the gateways it can see, only admin_global may issue or revoke tokens
+func (rt *Router) gatewayEnrollmentAdmin(w http.ResponseWriter, r *http.Request, write bool) (*models.User, bool) {
+	if rt.GatewayGuard == nil {
+		http.Error(w, "Gateway enrollment not initialized", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return nil, false
+	}
+	if write && !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return nil, false
+	}
+	return caller, true
+}
+
+// gatewayIdentityFromParam loads the {id} gateway identity
+func (rt *Router) gatewayIdentityFromParam(w http.ResponseWriter, r *http.Request) (*models.GatewayIdentity, bool) {
+	identity, err := rt.GatewayGuard.Identities.GetGatewayIdentity(chi.URLParam(r, "id"))
+	if errors.Is(err, data.ErrGatewayIdentityNotFound) {
+		http.Error(w, "Gateway identity not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return identity, true
+}
+
+// GET /api/admin/gateways/enrollments
+func (rt *Router) HandleAdminGetGatewayIdentities(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.gatewayEnrollmentAdmin(w, r, false)
+	if !ok {
+		return
+	}
+	identities, err := rt.GatewayGuard.Identities.GetGatewayIdentities()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	visible := make([]*models.GatewayIdentity, 0, len(identities))
+	for _, identity := range identities {
+		if canViewGateway(caller, identity.Hostname) {
+			visible = append(visible, identity)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+// POST /api/admin/gateways/enrollments
+// Issues the first token of a gateway. The token is returned once.
+func (rt *Router) HandleAdminEnrollGateway(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.gatewayEnrollmentAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	var req models.EnrollGatewayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Hostname = strings.TrimSpace(req.Hostname)
+	if req.Hostname == "" || len(req.Hostname) > 255 {
+		http.Error(w, "hostname is required (255 chars max)", http.StatusBadRequest)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	token, hash, prefix := data.NewGatewayToken()
+	identity := &models.GatewayIdentity{
+		Hostname:    req.Hostname,
+		TokenHash:   hash,
+		TokenPrefix: prefix,
+		CreatedBy:   actorName,
+		CreatedAt:   time.Now(),
+	}
+	err := rt.GatewayGuard.Identities.CreateGatewayIdentity(identity)
+	if errors.Is(err, data.ErrGatewayHostnameTaken) {
+		http.Error(w, "Gateway already enrolled: rotate or revoke its token", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to enroll gateway: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.GatewayGuard.Release(identity.Hostname)
+
+	rt.LogAudit(actorID, actorName, "ENROLL_GATEWAY", "GATEWAY", identity.ID, getIP(r),
+		"Enrolled gateway "+identity.Hostname+" (token "+prefix+"...)")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.GatewayTokenResponse{Identity: identity, Token: token})
+}
+
+// POST /api/admin/gateways/enrollments/{id}/rotate
+// The previous token stays valid for the grace period (GATEWAY_TOKEN_GRACE).
+func (rt *Router) HandleAdminRotateGatewayToken(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.gatewayEnrollmentAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	identity, ok := rt.gatewayIdentityFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	token, hash, prefix := data.NewGatewayToken()
+	err := rt.GatewayGuard.Identities.RotateGatewayToken(identity.ID, hash, prefix, rt.GatewayTokenGrace)
+	if errors.Is(err, data.ErrGatewayIdentityRevoked) {
+		http.Error(w, "Gateway identity revoked: enroll the gateway again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to rotate gateway token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rotated, ok := rt.gatewayIdentityFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "ROTATE_GATEWAY_TOKEN", "GATEWAY", identity.ID, getIP(r),
+		"Rotated token of "+identity.Hostname+" ("+identity.TokenPrefix+"... -> "+prefix+"..., previous valid for "+rt.GatewayTokenGrace.String()+")")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.GatewayTokenResponse{Identity: rotated, Token: token})
+}
+
+// POST /api/admin/gateways/enrollments/{id}/revoke
+// Every token of the gateway stops working at once; enroll it again to re-admit it.
+func (rt *Router) HandleAdminRevokeGateway(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.gatewayEnrollmentAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	identity, ok := rt.gatewayIdentityFromParam(w, r)
+	if !ok {
+		return
+	}
+
+	err := rt.GatewayGuard.Identities.RevokeGatewayIdentity(identity.ID)
+	if errors.Is(err, data.ErrGatewayIdentityRevoked) {
+		http.Error(w, "Gateway identity already revoked", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("[API] Failed to revoke gateway: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "REVOKE_GATEWAY", "GATEWAY", identity.ID, getIP(r),
+		"Revoked gateway "+identity.Hostname+" (token "+identity.TokenPrefix+"...)")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/admin/gateways/quarantine
+// Reports refused by the quarantine policy, to be enrolled
+func (rt *Router) HandleAdminGetGatewayQuarantine(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.gatewayEnrollmentAdmin(w, r, false)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) && caller.Role != models.RoleSupport {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rt.GatewayGuard.Quarantined())
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultFleetWindow = 7 * 24 * time.Hour
+	// A disk above this percentage, or full within diskWarningHorizon, is reported
+	diskWarningPercent = 90
+	diskWarningHorizon = 7.0 // days
+)
+
+// autoGatewayResolution picks the finest resolution still kept for a window
+func autoGatewayResolution(window time.Duration) string {
+	switch {
+	case window <= 6*time.Hour:
+		return models.GatewayResolutionRaw
+	case window <= 7*24*time.Hour:
+		return models.GatewayResolution5m
+	default:
+		return models.GatewayResolutionHourly
+	}
+}
+
+// GET /api/admin/gateways/{hostname}/metrics?from=&to=&resolution=raw|5m|1h|auto
+func (rt *Router) HandleAdminGatewayMetrics(w http.ResponseWriter, r *http.Request) {
+	if rt.GatewayMetricsStore == nil {
+		http.Error(w, "Gateway Metrics Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	hostname := chi.URLParam(r, "hostname")
+	if !canViewGateway(caller, hostname) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	key, err := rt.gatewayKey(hostname)
+	if err != nil {
+		log.Printf("[API] Failed to look up gateway %s: %v", hostname, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	params := r.URL.Query()
+	q := models.GatewayMetricsQuery{Gateway: key, To: time.Now()}
+	if v := params.Get("to"); v != "" {
+		if q.To, err = parseTimeParam(v); err != nil {
+			http.Error(w, "Invalid 'to' (RFC 3339 or unix seconds)", http.StatusBadRequest)
+			return
+		}
+	}
+	q.From = q.To.Add(-defaultTelemetryWindow)
+	if v := params.Get("from"); v != "" {
+		if q.From, err = parseTimeParam(v); err != nil {
+			http.Error(w, "Invalid 'from' (RFC 3339 or unix seconds)", http.StatusBadRequest)
+			return
+		}
+	}
+	if !q.From.Before(q.To) {
+		http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+
+	resolution := params.Get("resolution")
+	if resolution == "" || resolution == "auto" {
+		resolution = autoGatewayResolution(q.To.Sub(q.From))
+	}
+	bucket, ok := models.GatewayResolutions[resolution]
+	if !ok {
+		http.Error(w, "Invalid 'resolution' (raw, 5m, 1h or auto)", http.StatusBadRequest)
+		return
+	}
+	q.Resolution = bucket
+
+	points, err := rt.GatewayMetricsStore.QueryGatewayMetrics(q)
+	if err != nil {
+		log.Printf("[API] Failed to query gateway metrics: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.GatewayMetricsResponse{
+		Hostname:   hostname,
+		Resolution: resolution,
+		From:       q.From,
+		To:         q.To,
+		Points:     points,
+	})
+}
+
+// gatewayKey returns the key of the history of hostname: the ID of its
+// enrolled identity, else the hostname
+func (rt *Router) gatewayKey(hostname string) (string, error) {
+	if rt.GatewayGuard == nil {
+		return hostname, nil
+	}
+	identity, err := rt.GatewayGuard.Identities.GetGatewayIdentityByHostname(hostname)
+	if err != nil || identity == nil {
+		return hostname, err
+	}
+	return identity.ID, nil
+}
+
+// GET /api/admin/gateways/metrics?window=168h
+func (rt *Router) HandleAdminGatewayFleetMetrics(w http.ResponseWriter, r *http.Request) {
+	if rt.GatewayMetricsStore == nil {
+		http.Error(w, "Gateway Metrics Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) && caller.Role != models.RoleSupport {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	window := defaultFleetWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid 'window' (e.g. 24h, 168h)", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+
+	gateways, err := rt.Store.GetGateways()
+	if err != nil {
+		log.Printf("[API] Failed to get gateways: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Trends are fitted on rollups: raw points are not kept long enough
+	resolution := models.GatewayResolutions[models.GatewayResolution5m]
+	if window > 7*24*time.Hour {
+		resolution = models.GatewayResolutions[models.GatewayResolutionHourly]
+	}
+	now := time.Now()
+	from := now.Add(-window)
+
+	summary := models.GatewayFleetSummary{
+		Window:   window.String(),
+		Gateways: len(gateways),
+		Trends:   make([]models.GatewayTrend, 0, len(gateways)),
+	}
+	for _, gw := range gateways {
+		points, err := rt.GatewayMetricsStore.QueryGatewayMetrics(models.GatewayMetricsQuery{
+			Gateway:    gw.Key(),
+			Resolution: resolution,
+			From:       from,
+			To:         now,
+		})
+		if err != nil {
+			log.Printf("[API] Failed to query gateway metrics: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		trend := data.GatewayTrendOf(gw, points)
+		summary.Trends = append(summary.Trends, trend)
+
+		if gw.LastSeen.Before(from) {
+			continue
+		}
+		summary.Reporting++
+		summary.TotalClients += trend.ClientCount
+		summary.AvgCPU += trend.CPU
+		summary.AvgMemory += trend.Memory
+		summary.AvgDisk += trend.Disk
+		if trend.Disk >= diskWarningPercent || (trend.DiskFullInDays != nil && *trend.DiskFullInDays <= diskWarningHorizon) {
+			summary.DiskWarnings++
+		}
+	}
+	if summary.Reporting > 0 {
+		n := float64(summary.Reporting)
+		summary.AvgCPU /= n
+		summary.AvgMemory /= n
+		summary.AvgDisk /= n
+	}
+
+	// Soonest full disk first, then gateways whose disk is not growing
+	sort.SliceStable(summary.Trends, func(i, j int) bool {
+		a, b := summary.Trends[i].DiskFullInDays, summary.Trends[j].DiskFullInDays
+		switch {
+		case a != nil && b != nil:
+			return *a < *b
+		case a != nil || b != nil:
+			return a != nil
+		default:
+			return summary.Trends[i].Hostname < summary.Trends[j].Hostname
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
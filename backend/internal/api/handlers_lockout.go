@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// writeLoginLocked refuses a login while the account or the IP is locked.
+// The answer is the same for both, and for unknown accounts.
+func writeLoginLocked(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":       "too_many_attempts",
+		"retry_after": seconds,
+	})
+}
+
+// recordLoginFailure counts a failed password for email (user nil if the
+// account does not exist) and reports the lockouts it starts
+func (rt *Router) recordLoginFailure(r *http.Request, email string, user *models.User) {
+	ip := getIP(r)
+	account, addr, err := rt.LoginGuard.Failure(email, ip, time.Now())
+	if err != nil {
+		log.Printf("[AUTH] Failed to record login failure: %v", err)
+		return
+	}
+	if addr != nil {
+		log.Printf("[AUTH] IP %s locked until %s after repeated login failures", ip, addr.LockedUntil.Format(time.RFC3339))
+		rt.LogAudit(0, "system", "LOGIN_IP_LOCKED", "IP", ip, ip,
+			fmt.Sprintf("Locked until %s (lockout #%d)", addr.LockedUntil.Format(time.RFC3339), addr.Lockouts))
+	}
+	if account != nil && user != nil {
+		log.Printf("[AUTH] Account of user %d locked until %s after repeated login failures", user.ID, account.LockedUntil.Format(time.RFC3339))
+		rt.LogAudit(user.ID, user.Email, "ACCOUNT_LOCKED", "USER", user.Email, ip,
+			fmt.Sprintf("Locked until %s (lockout #%d)", account.LockedUntil.Format(time.RFC3339), account.Lockouts))
+		rt.sendLockoutNotice(user, *account.LockedUntil)
+	}
+}
+
+// sendLockoutNotice tells user that their account was locked, in the background
+func (rt *Router) sendLockoutNotice(user *models.User, until time.Time) {
+	base, err := frontendURL()
+	if err != nil {
+		log.Printf("[AUTH] No lockout notice to user %d: %v", user.ID, err)
+		return
+	}
+	link := base + "forgot-password"
+	body := fmt.Sprintf(`<p>Bonjour %s,</p>
+<p>Suite à plusieurs tentatives de connexion échouées, la connexion à votre compte Essensys est bloquée pendant %s.</p>
+<p>Si ces tentatives ne viennent pas de vous, quelqu'un essaie peut-être de deviner votre mot de passe : <a href="%s">choisissez-en un nouveau</a>, ce qui débloque aussi votre compte.</p>`,
+		html.EscapeString(user.FirstName), validityText(time.Until(until).Round(time.Minute)), html.EscapeString(link))
+	go func() {
+		if err := sendEmail([]string{user.Email}, "Connexion à votre compte Essensys bloquée", body); err != nil {
+			log.Printf("[AUTH] Failed to send lockout notice to user %d: %v", user.ID, err)
+		}
+	}()
+}
+
+// POST /api/admin/users/{id}/unlock
+// Lifts the login lockout of a user and forgets their failures
+func (rt *Router) HandleAdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, idStr, ok := rt.authorizeAdminUserAction(w, r, actionUnlock)
+	if !ok {
+		return
+	}
+	if err := rt.LoginGuard.UnlockAccount(target.Email); err != nil {
+		log.Printf("[API] Failed to unlock user %d: %v", target.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(caller.ID, caller.Email, "UNLOCK_USER", "USER", idStr, getIP(r), "Unlocked login of "+target.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/admin/lockouts
+// Lists the accounts and IPs locked after repeated login failures
+func (rt *Router) HandleAdminGetLockouts(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) && caller.Role != models.RoleSupport {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+	lockouts, err := rt.LoginGuard.Lockouts(time.Now())
+	if err != nil {
+		log.Printf("[API] Failed to list lockouts: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if rt.UserStore != nil {
+		for _, l := range lockouts {
+			if l.Kind != models.LoginThrottleAccount {
+				continue
+			}
+			if user, _ := rt.UserStore.GetUserByEmail(l.Value); user != nil {
+				l.UserID = user.ID
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lockouts)
+}
+
+// DELETE /api/admin/lockouts/ip/{ip}
+// Lifts the login lockout of an IP, e.g. the shared NAT of an office
+func (rt *Router) HandleAdminUnlockIP(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	if !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+	ip := chi.URLParam(r, "ip")
+	if err := rt.LoginGuard.UnlockIP(ip); err != nil {
+		log.Printf("[API] Failed to unlock IP %s: %v", ip, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	userID, username := auditActor(caller)
+	rt.LogAudit(userID, username, "UNLOCK_IP", "IP", ip, getIP(r), "Unlocked login from "+ip)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/metrics"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// validSerial restricts serials to what is safe as a legacy client ID
+var validSerial = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// globalMachineAction resolves the {id} machine for a lifecycle change reserved to admin_global
+func (rt *Router) globalMachineAction(w http.ResponseWriter, r *http.Request) (*models.User, *models.MachineDetail, bool) {
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	if !isGlobalCaller(caller) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return nil, nil, false
+	}
+	detail, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return nil, nil, false
+	}
+	return caller, detail, true
+}
+
+// writeMachineStoreError maps Store errors of the lifecycle methods
+func writeMachineStoreError(w http.ResponseWriter, machineID int, err error) {
+	switch {
+	case errors.Is(err, data.ErrMachineNotFound):
+		http.Error(w, "Machine not found", http.StatusNotFound)
+	case errors.Is(err, data.ErrSerialTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("[API] Failed to update machine %d: %v", machineID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// GET /api/admin/machines/{id}
+func (rt *Router) HandleAdminGetMachine(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	detail, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// POST /api/admin/machines/{id}/activate
+func (rt *Router) HandleAdminActivateMachine(w http.ResponseWriter, r *http.Request) {
+	rt.setMachineActive(w, r, true)
+}
+
+// POST /api/admin/machines/{id}/deactivate
+func (rt *Router) HandleAdminDeactivateMachine(w http.ResponseWriter, r *http.Request) {
+	rt.setMachineActive(w, r, false)
+}
+
+func (rt *Router) setMachineActive(w http.ResponseWriter, r *http.Request, active bool) {
+	caller, detail, ok := rt.globalMachineAction(w, r)
+	if !ok {
+		return
+	}
+	if detail.IsActive == active {
+		http.Error(w, "Machine status unchanged", http.StatusConflict)
+		return
+	}
+	if err := rt.Store.SetMachineActive(detail.ID, active); err != nil {
+		writeMachineStoreError(w, detail.ID, err)
+		return
+	}
+
+	action, verb := "ACTIVATE_MACHINE", "Activated"
+	if !active {
+		action, verb = "DEACTIVATE_MACHINE", "Deactivated"
+	}
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, action, "MACHINE", strconv.Itoa(detail.ID), getIP(r), verb+" machine "+detail.NoSerie)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PUT /api/admin/machines/{id}/serial
+func (rt *Router) HandleAdminRenameMachine(w http.ResponseWriter, r *http.Request) {
+	caller, detail, ok := rt.globalMachineAction(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		NoSerie string `json:"no_serie"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	noSerie := strings.TrimSpace(req.NoSerie)
+	if !validSerial.MatchString(noSerie) {
+		http.Error(w, "no_serie must be 1-64 letters, digits, '.', '_' or '-'", http.StatusBadRequest)
+		return
+	}
+	// detail may be shared with the store: keep the old serial before renaming
+	oldSerie := detail.NoSerie
+	if noSerie == oldSerie {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := rt.Store.RenameMachine(detail.ID, noSerie); err != nil {
+		writeMachineStoreError(w, detail.ID, err)
+		return
+	}
+	// The action queue is keyed by serial (the legacy client ID)
+	if rt.ActionStore != nil {
+		if err := rt.ActionStore.RenameQueue(oldSerie, noSerie); err != nil {
+			log.Printf("[API] Failed to move action queue of %s: %v", oldSerie, err)
+		}
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "RENAME_MACHINE", "MACHINE", strconv.Itoa(detail.ID), getIP(r),
+		fmt.Sprintf("Serial %s -> %s", oldSerie, noSerie))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PUT /api/admin/machines/{id}/alarm
+func (rt *Router) HandleAdminSetMachineAlarm(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	detail, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+	if !canManageMachine(caller, detail.ID) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		AutoriseAlarme *bool `json:"autorise_alarme"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.AutoriseAlarme == nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	was := detail.AutoriseAlarme
+	if err := rt.Store.SetMachineAlarm(detail.ID, *req.AutoriseAlarme); err != nil {
+		writeMachineStoreError(w, detail.ID, err)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "SET_MACHINE_ALARM", "MACHINE", strconv.Itoa(detail.ID), getIP(r),
+		fmt.Sprintf("AutoriseAlarme of %s: %t -> %t", detail.NoSerie, was, *req.AutoriseAlarme))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /api/admin/machines/{id}
+func (rt *Router) HandleAdminDeleteMachine(w http.ResponseWriter, r *http.Request) {
+	caller, detail, ok := rt.globalMachineAction(w, r)
+	if !ok {
+		return
+	}
+	if err := rt.Store.DeleteMachine(detail.ID); err != nil {
+		writeMachineStoreError(w, detail.ID, err)
+		return
+	}
+	if rt.ActionStore != nil {
+		if err := rt.ActionStore.DeleteQueue(detail.NoSerie); err != nil {
+			log.Printf("[API] Failed to delete action queue of %s: %v", detail.NoSerie, err)
+		}
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "DELETE_MACHINE", "MACHINE", strconv.Itoa(detail.ID), getIP(r), "Deleted machine "+detail.NoSerie)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/admin/machines/{id}/credentials/reveal
+// Rebuilds the legacy credentials of a machine. admin_global only, and the
+// mandatory reason is written to the audit log.
+func (rt *Router) HandleAdminRevealCredentials(w http.ResponseWriter, r *http.Request) {
+	caller, detail, ok := rt.globalMachineAction(w, r)
+	if !ok {
+		return
+	}
+	if rt.Credentials == nil {
+		http.Error(w, "Credential reveal disabled: CREDENTIAL_KEY is not set", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+	if rt.AuditStore == nil {
+		// The reveal is only allowed once its audit entry is written
+		http.Error(w, "Audit Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	m, err := rt.Store.GetMachineByID(detail.ID)
+	if err == nil && m == nil {
+		err = data.ErrMachineNotFound
+	}
+	if err != nil {
+		writeMachineStoreError(w, detail.ID, err)
+		return
+	}
+	// The store only keeps the hash of the credential, and its sealed copy
+	credential, err := rt.Credentials.Open(m.HashedPkey, m.SealedCredential)
+	if err != nil {
+		http.Error(w, "Credentials not kept for this machine", http.StatusConflict)
+		return
+	}
+
+	// The credential is username + password; without a recorded split, assume two halves
+	split := detail.CredentialSplit
+	if split <= 0 || split > len(credential) {
+		split = len(credential) / 2
+	}
+	decoded := credential[:split] + ":" + credential[split:]
+
+	actorID, actorName := auditActor(caller)
+	entry := &models.AuditLog{
+		UserID:       actorID,
+		Username:     actorName,
+		Action:       "REVEAL_CREDENTIALS",
+		ResourceType: "MACHINE",
+		ResourceID:   strconv.Itoa(detail.ID),
+		IPAddress:    getIP(r),
+		Details:      fmt.Sprintf("Revealed credentials of %s: %s", detail.NoSerie, strings.TrimSpace(req.Reason)),
+		CreatedAt:    time.Now(),
+	}
+	// Unlike LogAudit, a lost entry fails the reveal
+	if err := rt.AuditStore.CreateAuditLog(entry); err != nil {
+		metrics.AuditWriteFailures.Inc()
+		log.Printf("[AUDIT] Failed to write REVEAL_CREDENTIALS on MACHINE %d: %v", detail.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(models.CredentialReveal{
+		MachineID:  m.ID,
+		NoSerie:    m.NoSerie,
+		Credential: credential,
+		RawDecoded: decoded,
+		RawAuth:    base64.StdEncoding.EncodeToString([]byte(decoded)),
+	})
+}
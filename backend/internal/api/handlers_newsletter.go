@@ -9,6 +9,7 @@ import (
     "strconv"
     "time"
 
+    "github.com/essensys-hub/essensys-support-site/backend/internal/metrics"
     "github.com/essensys-hub/essensys-support-site/backend/internal/models"
     "github.com/go-chi/chi/v5"
     "gopkg.in/gomail.v2"
@@ -58,6 +59,12 @@ func sendEmail(to []string, subject, body string) error {
     return nil
 }
 
+// SendEmail sends a message through the SMTP server of the newsletter, for
+// the other subsystems (alert notifications)
+func SendEmail(to []string, subject, body string) error {
+    return sendEmail(to, subject, body)
+}
+
 // POST /api/newsletter/subscribe
 func (rt *Router) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
     var req SubscribeRequest
@@ -205,13 +212,16 @@ func (rt *Router) HandleSendNewsletter(w http.ResponseWriter, r *http.Request) {
         err := sendEmail([]string{s.Email}, n.Subject, n.Content)
         if err != nil {
             log.Printf("[NEWSLETTER] Failed to send to %s: %v", s.Email, err)
+            metrics.NewsletterEmails.WithLabelValues("failed").Inc()
             failCount++
         } else {
+            metrics.NewsletterEmails.WithLabelValues("sent").Inc()
             successCount++
         }
     }
     
     log.Printf("[NEWSLETTER] Finished. Success: %d, Failed: %d", successCount, failCount)
+    metrics.NewsletterSends.Inc()
     
     now := time.Now()
     n.Status = "sent"
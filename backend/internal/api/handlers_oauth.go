@@ -86,8 +86,9 @@ func (r *Router) HandleGoogleCallback(w http.ResponseWriter, req *http.Request)
 
     // 3. User Data (Email)
     var user struct {
-        Email string `json:"email"`
-        ID    string `json:"id"`
+        Email         string `json:"email"`
+        ID            string `json:"id"`
+        VerifiedEmail bool   `json:"verified_email"`
     }
     if err := json.Unmarshal(data, &user); err != nil {
         log.Println("Failed to parse user data")
@@ -127,6 +128,10 @@ func (r *Router) HandleGoogleCallback(w http.ResponseWriter, req *http.Request)
             CreatedAt:  time.Now(),
             LastLogin:  time.Now(),
         }
+        if user.VerifiedEmail {
+            now := time.Now()
+            newUser.VerifiedAt = &now
+        }
 
         if err := r.UserStore.CreateUser(newUser); err != nil {
             log.Println("Failed to create user from Google:", err)
@@ -140,13 +145,32 @@ func (r *Router) HandleGoogleCallback(w http.ResponseWriter, req *http.Request)
             return
         }
         r.UserStore.UpdateLastLogin(userDB.ID)
+        // Signing in with Google proves an address registered with a password
+        if user.VerifiedEmail && !models.IsUserVerified(userDB) {
+            if err := r.markEmailVerified(req, userDB); err != nil {
+                log.Println("Failed to verify e-mail from Google:", err)
+            }
+        }
         role = userDB.Role
     }
     
-    // 5. Generate JWT
-    // 5. Generate JWT
-    expirationTime := time.Now().Add(24 * time.Hour)
-    tokenString, err := GenerateJWT(user.Email, role, expirationTime)
+    // 5. Open a session (access and refresh tokens), after the code if 2FA is enabled
+    challenge, err := r.pendingTwoFactor(userDB)
+    if err != nil {
+        log.Println("Failed to check 2FA:", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if challenge != "" {
+        base, err := frontendURL()
+        if err != nil {
+            http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        http.Redirect(w, req, base + "login#challenge=" + challenge, http.StatusTemporaryRedirect)
+        return
+    }
+    tokens, err := r.startSession(req, userDB, false)
     if err != nil {
         log.Println("Failed to generate token:", err)
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -164,8 +188,8 @@ func (r *Router) HandleGoogleCallback(w http.ResponseWriter, req *http.Request)
     // Using a simpler approach: Set a cookie and redirect to admin
     http.SetCookie(w, &http.Cookie{
         Name:    "admin_token",
-        Value:   tokenString,
-        Expires: expirationTime,
+        Value:   tokens.Token,
+        Expires: time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second),
         Path:    "/",
         // HttpOnly: true, // If we want JS to access it, false. If we want Secure, true.
         // For simple Admin SPA, often JS needs it to send in Bearer header, OR we use cookie auth.
@@ -176,7 +200,7 @@ func (r *Router) HandleGoogleCallback(w http.ResponseWriter, req *http.Request)
     // Redirect to Admin Dashboard
     // Token delivered in the URL fragment (not query string): not sent to the
     // server, not logged, and not leaked via Referer. SPA reads location.hash.
-    http.Redirect(w, req, frontendURL + "admin#token=" + tokenString + "&role=" + role, http.StatusTemporaryRedirect)
+    http.Redirect(w, req, frontendURL + "admin#token=" + tokens.Token + "&refresh_token=" + tokens.RefreshToken + "&role=" + role, http.StatusTemporaryRedirect)
 }
 
 func getUserDataFromGoogle(code string) ([]byte, error) {
@@ -391,12 +415,14 @@ func (r *Router) HandleAppleCallback(w http.ResponseWriter, req *http.Request) {
              role = models.RoleUser
          }
          
+         now := time.Now()
          newUser := &models.User{
             Email:      email,
             Role:       role,
             Provider:   models.ProviderApple,
             CreatedAt:  time.Now(),
             LastLogin:  time.Now(),
+            VerifiedAt: &now, // Apple only hands out verified addresses
         }
         
         if err := r.UserStore.CreateUser(newUser); err != nil {
@@ -411,12 +437,31 @@ func (r *Router) HandleAppleCallback(w http.ResponseWriter, req *http.Request) {
             return
         }
         r.UserStore.UpdateLastLogin(userDB.ID)
+        if !models.IsUserVerified(userDB) {
+            if err := r.markEmailVerified(req, userDB); err != nil {
+                log.Println("Failed to verify e-mail from Apple:", err)
+            }
+        }
         role = userDB.Role
     }
 
-    // 6. Generate Session JWT
-    expirationTime := time.Now().Add(24 * time.Hour)
-    tokenString, err := GenerateJWT(email, role, expirationTime)
+    // 6. Open a session (access and refresh tokens), after the code if 2FA is enabled
+    challenge, err := r.pendingTwoFactor(userDB)
+    if err != nil {
+        log.Println("Failed to check 2FA:", err)
+        http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+        return
+    }
+    if challenge != "" {
+        base, err := frontendURL()
+        if err != nil {
+            http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        http.Redirect(w, req, base + "login#challenge=" + challenge, http.StatusSeeOther)
+        return
+    }
+    tokens, err := r.startSession(req, userDB, false)
     if err != nil {
         log.Println("Failed to generate token:", err)
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -432,5 +477,5 @@ func (r *Router) HandleAppleCallback(w http.ResponseWriter, req *http.Request) {
     // Important: Apple callback is a POST, so we must redirect with 303 See Other to turn it into a GET
     // Token delivered in the URL fragment (not query string): not sent to the
     // server, not logged, and not leaked via Referer. SPA reads location.hash.
-    http.Redirect(w, req, frontendURL + "admin#token=" + tokenString + "&role=" + role, http.StatusSeeOther)
+    http.Redirect(w, req, frontendURL + "admin#token=" + tokens.Token + "&refresh_token=" + tokens.RefreshToken + "&role=" + role, http.StatusSeeOther)
 }
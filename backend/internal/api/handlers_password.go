@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultPasswordResetTTL is the validity of a reset link, see PASSWORD_RESET_TTL
+	DefaultPasswordResetTTL = time.Hour
+
+	minPasswordLength = 8
+)
+
+// forgotPasswordReply is the answer of POST /api/auth/password/forgot,
+// whether or not the e-mail belongs to an account
+const forgotPasswordReply = "If an account exists for this e-mail, a reset link has been sent"
+
+// errNoFrontendURL: the links sent to users need FRONTEND_URL
+var errNoFrontendURL = errors.New("FRONTEND_URL is not set")
+
+// frontendURL is the public URL of the SPA (FRONTEND_URL, validated at
+// startup), with a trailing slash. Links are never built from the request:
+// its Host header is chosen by the client.
+func frontendURL() (string, error) {
+	u := os.Getenv("FRONTEND_URL")
+	if u == "" {
+		return "", errNoFrontendURL
+	}
+	return strings.TrimSuffix(u, "/") + "/", nil
+}
+
+// validityText is the lifetime of an e-mailed link, in the e-mail
+func validityText(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 heure"
+		}
+		return fmt.Sprintf("%d heures", d/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", d/time.Minute)
+}
+
+// canResetPassword tells whether a password reset may be offered to user:
+// accounts created with Google or Apple have no password to reset
+func canResetPassword(user *models.User) bool {
+	return !models.IsUserForbidden(user) && (user.Provider == models.ProviderEmail || user.PasswordHash != "")
+}
+
+// sendPasswordReset stores a reset token for user and e-mails its link.
+// The e-mail is sent in the background: the reply takes the same time
+// whether or not the account exists.
+func (rt *Router) sendPasswordReset(user *models.User) error {
+	base, err := frontendURL()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	ttl := rt.PasswordResetTTL
+	if ttl <= 0 {
+		ttl = DefaultPasswordResetTTL
+	}
+	token, hash := data.NewEmailToken()
+	if err := rt.EmailTokens.CreateEmailToken(hash, user.ID, models.EmailTokenPasswordReset, now, now.Add(ttl)); err != nil {
+		return err
+	}
+
+	// Token in the fragment: not sent to the server, not logged
+	link := base + "reset-password#token=" + token
+	body := fmt.Sprintf(`<p>Bonjour %s,</p>
+<p>Une réinitialisation du mot de passe de votre compte Essensys a été demandée.</p>
+<p><a href="%s">Choisir un nouveau mot de passe</a></p>
+<p>Ce lien est valable %s et ne peut servir qu'une fois. Si vous n'êtes pas à l'origine de cette demande, ignorez ce message : votre mot de passe reste inchangé.</p>`,
+		html.EscapeString(user.FirstName), html.EscapeString(link), validityText(ttl))
+	go func() {
+		if err := sendEmail([]string{user.Email}, "Réinitialisation de votre mot de passe Essensys", body); err != nil {
+			log.Printf("[AUTH] Failed to send password reset e-mail to user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// POST /api/auth/password/forgot
+// Sends a reset link to the e-mail of an account. The reply is the same
+// whether or not the account exists.
+func (rt *Router) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	if _, err := frontendURL(); err != nil || rt.UserStore == nil || rt.EmailTokens == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+	if !rt.PasswordResetIPLimit.Allow(getIP(r)) {
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	email := strings.TrimSpace(req.Email)
+	user, err := rt.UserStore.GetUserByEmail(email)
+	if err != nil {
+		log.Printf("[AUTH] Failed to look up %s for password reset: %v", email, err)
+	}
+	// Past the per-account limit, the request is dropped silently: a 429
+	// would tell that the account exists
+	if err == nil && user != nil && canResetPassword(user) && rt.PasswordResetEmailLimit.Allow(strings.ToLower(user.Email)) {
+		if err := rt.sendPasswordReset(user); err != nil {
+			log.Printf("[AUTH] Failed to create password reset token for user %d: %v", user.ID, err)
+		} else {
+			rt.LogAudit(user.ID, user.Email, "PASSWORD_RESET_REQUESTED", "USER", user.Email, getIP(r), "Password reset link sent")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": forgotPasswordReply})
+}
+
+// POST /api/auth/password/reset
+// Sets a new password with the token of a reset link, and logs the user
+// out of every session
+func (rt *Router) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	if rt.UserStore == nil || rt.EmailTokens == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+	if !rt.PasswordResetIPLimit.Allow(getIP(r)) {
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	userID, err := rt.EmailTokens.ConsumeEmailToken(data.HashEmailToken(req.Token), models.EmailTokenPasswordReset, time.Now())
+	if errors.Is(err, data.ErrEmailTokenInvalid) {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("[AUTH] Failed to consume password reset token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	user, err := rt.UserStore.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if models.IsUserForbidden(user) {
+		models.WriteAccountForbidden(w)
+		return
+	}
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.UserStore.UpdatePassword(user.ID, string(hashed)); err != nil {
+		log.Printf("[AUTH] Failed to reset password of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.revokeUserSessions(user.ID, models.SessionPasswordReset)
+	// The owner proved control of the e-mail: a lockout by someone else ends
+	if err := rt.LoginGuard.UnlockAccount(user.Email); err != nil {
+		log.Printf("[AUTH] Failed to unlock user %d: %v", user.ID, err)
+	}
+	rt.LogAudit(user.ID, user.Email, "PASSWORD_RESET", "USER", user.Email, getIP(r), "Password reset by e-mail link, sessions revoked")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password updated"})
+}
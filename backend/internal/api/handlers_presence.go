@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+const defaultPresenceWindow = 24 * time.Hour
+
+// presenceWindow reads ?window= (Go duration), the period of the availability
+func presenceWindow(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		return defaultPresenceWindow, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		http.Error(w, "Invalid 'window' (e.g. 24h, 720h)", http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
+
+// presenceByResource queries the transitions of every resource of kind over
+// the window and groups them by resource
+func (rt *Router) presenceByResource(kind string, from, now time.Time) (map[string][]models.PresenceTransition, error) {
+	transitions, err := rt.Presence.History.QueryTransitions(kind, "", from, now)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string][]models.PresenceTransition)
+	for _, t := range transitions {
+		byID[t.ResourceID] = append(byID[t.ResourceID], t)
+	}
+	return byID, nil
+}
+
+// writeMachinesWithPresence answers GET /api/admin/machines with the presence of each machine
+func (rt *Router) writeMachinesWithPresence(w http.ResponseWriter, r *http.Request, machines []*models.MachineDetail) {
+	window, ok := presenceWindow(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	from := now.Add(-window)
+	byID, err := rt.presenceByResource(models.PresenceMachine, from, now)
+	if err != nil {
+		log.Printf("[API] Failed to query presence: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]models.MachinePresence, 0, len(machines))
+	for _, m := range machines {
+		list = append(list, models.MachinePresence{
+			MachineDetail: m,
+			Presence:      rt.Presence.PresenceOf(models.PresenceMachine, m.LastSeen, byID[strconv.Itoa(m.ID)], from, now),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// writeGatewaysWithPresence answers GET /api/admin/gateways with the presence of each gateway
+func (rt *Router) writeGatewaysWithPresence(w http.ResponseWriter, r *http.Request, gateways []*models.GatewayStatus) {
+	window, ok := presenceWindow(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	from := now.Add(-window)
+	byID, err := rt.presenceByResource(models.PresenceGateway, from, now)
+	if err != nil {
+		log.Printf("[API] Failed to query presence: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	list := make([]models.GatewayPresence, 0, len(gateways))
+	for _, gw := range gateways {
+		list = append(list, models.GatewayPresence{
+			GatewayStatus: gw,
+			Presence:      rt.Presence.PresenceOf(models.PresenceGateway, gw.LastSeen, byID[gw.Key()], from, now),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GET /api/admin/presence/{kind}/{id}?window=168h
+// kind is machine (id = machine ID) or gateway (id = hostname)
+func (rt *Router) HandleAdminPresenceHistory(w http.ResponseWriter, r *http.Request) {
+	if rt.Presence == nil {
+		http.Error(w, "Presence tracking not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	window, ok := presenceWindow(w, r)
+	if !ok {
+		return
+	}
+
+	kind, id := chi.URLParam(r, "kind"), chi.URLParam(r, "id")
+	var lastSeen time.Time
+	switch kind {
+	case models.PresenceMachine:
+		machine, ok := rt.machineFromParam(w, r, caller)
+		if !ok {
+			return
+		}
+		lastSeen = machine.LastSeen
+	case models.PresenceGateway:
+		// id is the key of the gateway: its ID once enrolled, else its hostname
+		gateways, err := rt.Store.GetGateways()
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		var gateway *models.GatewayStatus
+		for _, gw := range gateways {
+			if gw.Key() == id {
+				gateway = gw
+				break
+			}
+		}
+		if gateway == nil {
+			http.Error(w, "Gateway not found", http.StatusNotFound)
+			return
+		}
+		if !canViewGateway(caller, gateway.Hostname) {
+			http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+			return
+		}
+		lastSeen = gateway.LastSeen
+	default:
+		http.Error(w, "Invalid kind (machine or gateway)", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	from := now.Add(-window)
+	transitions, err := rt.Presence.History.QueryTransitions(kind, id, from, now)
+	if err != nil {
+		log.Printf("[API] Failed to query presence: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.PresenceHistory{
+		Kind:        kind,
+		ResourceID:  id,
+		From:        from,
+		To:          now,
+		Presence:    rt.Presence.PresenceOf(kind, lastSeen, transitions, from, now),
+		Transitions: transitions,
+	})
+}
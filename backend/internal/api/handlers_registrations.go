@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// registrationAdmin checks the store and the caller: the queue is fleet-wide,
+// support may read it, only admin_global may review it
+func (rt *Router) registrationAdmin(w http.ResponseWriter, r *http.Request, write bool) (*models.User, bool) {
+	if rt.RegistrationStore == nil {
+		http.Error(w, "Registration Store not initialized", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !isGlobalCaller(caller) && (write || caller.Role != models.RoleSupport) {
+		http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+		return nil, false
+	}
+	return caller, true
+}
+
+// registrationFromParam loads the {id} registration
+func (rt *Router) registrationFromParam(w http.ResponseWriter, r *http.Request) (*models.PendingRegistration, bool) {
+	reg, err := rt.RegistrationStore.GetRegistration(chi.URLParam(r, "id"))
+	if errors.Is(err, data.ErrRegistrationNotFound) {
+		http.Error(w, "Registration not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return reg, true
+}
+
+// GET /api/admin/registrations?status=pending
+func (rt *Router) HandleAdminGetRegistrations(w http.ResponseWriter, r *http.Request) {
+	if _, ok := rt.registrationAdmin(w, r, false); !ok {
+		return
+	}
+	regs, err := rt.RegistrationStore.GetRegistrations(r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(regs)
+}
+
+// POST /api/admin/registrations/{id}/approve
+// Creates the machine (active at once), optionally with its real serial.
+func (rt *Router) HandleAdminApproveRegistration(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.registrationAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	reg, ok := rt.registrationFromParam(w, r)
+	if !ok {
+		return
+	}
+	if reg.Status == models.RegistrationApproved {
+		http.Error(w, "Registration already approved", http.StatusConflict)
+		return
+	}
+
+	var req models.ApproveRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	noSerie := strings.TrimSpace(req.NoSerie)
+	if noSerie != "" && !validSerial.MatchString(noSerie) {
+		http.Error(w, "no_serie must be 1-64 letters, digits, '.', '_' or '-'", http.StatusBadRequest)
+		return
+	}
+
+	// Retrying after a failure is safe: RegisterUnknownMachine returns the existing machine
+	m, err := rt.Store.RegisterUnknownMachine(reg.HashedPkey, reg.SealedCredential)
+	if err != nil {
+		log.Printf("[API] Failed to register approved machine %s: %v", reg.KeyPrefix, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if noSerie != "" && noSerie != m.NoSerie {
+		if err := rt.Store.RenameMachine(m.ID, noSerie); err != nil {
+			writeMachineStoreError(w, m.ID, err)
+			return
+		}
+	} else {
+		noSerie = m.NoSerie
+	}
+	if err := rt.Store.SetMachineActive(m.ID, true); err != nil {
+		writeMachineStoreError(w, m.ID, err)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	if err := rt.RegistrationStore.ResolveRegistration(reg.ID, models.RegistrationApproved, actorName, m.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(actorID, actorName, "APPROVE_REGISTRATION", "MACHINE", strconv.Itoa(m.ID), getIP(r),
+		fmt.Sprintf("Approved key %s... from %s as %s", reg.KeyPrefix, reg.LastIP, noSerie))
+
+	reg, _ = rt.RegistrationStore.GetRegistration(reg.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reg)
+}
+
+// POST /api/admin/registrations/{id}/reject
+// Rejected keys stay in the queue so that further attempts are not re-queued.
+func (rt *Router) HandleAdminRejectRegistration(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.registrationAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	reg, ok := rt.registrationFromParam(w, r)
+	if !ok {
+		return
+	}
+	if reg.Status != models.RegistrationPending {
+		http.Error(w, "Registration is not pending", http.StatusConflict)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	if err := rt.RegistrationStore.ResolveRegistration(reg.ID, models.RegistrationRejected, actorName, 0); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(actorID, actorName, "REJECT_REGISTRATION", "REGISTRATION", reg.ID, getIP(r),
+		fmt.Sprintf("Rejected key %s... from %s (%d attempts)", reg.KeyPrefix, reg.LastIP, reg.Attempts))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /api/admin/registrations/{id}
+// Forgets an entry: the key is queued again on its next attempt.
+func (rt *Router) HandleAdminDeleteRegistration(w http.ResponseWriter, r *http.Request) {
+	caller, ok := rt.registrationAdmin(w, r, true)
+	if !ok {
+		return
+	}
+	reg, ok := rt.registrationFromParam(w, r)
+	if !ok {
+		return
+	}
+	if err := rt.RegistrationStore.DeleteRegistration(reg.ID); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	actorID, actorName := auditActor(caller)
+	rt.LogAudit(actorID, actorName, "DELETE_REGISTRATION", "REGISTRATION", reg.ID, getIP(r),
+		fmt.Sprintf("Deleted %s registration of key %s...", reg.Status, reg.KeyPrefix))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/middleware"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Token lifetimes, see ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// refreshReuseGrace tolerates the concurrent refreshes of several tabs
+	// sharing a refresh token: only the first rotates it, the others get 401
+	// without revoking the session
+	refreshReuseGrace = 10 * time.Second
+
+	// legacyTokenTTL is the lifetime of access tokens without a session store
+	legacyTokenTTL = 24 * time.Hour
+
+	maxUserAgent = 255
+)
+
+func (rt *Router) accessTokenTTL() time.Duration {
+	if rt.AccessTokenTTL > 0 {
+		return rt.AccessTokenTTL
+	}
+	return DefaultAccessTokenTTL
+}
+
+func (rt *Router) refreshTokenTTL() time.Duration {
+	if rt.RefreshTokenTTL > 0 {
+		return rt.RefreshTokenTTL
+	}
+	return DefaultRefreshTokenTTL
+}
+
+// startSession opens a session for a user who just logged in and returns its
+// tokens. twoFactor records that the login passed a second factor. Without a
+// session store, only a 24h access token is issued.
+func (rt *Router) startSession(r *http.Request, user *models.User, twoFactor bool) (*models.AuthTokens, error) {
+	now := time.Now()
+	if rt.Sessions == nil {
+		token, err := GenerateJWT(user.Email, user.Role, "", now.Add(legacyTokenTTL))
+		if err != nil {
+			return nil, err
+		}
+		return &models.AuthTokens{Token: token, ExpiresIn: int(legacyTokenTTL.Seconds())}, nil
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	refresh, hash := data.NewRefreshToken()
+	session := &models.Session{
+		ID:         data.NewSessionID(),
+		UserID:     user.ID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(rt.refreshTokenTTL()),
+		IP:         getIP(r),
+		UserAgent:  userAgent,
+		TwoFactor:  twoFactor,
+	}
+	if err := rt.Sessions.CreateSession(session, hash); err != nil {
+		return nil, err
+	}
+	return rt.sessionTokens(user, session.ID, refresh, now)
+}
+
+// sessionTokens signs an access token of a session, with the role read from the user
+func (rt *Router) sessionTokens(user *models.User, sessionID, refresh string, now time.Time) (*models.AuthTokens, error) {
+	ttl := rt.accessTokenTTL()
+	token, err := GenerateJWT(user.Email, user.Role, sessionID, now.Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuthTokens{Token: token, RefreshToken: refresh, ExpiresIn: int(ttl.Seconds())}, nil
+}
+
+// revokeUserSessions logs out a user everywhere, e.g. when an admin forbids them
+func (rt *Router) revokeUserSessions(userID int, reason string) {
+	if rt.Sessions == nil {
+		return
+	}
+	n, err := rt.Sessions.RevokeUserSessions(userID, reason, time.Now())
+	if err != nil {
+		log.Printf("[AUTH] Failed to revoke sessions of user %d: %v", userID, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("[AUTH] Revoked %d session(s) of user %d (%s)", n, userID, reason)
+	}
+}
+
+// sessionUser returns the user logged in by UserTokenMiddlewareWithStore and its session ID
+func (rt *Router) sessionUser(w http.ResponseWriter, r *http.Request) (*models.User, string, bool) {
+	if rt.UserStore == nil || rt.Sessions == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil, "", false
+	}
+	email, _ := r.Context().Value("user_email").(string)
+	user, err := rt.UserStore.GetUserByEmail(email)
+	if err != nil || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", false
+	}
+	sid, _ := r.Context().Value("session_id").(string)
+	return user, sid, true
+}
+
+// POST /api/auth/refresh
+// Exchanges a refresh token for a new access token and a new refresh token.
+// Presenting a refresh token that was already rotated revokes its session.
+func (rt *Router) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	if rt.UserStore == nil || rt.Sessions == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	refresh, hash := data.NewRefreshToken()
+	session, err := rt.Sessions.RotateRefreshToken(data.HashRefreshToken(req.RefreshToken), hash, now, now.Add(rt.refreshTokenTTL()), refreshReuseGrace)
+	if errors.Is(err, data.ErrRefreshTokenReused) {
+		userID, email := session.UserID, ""
+		if user, _ := rt.UserStore.GetUserByID(session.UserID); user != nil {
+			email = user.Email
+		}
+		log.Printf("[AUTH] Refresh token reused for session %s of user %d, session revoked", session.ID, session.UserID)
+		rt.LogAudit(userID, email, "REFRESH_TOKEN_REUSE", "SESSION", session.ID, getIP(r), "Rotated refresh token presented again, session revoked")
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, data.ErrRefreshTokenInvalid) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("[AUTH] Failed to rotate refresh token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// The user may have been deleted or forbidden by a store that missed the revocation
+	user, err := rt.UserStore.GetUserByID(session.UserID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || models.IsUserForbidden(user) {
+		reason := models.SessionUserDeleted
+		if user != nil {
+			reason = models.SessionForbidden
+		}
+		if err := rt.Sessions.RevokeSession(session.ID, reason, now); err != nil {
+			log.Printf("[AUTH] Failed to revoke session %s: %v", session.ID, err)
+		}
+		if user != nil {
+			models.WriteAccountForbidden(w)
+			return
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := rt.sessionTokens(user, session.ID, refresh, now)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// logoutSession finds the session to close: the "sid" of the access token,
+// even expired, or else the refresh token of the body
+func (rt *Router) logoutSession(r *http.Request) (*models.Session, error) {
+	tokenStr := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if tokenStr != "" {
+		// Signature checked, expiry not: logging out after the token expired is fine
+		parser := jwt.Parser{SkipClaimsValidation: true}
+		if token, err := parser.Parse(tokenStr, middleware.JWTKeyFunc); err == nil {
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				if sid, _ := claims["sid"].(string); sid != "" {
+					return rt.Sessions.GetSession(sid)
+				}
+			}
+		}
+	}
+	var req models.RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return nil, data.ErrSessionNotFound
+	}
+	if req.RefreshToken == "" {
+		return nil, data.ErrSessionNotFound
+	}
+	return rt.Sessions.SessionByRefreshToken(data.HashRefreshToken(req.RefreshToken))
+}
+
+// POST /api/auth/logout
+// Revokes the session of the access token (Authorization header) or of the
+// refresh token (body). Always succeeds: the client drops its tokens anyway.
+func (rt *Router) HandleLogout(w http.ResponseWriter, r *http.Request) {
+	if rt.Sessions == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	session, err := rt.logoutSession(r)
+	if err != nil {
+		if !errors.Is(err, data.ErrSessionNotFound) {
+			log.Printf("[AUTH] Failed to find session to log out: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if session.RevokedAt == nil {
+		if err := rt.Sessions.RevokeSession(session.ID, models.SessionLogout, time.Now()); err != nil {
+			log.Printf("[AUTH] Failed to revoke session %s: %v", session.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		email := ""
+		if rt.UserStore != nil {
+			if user, _ := rt.UserStore.GetUserByID(session.UserID); user != nil {
+				email = user.Email
+			}
+		}
+		rt.LogAudit(session.UserID, email, "LOGOUT", "SESSION", session.ID, getIP(r), "Logout")
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /api/auth/logout-all
+// Revokes every session of the caller, the current one included
+func (rt *Router) HandleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := rt.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	n, err := rt.Sessions.RevokeUserSessions(user.ID, models.SessionLogoutAll, time.Now())
+	if err != nil {
+		log.Printf("[AUTH] Failed to revoke sessions of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(user.ID, user.Email, "LOGOUT_ALL", "SESSION", "", getIP(r), "Logged out of "+strconv.Itoa(n)+" session(s)")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": n})
+}
+
+// GET /api/auth/sessions
+// Lists the open sessions of the caller
+func (rt *Router) HandleGetSessions(w http.ResponseWriter, r *http.Request) {
+	user, sid, ok := rt.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := rt.Sessions.GetUserSessions(user.ID, time.Now())
+	if err != nil {
+		log.Printf("[AUTH] Failed to list sessions of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	for _, s := range sessions {
+		s.Current = s.ID == sid
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// DELETE /api/auth/sessions/{id}
+// Revokes one session of the caller, e.g. a lost device
+func (rt *Router) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := rt.sessionUser(w, r)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	session, err := rt.Sessions.GetSession(id)
+	if errors.Is(err, data.ErrSessionNotFound) || (err == nil && session.UserID != user.ID) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.Sessions.RevokeSession(id, models.SessionLogout, time.Now()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(user.ID, user.Email, "LOGOUT", "SESSION", id, getIP(r), "Revoked session from "+session.IP)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+const defaultTelemetryWindow = 24 * time.Hour
+
+// parseTimeParam accepts RFC 3339 timestamps or unix seconds
+func parseTimeParam(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseIntList parses a comma separated list such as "350,351"
+func parseIntList(v string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", part)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// GET /api/admin/machines/{id}/telemetry?keys=350,351&from=&to=&interval=
+func (rt *Router) HandleAdminMachineTelemetry(w http.ResponseWriter, r *http.Request) {
+	if rt.TelemetryStore == nil {
+		http.Error(w, "Telemetry Store not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	caller, ok := rt.adminCaller(w, r)
+	if !ok {
+		return
+	}
+	machine, ok := rt.machineFromParam(w, r, caller)
+	if !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	q := models.TelemetryQuery{MachineID: machine.ID, To: time.Now()}
+
+	keys, err := parseIntList(params.Get("keys"))
+	if err != nil {
+		http.Error(w, "Invalid keys: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.Keys = keys
+
+	if v := params.Get("to"); v != "" {
+		if q.To, err = parseTimeParam(v); err != nil {
+			http.Error(w, "Invalid 'to' (RFC 3339 or unix seconds)", http.StatusBadRequest)
+			return
+		}
+	}
+	q.From = q.To.Add(-defaultTelemetryWindow)
+	if v := params.Get("from"); v != "" {
+		if q.From, err = parseTimeParam(v); err != nil {
+			http.Error(w, "Invalid 'from' (RFC 3339 or unix seconds)", http.StatusBadRequest)
+			return
+		}
+	}
+	if !q.From.Before(q.To) {
+		http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+		return
+	}
+	if v := params.Get("interval"); v != "" {
+		if q.Interval, err = time.ParseDuration(v); err != nil || q.Interval < 0 {
+			http.Error(w, "Invalid 'interval' (e.g. 5m, 1h)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	series, truncated, err := rt.TelemetryStore.QueryTelemetry(q)
+	if err != nil {
+		log.Printf("[API] Failed to query telemetry: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TelemetryResponse{
+		MachineID: machine.ID,
+		NoSerie:   machine.NoSerie,
+		From:      q.From,
+		To:        q.To,
+		Series:    series,
+		Truncated: truncated,
+	})
+}
@@ -0,0 +1,438 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/totp"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// twoFactorChallengeTTL is the time left to type the code after the password
+	twoFactorChallengeTTL = 5 * time.Minute
+
+	twoFactorChallengeIssuer = "essensys-backend/2fa-challenge"
+	totpIssuer               = "Essensys"
+
+	// totpSkew accepts the codes of the previous and next 30 s steps
+	totpSkew = 1
+)
+
+// twoFactorChallengeKey signs the login challenges, like verificationKey:
+// a challenge is never a valid access token
+func twoFactorChallengeKey() []byte {
+	sum := sha256.Sum256([]byte("2fa-challenge:" + os.Getenv("JWT_SECRET")))
+	return sum[:]
+}
+
+// twoFactorChallenge proves that user passed the first factor (password or
+// OAuth provider) and is waiting for the second one
+func twoFactorChallenge(user *models.User, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"uid": user.ID,
+		"exp": expiresAt.Unix(),
+		"iss": twoFactorChallengeIssuer,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(twoFactorChallengeKey())
+}
+
+// parseTwoFactorChallenge returns the user ID of a valid challenge
+func parseTwoFactorChallenge(tokenStr string) (int, error) {
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return twoFactorChallengeKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, fmt.Errorf("invalid challenge: %v", err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	uid, _ := claims["uid"].(float64)
+	if iss, _ := claims["iss"].(string); iss != twoFactorChallengeIssuer || uid == 0 {
+		return 0, fmt.Errorf("invalid challenge claims")
+	}
+	return int(uid), nil
+}
+
+// pendingTwoFactor returns a login challenge when user enabled 2FA, "" when
+// the first factor is enough
+func (rt *Router) pendingTwoFactor(user *models.User) (string, error) {
+	if rt.TwoFactor == nil {
+		return "", nil
+	}
+	tf, err := rt.TwoFactor.GetTwoFactor(user.ID)
+	if err != nil {
+		return "", err
+	}
+	if !tf.Enabled() {
+		return "", nil
+	}
+	return twoFactorChallenge(user, time.Now().Add(twoFactorChallengeTTL))
+}
+
+// verifySecondFactor checks the TOTP code or the recovery code of req and
+// returns how the user proved it, "" if neither is valid
+func (rt *Router) verifySecondFactor(tf *models.TwoFactor, req *models.TwoFactorRequest) (string, error) {
+	now := time.Now()
+	if req.Code != "" {
+		counter, ok := totp.Validate(tf.Secret, req.Code, now, totpSkew)
+		if !ok {
+			return "", nil
+		}
+		// A code seen by a shoulder or a proxy cannot be replayed
+		fresh, err := rt.TwoFactor.UseTwoFactorCounter(tf.UserID, counter)
+		if err != nil || !fresh {
+			return "", err
+		}
+		return "totp", nil
+	}
+	if req.RecoveryCode != "" {
+		used, err := rt.TwoFactor.UseRecoveryCode(tf.UserID, data.HashRecoveryCode(req.RecoveryCode), now)
+		if err != nil || !used {
+			return "", err
+		}
+		return "recovery code", nil
+	}
+	return "", nil
+}
+
+// loginResponse is the answer of a completed login
+func loginResponse(user *models.User, tokens *models.AuthTokens) map[string]interface{} {
+	return map[string]interface{}{
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user": models.UserResponse{
+			ID:            user.ID,
+			Email:         user.Email,
+			Role:          user.Role,
+			FirstName:     user.FirstName,
+			LastName:      user.LastName,
+			Provider:      user.Provider,
+			EmailVerified: models.IsUserVerified(user),
+		},
+	}
+}
+
+// POST /api/auth/login/2fa
+// Second step of a login with 2FA: the challenge of the first step and a
+// TOTP code or a recovery code
+func (rt *Router) HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if rt.UserStore == nil || rt.TwoFactor == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req models.TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Challenge == "" {
+		http.Error(w, "Challenge is required", http.StatusBadRequest)
+		return
+	}
+	userID, err := parseTwoFactorChallenge(req.Challenge)
+	if err != nil {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+	// Six digits: the attempts per account are few
+	if !rt.TwoFactorLimit.Allow(strconv.Itoa(userID)) {
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+	user, err := rt.UserStore.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+	if models.IsUserForbidden(user) {
+		rt.LogAudit(user.ID, user.Email, "LOGIN_FAILED", "USER", "", getIP(r), "Account forbidden")
+		models.WriteAccountForbidden(w)
+		return
+	}
+	tf, err := rt.TwoFactor.GetTwoFactor(user.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !tf.Enabled() {
+		// Reset by an admin since the first step: log in again
+		http.Error(w, "Invalid or expired challenge", http.StatusUnauthorized)
+		return
+	}
+
+	method, err := rt.verifySecondFactor(tf, &req)
+	if err != nil {
+		log.Printf("[AUTH] Failed to check second factor of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if method == "" {
+		rt.LogAudit(user.ID, user.Email, "LOGIN_FAILED", "USER", "", getIP(r), "Invalid two-factor code")
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	rt.UserStore.UpdateLastLogin(user.ID)
+	rt.LogAudit(user.ID, user.Email, "LOGIN", "USER", "", getIP(r), "Login successful (2FA, "+method+")")
+
+	tokens, err := rt.startSession(r, user, true)
+	if err != nil {
+		log.Printf("[Login] Failed to open session: %v", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loginResponse(user, tokens))
+}
+
+// twoFactorUser returns the caller of the enrolment endpoints and their enrolment
+func (rt *Router) twoFactorUser(w http.ResponseWriter, r *http.Request) (*models.User, *models.TwoFactor, bool) {
+	if rt.UserStore == nil || rt.TwoFactor == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return nil, nil, false
+	}
+	email, _ := r.Context().Value("user_email").(string)
+	user, err := rt.UserStore.GetUserByEmail(email)
+	if err != nil || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	tf, err := rt.TwoFactor.GetTwoFactor(user.ID)
+	if err != nil {
+		log.Printf("[AUTH] Failed to get 2FA of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return user, tf, true
+}
+
+// checkCallerCode verifies the code proving a change to the 2FA of the caller
+func (rt *Router) checkCallerCode(w http.ResponseWriter, r *http.Request, user *models.User, tf *models.TwoFactor) (string, bool) {
+	if !tf.Enabled() {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return "", false
+	}
+	var req models.TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return "", false
+	}
+	if !rt.TwoFactorLimit.Allow(strconv.Itoa(user.ID)) {
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return "", false
+	}
+	method, err := rt.verifySecondFactor(tf, &req)
+	if err != nil {
+		log.Printf("[AUTH] Failed to check second factor of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return "", false
+	}
+	if method == "" {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return "", false
+	}
+	return method, true
+}
+
+// GET /api/auth/2fa
+// 2FA status of the caller
+func (rt *Router) HandleGetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, tf, ok := rt.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	status := models.TwoFactorStatus{
+		Enabled:  tf.Enabled(),
+		Required: models.IsTwoFactorRequired(rt.TwoFactorPolicy, user.Role),
+	}
+	if tf.Enabled() {
+		status.EnabledAt = tf.EnabledAt
+		n, err := rt.TwoFactor.CountRecoveryCodes(user.ID)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		status.RecoveryCodesLeft = n
+	}
+	if sid, _ := r.Context().Value("session_id").(string); sid != "" && rt.Sessions != nil {
+		if session, err := rt.Sessions.GetSession(sid); err == nil {
+			status.SessionVerified = session.TwoFactor
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// POST /api/auth/2fa/setup
+// Generates the secret to enrol in an authenticator app. 2FA is enabled by
+// the confirmation of a first code.
+func (rt *Router) HandleSetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, tf, ok := rt.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	if tf.Enabled() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	secret := totp.GenerateSecret()
+	if err := rt.TwoFactor.SetTwoFactorSecret(user.ID, secret, time.Now()); err != nil {
+		log.Printf("[AUTH] Failed to store 2FA secret of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.TwoFactorSetup{
+		Secret: secret,
+		URI:    totp.ProvisioningURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// POST /api/auth/2fa/confirm
+// Enables 2FA with a first code of the app and returns the recovery codes,
+// shown once
+func (rt *Router) HandleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, tf, ok := rt.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	if tf.Enabled() {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if tf == nil {
+		http.Error(w, "Start the setup first", http.StatusBadRequest)
+		return
+	}
+	var req models.TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+	if !rt.TwoFactorLimit.Allow(strconv.Itoa(user.ID)) {
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+	now := time.Now()
+	counter, valid := totp.Validate(tf.Secret, req.Code, now, totpSkew)
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes := data.NewRecoveryCodes()
+	if err := rt.TwoFactor.EnableTwoFactor(user.ID, counter, now, hashes); err != nil {
+		log.Printf("[AUTH] Failed to enable 2FA of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// The code just typed proves the second factor for the current session
+	if sid, _ := r.Context().Value("session_id").(string); sid != "" && rt.Sessions != nil {
+		if err := rt.Sessions.SetSessionTwoFactor(sid); err != nil {
+			log.Printf("[AUTH] Failed to mark session %s verified: %v", sid, err)
+		}
+	}
+	rt.LogAudit(user.ID, user.Email, "ENABLE_2FA", "USER", user.Email, getIP(r), "Two-factor authentication enabled")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// POST /api/auth/2fa/disable
+// Disables 2FA with a code or a recovery code, unless the policy requires it
+// for the role of the caller
+func (rt *Router) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, tf, ok := rt.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	if models.IsTwoFactorRequired(rt.TwoFactorPolicy, user.Role) {
+		http.Error(w, "Forbidden: two-factor authentication is required for your role", http.StatusForbidden)
+		return
+	}
+	method, ok := rt.checkCallerCode(w, r, user, tf)
+	if !ok {
+		return
+	}
+	if err := rt.TwoFactor.DeleteTwoFactor(user.ID); err != nil {
+		log.Printf("[AUTH] Failed to disable 2FA of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(user.ID, user.Email, "DISABLE_2FA", "USER", user.Email, getIP(r), "Two-factor authentication disabled ("+method+")")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/auth/2fa/recovery-codes
+// Replaces the recovery codes, e.g. when few are left
+func (rt *Router) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, tf, ok := rt.twoFactorUser(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := rt.checkCallerCode(w, r, user, tf); !ok {
+		return
+	}
+	codes, hashes := data.NewRecoveryCodes()
+	if err := rt.TwoFactor.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		log.Printf("[AUTH] Failed to replace recovery codes of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(user.ID, user.Email, "REGENERATE_RECOVERY_CODES", "USER", user.Email, getIP(r), "Recovery codes replaced")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// deleteTwoFactor drops the enrolment of a deleted user
+func (rt *Router) deleteTwoFactor(userID int) {
+	if rt.TwoFactor == nil {
+		return
+	}
+	if err := rt.TwoFactor.DeleteTwoFactor(userID); err != nil {
+		log.Printf("[AUTH] Failed to delete 2FA of user %d: %v", userID, err)
+	}
+}
+
+// POST /api/admin/users/{id}/2fa/reset
+// Disables the 2FA of a user who lost their device and recovery codes, and
+// logs them out everywhere
+func (rt *Router) HandleAdminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if rt.TwoFactor == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	caller, target, idStr, ok := rt.authorizeAdminUserAction(w, r, actionResetTwoFactor)
+	if !ok {
+		return
+	}
+	tf, err := rt.TwoFactor.GetTwoFactor(target.ID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !tf.Enabled() {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return
+	}
+	if err := rt.TwoFactor.DeleteTwoFactor(target.ID); err != nil {
+		log.Printf("[API] Failed to reset 2FA of user %d: %v", target.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.revokeUserSessions(target.ID, models.SessionTwoFactorReset)
+	rt.LogAudit(caller.ID, caller.Email, "RESET_2FA", "USER", idStr, getIP(r), "Reset two-factor authentication of "+target.Email)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/totp"
+)
+
+// enabledTwoFactor enrols user 1 and returns its factor and recovery codes
+func enabledTwoFactor(t *testing.T, rt *Router) (*models.TwoFactor, []string) {
+	t.Helper()
+	now := time.Now()
+	if err := rt.TwoFactor.SetTwoFactorSecret(1, totp.GenerateSecret(), now); err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes := data.NewRecoveryCodes()
+	// The confirmation code of the enrolment is two steps old
+	if err := rt.TwoFactor.EnableTwoFactor(1, totp.Counter(now)-2, now, hashes); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := rt.TwoFactor.GetTwoFactor(1)
+	if err != nil || tf == nil {
+		t.Fatalf("GetTwoFactor() = %v, %v", tf, err)
+	}
+	return tf, codes
+}
+
+func TestVerifySecondFactorRefusesReplayedCode(t *testing.T) {
+	rt := &Router{TwoFactor: data.NewMemoryTwoFactorStore()}
+	tf, _ := enabledTwoFactor(t, rt)
+	code, err := totp.Code(tf.Secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if method, err := rt.verifySecondFactor(tf, &models.TwoFactorRequest{Code: code}); err != nil || method != "totp" {
+		t.Fatalf("first use = %q, %v, want totp", method, err)
+	}
+	if method, err := rt.verifySecondFactor(tf, &models.TwoFactorRequest{Code: code}); err != nil || method != "" {
+		t.Fatalf("replay = %q, %v, want refused", method, err)
+	}
+
+	// An older code, still within the skew, comes before the used step
+	previous, _ := totp.Code(tf.Secret, totp.Counter(time.Now())-1)
+	if method, _ := rt.verifySecondFactor(tf, &models.TwoFactorRequest{Code: previous}); method != "" {
+		t.Errorf("code of an earlier step = %q, want refused", method)
+	}
+}
+
+func TestVerifySecondFactorRecoveryCodeSingleUse(t *testing.T) {
+	rt := &Router{TwoFactor: data.NewMemoryTwoFactorStore()}
+	tf, codes := enabledTwoFactor(t, rt)
+
+	// Typed in upper case and without the dash
+	typed := strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))
+	if method, err := rt.verifySecondFactor(tf, &models.TwoFactorRequest{RecoveryCode: typed}); err != nil || method != "recovery code" {
+		t.Fatalf("first use = %q, %v, want recovery code", method, err)
+	}
+	if method, _ := rt.verifySecondFactor(tf, &models.TwoFactorRequest{RecoveryCode: codes[0]}); method != "" {
+		t.Errorf("second use = %q, want refused", method)
+	}
+	if n, _ := rt.TwoFactor.CountRecoveryCodes(1); n != models.RecoveryCodeCount-1 {
+		t.Errorf("CountRecoveryCodes() = %d, want %d", n, models.RecoveryCodeCount-1)
+	}
+	if method, _ := rt.verifySecondFactor(tf, &models.TwoFactorRequest{RecoveryCode: "aaaaa-bbbbb"}); method != "" {
+		t.Errorf("unknown code = %q, want refused", method)
+	}
+
+	// Regenerated codes replace the unused ones
+	_, hashes := data.NewRecoveryCodes()
+	if err := rt.TwoFactor.ReplaceRecoveryCodes(1, hashes); err != nil {
+		t.Fatal(err)
+	}
+	if method, _ := rt.verifySecondFactor(tf, &models.TwoFactorRequest{RecoveryCode: codes[1]}); method != "" {
+		t.Errorf("replaced code = %q, want refused", method)
+	}
+}
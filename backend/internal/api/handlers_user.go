@@ -5,7 +5,6 @@ import (
 	"log"
 	"net"
 	"net/http"
-	"strings"
 
 
 	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
@@ -13,16 +12,10 @@ import (
     "time"
 )
 
-// Helper to get IP from request (handling proxies)
+// Helper to get IP from request. Behind a trusted proxy, the RealIP middleware
+// has already replaced RemoteAddr with the client address; X-Forwarded-For is
+// never read here, a client could forge it.
 func getIP(r *http.Request) string {
-    // Check X-Forwarded-For
-    forwarded := r.Header.Get("X-Forwarded-For")
-    if forwarded != "" {
-        return strings.Split(forwarded, ",")[0]
-    }
-    
-    // Check RealIP middleware context if present (not strictly standard but common)
-    // Or just RemoteAddr
     host, _, err := net.SplitHostPort(r.RemoteAddr)
     if err == nil {
         return host
@@ -196,6 +189,7 @@ func (rt *Router) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
         FirstName: user.FirstName,
         LastName:  user.LastName,
         Provider:  user.Provider,
+        EmailVerified: models.IsUserVerified(user),
         LinkedMachineID: user.LinkedMachineID,
         LinkedGatewayID: user.LinkedGatewayID,
     }
@@ -280,6 +274,8 @@ func (rt *Router) HandleDeleteProfile(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "Internal Server Error", http.StatusInternalServerError)
         return
     }
+    rt.revokeUserSessions(user.ID, models.SessionUserDeleted)
+    rt.deleteTwoFactor(user.ID)
     
     // Audit (Note: logging action for a deleted user ID might be tricky if reporting relies on User existence, but Audit table stores snapshotted username)
     rt.LogAudit(user.ID, email, "DELETE_PROFILE", "USER", email, getIP(r), "User deleted their own account")
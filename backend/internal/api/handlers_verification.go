@@ -0,0 +1,243 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// DefaultEmailVerificationTTL is the validity of a verification link, see EMAIL_VERIFICATION_TTL
+	DefaultEmailVerificationTTL = 48 * time.Hour
+
+	emailVerificationIssuer = "essensys-backend/email-verification"
+)
+
+// verificationKey signs the verification links. Derived from JWT_SECRET but
+// distinct from the access token key: a link is never a valid access token.
+func verificationKey() []byte {
+	sum := sha256.Sum256([]byte("email-verification:" + os.Getenv("JWT_SECRET")))
+	return sum[:]
+}
+
+// emailVerificationToken signs the ID and e-mail of user: the link stops
+// working if the address changes
+func emailVerificationToken(user *models.User, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"uid":   user.ID,
+		"email": user.Email,
+		"exp":   expiresAt.Unix(),
+		"iss":   emailVerificationIssuer,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(verificationKey())
+}
+
+// parseEmailVerificationToken returns the user ID and e-mail of a valid link
+func parseEmailVerificationToken(tokenStr string) (int, string, error) {
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return verificationKey(), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, "", fmt.Errorf("invalid token: %v", err)
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	uid, _ := claims["uid"].(float64)
+	email, _ := claims["email"].(string)
+	if iss, _ := claims["iss"].(string); iss != emailVerificationIssuer || uid == 0 || email == "" {
+		return 0, "", fmt.Errorf("invalid token claims")
+	}
+	return int(uid), email, nil
+}
+
+// sendEmailVerification e-mails a verification link to user, in the background
+func (rt *Router) sendEmailVerification(user *models.User) error {
+	base, err := frontendURL()
+	if err != nil {
+		return err
+	}
+	ttl := rt.EmailVerificationTTL
+	if ttl <= 0 {
+		ttl = DefaultEmailVerificationTTL
+	}
+	token, err := emailVerificationToken(user, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	// Token in the fragment: not sent to the server, not logged
+	link := base + "verify-email#token=" + token
+	body := fmt.Sprintf(`<p>Bonjour %s,</p>
+<p>Confirmez l'adresse email de votre compte Essensys :</p>
+<p><a href="%s">Vérifier mon adresse email</a></p>
+<p>Ce lien est valable %s. Si vous n'avez pas créé de compte, ignorez ce message.</p>`,
+		html.EscapeString(user.FirstName), html.EscapeString(link), validityText(ttl))
+	go func() {
+		if err := sendEmail([]string{user.Email}, "Vérifiez votre adresse email Essensys", body); err != nil {
+			log.Printf("[AUTH] Failed to send verification e-mail to user %d: %v", user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// promoteFirstLocalAdmin makes a verified guest the local admin of their
+// linked machine, if the machine has none yet
+func (rt *Router) promoteFirstLocalAdmin(r *http.Request, user *models.User) {
+	if user.Role != models.RoleGuestLocal || user.LinkedMachineID == nil || !models.IsUserVerified(user) {
+		return
+	}
+	machineID := *user.LinkedMachineID
+	hasAdmin, err := rt.UserStore.HasLocalAdmin(machineID)
+	if err != nil || hasAdmin {
+		return
+	}
+	if err := rt.UserStore.UpdateUserRole(user.ID, models.RoleAdminLocal); err != nil {
+		log.Printf("[AUTH] Failed to promote user %d to local admin: %v", user.ID, err)
+		return
+	}
+	log.Printf("[AUTH] First verified user for machine %d. Promoting user %d to AdminLocal.", machineID, user.ID)
+	user.Role = models.RoleAdminLocal
+	rt.revokeUserSessions(user.ID, models.SessionRoleChanged)
+	rt.LogAudit(user.ID, user.Email, "UPDATE_ROLE", "USER", strconv.Itoa(user.ID), getIP(r),
+		"Promoted to admin_local of machine "+strconv.Itoa(machineID)+" (first verified user)")
+}
+
+// markEmailVerified records the verification of user and applies its effects
+func (rt *Router) markEmailVerified(r *http.Request, user *models.User) error {
+	now := time.Now()
+	if err := rt.UserStore.SetEmailVerified(user.ID, now); err != nil {
+		return err
+	}
+	if user.VerifiedAt == nil {
+		user.VerifiedAt = &now
+	}
+	rt.promoteFirstLocalAdmin(r, user)
+	return nil
+}
+
+// POST /api/auth/verify-email
+// Verifies the e-mail address of the link sent at registration
+func (rt *Router) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if rt.UserStore == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+	userID, email, err := parseEmailVerificationToken(req.Token)
+	if err != nil {
+		http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+	user, err := rt.UserStore.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Email != email {
+		http.Error(w, "Invalid or expired link", http.StatusBadRequest)
+		return
+	}
+
+	if !models.IsUserVerified(user) {
+		if err := rt.markEmailVerified(r, user); err != nil {
+			log.Printf("[AUTH] Failed to verify e-mail of user %d: %v", user.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		rt.LogAudit(user.ID, user.Email, "EMAIL_VERIFIED", "USER", user.Email, getIP(r), "E-mail verified by link")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified", "role": user.Role})
+}
+
+// POST /api/auth/verify-email/resend
+// Sends a new verification link to the caller
+func (rt *Router) HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	if _, err := frontendURL(); err != nil || rt.UserStore == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	email, _ := r.Context().Value("user_email").(string)
+	user, err := rt.UserStore.GetUserByEmail(email)
+	if err != nil || user == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if models.IsUserVerified(user) {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+	if !rt.VerificationLimit.Allow(strconv.Itoa(user.ID)) {
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err := rt.sendEmailVerification(user); err != nil {
+		log.Printf("[AUTH] Failed to create verification link for user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(user.ID, user.Email, "RESEND_VERIFICATION", "USER", user.Email, getIP(r), "Verification link sent")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// POST /api/admin/users/{id}/verification
+// Sends a new verification link to a user
+func (rt *Router) HandleAdminResendVerification(w http.ResponseWriter, r *http.Request) {
+	if _, err := frontendURL(); err != nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	caller, target, idStr, ok := rt.authorizeAdminUserAction(w, r, actionVerifyEmail)
+	if !ok {
+		return
+	}
+	if models.IsUserVerified(target) {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+	if err := rt.sendEmailVerification(target); err != nil {
+		log.Printf("[API] Failed to create verification link for user %d: %v", target.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(caller.ID, caller.Email, "RESEND_VERIFICATION", "USER", idStr, getIP(r), "Sent verification link to "+target.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// POST /api/admin/users/{id}/verify
+// Marks the e-mail of a user verified without the link
+func (rt *Router) HandleAdminVerifyUser(w http.ResponseWriter, r *http.Request) {
+	caller, target, idStr, ok := rt.authorizeAdminUserAction(w, r, actionVerifyEmail)
+	if !ok {
+		return
+	}
+	if models.IsUserVerified(target) {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+	if err := rt.markEmailVerified(r, target); err != nil {
+		log.Printf("[API] Failed to verify e-mail of user %d: %v", target.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	rt.LogAudit(caller.ID, caller.Email, "VERIFY_EMAIL", "USER", idStr, getIP(r), "Marked e-mail of "+target.Email+" verified")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/metrics"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterMetrics registers in reg the gauges read from the store on every
+// scrape: machines and gateways by presence state, and gateway usage.
+func (rt *Router) RegisterMetrics(reg prometheus.Registerer) {
+	if rt.Presence != nil {
+		presence := func(kind string) func() ([]metrics.Sample, error) {
+			return func() ([]metrics.Sample, error) {
+				machines, gateways, err := rt.Presence.Counts(time.Now())
+				if err != nil {
+					return nil, err
+				}
+				c := machines
+				if kind == models.PresenceGateway {
+					c = gateways
+				}
+				return []metrics.Sample{
+					{Labels: []string{models.PresenceOnline}, Value: float64(c.Online)},
+					{Labels: []string{models.PresenceLate}, Value: float64(c.Late)},
+					{Labels: []string{models.PresenceOffline}, Value: float64(c.Offline)},
+				}, nil
+			}
+		}
+		reg.MustRegister(metrics.NewGaugeFunc("essensys_machines", "Machines by presence state.", []string{"state"}, presence(models.PresenceMachine)))
+		reg.MustRegister(metrics.NewGaugeFunc("essensys_gateways", "Gateways by presence state.", []string{"state"}, presence(models.PresenceGateway)))
+	}
+
+	gateway := func(value func(gw *models.GatewayStatus) float64) func() ([]metrics.Sample, error) {
+		return func() ([]metrics.Sample, error) {
+			gateways, err := rt.Store.GetGateways()
+			if err != nil {
+				return nil, err
+			}
+			samples := make([]metrics.Sample, 0, len(gateways))
+			for _, gw := range gateways {
+				samples = append(samples, metrics.Sample{Labels: []string{gw.Key(), gw.Hostname}, Value: value(gw)})
+			}
+			return samples, nil
+		}
+	}
+	// The key (ID once enrolled) keeps the series of a gateway stable
+	gatewayLabels := []string{"gateway", "hostname"}
+	reg.MustRegister(metrics.NewGaugeFunc("essensys_gateway_cpu_percent", "CPU usage last reported by the gateway.", gatewayLabels,
+		gateway(func(gw *models.GatewayStatus) float64 { return gw.MetricsPoint().CPU })))
+	reg.MustRegister(metrics.NewGaugeFunc("essensys_gateway_memory_percent", "Memory usage last reported by the gateway.", gatewayLabels,
+		gateway(func(gw *models.GatewayStatus) float64 { return gw.MetricsPoint().Memory })))
+	reg.MustRegister(metrics.NewGaugeFunc("essensys_gateway_disk_percent", "Disk usage last reported by the gateway.", gatewayLabels,
+		gateway(func(gw *models.GatewayStatus) float64 { return gw.MetricsPoint().Disk })))
+	reg.MustRegister(metrics.NewGaugeFunc("essensys_gateway_last_seen_timestamp_seconds", "Time of the last report of the gateway.", gatewayLabels,
+		gateway(func(gw *models.GatewayStatus) float64 { return float64(gw.LastSeen.Unix()) })))
+}
@@ -0,0 +1,223 @@
+// Package catalog loads docs/TableReference.json, the reference of the legacy
+// Table d'Echange, and decodes raw exchange key/values into readable states.
+package catalog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key kinds
+const (
+	KindEnum    = "enum"    // The value selects one entry (e.g. heating mode of a zone)
+	KindBitmask = "bitmask" // Each bit is an entry (e.g. one light per bit)
+)
+
+// Entry is one row of TableReference.json
+type Entry struct {
+	Zone             string `json:"zone"`
+	Piece            string `json:"piece"`
+	Categorie        string `json:"categorie"`
+	Keys             string `json:"keys"`
+	Value            string `json:"value"`
+	Attribute        string `json:"attribute"`
+	Action           string `json:"action"`
+	ShortDescription string `json:"shortDescription"`
+	LongDescription  string `json:"longDescription"`
+}
+
+type StatusBit struct {
+	Bit         string `json:"bit"`
+	Value       int    `json:"value"`
+	Description string `json:"description"`
+}
+
+// StatusRegister is a read-only bitfield such as the Alerte register (key 11)
+type StatusRegister struct {
+	Key  int         `json:"key"`
+	Name string      `json:"name"`
+	Bits []StatusBit `json:"bits"`
+}
+
+type Scenario struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Base        int    `json:"base"`
+	Description string `json:"description"`
+}
+
+// Reference is the raw content of TableReference.json
+type Reference struct {
+	Source               string                    `json:"source"`
+	Entries              []Entry                   `json:"entries"`
+	ScenarioDescriptions []Scenario                `json:"scenarioDescriptions"`
+	SystemStatusReadOnly map[string]StatusRegister `json:"systemStatusReadOnly"`
+}
+
+// KeyInfo describes one exchange key and every value the catalog knows for it
+type KeyInfo struct {
+	Key       int     `json:"key"`
+	Kind      string  `json:"kind"`
+	Zone      string  `json:"zone,omitempty"`
+	Categorie string  `json:"categorie,omitempty"`
+	Values    []Value `json:"values"`
+}
+
+// Value is one known value (enum) or bit (bitmask) of a key
+type Value struct {
+	Value     int    `json:"value"`
+	Label     string `json:"label"`
+	Zone      string `json:"zone,omitempty"`
+	Piece     string `json:"piece,omitempty"`
+	Categorie string `json:"categorie,omitempty"`
+	Action    string `json:"action,omitempty"`
+}
+
+// Catalog is an immutable, indexed version of the reference
+type Catalog struct {
+	Version   string                    `json:"version"`
+	LoadedAt  time.Time                 `json:"loaded_at"`
+	Source    string                    `json:"source"`
+	Keys      []*KeyInfo                `json:"keys"`
+	Scenarios []Scenario                `json:"scenarios"`
+	Status    map[string]StatusRegister `json:"status_registers"`
+
+	byKey    map[int]*KeyInfo
+	registry map[int]StatusRegister
+}
+
+// Parse builds a catalog from the JSON content of TableReference.json
+func Parse(content []byte) (*Catalog, error) {
+	var ref Reference
+	if err := json.Unmarshal(content, &ref); err != nil {
+		return nil, fmt.Errorf("decode reference: %w", err)
+	}
+	if len(ref.Entries) == 0 {
+		return nil, fmt.Errorf("reference has no entries")
+	}
+
+	sum := sha256.Sum256(content)
+	c := &Catalog{
+		Version:   hex.EncodeToString(sum[:6]),
+		LoadedAt:  time.Now(),
+		Source:    ref.Source,
+		Scenarios: ref.ScenarioDescriptions,
+		Status:    ref.SystemStatusReadOnly,
+		byKey:     make(map[int]*KeyInfo),
+		registry:  make(map[int]StatusRegister),
+	}
+	if c.Status == nil {
+		c.Status = map[string]StatusRegister{}
+	}
+	for _, reg := range c.Status {
+		c.registry[reg.Key] = reg
+	}
+
+	zones := make(map[int]map[string]bool)
+	for i, e := range ref.Entries {
+		key, err := strconv.Atoi(strings.TrimSpace(e.Keys))
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: invalid key %q", i, e.Keys)
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(e.Value))
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: invalid value %q", i, e.Value)
+		}
+		info, ok := c.byKey[key]
+		if !ok {
+			info = &KeyInfo{Key: key, Zone: clean(e.Zone), Categorie: clean(e.Categorie)}
+			c.byKey[key] = info
+			zones[key] = make(map[string]bool)
+		}
+		zones[key][clean(e.Zone)+"|"+clean(e.Piece)] = true
+		info.Values = append(info.Values, Value{
+			Value:     value,
+			Label:     strings.TrimSpace(e.Attribute),
+			Zone:      clean(e.Zone),
+			Piece:     clean(e.Piece),
+			Categorie: clean(e.Categorie),
+			Action:    clean(e.Action),
+		})
+	}
+
+	for key, info := range c.byKey {
+		// A key shared by several zones/pieces is a bitfield (one output per bit)
+		info.Kind = KindEnum
+		if len(zones[key]) > 1 {
+			info.Kind = KindBitmask
+			info.Zone = ""
+			for _, v := range info.Values {
+				if v.Categorie != info.Categorie {
+					info.Categorie = ""
+					break
+				}
+			}
+		}
+		sort.SliceStable(info.Values, func(i, j int) bool { return info.Values[i].Value < info.Values[j].Value })
+		c.Keys = append(c.Keys, info)
+	}
+	sort.Slice(c.Keys, func(i, j int) bool { return c.Keys[i].Key < c.Keys[j].Key })
+	return c, nil
+}
+
+// Key returns the catalog description of an exchange key
+func (c *Catalog) Key(key int) (*KeyInfo, bool) {
+	info, ok := c.byKey[key]
+	return info, ok
+}
+
+// clean maps the "NULL" placeholders of the reference to empty strings
+func clean(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "NULL" {
+		return ""
+	}
+	return s
+}
+
+// Registry holds the current catalog and reloads it from disk on demand
+type Registry struct {
+	mu      sync.RWMutex
+	path    string
+	current *Catalog
+}
+
+// NewRegistry loads the catalog at path
+func NewRegistry(path string) (*Registry, error) {
+	r := &Registry{path: path}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Current returns the catalog in use. It is never modified once published.
+func (r *Registry) Current() *Catalog {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Reload re-reads the reference file. The previous catalog stays active on error.
+func (r *Registry) Reload() (*Catalog, error) {
+	content, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(content)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.current = c
+	r.mu.Unlock()
+	return c, nil
+}
@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// Flags raised on values the catalog cannot explain
+const (
+	IssueUnknownKey   = "unknown_key"
+	IssueUnknownValue = "unknown_value"
+	IssueUnknownBits  = "unknown_bits"
+	IssueInvalidValue = "invalid_value"
+)
+
+// DecodedValue is the human-readable form of one exchange key/value
+type DecodedValue struct {
+	Key       int     `json:"k"`
+	Raw       string  `json:"v"`
+	Kind      string  `json:"kind,omitempty"`
+	Zone      string  `json:"zone,omitempty"`
+	Categorie string  `json:"categorie,omitempty"`
+	Label     string  `json:"label,omitempty"`  // Enum keys: the selected entry
+	Active    []Value `json:"active,omitempty"` // Bitmask keys and status registers: the bits set
+	Issue     string  `json:"issue,omitempty"`
+}
+
+// ZoneState summarises what a zone or output is currently doing
+type ZoneState struct {
+	Zone      string `json:"zone"`
+	Piece     string `json:"piece,omitempty"`
+	Categorie string `json:"categorie,omitempty"`
+	Key       int    `json:"k"`
+	State     string `json:"state"`
+}
+
+// DecodedState is the decoded snapshot of a machine
+type DecodedState struct {
+	CatalogVersion string         `json:"catalog_version"`
+	Zones          []ZoneState    `json:"zones"`
+	Values         []DecodedValue `json:"values"`
+	Flagged        int            `json:"flagged"` // Number of values with an Issue
+}
+
+// Decode turns a raw snapshot into zone states, flagging values outside the catalog
+func (c *Catalog) Decode(values []models.ExchangeKeyValue) *DecodedState {
+	state := &DecodedState{
+		CatalogVersion: c.Version,
+		Zones:          []ZoneState{},
+		Values:         make([]DecodedValue, 0, len(values)),
+	}
+	for _, kv := range values {
+		d := c.decodeOne(kv)
+		if d.Issue != "" {
+			state.Flagged++
+		}
+		state.Values = append(state.Values, d)
+
+		switch {
+		case d.Kind == KindEnum && d.Label != "":
+			state.Zones = append(state.Zones, ZoneState{Zone: d.Zone, Categorie: d.Categorie, Key: d.Key, State: d.Label})
+		case d.Kind == KindBitmask:
+			for _, v := range d.Active {
+				state.Zones = append(state.Zones, ZoneState{Zone: v.Zone, Piece: v.Piece, Categorie: v.Categorie, Key: d.Key, State: v.Label})
+			}
+		}
+	}
+	return state
+}
+
+func (c *Catalog) decodeOne(kv models.ExchangeKeyValue) DecodedValue {
+	d := DecodedValue{Key: kv.K, Raw: kv.V}
+
+	if reg, ok := c.registry[kv.K]; ok {
+		d.Kind = KindBitmask
+		d.Zone = reg.Name
+		n, ok := parseRaw(kv.V, true)
+		if !ok {
+			d.Issue = IssueInvalidValue
+			return d
+		}
+		for _, b := range reg.Bits {
+			if n&b.Value != 0 {
+				d.Active = append(d.Active, Value{Value: b.Value, Label: b.Description, Zone: reg.Name})
+				n &^= b.Value
+			}
+		}
+		if n != 0 {
+			d.Issue = IssueUnknownBits
+		}
+		return d
+	}
+
+	info, ok := c.byKey[kv.K]
+	if !ok {
+		d.Issue = IssueUnknownKey
+		return d
+	}
+	d.Kind = info.Kind
+	d.Zone = info.Zone
+	d.Categorie = info.Categorie
+
+	n, ok := parseRaw(kv.V, info.Kind == KindBitmask)
+	if !ok {
+		d.Issue = IssueInvalidValue
+		return d
+	}
+
+	if info.Kind == KindEnum {
+		for _, v := range info.Values {
+			if v.Value == n {
+				d.Label = v.Label
+				return d
+			}
+		}
+		d.Issue = IssueUnknownValue
+		return d
+	}
+
+	remaining := n
+	for _, v := range info.Values {
+		if v.Value != 0 && n&v.Value == v.Value {
+			d.Active = append(d.Active, v)
+			remaining &^= v.Value
+		}
+	}
+	if remaining != 0 {
+		d.Issue = IssueUnknownBits
+	}
+	return d
+}
+
+// parseRaw reads a decimal value. Bitfields may also be reported as an
+// 8-character binary string (e.g. "01001100").
+func parseRaw(raw string, bitfield bool) (int, bool) {
+	raw = strings.TrimSpace(raw)
+	if bitfield && len(raw) == 8 && strings.Trim(raw, "01") == "" {
+		n, err := strconv.ParseInt(raw, 2, 32)
+		return int(n), err == nil
+	}
+	n, err := strconv.Atoi(raw)
+	return n, err == nil && n >= 0
+}
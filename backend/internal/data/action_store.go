@@ -0,0 +1,295 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// ActionStore queues Table d'Echange writes per machine (keyed by NoSerie)
+type ActionStore interface {
+	EnqueueAction(a *models.MachineAction) error
+	// NextActions returns the open actions of a machine in FIFO order and
+	// marks them delivered. Actions past their expiry are expired instead.
+	NextActions(noSerie string) ([]*models.MachineAction, error)
+	AcknowledgeAction(noSerie, guid string) error
+	// ConfirmActions marks delivered/acknowledged actions as confirmed when
+	// the reported values match their params. Returns the number confirmed.
+	ConfirmActions(noSerie string, values []models.ExchangeKeyValue) (int, error)
+	GetActions(noSerie string) ([]*models.MachineAction, error)
+	CancelAction(noSerie, guid string) error
+	// RenameQueue moves a queue when the serial of a machine changes
+	RenameQueue(oldSerie, newSerie string) error
+	DeleteQueue(noSerie string) error
+}
+
+var (
+	ErrActionNotFound = errors.New("action not found")
+	ErrActionClosed   = errors.New("action is no longer open")
+)
+
+// FileActionStore keeps the action queues in memory and persists them to a JSON file
+type FileActionStore struct {
+	mu       sync.Mutex
+	actions  map[string][]*models.MachineAction // noSerie -> queue (FIFO)
+	filePath string
+}
+
+func NewFileActionStore(storagePath string) *FileActionStore {
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		log.Printf("Failed to create action storage dir: %v", err)
+	}
+	s := &FileActionStore{
+		actions:  make(map[string][]*models.MachineAction),
+		filePath: storagePath,
+	}
+	s.load()
+	return s
+}
+
+func (s *FileActionStore) load() {
+	file, err := os.Open(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open action storage file: %v", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	var actions map[string][]*models.MachineAction
+	if err := json.NewDecoder(file).Decode(&actions); err != nil {
+		log.Printf("Failed to decode action storage file: %v", err)
+		return
+	}
+	if actions != nil {
+		s.actions = actions
+	}
+	log.Printf("Loaded action queues for %d machines.", len(s.actions))
+}
+
+func (s *FileActionStore) save() {
+	b, err := json.Marshal(s.actions)
+	if err != nil {
+		log.Printf("Failed to encode action storage file: %v", err)
+		return
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write action storage file: %v", err)
+	}
+}
+
+func (s *FileActionStore) EnqueueAction(a *models.MachineAction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a.GUID == "" {
+		a.GUID = newUUID()
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	a.Status = models.ActionPending
+	s.actions[a.NoSerie] = append(s.actions[a.NoSerie], a)
+	s.save()
+	return nil
+}
+
+func (s *FileActionStore) NextActions(noSerie string) ([]*models.MachineAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	changed := s.expire(noSerie, now)
+	out := []*models.MachineAction{}
+	for _, a := range s.actions[noSerie] {
+		if !a.IsOpen() {
+			continue
+		}
+		changed = true
+		a.Status = models.ActionDelivered
+		a.Attempts++
+		a.DeliveredAt = &now
+		out = append(out, copyAction(a))
+	}
+	if changed {
+		s.save()
+	}
+	return out, nil
+}
+
+func (s *FileActionStore) AcknowledgeAction(noSerie, guid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.find(noSerie, guid)
+	if a == nil {
+		return ErrActionNotFound
+	}
+	switch a.Status {
+	case models.ActionAcknowledged, models.ActionConfirmed:
+		return nil // Legacy clients may retry the acknowledgement
+	case models.ActionPending, models.ActionDelivered:
+	default:
+		return ErrActionClosed
+	}
+	now := time.Now()
+	a.Status = models.ActionAcknowledged
+	a.AckedAt = &now
+	s.save()
+	return nil
+}
+
+func (s *FileActionStore) ConfirmActions(noSerie string, values []models.ExchangeKeyValue) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reported := make(map[int]string, len(values))
+	for _, kv := range values {
+		reported[kv.K] = kv.V
+	}
+
+	now := time.Now()
+	confirmed := 0
+	for _, a := range s.actions[noSerie] {
+		if a.Status != models.ActionDelivered && a.Status != models.ActionAcknowledged {
+			continue
+		}
+		if !paramsMatch(a.Params, reported) {
+			continue
+		}
+		a.Status = models.ActionConfirmed
+		a.ConfirmedAt = &now
+		confirmed++
+	}
+	if confirmed > 0 {
+		s.save()
+	}
+	return confirmed, nil
+}
+
+func (s *FileActionStore) GetActions(noSerie string) ([]*models.MachineAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expire(noSerie, time.Now()) {
+		s.save()
+	}
+
+	list := make([]*models.MachineAction, 0, len(s.actions[noSerie]))
+	for _, a := range s.actions[noSerie] {
+		list = append(list, copyAction(a))
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+func (s *FileActionStore) CancelAction(noSerie, guid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.find(noSerie, guid)
+	if a == nil {
+		return ErrActionNotFound
+	}
+	if !a.IsOpen() {
+		return ErrActionClosed
+	}
+	a.Status = models.ActionCancelled
+	s.save()
+	return nil
+}
+
+// expire closes the open actions of a machine whose TTL has elapsed. It
+// reports whether anything changed; the caller holds the lock and saves.
+func (s *FileActionStore) RenameQueue(oldSerie, newSerie string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue, ok := s.actions[oldSerie]
+	if !ok {
+		return nil
+	}
+	for _, a := range queue {
+		a.NoSerie = newSerie
+	}
+	s.actions[newSerie] = append(s.actions[newSerie], queue...)
+	delete(s.actions, oldSerie)
+	s.save()
+	return nil
+}
+
+func (s *FileActionStore) DeleteQueue(noSerie string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.actions[noSerie]; !ok {
+		return nil
+	}
+	delete(s.actions, noSerie)
+	s.save()
+	return nil
+}
+
+func (s *FileActionStore) expire(noSerie string, now time.Time) bool {
+	changed := false
+	for _, a := range s.actions[noSerie] {
+		if a.IsOpen() && now.After(a.ExpiresAt) {
+			a.Status = models.ActionExpired
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s *FileActionStore) find(noSerie, guid string) *models.MachineAction {
+	for _, a := range s.actions[noSerie] {
+		if a.GUID == guid {
+			return a
+		}
+	}
+	return nil
+}
+
+// paramsMatch is true when at least one param was reported and every reported
+// param has the requested value. Keys the machine does not report are ignored.
+func paramsMatch(params []models.ExchangeKeyValue, reported map[int]string) bool {
+	seen := 0
+	for _, p := range params {
+		v, ok := reported[p.K]
+		if !ok {
+			continue
+		}
+		if v != p.V {
+			return false
+		}
+		seen++
+	}
+	return seen > 0
+}
+
+func copyAction(a *models.MachineAction) *models.MachineAction {
+	c := *a
+	c.Params = append([]models.ExchangeKeyValue(nil), a.Params...)
+	return &c
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,291 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// AlertStore holds the alert rules and the alerts they raised
+type AlertStore interface {
+	GetRules() ([]*models.AlertRule, error)
+	GetRule(id string) (*models.AlertRule, error)
+	SaveRule(r *models.AlertRule) error // Creates the rule when ID is empty
+	DeleteRule(id string) error
+
+	// GetAlerts returns the matching alerts, most recent first
+	GetAlerts(filter models.AlertFilter) ([]*models.Alert, error)
+	GetAlert(id string) (*models.Alert, error)
+	SaveAlert(a *models.Alert) error // Creates the alert when ID is empty
+	// PruneAlerts deletes the alerts resolved before cutoff
+	PruneAlerts(cutoff time.Time) error
+}
+
+var (
+	ErrAlertRuleNotFound = errors.New("alert rule not found")
+	ErrAlertNotFound     = errors.New("alert not found")
+	ErrAlertResolved     = errors.New("alert already resolved")
+)
+
+// DefaultAlertRules are created when the store starts empty
+func DefaultAlertRules() []*models.AlertRule {
+	return []*models.AlertRule{
+		{Name: "Armoire hors ligne", Condition: models.AlertMachineOffline, Severity: models.AlertWarning, ForSeconds: 300, Notify: true},
+		{Name: "Passerelle hors ligne", Condition: models.AlertGatewayOffline, Severity: models.AlertCritical, ForSeconds: 300, Notify: true},
+		{Name: "Service de passerelle arrêté", Condition: models.AlertGatewayServiceDown, Severity: models.AlertWarning, ForSeconds: 120, Notify: true},
+		{Name: "Disque de passerelle plein", Condition: models.AlertGatewayMetric, Severity: models.AlertCritical, Metric: "disk", Operator: "gte", Threshold: 90, ForSeconds: 600, Notify: true},
+	}
+}
+
+// alertFile is the format of the storage file
+type alertFile struct {
+	Rules  []*models.AlertRule `json:"rules"`
+	Alerts []*models.Alert     `json:"alerts"`
+}
+
+// FileAlertStore keeps rules and alerts in memory and persists them to a JSON file
+type FileAlertStore struct {
+	mu       sync.RWMutex
+	rules    map[string]*models.AlertRule
+	alerts   map[string]*models.Alert
+	filePath string
+}
+
+func NewFileAlertStore(storagePath string) *FileAlertStore {
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		log.Printf("Failed to create alert storage dir: %v", err)
+	}
+	s := &FileAlertStore{
+		rules:    make(map[string]*models.AlertRule),
+		alerts:   make(map[string]*models.Alert),
+		filePath: storagePath,
+	}
+	if !s.load() {
+		now := time.Now()
+		for _, r := range DefaultAlertRules() {
+			r.ID = newUUID()
+			r.Enabled = true
+			r.CreatedAt, r.UpdatedAt = now, now
+			s.rules[r.ID] = r
+		}
+		if err := s.save(); err != nil {
+			log.Printf("Failed to write default alert rules: %v", err)
+		}
+	}
+	return s
+}
+
+// load reads the file, false when there is none yet
+func (s *FileAlertStore) load() bool {
+	b, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open alert storage file: %v", err)
+			return true // Do not overwrite a file we could not read
+		}
+		return false
+	}
+
+	var f alertFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		log.Printf("Failed to decode alert storage file: %v", err)
+		return true
+	}
+	for _, r := range f.Rules {
+		s.rules[r.ID] = r
+	}
+	for _, a := range f.Alerts {
+		s.alerts[a.ID] = a
+	}
+	log.Printf("Loaded %d alert rules and %d alerts.", len(s.rules), len(s.alerts))
+	return true
+}
+
+// save writes the file; the caller holds the lock
+func (s *FileAlertStore) save() error {
+	f := alertFile{
+		Rules:  make([]*models.AlertRule, 0, len(s.rules)),
+		Alerts: make([]*models.Alert, 0, len(s.alerts)),
+	}
+	for _, r := range s.rules {
+		f.Rules = append(f.Rules, r)
+	}
+	sort.Slice(f.Rules, func(i, j int) bool { return f.Rules[i].CreatedAt.Before(f.Rules[j].CreatedAt) })
+	for _, a := range s.alerts {
+		f.Alerts = append(f.Alerts, a)
+	}
+	sort.Slice(f.Alerts, func(i, j int) bool { return f.Alerts[i].OpenedAt.Before(f.Alerts[j].OpenedAt) })
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write alert storage file: %v", err)
+		return err
+	}
+	return nil
+}
+
+func copyRule(r *models.AlertRule) *models.AlertRule {
+	c := *r
+	c.Recipients = append([]string(nil), r.Recipients...)
+	return &c
+}
+
+func (s *FileAlertStore) GetRules() ([]*models.AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]*models.AlertRule, 0, len(s.rules))
+	for _, r := range s.rules {
+		list = append(list, copyRule(r))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (s *FileAlertStore) GetRule(id string) (*models.AlertRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.rules[id]
+	if !ok {
+		return nil, ErrAlertRuleNotFound
+	}
+	return copyRule(r), nil
+}
+
+func (s *FileAlertStore) SaveRule(r *models.AlertRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if r.ID == "" {
+		r.ID = newUUID()
+		r.CreatedAt = now
+	} else if existing, ok := s.rules[r.ID]; ok {
+		r.CreatedAt = existing.CreatedAt
+	} else {
+		return ErrAlertRuleNotFound
+	}
+	r.UpdatedAt = now
+
+	previous, existed := s.rules[r.ID]
+	s.rules[r.ID] = copyRule(r)
+	if err := s.save(); err != nil {
+		if existed {
+			s.rules[r.ID] = previous
+		} else {
+			delete(s.rules, r.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileAlertStore) DeleteRule(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rules[id]
+	if !ok {
+		return ErrAlertRuleNotFound
+	}
+	delete(s.rules, id)
+	if err := s.save(); err != nil {
+		s.rules[id] = r
+		return err
+	}
+	return nil
+}
+
+func (s *FileAlertStore) GetAlerts(filter models.AlertFilter) ([]*models.Alert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []*models.Alert{}
+	for _, a := range s.alerts {
+		switch {
+		case filter.Status == "active" && !a.Active():
+			continue
+		case filter.Status != "" && filter.Status != "active" && a.Status != filter.Status:
+			continue
+		case filter.Kind != "" && a.Kind != filter.Kind:
+			continue
+		case filter.ResourceID != "" && a.ResourceID != filter.ResourceID:
+			continue
+		case filter.RuleID != "" && a.RuleID != filter.RuleID:
+			continue
+		}
+		c := *a
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].OpenedAt.After(list[j].OpenedAt) })
+	return list, nil
+}
+
+func (s *FileAlertStore) GetAlert(id string) (*models.Alert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.alerts[id]
+	if !ok {
+		return nil, ErrAlertNotFound
+	}
+	c := *a
+	return &c, nil
+}
+
+func (s *FileAlertStore) SaveAlert(a *models.Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a.ID == "" {
+		a.ID = newUUID()
+	} else if _, ok := s.alerts[a.ID]; !ok {
+		return ErrAlertNotFound
+	}
+	previous, existed := s.alerts[a.ID]
+	c := *a
+	s.alerts[a.ID] = &c
+	if err := s.save(); err != nil {
+		if existed {
+			s.alerts[a.ID] = previous
+		} else {
+			delete(s.alerts, a.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *FileAlertStore) PruneAlerts(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for id, a := range s.alerts {
+		if a.ResolvedAt != nil && a.ResolvedAt.Before(cutoff) {
+			delete(s.alerts, id)
+			pruned++
+		}
+	}
+	if pruned == 0 {
+		return nil
+	}
+	log.Printf("[ALERTS] Pruned %d resolved alerts", pruned)
+	return s.save()
+}
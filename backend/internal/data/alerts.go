@@ -0,0 +1,568 @@
+package data
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/catalog"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// AlertEngine evaluates the alert rules on machines and gateways, opens and
+// resolves alerts and sends the notifications.
+//
+// An alert opens once its condition held for ForSeconds, and resolves once it
+// was clear for ResolveAfterSeconds. There is one active alert per rule and
+// resource; an alert reopening within FlapWindow after resolving is the same
+// alert (Flaps+1) and is not notified again.
+//
+// The notifications of an evaluation are sent after it, outside the lock: one
+// e-mail per recipient list and evaluation, however many alerts changed.
+type AlertEngine struct {
+	Store   Store
+	Alerts  AlertStore
+	Policy  models.PresencePolicy
+	Catalog *catalog.Registry // Optional, to compare machine values with their label
+
+	Notify              func(to []string, subject, body string) error // Nil: no e-mail
+	Recipients          []string                                      // When the rule has none
+	DefaultResolveAfter time.Duration
+	FlapWindow          time.Duration
+
+	mu       sync.Mutex
+	pending  map[string]time.Time // Condition true, alert not open yet: since when
+	clearing map[string]time.Time // Condition false, alert still active: since when
+	outbox   []alertNotice        // Queued by the running evaluation
+}
+
+// alertNotice is a notification queued during an evaluation
+type alertNotice struct {
+	alertID string
+	opened  bool // Opening notice: NotifiedAt is cleared if it is not sent
+	to      []string
+	subject string
+	body    string
+}
+
+// alertResource is a machine or a gateway seen by the engine
+type alertResource struct {
+	kind     string
+	id       string
+	name     string
+	lastSeen time.Time
+	gateway  *models.GatewayStatus
+	machine  *models.MachineDetail
+}
+
+// alertCheck is the outcome of one rule on one resource
+type alertCheck struct {
+	firing  bool
+	value   string
+	message string
+}
+
+func NewAlertEngine(store Store, alerts AlertStore, policy models.PresencePolicy) *AlertEngine {
+	return &AlertEngine{
+		Store:               store,
+		Alerts:              alerts,
+		Policy:              policy,
+		DefaultResolveAfter: 2 * time.Minute,
+		FlapWindow:          15 * time.Minute,
+	}
+}
+
+// RunAlertEngine evaluates the rules every interval and prunes the resolved
+// alerts older than retention once an hour. Blocking, run it in a goroutine.
+func RunAlertEngine(e *AlertEngine, every, retention time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		now := time.Now()
+		if err := e.Evaluate(now); err != nil {
+			log.Printf("[ALERTS] Evaluation failed: %v", err)
+		}
+		if retention > 0 && now.Sub(lastPrune) >= time.Hour {
+			if err := e.Alerts.PruneAlerts(now.Add(-retention)); err != nil {
+				log.Printf("[ALERTS] Prune failed: %v", err)
+			}
+			lastPrune = now
+		}
+		<-ticker.C
+	}
+}
+
+func alertKey(ruleID, kind, resourceID string) string {
+	return ruleID + "|" + kind + "|" + resourceID
+}
+
+// Evaluate checks every enabled rule on the resources it applies to, then
+// sends the notifications
+func (e *AlertEngine) Evaluate(now time.Time) error {
+	notices, err := e.evaluate(now)
+	e.send(notices, now)
+	return err
+}
+
+// evaluate updates the alerts and returns the notifications to send
+func (e *AlertEngine) evaluate(now time.Time) ([]alertNotice, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.outbox = nil
+	if e.pending == nil {
+		e.pending = make(map[string]time.Time)
+		e.clearing = make(map[string]time.Time)
+	}
+
+	rules, err := e.Alerts.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := e.resources()
+	if err != nil {
+		return nil, err
+	}
+	all, err := e.Alerts.GetAlerts(models.AlertFilter{})
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]*models.Alert)
+	resolved := make(map[string]*models.Alert) // Latest resolved, for flaps
+	// all is sorted most recent first
+	for _, a := range all {
+		key := alertKey(a.RuleID, a.Kind, a.ResourceID)
+		if a.Active() {
+			active[key] = a
+		} else if _, ok := resolved[key]; !ok {
+			resolved[key] = a
+		}
+	}
+
+	// Targets whose global rule is replaced, disabled overrides included
+	overridden := make(map[string]bool)
+	for _, r := range rules {
+		if r.Overrides != "" && r.Target != "" {
+			overridden[r.Overrides+"|"+r.Target] = true
+		}
+	}
+
+	evaluated := make(map[string]bool)
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		kind := rule.Kind()
+		for _, res := range resources[kind] {
+			if rule.Target != "" && rule.Target != res.id {
+				continue
+			}
+			if rule.Target == "" && overridden[rule.ID+"|"+res.id] {
+				continue
+			}
+			key := alertKey(rule.ID, kind, res.id)
+			evaluated[key] = true
+			e.apply(rule, res, e.check(rule, res, now), active[key], resolved[key], now)
+		}
+	}
+
+	// Rules deleted or disabled, resources removed: nothing left to watch
+	for key, a := range active {
+		if evaluated[key] {
+			continue
+		}
+		delete(e.clearing, key)
+		e.resolve(a, nil, now)
+	}
+	for key := range e.pending {
+		if !evaluated[key] {
+			delete(e.pending, key)
+		}
+	}
+	notices := e.outbox
+	e.outbox = nil
+	return notices, nil
+}
+
+// apply moves the alert of one rule and resource through its lifecycle
+func (e *AlertEngine) apply(rule *models.AlertRule, res alertResource, c alertCheck, current, lastResolved *models.Alert, now time.Time) {
+	key := alertKey(rule.ID, res.kind, res.id)
+
+	if !c.firing {
+		delete(e.pending, key)
+		if current == nil {
+			delete(e.clearing, key)
+			return
+		}
+		since, ok := e.clearing[key]
+		if !ok {
+			since = now
+			e.clearing[key] = now
+		}
+		resolveAfter := time.Duration(rule.ResolveAfterSeconds) * time.Second
+		if rule.ResolveAfterSeconds == 0 {
+			resolveAfter = e.DefaultResolveAfter
+		}
+		if now.Sub(since) >= resolveAfter {
+			delete(e.clearing, key)
+			e.resolve(current, rule, now)
+		}
+		return
+	}
+
+	delete(e.clearing, key)
+	if current != nil {
+		if current.Value != c.value || current.Message != c.message {
+			current.Value, current.Message, current.UpdatedAt = c.value, c.message, now
+			e.save(current)
+		}
+		return
+	}
+
+	since, ok := e.pending[key]
+	if !ok {
+		since = now
+		e.pending[key] = now
+	}
+	if now.Sub(since) < time.Duration(rule.ForSeconds)*time.Second {
+		return
+	}
+	delete(e.pending, key)
+
+	if lastResolved != nil && lastResolved.ResolvedAt != nil && now.Sub(*lastResolved.ResolvedAt) <= e.FlapWindow {
+		a := lastResolved
+		a.Status = models.AlertOpen
+		a.Flaps++
+		a.ResolvedAt, a.ResolvedBy = nil, ""
+		a.AckedBy, a.AckedAt, a.AckNote = "", nil, ""
+		a.Value, a.Message, a.UpdatedAt = c.value, c.message, now
+		a.RuleName, a.Severity = rule.Name, rule.Severity
+		log.Printf("[ALERTS] Reopened %s on %s %s (flap %d)", rule.Name, res.kind, res.name, a.Flaps)
+		e.save(a)
+		return
+	}
+
+	a := &models.Alert{
+		RuleID:     rule.ID,
+		RuleName:   rule.Name,
+		Severity:   rule.Severity,
+		Kind:       res.kind,
+		ResourceID: res.id,
+		Resource:   res.name,
+		Status:     models.AlertOpen,
+		Message:    c.message,
+		Value:      c.value,
+		OpenedAt:   since,
+		UpdatedAt:  now,
+	}
+	log.Printf("[ALERTS] Opened %s on %s %s: %s", rule.Name, res.kind, res.name, c.message)
+	to := e.recipients(rule)
+	if len(to) > 0 {
+		a.NotifiedAt = &now
+	}
+	e.save(a) // Sets the ID of the notice
+	if len(to) > 0 {
+		e.queue(a, true, to, "["+strings.ToUpper(a.Severity)+"] "+a.RuleName+" - "+a.Resource, alertBody(a, "Alerte ouverte"))
+	}
+}
+
+// resolve closes an alert whose condition cleared. rule is nil when the
+// alert has nothing left to watch; it is then closed without notification.
+func (e *AlertEngine) resolve(a *models.Alert, rule *models.AlertRule, now time.Time) {
+	a.Status = models.AlertResolved
+	a.ResolvedAt = &now
+	a.UpdatedAt = now
+	log.Printf("[ALERTS] Resolved %s on %s %s", a.RuleName, a.Kind, a.Resource)
+	e.save(a)
+	if rule != nil && a.NotifiedAt != nil {
+		if to := e.recipients(rule); len(to) > 0 {
+			e.queue(a, false, to, "[RESOLU] "+a.RuleName+" - "+a.Resource, alertBody(a, "Alerte résolue"))
+		}
+	}
+}
+
+// Acknowledge marks an active alert as handled by actor. It stays active
+// until its condition clears.
+func (e *AlertEngine) Acknowledge(id, actor, note string) (*models.Alert, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	a, err := e.Alerts.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Active() {
+		return nil, ErrAlertResolved
+	}
+	now := time.Now()
+	a.Status = models.AlertAcknowledged
+	a.AckedBy, a.AckedAt, a.AckNote = actor, &now, note
+	a.UpdatedAt = now
+	if err := e.Alerts.SaveAlert(a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Resolve closes an active alert by hand. It opens again, as a flap, if its
+// condition still holds after the rule's ForSeconds.
+func (e *AlertEngine) Resolve(id, actor, note string) (*models.Alert, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	a, err := e.Alerts.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if !a.Active() {
+		return nil, ErrAlertResolved
+	}
+	now := time.Now()
+	a.Status = models.AlertResolved
+	a.ResolvedAt, a.ResolvedBy = &now, actor
+	if note != "" {
+		a.AckNote = note
+	}
+	a.UpdatedAt = now
+	if err := e.Alerts.SaveAlert(a); err != nil {
+		return nil, err
+	}
+	key := alertKey(a.RuleID, a.Kind, a.ResourceID)
+	delete(e.pending, key)
+	delete(e.clearing, key)
+	return a, nil
+}
+
+func (e *AlertEngine) save(a *models.Alert) {
+	if err := e.Alerts.SaveAlert(a); err != nil {
+		log.Printf("[ALERTS] Failed to save alert %s: %v", a.ID, err)
+	}
+}
+
+// recipients returns who is e-mailed about the alerts of rule, none if the
+// rule is not notified
+func (e *AlertEngine) recipients(rule *models.AlertRule) []string {
+	if !rule.Notify || e.Notify == nil {
+		return nil
+	}
+	if len(rule.Recipients) > 0 {
+		return rule.Recipients
+	}
+	return e.Recipients
+}
+
+// queue adds a notification to the outbox of the running evaluation
+func (e *AlertEngine) queue(a *models.Alert, opened bool, to []string, subject, body string) {
+	e.outbox = append(e.outbox, alertNotice{alertID: a.ID, opened: opened, to: to, subject: subject, body: body})
+}
+
+// send e-mails the notices of an evaluation, one e-mail per recipient list.
+// The opening notices that could not be sent are not marked as notified, so
+// that their resolution is not notified either.
+func (e *AlertEngine) send(notices []alertNotice, notifiedAt time.Time) {
+	var order []string
+	batches := make(map[string][]alertNotice)
+	for _, n := range notices {
+		key := strings.Join(n.to, ",")
+		if _, ok := batches[key]; !ok {
+			order = append(order, key)
+		}
+		batches[key] = append(batches[key], n)
+	}
+
+	for _, key := range order {
+		batch := batches[key]
+		subject, body := batch[0].subject, batch[0].body
+		if len(batch) > 1 {
+			subject = fmt.Sprintf("[ALERTES] %d notifications", len(batch))
+			bodies := make([]string, len(batch))
+			for i, n := range batch {
+				bodies[i] = n.body
+			}
+			body = strings.Join(bodies, "<hr>")
+		}
+		if err := e.Notify(batch[0].to, subject, body); err != nil {
+			log.Printf("[ALERTS] Failed to send %d notifications to %s: %v", len(batch), key, err)
+			for _, n := range batch {
+				if n.opened {
+					e.unmarkNotified(n.alertID, notifiedAt)
+				}
+			}
+		}
+	}
+}
+
+// unmarkNotified clears the NotifiedAt set by the evaluation at notifiedAt
+func (e *AlertEngine) unmarkNotified(id string, notifiedAt time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	a, err := e.Alerts.GetAlert(id)
+	if err != nil || a.NotifiedAt == nil || !a.NotifiedAt.Equal(notifiedAt) {
+		return
+	}
+	a.NotifiedAt = nil
+	e.save(a)
+}
+
+func alertBody(a *models.Alert, title string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<h2>%s : %s</h2>", title, html.EscapeString(a.RuleName))
+	fmt.Fprintf(&b, "<p>%s</p><ul>", html.EscapeString(a.Message))
+	fmt.Fprintf(&b, "<li>Sévérité : %s</li>", a.Severity)
+	fmt.Fprintf(&b, "<li>Ressource : %s %s</li>", a.Kind, html.EscapeString(a.Resource))
+	if a.Value != "" {
+		fmt.Fprintf(&b, "<li>Valeur : %s</li>", html.EscapeString(a.Value))
+	}
+	fmt.Fprintf(&b, "<li>Ouverte le : %s</li>", a.OpenedAt.Format(time.RFC3339))
+	if a.ResolvedAt != nil {
+		fmt.Fprintf(&b, "<li>Résolue le : %s</li>", a.ResolvedAt.Format(time.RFC3339))
+	}
+	b.WriteString("</ul>")
+	return b.String()
+}
+
+// resources lists the machines and gateways by kind
+func (e *AlertEngine) resources() (map[string][]alertResource, error) {
+	machines, err := e.Store.GetMachines()
+	if err != nil {
+		return nil, err
+	}
+	gateways, err := e.Store.GetGateways()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]alertResource{
+		models.PresenceMachine: make([]alertResource, 0, len(machines)),
+		models.PresenceGateway: make([]alertResource, 0, len(gateways)),
+	}
+	for _, m := range machines {
+		if !m.IsActive {
+			continue // Deactivated on purpose, not a fault
+		}
+		out[models.PresenceMachine] = append(out[models.PresenceMachine], alertResource{
+			kind: models.PresenceMachine, id: strconv.Itoa(m.ID), name: m.NoSerie, lastSeen: m.LastSeen, machine: m,
+		})
+	}
+	for _, gw := range gateways {
+		out[models.PresenceGateway] = append(out[models.PresenceGateway], alertResource{
+			kind: models.PresenceGateway, id: gw.Key(), name: gw.Hostname, lastSeen: gw.LastSeen, gateway: gw,
+		})
+	}
+	return out, nil
+}
+
+// check evaluates the condition of rule on res
+func (e *AlertEngine) check(rule *models.AlertRule, res alertResource, now time.Time) alertCheck {
+	switch rule.Condition {
+	case models.AlertMachineOffline, models.AlertGatewayOffline:
+		state, _ := e.Policy.State(res.kind, res.lastSeen, now)
+		want := rule.State
+		if want == "" {
+			want = models.PresenceOffline
+		}
+		firing := state == want || (want == models.PresenceLate && state == models.PresenceOffline)
+		msg := fmt.Sprintf("%s est %s", res.name, state)
+		if !res.lastSeen.IsZero() {
+			msg += ", vu pour la dernière fois le " + res.lastSeen.Format(time.RFC3339)
+		}
+		return alertCheck{firing: firing, value: state, message: msg}
+
+	case models.AlertGatewayServiceDown:
+		var down []string
+		for name, up := range res.gateway.Services {
+			if !up && (rule.Service == "" || rule.Service == name) {
+				down = append(down, name)
+			}
+		}
+		sort.Strings(down)
+		value := strings.Join(down, ", ")
+		return alertCheck{firing: len(down) > 0, value: value, message: fmt.Sprintf("Services arrêtés sur %s : %s", res.name, value)}
+
+	case models.AlertGatewayMetric:
+		p := res.gateway.MetricsPoint()
+		var v float64
+		switch rule.Metric {
+		case "cpu":
+			v = p.CPU
+		case "memory":
+			v = p.Memory
+		case "disk":
+			v = p.Disk
+		case "client_count":
+			v = p.ClientCount
+		}
+		value := strconv.FormatFloat(v, 'f', 1, 64)
+		return alertCheck{
+			firing:  compareAlertNumber(v, rule.Operator, rule.Threshold),
+			value:   value,
+			message: fmt.Sprintf("%s de %s : %s (seuil %s %g)", rule.Metric, res.name, value, rule.Operator, rule.Threshold),
+		}
+
+	case models.AlertMachineValue:
+		raw, label, ok := e.machineValue(res.machine, rule.Key)
+		if !ok {
+			return alertCheck{}
+		}
+		value := raw
+		if label != "" {
+			value = raw + " (" + label + ")"
+		}
+		var firing bool
+		if rule.Value != "" {
+			// Text comparison, with the raw value or its catalog label
+			match := raw == rule.Value || strings.EqualFold(label, rule.Value)
+			firing = match == (rule.Operator != "ne")
+		} else if n, err := strconv.ParseFloat(strings.TrimSpace(raw), 64); err == nil {
+			firing = compareAlertNumber(n, rule.Operator, rule.Threshold)
+		}
+		return alertCheck{firing: firing, value: value, message: fmt.Sprintf("Clé %d de %s : %s", rule.Key, res.name, value)}
+	}
+	return alertCheck{}
+}
+
+// machineValue returns the last value of key and its catalog label
+func (e *AlertEngine) machineValue(m *models.MachineDetail, key int) (raw, label string, ok bool) {
+	snapshot, err := e.Store.GetClientData(m.NoSerie)
+	if err != nil {
+		return "", "", false
+	}
+	for _, kv := range snapshot {
+		if kv.K != key {
+			continue
+		}
+		if e.Catalog != nil {
+			if d := e.Catalog.Current().Decode([]models.ExchangeKeyValue{kv}); len(d.Values) == 1 {
+				label = d.Values[0].Label
+			}
+		}
+		return kv.V, label, true
+	}
+	return "", "", false
+}
+
+// AlertOperators are the comparisons a rule may use
+var AlertOperators = map[string]bool{"gt": true, "gte": true, "lt": true, "lte": true, "eq": true, "ne": true}
+
+func compareAlertNumber(v float64, op string, threshold float64) bool {
+	switch op {
+	case "gt":
+		return v > threshold
+	case "gte":
+		return v >= threshold
+	case "lt":
+		return v < threshold
+	case "lte":
+		return v <= threshold
+	case "eq":
+		return v == threshold
+	case "ne":
+		return v != threshold
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// newTestAlertEngine watches the machines of store with one notified
+// machine_offline rule
+func newTestAlertEngine(t *testing.T, store Store) *AlertEngine {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	rules := `{"rules": [{"id": "offline", "name": "Offline", "condition": "machine_offline", "severity": "critical", "enabled": true, "notify": true}], "alerts": []}`
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := NewAlertEngine(store, NewFileAlertStore(path), models.PresencePolicy{
+		MachineInterval: 10 * time.Second, GatewayInterval: time.Minute, LateAfter: 3, OfflineAfter: 10,
+	})
+	e.Recipients = []string{"ops@example.com"}
+	return e
+}
+
+func TestAlertEngineBatchesNotificationsOutsideTheLock(t *testing.T) {
+	store := newTestStore(t, 6, PersistenceOptions{}) // 3 active machines, never seen
+	e := newTestAlertEngine(t, store)
+	var sent []string
+	e.Notify = func(to []string, subject, body string) error {
+		if !e.mu.TryLock() {
+			t.Error("notification sent while holding the engine lock")
+		} else {
+			e.mu.Unlock()
+		}
+		sent = append(sent, subject)
+		return nil
+	}
+
+	if err := e.Evaluate(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0] != "[ALERTES] 3 notifications" {
+		t.Errorf("sent %q, want one e-mail for the 3 alerts", sent)
+	}
+	alerts, _ := e.Alerts.GetAlerts(models.AlertFilter{})
+	for _, a := range alerts {
+		if a.NotifiedAt == nil {
+			t.Errorf("alert on %s not marked as notified", a.Resource)
+		}
+	}
+}
+
+func TestAlertEngineUnmarksUnsentNotifications(t *testing.T) {
+	store := newTestStore(t, 2, PersistenceOptions{}) // Machine 2 is active
+	e := newTestAlertEngine(t, store)
+	e.Notify = func(to []string, subject, body string) error {
+		return errors.New("smtp down")
+	}
+
+	if err := e.Evaluate(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	alerts, _ := e.Alerts.GetAlerts(models.AlertFilter{})
+	if len(alerts) != 1 {
+		t.Fatalf("%d alerts, want 1", len(alerts))
+	}
+	if a := alerts[0]; !a.Active() || a.NotifiedAt != nil {
+		t.Errorf("alert %s: active %v, notified %v; want active, not notified", a.Resource, a.Active(), a.NotifiedAt)
+	}
+}
@@ -1,9 +1,12 @@
 package data
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 	"time"
 
+	"github.com/essensys-hub/essensys-support-site/backend/internal/events"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
 	"github.com/jmoiron/sqlx"
 )
@@ -15,7 +18,8 @@ type AuditStore interface {
 }
 
 type PostgresAuditStore struct {
-	db *sqlx.DB
+	db     *sqlx.DB
+	Events *events.Bus // Publishes the new entries, nil to disable
 }
 
 func NewPostgresAuditStore(db *sqlx.DB) *PostgresAuditStore {
@@ -53,8 +57,26 @@ func (s *PostgresAuditStore) CreateAuditLog(l *models.AuditLog) error {
 	_, err := s.db.NamedExec(query, l)
 	if err != nil {
 		log.Printf("Error inserting audit log: %v", err)
+		return err
 	}
-	return err
+	s.publish(l)
+	return nil
+}
+
+// publish sends the entry to the event bus, scoped like GetAuditLogs: to the
+// machine linked to the user who performed the action
+func (s *PostgresAuditStore) publish(l *models.AuditLog) {
+	if s.Events == nil {
+		return
+	}
+	var machineID sql.NullInt64
+	if l.UserID != 0 {
+		if err := s.db.Get(&machineID, `SELECT linked_machine_id FROM users WHERE id = $1`, l.UserID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error reading audit user scope: %v", err)
+		}
+	}
+	entry := *l
+	s.Events.Publish(events.Event{Type: events.Audit, Time: l.CreatedAt, MachineID: int(machineID.Int64), Data: &entry})
 }
 
 func (s *PostgresAuditStore) GetAuditLogs(filter models.AuditFilter) ([]*models.AuditLog, error) {
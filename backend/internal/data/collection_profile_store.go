@@ -0,0 +1,191 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// CollectionProfileStore manages the key sets requested through /api/serverinfos
+type CollectionProfileStore interface {
+	GetProfiles() ([]*models.CollectionProfile, error)
+	GetProfile(id string) (*models.CollectionProfile, error)
+	SaveProfile(p *models.CollectionProfile) error
+	DeleteProfile(id string) error
+	// ResolveCollection picks the profile of a machine: machine assignment first,
+	// then firmware version, then the fleet default, then the built-in key set.
+	ResolveCollection(machineID int, version string) (*models.EffectiveCollection, error)
+}
+
+var ErrProfileNotFound = errors.New("collection profile not found")
+
+// FileCollectionProfileStore keeps the profiles in memory and persists them to a JSON file
+type FileCollectionProfileStore struct {
+	mu       sync.RWMutex
+	profiles map[string]*models.CollectionProfile
+	filePath string
+}
+
+func NewFileCollectionProfileStore(storagePath string) *FileCollectionProfileStore {
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		log.Printf("Failed to create profile storage dir: %v", err)
+	}
+	s := &FileCollectionProfileStore{
+		profiles: make(map[string]*models.CollectionProfile),
+		filePath: storagePath,
+	}
+	s.load()
+	return s
+}
+
+func (s *FileCollectionProfileStore) load() {
+	file, err := os.Open(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open profile storage file: %v", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	var list []*models.CollectionProfile
+	if err := json.NewDecoder(file).Decode(&list); err != nil {
+		log.Printf("Failed to decode profile storage file: %v", err)
+		return
+	}
+	for _, p := range list {
+		s.profiles[p.ID] = p
+	}
+	log.Printf("Loaded %d collection profiles.", len(s.profiles))
+}
+
+func (s *FileCollectionProfileStore) save() {
+	b, err := json.Marshal(s.sorted())
+	if err != nil {
+		log.Printf("Failed to encode profile storage file: %v", err)
+		return
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write profile storage file: %v", err)
+	}
+}
+
+// sorted returns the profiles by name; the caller holds the lock
+func (s *FileCollectionProfileStore) sorted() []*models.CollectionProfile {
+	list := make([]*models.CollectionProfile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *FileCollectionProfileStore) GetProfiles() ([]*models.CollectionProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := s.sorted()
+	for i, p := range list {
+		list[i] = copyProfile(p)
+	}
+	return list, nil
+}
+
+func (s *FileCollectionProfileStore) GetProfile(id string) (*models.CollectionProfile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.profiles[id]
+	if !ok {
+		return nil, ErrProfileNotFound
+	}
+	return copyProfile(p), nil
+}
+
+func (s *FileCollectionProfileStore) SaveProfile(p *models.CollectionProfile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.ID == "" {
+		p.ID = newUUID()
+	}
+	// Only one fleet default at a time
+	if p.IsDefault {
+		for _, other := range s.profiles {
+			if other.ID != p.ID && other.IsDefault {
+				other.IsDefault = false
+				other.UpdatedAt = time.Now()
+			}
+		}
+	}
+	s.profiles[p.ID] = copyProfile(p)
+	s.save()
+	return nil
+}
+
+func (s *FileCollectionProfileStore) DeleteProfile(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.profiles[id]; !ok {
+		return ErrProfileNotFound
+	}
+	delete(s.profiles, id)
+	s.save()
+	return nil
+}
+
+func (s *FileCollectionProfileStore) ResolveCollection(machineID int, version string) (*models.EffectiveCollection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	var byMachine, byFirmware, byDefault *models.CollectionProfile
+	// Iterate in a stable order so overlapping profiles resolve deterministically
+	for _, p := range s.sorted() {
+		if !p.IsActive(now) {
+			continue
+		}
+		if byMachine == nil && slices.Contains(p.MachineIDs, machineID) {
+			byMachine = p
+		}
+		if byFirmware == nil && version != "" && slices.Contains(p.FirmwareVersions, version) {
+			byFirmware = p
+		}
+		if byDefault == nil && p.IsDefault {
+			byDefault = p
+		}
+	}
+
+	eff := &models.EffectiveCollection{MachineID: machineID, Version: version}
+	switch {
+	case byMachine != nil:
+		eff.Source, eff.Profile = "machine", copyProfile(byMachine)
+	case byFirmware != nil:
+		eff.Source, eff.Profile = "firmware", copyProfile(byFirmware)
+	case byDefault != nil:
+		eff.Source, eff.Profile = "default", copyProfile(byDefault)
+	default:
+		eff.Source = "builtin"
+		eff.Keys = slices.Clone(models.DefaultCollectionKeys)
+		return eff, nil
+	}
+	eff.Keys = eff.Profile.Keys
+	return eff, nil
+}
+
+func copyProfile(p *models.CollectionProfile) *models.CollectionProfile {
+	c := *p
+	c.Keys = slices.Clone(p.Keys)
+	c.MachineIDs = slices.Clone(p.MachineIDs)
+	c.FirmwareVersions = slices.Clone(p.FirmwareVersions)
+	return &c
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// ErrCredentialNotKept: the machine has no sealed credential (registered
+// without a sealer), or it was sealed with another key
+var ErrCredentialNotKept = errors.New("credential not kept")
+
+// MachineKey is the storage key of a legacy credential (username + password,
+// the MD5 of the activation key): its SHA-256 in hex. The stores never keep
+// the credential itself; see CredentialSealer for the audited reveal.
+func MachineKey(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
+// isMachineKey tells a storage key from a credential stored before the keys
+// were hashed: legacy credentials are 32 hex characters, keys 64
+func isMachineKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// CredentialSealer encrypts the credentials kept for the audited reveal
+// (AES-256-GCM), bound to their machine key. A nil sealer keeps none.
+type CredentialSealer struct {
+	aead cipher.AEAD
+}
+
+// NewCredentialSealer derives the encryption key from secret
+func NewCredentialSealer(secret string) (*CredentialSealer, error) {
+	key := sha256.Sum256([]byte("machine-credentials:" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialSealer{aead: aead}, nil
+}
+
+// Seal encrypts credential; "" with a nil sealer
+func (c *CredentialSealer) Seal(credential string) string {
+	if c == nil {
+		return ""
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	out := c.aead.Seal(nonce, nonce, []byte(credential), []byte(MachineKey(credential)))
+	return base64.RawStdEncoding.EncodeToString(out)
+}
+
+// Open decrypts the credential sealed for the machine key
+func (c *CredentialSealer) Open(key, sealed string) (string, error) {
+	if c == nil || sealed == "" {
+		return "", ErrCredentialNotKept
+	}
+	b, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < c.aead.NonceSize() {
+		return "", ErrCredentialNotKept
+	}
+	n := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, b[:n], b[n:], []byte(key))
+	if err != nil {
+		return "", ErrCredentialNotKept
+	}
+	return string(plain), nil
+}
+
+// CredentialFingerprint identifies a machine key in the connection details
+// and the logs: the first 8 bytes of the SHA-256 of the credential
+func CredentialFingerprint(key string) string {
+	return key[:16]
+}
+
+// restoreKeys sets the keys and sealed credentials of the machines read from
+// a file. Files written before the keys were hashed are keyed by the
+// credential: their entries move to its MachineKey and the credential is
+// sealed. Returns the number of machines moved. Gateways, keyed by hostname
+// before enrolled ones were keyed by ID, move to their Key.
+func (pd *PersistenceData) restoreKeys(sealer *CredentialSealer) int {
+	machines := make(map[string]*models.Machine, len(pd.Machines))
+	details := make(map[string]*models.MachineDetail, len(pd.Details))
+	moved := 0
+	for key, m := range pd.Machines {
+		if !isMachineKey(key) {
+			m.SealedCredential = sealer.Seal(key)
+			key = MachineKey(key)
+			moved++
+		} else {
+			m.SealedCredential = pd.Credentials[key]
+		}
+		m.HashedPkey = key
+		machines[key] = m
+	}
+	for key, d := range pd.Details {
+		if !isMachineKey(key) {
+			key = MachineKey(key)
+		}
+		details[key] = d
+	}
+	pd.Machines, pd.Details, pd.Credentials = machines, details, nil
+	if pd.Gateways != nil {
+		gateways := make(map[string]*models.GatewayStatus, len(pd.Gateways))
+		for _, gw := range pd.Gateways {
+			gateways[gw.Key()] = gw
+		}
+		pd.Gateways = gateways
+	}
+	return moved
+}
+
+// sealedCredentials collects the sealed credentials of machines, written
+// apart in the file: Machine does not serialize them
+func sealedCredentials(machines map[string]*models.Machine) map[string]string {
+	out := make(map[string]string)
+	for key, m := range machines {
+		if m.SealedCredential != "" {
+			out[key] = m.SealedCredential
+		}
+	}
+	return out
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A legacy credential: username + password, 32 hex characters
+const testCredential = "0123456789abcdeffedcba9876543210"
+
+func TestCredentialSealerRoundTrip(t *testing.T) {
+	sealer, err := NewCredentialSealer("a-long-enough-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := MachineKey(testCredential)
+	sealed := sealer.Seal(testCredential)
+	if strings.Contains(sealed, testCredential) {
+		t.Fatal("credential in clear in the sealed form")
+	}
+	if got, err := sealer.Open(key, sealed); err != nil || got != testCredential {
+		t.Errorf("Open() = %q, %v, want %q", got, err, testCredential)
+	}
+
+	// Bound to its machine key, and to the secret
+	if _, err := sealer.Open(MachineKey("another"), sealed); !errors.Is(err, ErrCredentialNotKept) {
+		t.Errorf("Open() with another key: %v, want ErrCredentialNotKept", err)
+	}
+	other, _ := NewCredentialSealer("another-test-secret")
+	if _, err := other.Open(key, sealed); !errors.Is(err, ErrCredentialNotKept) {
+		t.Errorf("Open() with another secret: %v, want ErrCredentialNotKept", err)
+	}
+	var none *CredentialSealer
+	if none.Seal(testCredential) != "" {
+		t.Error("a nil sealer must keep nothing")
+	}
+	if _, err := sealer.Open(key, ""); !errors.Is(err, ErrCredentialNotKept) {
+		t.Errorf("Open() of nothing: %v, want ErrCredentialNotKept", err)
+	}
+}
+
+func TestLoadHashesLegacyKeys(t *testing.T) {
+	sealer, err := NewCredentialSealer("a-long-enough-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "machines.json")
+	legacy := `{
+		"machines": {"` + testCredential + `": {"id": 7, "no_serie": "ESS-007", "hashed_pkey": "` + testCredential + `", "is_active": true}},
+		"details": {"` + testCredential + `": {"id": 7, "no_serie": "ESS-007"}},
+		"next_machine_id": 8
+	}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	NewMemoryStore(path, PersistenceOptions{Sealer: sealer}).Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), testCredential) {
+		t.Error("credential still in clear in the file")
+	}
+
+	// Found by its hash after a reload, with the credential kept for the reveal
+	s := NewMemoryStore(path, PersistenceOptions{Sealer: sealer})
+	defer s.Close()
+	key := MachineKey(testCredential)
+	m, err := s.GetMachineByHashedPkey(key)
+	if err != nil || m == nil || m.ID != 7 {
+		t.Fatalf("GetMachineByHashedPkey() = %v, %v, want machine 7", m, err)
+	}
+	if got, err := sealer.Open(m.HashedPkey, m.SealedCredential); err != nil || got != testCredential {
+		t.Errorf("revealed %q, %v, want %q", got, err, testCredential)
+	}
+	if d, _ := s.GetMachineByID(7); d == nil || d.HashedPkey != key {
+		t.Errorf("detail not moved to the hashed key")
+	}
+}
@@ -0,0 +1,881 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/events"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/geo"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// DatabaseStore is the Postgres implementation of Store (STORE_BACKEND=postgres)
+type DatabaseStore struct {
+	db     *sqlx.DB
+	Geo    *geo.Resolver // Locates new IPs, nil to disable
+	Events *events.Bus   // Publishes heartbeats, registrations, geo updates and gateway reports, nil to disable
+}
+
+func NewDatabaseStore(db *sqlx.DB) *DatabaseStore {
+	return &DatabaseStore{db: db}
+}
+
+// EnsureTableExists creates the fleet tables. es_machine may predate this
+// store: missing columns are added, existing rows are kept. It fails, listing
+// them, if machines of such a table share a hashed_pkey: they must be merged
+// or deleted by hand before the unique index can be created.
+func (s *DatabaseStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS es_machine (
+		id SERIAL PRIMARY KEY,
+		no_serie VARCHAR(64) NOT NULL,
+		version VARCHAR(32),
+		pkey VARCHAR(255),
+		hashed_pkey VARCHAR(255) NOT NULL,
+		autorise_alarme BOOLEAN NOT NULL DEFAULT FALSE,
+		is_active BOOLEAN NOT NULL DEFAULT FALSE,
+		date_creation TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		date_modification TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	ALTER TABLE es_machine ADD COLUMN IF NOT EXISTS version VARCHAR(32);
+	ALTER TABLE es_machine ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE es_machine ADD COLUMN IF NOT EXISTS sealed_credential TEXT NOT NULL DEFAULT '';
+	DO $$
+	DECLARE
+		dups TEXT;
+	BEGIN
+		IF to_regclass('idx_es_machine_hashed_pkey') IS NULL THEN
+			SELECT string_agg(ids, '; ') INTO dups FROM (
+				SELECT 'machines ' || string_agg(id::text, ', ' ORDER BY id) AS ids
+				FROM es_machine GROUP BY hashed_pkey HAVING COUNT(*) > 1
+			) d;
+			IF dups IS NOT NULL THEN
+				RAISE EXCEPTION 'es_machine: several machines share a hashed_pkey, merge or delete them: %', dups;
+			END IF;
+			CREATE UNIQUE INDEX idx_es_machine_hashed_pkey ON es_machine(hashed_pkey);
+		END IF;
+	END $$;
+	CREATE INDEX IF NOT EXISTS idx_es_machine_no_serie ON es_machine(no_serie);
+
+	CREATE TABLE IF NOT EXISTS es_machine_detail (
+		machine_id INT PRIMARY KEY REFERENCES es_machine(id) ON DELETE CASCADE,
+		ip VARCHAR(64) NOT NULL DEFAULT '',
+		last_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		credential_fingerprint VARCHAR(64) NOT NULL DEFAULT '',
+		credential_split INT NOT NULL DEFAULT 0,
+		last_auth_outcome VARCHAR(32) NOT NULL DEFAULT '',
+		geo_location TEXT NOT NULL DEFAULT '',
+		lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+		lon DOUBLE PRECISION NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_es_machine_detail_last_seen ON es_machine_detail(last_seen);
+	-- Rows located before the column: country is the end of geo_location
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+		               WHERE table_name = 'es_machine_detail' AND column_name = 'country') THEN
+			ALTER TABLE es_machine_detail ADD COLUMN country VARCHAR(64) NOT NULL DEFAULT '';
+			UPDATE es_machine_detail SET country = regexp_replace(regexp_replace(geo_location, ' \([^(]*\)$', ''), '^.*, ', '')
+				WHERE geo_location <> '';
+		END IF;
+	END $$;
+	CREATE INDEX IF NOT EXISTS idx_es_machine_detail_country ON es_machine_detail(country);
+
+	CREATE TABLE IF NOT EXISTS es_client_data (
+		client_id VARCHAR(64) PRIMARY KEY,
+		data JSONB NOT NULL,
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS es_gateway (
+		gateway_key VARCHAR(255) PRIMARY KEY, -- GatewayStatus.Key(): gateway_id, else hostname
+		hostname VARCHAR(255) NOT NULL,
+		timestamp DOUBLE PRECISION NOT NULL DEFAULT 0,
+		cpu DOUBLE PRECISION NOT NULL DEFAULT 0,
+		memory JSONB,
+		disk JSONB,
+		services JSONB,
+		client_count INT NOT NULL DEFAULT 0,
+		ip VARCHAR(64) NOT NULL DEFAULT '',
+		last_seen TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		geo_location TEXT NOT NULL DEFAULT '',
+		lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+		lon DOUBLE PRECISION NOT NULL DEFAULT 0
+	);
+	ALTER TABLE es_gateway ADD COLUMN IF NOT EXISTS gateway_id VARCHAR(64) NOT NULL DEFAULT '';
+	-- Tables keyed by the reported hostname: enrolled gateways move to their ID
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+		               WHERE table_name = 'es_gateway' AND column_name = 'gateway_key') THEN
+			ALTER TABLE es_gateway ADD COLUMN gateway_key VARCHAR(255);
+			UPDATE es_gateway SET gateway_key = CASE WHEN gateway_id <> '' THEN gateway_id ELSE hostname END;
+			ALTER TABLE es_gateway ALTER COLUMN gateway_key SET NOT NULL;
+			ALTER TABLE es_gateway DROP CONSTRAINT es_gateway_pkey;
+			ALTER TABLE es_gateway ADD PRIMARY KEY (gateway_key);
+		END IF;
+	END $$;
+	CREATE INDEX IF NOT EXISTS idx_es_gateway_hostname ON es_gateway(hostname);
+	CREATE INDEX IF NOT EXISTS idx_es_gateway_last_seen ON es_gateway(last_seen);
+	-- Rows located before the column: country is the end of geo_location
+	DO $$
+	BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+		               WHERE table_name = 'es_gateway' AND column_name = 'country') THEN
+			ALTER TABLE es_gateway ADD COLUMN country VARCHAR(64) NOT NULL DEFAULT '';
+			UPDATE es_gateway SET country = regexp_replace(regexp_replace(geo_location, ' \([^(]*\)$', ''), '^.*, ', '')
+				WHERE geo_location <> '';
+		END IF;
+	END $$;
+	CREATE INDEX IF NOT EXISTS idx_es_gateway_country ON es_gateway(country);
+
+	CREATE TABLE IF NOT EXISTS es_subscriber (
+		email VARCHAR(255) PRIMARY KEY,
+		date_joined TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS es_newsletter (
+		id VARCHAR(64) PRIMARY KEY,
+		subject TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL DEFAULT '',
+		status VARCHAR(16) NOT NULL DEFAULT 'draft',
+		version INT NOT NULL DEFAULT 1,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		sent_at TIMESTAMP WITH TIME ZONE NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_es_newsletter_created_at ON es_newsletter(created_at DESC);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+// Legacy es_machine rows may hold NULLs in the columns added over time
+const machineColumns = `
+	m.id, m.no_serie, COALESCE(m.version, '') AS version, m.hashed_pkey,
+	COALESCE(m.autorise_alarme, FALSE) AS autorise_alarme, COALESCE(m.is_active, FALSE) AS is_active,
+	COALESCE(m.date_creation, NOW()) AS date_creation, COALESCE(m.date_modification, NOW()) AS date_modification,
+	m.tags, COALESCE(m.sealed_credential, '') AS sealed_credential`
+
+// machineDetailColumns selects a machineDetailRow from es_machine m LEFT JOIN
+// es_machine_detail d: machines that never connected have no detail
+const machineDetailColumns = machineColumns + `,
+	COALESCE(d.ip, '') AS ip, COALESCE(d.last_seen, m.date_creation, NOW()) AS last_seen,
+	COALESCE(d.credential_fingerprint, '') AS credential_fingerprint,
+	COALESCE(d.credential_split, 0) AS credential_split,
+	COALESCE(d.last_auth_outcome, '') AS last_auth_outcome,
+	COALESCE(d.geo_location, '') AS geo_location, COALESCE(d.country, '') AS country,
+	COALESCE(d.lat, 0) AS lat, COALESCE(d.lon, 0) AS lon`
+
+// machineRow scans es_machine: Machine.Tags is not a sqlx field
+type machineRow struct {
+	models.Machine
+	Tags pq.StringArray `db:"tags"`
+}
+
+func (r *machineRow) machine() *models.Machine {
+	m := r.Machine
+	m.Tags = []string(r.Tags)
+	return &m
+}
+
+// machineDetailRow scans es_machine joined with es_machine_detail
+type machineDetailRow struct {
+	models.Machine
+	Tags                  pq.StringArray `db:"tags"`
+	IP                    string         `db:"ip"`
+	LastSeen              time.Time      `db:"last_seen"`
+	CredentialFingerprint string         `db:"credential_fingerprint"`
+	CredentialSplit       int            `db:"credential_split"`
+	LastAuthOutcome       string         `db:"last_auth_outcome"`
+	GeoLocation           string         `db:"geo_location"`
+	Country               string         `db:"country"`
+	Lat                   float64        `db:"lat"`
+	Lon                   float64        `db:"lon"`
+}
+
+func (r *machineDetailRow) detail() *models.MachineDetail {
+	return &models.MachineDetail{
+		ID:                    r.ID,
+		NoSerie:               r.NoSerie,
+		IP:                    r.IP,
+		LastSeen:              r.LastSeen,
+		Version:               r.Version,
+		Tags:                  []string(r.Tags),
+		IsActive:              r.IsActive,
+		AutoriseAlarme:        r.AutoriseAlarme,
+		CredentialFingerprint: r.CredentialFingerprint,
+		CredentialSplit:       r.CredentialSplit,
+		LastAuthOutcome:       r.LastAuthOutcome,
+		GeoLocation:           r.GeoLocation,
+		Country:               r.Country,
+		Lat:                   r.Lat,
+		Lon:                   r.Lon,
+	}
+}
+
+// getMachine returns ErrMachineNotFound when no row matches
+func (s *DatabaseStore) getMachine(q sqlx.Queryer, where string, arg interface{}) (*models.Machine, error) {
+	var row machineRow
+	err := sqlx.Get(q, &row, `SELECT `+machineColumns+` FROM es_machine m WHERE `+where, arg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMachineNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.machine(), nil
+}
+
+// GetMachineByHashedPkey also returns inactive machines: the auth middleware
+// refuses them, registering them again would duplicate the key
+func (s *DatabaseStore) GetMachineByHashedPkey(key string) (*models.Machine, error) {
+	return s.getMachine(s.db, `m.hashed_pkey = $1`, key)
+}
+
+func (s *DatabaseStore) GetMachineByID(id int) (*models.Machine, error) {
+	return s.getMachine(s.db, `m.id = $1`, id)
+}
+
+func (s *DatabaseStore) RegisterUnknownMachine(key, sealed string) (*models.Machine, error) {
+	if !isMachineKey(key) {
+		return nil, fmt.Errorf("malformed key")
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Concurrent first connections of the same machine insert one row
+	var id int
+	err = tx.Get(&id, `
+		INSERT INTO es_machine (no_serie, hashed_pkey, sealed_credential, is_active, autorise_alarme, date_creation, date_modification)
+		VALUES ($1, $2, $3, FALSE, FALSE, NOW(), NOW())
+		ON CONFLICT (hashed_pkey) DO NOTHING
+		RETURNING id`, fmt.Sprintf("UNKNOWN-%s", key[:8]), key, sealed)
+	created := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if created {
+		if _, err := tx.Exec(`INSERT INTO es_machine_detail (machine_id, ip, last_seen) VALUES ($1, '-', NOW())`, id); err != nil {
+			return nil, err
+		}
+	}
+	m, err := s.getMachine(tx, `m.hashed_pkey = $1`, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if created {
+		log.Printf("[STORE] Registered Unknown Machine: %s", m.NoSerie)
+		registered := *m
+		s.Events.Publish(events.Event{Type: events.MachineRegistered, MachineID: m.ID, Data: &registered})
+	}
+	return m, nil
+}
+
+func (s *DatabaseStore) UpdateMachineStatus(key, ip, outcome string, userLen int) {
+	// The detail row is created on demand for machines inserted by hand
+	var prev struct {
+		MachineID   int       `db:"machine_id"`
+		NoSerie     string    `db:"no_serie"`
+		LastSeen    time.Time `db:"last_seen"`
+		IP          string    `db:"ip"`
+		GeoLocation string    `db:"geo_location"`
+	}
+	err := s.db.Get(&prev, `
+		WITH m AS (SELECT id, no_serie FROM es_machine WHERE hashed_pkey = $1),
+		old AS (SELECT d.ip, d.geo_location FROM es_machine_detail d JOIN m ON d.machine_id = m.id)
+		INSERT INTO es_machine_detail (machine_id, ip, last_seen, credential_fingerprint, credential_split, last_auth_outcome)
+		SELECT m.id, $2, NOW(), $3, $4, $5 FROM m
+		ON CONFLICT (machine_id) DO UPDATE SET
+			ip = EXCLUDED.ip,
+			last_seen = EXCLUDED.last_seen,
+			credential_fingerprint = EXCLUDED.credential_fingerprint,
+			credential_split = EXCLUDED.credential_split,
+			last_auth_outcome = EXCLUDED.last_auth_outcome
+		RETURNING machine_id, last_seen,
+			(SELECT no_serie FROM m) AS no_serie,
+			COALESCE((SELECT ip FROM old), '') AS ip,
+			COALESCE((SELECT geo_location FROM old), '') AS geo_location`,
+		key, ip, CredentialFingerprint(key), userLen, outcome)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("[DB STORE] Failed to update status of machine: %v", err)
+		return
+	}
+	s.Events.Publish(events.Event{Type: events.MachineHeartbeat, MachineID: prev.MachineID, Data: &models.MachineHeartbeat{
+		ID: prev.MachineID, NoSerie: prev.NoSerie, IP: ip, LastSeen: prev.LastSeen, Outcome: outcome,
+	}})
+
+	if geo.PublicIP(ip) != nil && (prev.IP != ip || prev.GeoLocation == "") {
+		s.locate(`UPDATE es_machine_detail SET geo_location = $1, lat = $2, lon = $3, country = $6 WHERE machine_id = $4 AND ip = $5`, prev.MachineID, ip)
+	}
+}
+
+// locate resolves ip in the background and runs update with
+// (location, lat, lon, key, ip, country): the row is only changed if it
+// still has ip
+func (s *DatabaseStore) locate(update string, key interface{}, ip string) {
+	s.Geo.Resolve(ip, func(loc *geo.Location) {
+		label := loc.Label()
+		if _, err := s.db.Exec(update, label, loc.Lat, loc.Lon, key, ip, loc.Country); err != nil {
+			log.Printf("[DB STORE] Failed to save geo info of %v: %v", key, err)
+			return
+		}
+		log.Printf("Geo Update for %v (%s): %s", key, ip, label)
+		event := events.Event{Data: &models.GeoUpdate{IP: ip, GeoLocation: label, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon}}
+		switch k := key.(type) {
+		case int:
+			event.Type, event.MachineID = events.MachineGeo, k
+		case string:
+			event.Type, event.Gateway = events.GatewayGeo, k
+		}
+		s.Events.Publish(event)
+	})
+}
+
+func (s *DatabaseStore) SetMachineVersion(machineID int, version string) error {
+	res, err := s.db.Exec(`UPDATE es_machine SET version = $1, date_modification = NOW() WHERE id = $2 AND version IS DISTINCT FROM $1`, version, machineID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		// Unchanged version, or no such machine
+		var exists bool
+		if err := s.db.Get(&exists, `SELECT EXISTS(SELECT 1 FROM es_machine WHERE id = $1)`, machineID); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrMachineNotFound
+		}
+	}
+	return nil
+}
+
+func (s *DatabaseStore) SetMachineTags(machineID int, tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	return s.updateMachine(s.db, `UPDATE es_machine SET tags = $1, date_modification = NOW() WHERE id = $2`, pq.Array(tags), machineID)
+}
+
+func (s *DatabaseStore) SetMachineActive(machineID int, active bool) error {
+	return s.updateMachine(s.db, `UPDATE es_machine SET is_active = $1, date_modification = NOW() WHERE id = $2`, active, machineID)
+}
+
+func (s *DatabaseStore) SetMachineAlarm(machineID int, allowed bool) error {
+	return s.updateMachine(s.db, `UPDATE es_machine SET autorise_alarme = $1, date_modification = NOW() WHERE id = $2`, allowed, machineID)
+}
+
+// RenameMachine changes the serial and moves the last snapshot with it
+func (s *DatabaseStore) RenameMachine(machineID int, noSerie string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldSerie string
+	err = tx.Get(&oldSerie, `SELECT no_serie FROM es_machine WHERE id = $1 FOR UPDATE`, machineID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMachineNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var taken bool
+	if err := tx.Get(&taken, `SELECT EXISTS(SELECT 1 FROM es_machine WHERE no_serie = $1 AND id <> $2)`, noSerie, machineID); err != nil {
+		return err
+	}
+	if taken {
+		return ErrSerialTaken
+	}
+	if err := s.updateMachine(tx, `UPDATE es_machine SET no_serie = $1, date_modification = NOW() WHERE id = $2`, noSerie, machineID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE es_client_data SET client_id = $1 WHERE client_id = $2`, noSerie, oldSerie); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteMachine removes the machine, its detail (cascade) and its last snapshot
+func (s *DatabaseStore) DeleteMachine(machineID int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var noSerie string
+	err = tx.Get(&noSerie, `DELETE FROM es_machine WHERE id = $1 RETURNING no_serie`, machineID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrMachineNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM es_client_data WHERE client_id = $1`, noSerie); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// updateMachine runs a statement on one machine, ErrMachineNotFound if no row matched
+func (s *DatabaseStore) updateMachine(e sqlx.Execer, query string, args ...interface{}) error {
+	res, err := e.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMachineNotFound
+	}
+	return nil
+}
+
+func (s *DatabaseStore) SaveClientData(clientID string, data []models.ExchangeKeyValue) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO es_client_data (client_id, data, updated_at) VALUES ($1, $2, NOW())
+		ON CONFLICT (client_id) DO UPDATE SET data = EXCLUDED.data, updated_at = EXCLUDED.updated_at`,
+		clientID, payload)
+	if err != nil {
+		return err
+	}
+	log.Printf("[STORE] Saved %d data points for client %s", len(data), clientID)
+	return nil
+}
+
+func (s *DatabaseStore) GetClientData(clientID string) ([]models.ExchangeKeyValue, error) {
+	var payload []byte
+	err := s.db.Get(&payload, `SELECT data FROM es_client_data WHERE client_id = $1`, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []models.ExchangeKeyValue
+	if err := json.Unmarshal(payload, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// gatewayRow holds the JSONB columns of es_gateway
+type gatewayRow struct {
+	Hostname    string    `db:"hostname"`
+	Timestamp   float64   `db:"timestamp"`
+	CPU         float64   `db:"cpu"`
+	Memory      []byte    `db:"memory"`
+	Disk        []byte    `db:"disk"`
+	Services    []byte    `db:"services"`
+	ClientCount int       `db:"client_count"`
+	GatewayID   string    `db:"gateway_id"`
+	IP          string    `db:"ip"`
+	LastSeen    time.Time `db:"last_seen"`
+	GeoLocation string    `db:"geo_location"`
+	Country     string    `db:"country"`
+	Lat         float64   `db:"lat"`
+	Lon         float64   `db:"lon"`
+}
+
+func (r *gatewayRow) gateway() *models.GatewayStatus {
+	gw := &models.GatewayStatus{
+		Hostname:    r.Hostname,
+		Timestamp:   r.Timestamp,
+		CPU:         r.CPU,
+		ClientCount: r.ClientCount,
+		GatewayID:   r.GatewayID,
+		IP:          r.IP,
+		LastSeen:    r.LastSeen,
+		GeoLocation: r.GeoLocation,
+		Country:     r.Country,
+		Lat:         r.Lat,
+		Lon:         r.Lon,
+	}
+	// Columns are written from these maps: a decode error leaves the map nil
+	if len(r.Memory) > 0 {
+		json.Unmarshal(r.Memory, &gw.Memory)
+	}
+	if len(r.Disk) > 0 {
+		json.Unmarshal(r.Disk, &gw.Disk)
+	}
+	if len(r.Services) > 0 {
+		json.Unmarshal(r.Services, &gw.Services)
+	}
+	return gw
+}
+
+func (s *DatabaseStore) SaveGateway(gw *models.GatewayStatus) error {
+	var existing struct {
+		IP          string  `db:"ip"`
+		GeoLocation string  `db:"geo_location"`
+		Country     string  `db:"country"`
+		Lat         float64 `db:"lat"`
+		Lon         float64 `db:"lon"`
+	}
+	err := s.db.Get(&existing, `SELECT ip, geo_location, country, lat, lon FROM es_gateway WHERE gateway_key = $1`, gw.Key())
+	exists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// Same rules as MemoryStore: keep the geo info unless the IP changed
+	validIP := geo.PublicIP(gw.IP) != nil
+	triggerGeo := false
+	if exists {
+		if gw.IP != existing.IP && validIP {
+			triggerGeo = true
+		} else {
+			gw.GeoLocation = existing.GeoLocation
+			gw.Country = existing.Country
+			gw.Lat = existing.Lat
+			gw.Lon = existing.Lon
+		}
+		if gw.GeoLocation == "" && validIP {
+			triggerGeo = true
+		}
+	} else {
+		triggerGeo = validIP
+	}
+
+	// Same rule as MemoryStore: one row per hostname, so the rows of the
+	// hostname under another key (before the enrollment, revoked) go
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM es_gateway WHERE hostname = $1 AND gateway_key <> $2`, gw.Hostname, gw.Key()); err != nil {
+		return err
+	}
+	if err := upsertGateway(tx, gw); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	report := *gw
+	s.Events.Publish(events.Event{Type: events.GatewayReport, Gateway: gw.Hostname, Data: &report})
+
+	if triggerGeo {
+		s.locate(`UPDATE es_gateway SET geo_location = $1, lat = $2, lon = $3, country = $6 WHERE hostname = $4 AND ip = $5`, gw.Hostname, gw.IP)
+	}
+	return nil
+}
+
+// gatewayColumns selects a gatewayRow from es_gateway
+const gatewayColumns = `hostname, timestamp, cpu, memory, disk, services, client_count, gateway_id, ip, last_seen, geo_location, country, lat, lon`
+
+// upsertGateway writes a gateway as is, geo info included
+func upsertGateway(e sqlx.Execer, gw *models.GatewayStatus) error {
+	memory, err := json.Marshal(gw.Memory)
+	if err != nil {
+		return err
+	}
+	disk, err := json.Marshal(gw.Disk)
+	if err != nil {
+		return err
+	}
+	services, err := json.Marshal(gw.Services)
+	if err != nil {
+		return err
+	}
+	_, err = e.Exec(`
+		INSERT INTO es_gateway (hostname, timestamp, cpu, memory, disk, services, client_count, ip, last_seen, geo_location, lat, lon, gateway_id, country, gateway_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (gateway_key) DO UPDATE SET
+			hostname = EXCLUDED.hostname,
+			timestamp = EXCLUDED.timestamp,
+			cpu = EXCLUDED.cpu,
+			memory = EXCLUDED.memory,
+			disk = EXCLUDED.disk,
+			services = EXCLUDED.services,
+			client_count = EXCLUDED.client_count,
+			ip = EXCLUDED.ip,
+			last_seen = EXCLUDED.last_seen,
+			geo_location = EXCLUDED.geo_location,
+			lat = EXCLUDED.lat,
+			lon = EXCLUDED.lon,
+			gateway_id = EXCLUDED.gateway_id,
+			country = EXCLUDED.country`,
+		gw.Hostname, gw.Timestamp, gw.CPU, memory, disk, services, gw.ClientCount,
+		gw.IP, gw.LastSeen, gw.GeoLocation, gw.Lat, gw.Lon, gw.GatewayID, gw.Country, gw.Key())
+	return err
+}
+
+func (s *DatabaseStore) GetGateways() ([]*models.GatewayStatus, error) {
+	var rows []gatewayRow
+	err := s.db.Select(&rows, `
+		SELECT `+gatewayColumns+` FROM es_gateway ORDER BY hostname`)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*models.GatewayStatus, 0, len(rows))
+	for i := range rows {
+		list = append(list, rows[i].gateway())
+	}
+	return list, nil
+}
+
+func (s *DatabaseStore) GetStats() (*models.AdminStatsResponse, error) {
+	var stats models.AdminStatsResponse
+	err := s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM es_machine), (SELECT COUNT(*) FROM es_gateway)`).
+		Scan(&stats.TotalMachines, &stats.TotalGateways)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (s *DatabaseStore) GetMachines() ([]*models.MachineDetail, error) {
+	var rows []machineDetailRow
+	err := s.db.Select(&rows, `
+		SELECT `+machineDetailColumns+`
+		FROM es_machine m
+		LEFT JOIN es_machine_detail d ON d.machine_id = m.id
+		ORDER BY m.id`)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*models.MachineDetail, 0, len(rows))
+	for i := range rows {
+		list = append(list, rows[i].detail())
+	}
+	return list, nil
+}
+
+// machineSortColumns are the SQL expressions of models.MachineSortFields
+var machineSortColumns = map[string]string{
+	models.FleetSortID:       `m.id`,
+	models.FleetSortSerial:   `m.no_serie COLLATE "C"`,
+	models.FleetSortLastSeen: `COALESCE(d.last_seen, m.date_creation, NOW())`,
+	models.FleetSortVersion:  `COALESCE(m.version, '') COLLATE "C"`,
+	models.FleetSortIP:       `COALESCE(d.ip, '') COLLATE "C"`,
+	models.FleetSortCountry:  `COALESCE(d.country, '') COLLATE "C"`,
+}
+
+func (s *DatabaseStore) QueryMachines(q models.FleetQuery) (*models.MachinePage, error) {
+	field, cursor, err := parseFleetQuery(q, models.MachineSortFields)
+	if err != nil {
+		return nil, err
+	}
+	var key int
+	if cursor != nil {
+		if key, err = strconv.Atoi(cursor.Key); err != nil {
+			return nil, fmt.Errorf("%w: bad cursor: %v", ErrInvalidFleetQuery, err)
+		}
+	}
+
+	var f sqlFilter
+	if q.Active != nil {
+		f.where(`COALESCE(m.is_active, FALSE) = ` + f.arg(*q.Active))
+	}
+	if q.Version != "" {
+		f.where(`COALESCE(m.version, '') = ` + f.arg(q.Version))
+	}
+	f.applyFleetQuery(q, machineSortColumns[models.FleetSortLastSeen], `COALESCE(d.country, '')`,
+		`m.no_serie`, `COALESCE(d.ip, '')`, `COALESCE(d.geo_location, '')`)
+	const from = ` FROM es_machine m LEFT JOIN es_machine_detail d ON d.machine_id = m.id`
+
+	page := &models.MachinePage{}
+	if err := s.db.Get(&page.Total, `SELECT COUNT(*)`+from+f.clause(), f.args...); err != nil {
+		return nil, err
+	}
+	order := f.keyset(q, field, cursor, machineSortColumns[field], `m.id`, key)
+	var rows []machineDetailRow
+	if err := s.db.Select(&rows, `SELECT `+machineDetailColumns+from+f.clause()+order, f.args...); err != nil {
+		return nil, err
+	}
+
+	page.Items = make([]*models.MachineDetail, 0, len(rows))
+	for i := range rows {
+		page.Items = append(page.Items, rows[i].detail())
+	}
+	if q.Limit > 0 && len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		last := page.Items[q.Limit-1]
+		page.NextCursor = fleetCursor{Sort: field, Value: machineSortValue(last, field), Key: padID(last.ID)}.encode()
+	}
+	return page, nil
+}
+
+// gatewaySortColumns are the SQL expressions of models.GatewaySortFields
+var gatewaySortColumns = map[string]string{
+	models.FleetSortHostname: `hostname COLLATE "C"`,
+	models.FleetSortLastSeen: `last_seen`,
+	models.FleetSortIP:       `ip COLLATE "C"`,
+	models.FleetSortCountry:  `country COLLATE "C"`,
+}
+
+func (s *DatabaseStore) QueryGateways(q models.FleetQuery) (*models.GatewayPage, error) {
+	field, cursor, err := parseFleetQuery(q, models.GatewaySortFields)
+	if err != nil {
+		return nil, err
+	}
+	var key string
+	if cursor != nil {
+		key = cursor.Key
+	}
+
+	var f sqlFilter
+	f.applyFleetQuery(q, `last_seen`, `country`, `hostname`, `ip`, `geo_location`)
+
+	page := &models.GatewayPage{}
+	if err := s.db.Get(&page.Total, `SELECT COUNT(*) FROM es_gateway`+f.clause(), f.args...); err != nil {
+		return nil, err
+	}
+	order := f.keyset(q, field, cursor, gatewaySortColumns[field], `hostname COLLATE "C"`, key)
+	var rows []gatewayRow
+	if err := s.db.Select(&rows, `SELECT `+gatewayColumns+` FROM es_gateway`+f.clause()+order, f.args...); err != nil {
+		return nil, err
+	}
+
+	page.Items = make([]*models.GatewayStatus, 0, len(rows))
+	for i := range rows {
+		page.Items = append(page.Items, rows[i].gateway())
+	}
+	if q.Limit > 0 && len(page.Items) > q.Limit {
+		page.Items = page.Items[:q.Limit]
+		last := page.Items[q.Limit-1]
+		page.NextCursor = fleetCursor{Sort: field, Value: gatewaySortValue(last, field), Key: last.Hostname}.encode()
+	}
+	return page, nil
+}
+
+func (s *DatabaseStore) AddSubscriber(email string) error {
+	_, err := s.db.Exec(`INSERT INTO es_subscriber (email, date_joined) VALUES ($1, NOW()) ON CONFLICT (email) DO NOTHING`, email)
+	return err
+}
+
+func (s *DatabaseStore) DeleteSubscriber(email string) error {
+	res, err := s.db.Exec(`DELETE FROM es_subscriber WHERE email = $1`, email)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("subscriber not found")
+	}
+	return nil
+}
+
+func (s *DatabaseStore) GetSubscribers() ([]models.Subscriber, error) {
+	list := []models.Subscriber{}
+	rows, err := s.db.Query(`SELECT email, date_joined FROM es_subscriber ORDER BY date_joined`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sub models.Subscriber
+		if err := rows.Scan(&sub.Email, &sub.DateJoined); err != nil {
+			return nil, err
+		}
+		list = append(list, sub)
+	}
+	return list, rows.Err()
+}
+
+// newsletterRow maps es_newsletter (models.Newsletter has no db tags)
+type newsletterRow struct {
+	ID        string       `db:"id"`
+	Subject   string       `db:"subject"`
+	Content   string       `db:"content"`
+	Status    string       `db:"status"`
+	Version   int          `db:"version"`
+	CreatedAt time.Time    `db:"created_at"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	SentAt    sql.NullTime `db:"sent_at"`
+}
+
+func (r *newsletterRow) newsletter() models.Newsletter {
+	n := models.Newsletter{
+		ID:        r.ID,
+		Subject:   r.Subject,
+		Content:   r.Content,
+		Status:    r.Status,
+		Version:   r.Version,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+	if r.SentAt.Valid {
+		sentAt := r.SentAt.Time
+		n.SentAt = &sentAt
+	}
+	return n
+}
+
+const newsletterColumns = `id, subject, content, status, version, created_at, updated_at, sent_at`
+
+func (s *DatabaseStore) GetNewsletters() ([]models.Newsletter, error) {
+	var rows []newsletterRow
+	if err := s.db.Select(&rows, `SELECT `+newsletterColumns+` FROM es_newsletter ORDER BY created_at DESC`); err != nil {
+		return nil, err
+	}
+	list := make([]models.Newsletter, 0, len(rows))
+	for i := range rows {
+		list = append(list, rows[i].newsletter())
+	}
+	return list, nil
+}
+
+func (s *DatabaseStore) GetNewsletter(id string) (*models.Newsletter, error) {
+	var row newsletterRow
+	err := s.db.Get(&row, `SELECT `+newsletterColumns+` FROM es_newsletter WHERE id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("newsletter not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	n := row.newsletter()
+	return &n, nil
+}
+
+func (s *DatabaseStore) SaveNewsletter(n models.Newsletter) error {
+	return saveNewsletter(s.db, n)
+}
+
+func saveNewsletter(e sqlx.Execer, n models.Newsletter) error {
+	_, err := e.Exec(`
+		INSERT INTO es_newsletter (`+newsletterColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (id) DO UPDATE SET
+			subject = EXCLUDED.subject,
+			content = EXCLUDED.content,
+			status = EXCLUDED.status,
+			version = EXCLUDED.version,
+			updated_at = EXCLUDED.updated_at,
+			sent_at = EXCLUDED.sent_at`,
+		n.ID, n.Subject, n.Content, n.Status, n.Version, n.CreatedAt, n.UpdatedAt, n.SentAt)
+	return err
+}
+
+func (s *DatabaseStore) DeleteNewsletter(id string) error {
+	res, err := s.db.Exec(`DELETE FROM es_newsletter WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("newsletter not found")
+	}
+	return nil
+}
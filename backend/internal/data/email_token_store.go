@@ -0,0 +1,183 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrEmailTokenInvalid is returned for an unknown, expired or already used token
+var ErrEmailTokenInvalid = errors.New("invalid or expired token")
+
+// NewEmailToken returns a random token to send by e-mail and its hash
+func NewEmailToken() (token, hash string) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	token = hex.EncodeToString(b[:])
+	return token, HashEmailToken(token)
+}
+
+// HashEmailToken is the stored form of a token sent by e-mail
+func HashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EmailTokenStore keeps the hashes of the single-use tokens sent by e-mail
+// (password reset). A token is bound to a user and a purpose.
+type EmailTokenStore interface {
+	EnsureTableExists() error
+	// CreateEmailToken stores a token and invalidates the earlier unused
+	// tokens of the user for the same purpose: only the last e-mail works
+	CreateEmailToken(hash string, userID int, purpose string, now, expiresAt time.Time) error
+	// ConsumeEmailToken marks a token used and returns its user, or
+	// ErrEmailTokenInvalid
+	ConsumeEmailToken(hash, purpose string, now time.Time) (int, error)
+	// PruneEmailTokens deletes the tokens expired before cutoff
+	PruneEmailTokens(cutoff time.Time) error
+}
+
+// RunEmailTokenPruner deletes old tokens every interval. Blocking, run it in a goroutine.
+func RunEmailTokenPruner(store EmailTokenStore, retention, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := store.PruneEmailTokens(time.Now().Add(-retention)); err != nil {
+			log.Printf("[EMAIL TOKENS] Prune failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// ---------------------------------------------------------------------
+// Postgres implementation
+// ---------------------------------------------------------------------
+
+type PostgresEmailTokenStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresEmailTokenStore(db *sqlx.DB) *PostgresEmailTokenStore {
+	return &PostgresEmailTokenStore{db: db}
+}
+
+func (s *PostgresEmailTokenStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS email_tokens (
+		hash VARCHAR(64) PRIMARY KEY,
+		user_id INT NOT NULL,
+		purpose VARCHAR(32) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_tokens_user ON email_tokens(user_id, purpose);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+func (s *PostgresEmailTokenStore) CreateEmailToken(hash string, userID int, purpose string, now, expiresAt time.Time) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM email_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purpose); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO email_tokens (hash, user_id, purpose, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		hash, userID, purpose, now, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresEmailTokenStore) ConsumeEmailToken(hash, purpose string, now time.Time) (int, error) {
+	// Single statement: two concurrent uses cannot both succeed
+	var userID int
+	err := s.db.Get(&userID, `
+		UPDATE email_tokens SET used_at = $1
+		WHERE hash = $2 AND purpose = $3 AND used_at IS NULL AND expires_at > $1
+		RETURNING user_id`, now, hash, purpose)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrEmailTokenInvalid
+	}
+	return userID, err
+}
+
+func (s *PostgresEmailTokenStore) PruneEmailTokens(cutoff time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM email_tokens WHERE expires_at < $1`, cutoff)
+	return err
+}
+
+// ---------------------------------------------------------------------
+// In-memory implementation (used when no database is configured)
+// ---------------------------------------------------------------------
+
+type MemoryEmailTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*memoryEmailToken // hash -> token
+}
+
+type memoryEmailToken struct {
+	userID    int
+	purpose   string
+	expiresAt time.Time
+	used      bool
+}
+
+func NewMemoryEmailTokenStore() *MemoryEmailTokenStore {
+	return &MemoryEmailTokenStore{tokens: make(map[string]*memoryEmailToken)}
+}
+
+func (s *MemoryEmailTokenStore) EnsureTableExists() error {
+	return nil
+}
+
+func (s *MemoryEmailTokenStore) CreateEmailToken(hash string, userID int, purpose string, now, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for h, t := range s.tokens {
+		if t.userID == userID && t.purpose == purpose && !t.used {
+			delete(s.tokens, h)
+		}
+	}
+	s.tokens[hash] = &memoryEmailToken{userID: userID, purpose: purpose, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryEmailTokenStore) ConsumeEmailToken(hash, purpose string, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[hash]
+	if !ok || t.used || t.purpose != purpose || !now.Before(t.expiresAt) {
+		return 0, ErrEmailTokenInvalid
+	}
+	t.used = true
+	return t.userID, nil
+}
+
+func (s *MemoryEmailTokenStore) PruneEmailTokens(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for h, t := range s.tokens {
+		if t.expiresAt.Before(cutoff) {
+			delete(s.tokens, h)
+		}
+	}
+	return nil
+}
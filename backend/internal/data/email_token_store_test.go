@@ -0,0 +1,72 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func TestConsumeEmailToken(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		consume func(s EmailTokenStore, token string) (int, error)
+		wantErr error
+	}{
+		{"valid token", func(s EmailTokenStore, token string) (int, error) {
+			return s.ConsumeEmailToken(HashEmailToken(token), models.EmailTokenPasswordReset, t0.Add(time.Minute))
+		}, nil},
+		{"used twice", func(s EmailTokenStore, token string) (int, error) {
+			s.ConsumeEmailToken(HashEmailToken(token), models.EmailTokenPasswordReset, t0.Add(time.Minute))
+			return s.ConsumeEmailToken(HashEmailToken(token), models.EmailTokenPasswordReset, t0.Add(2*time.Minute))
+		}, ErrEmailTokenInvalid},
+		{"expired", func(s EmailTokenStore, token string) (int, error) {
+			return s.ConsumeEmailToken(HashEmailToken(token), models.EmailTokenPasswordReset, t0.Add(time.Hour))
+		}, ErrEmailTokenInvalid},
+		{"other purpose", func(s EmailTokenStore, token string) (int, error) {
+			return s.ConsumeEmailToken(HashEmailToken(token), "other_purpose", t0.Add(time.Minute))
+		}, ErrEmailTokenInvalid},
+		{"superseded by a later e-mail", func(s EmailTokenStore, token string) (int, error) {
+			_, hash := NewEmailToken()
+			s.CreateEmailToken(hash, 7, models.EmailTokenPasswordReset, t0, t0.Add(time.Hour))
+			return s.ConsumeEmailToken(HashEmailToken(token), models.EmailTokenPasswordReset, t0.Add(time.Minute))
+		}, ErrEmailTokenInvalid},
+		{"later token of another user", func(s EmailTokenStore, token string) (int, error) {
+			_, hash := NewEmailToken()
+			s.CreateEmailToken(hash, 8, models.EmailTokenPasswordReset, t0, t0.Add(time.Hour))
+			return s.ConsumeEmailToken(HashEmailToken(token), models.EmailTokenPasswordReset, t0.Add(time.Minute))
+		}, nil},
+		{"unknown token", func(s EmailTokenStore, _ string) (int, error) {
+			token, _ := NewEmailToken()
+			return s.ConsumeEmailToken(HashEmailToken(token), models.EmailTokenPasswordReset, t0.Add(time.Minute))
+		}, ErrEmailTokenInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryEmailTokenStore()
+			token, hash := NewEmailToken()
+			if err := s.CreateEmailToken(hash, 7, models.EmailTokenPasswordReset, t0, t0.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			userID, err := tt.consume(s, token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ConsumeEmailToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && userID != 7 {
+				t.Errorf("ConsumeEmailToken() user = %d, want 7", userID)
+			}
+		})
+	}
+}
+
+func TestEmailTokenIsStoredHashed(t *testing.T) {
+	token, hash := NewEmailToken()
+	if hash == token || hash != HashEmailToken(token) {
+		t.Error("hash is not the stored form of the token")
+	}
+	if other, _ := NewEmailToken(); other == token {
+		t.Error("two tokens are equal")
+	}
+}
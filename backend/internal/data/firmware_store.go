@@ -0,0 +1,569 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// FirmwareStore keeps the firmware releases and drives the rollout campaigns
+type FirmwareStore interface {
+	// Releases
+	GetReleases() ([]*models.FirmwareRelease, error)
+	GetRelease(version string) (*models.FirmwareRelease, error)
+	AddRelease(rel *models.FirmwareRelease, content io.Reader) error
+	DeleteRelease(version string) error
+
+	// Campaigns
+	GetCampaigns() ([]*models.FirmwareCampaign, error)
+	GetCampaign(id string) (*models.FirmwareCampaignProgress, error)
+	SaveCampaign(c *models.FirmwareCampaign) error
+	SetCampaignStatus(id, status string) error
+
+	// Rollout, called from the legacy endpoints
+	OfferUpdate(m *models.Machine) (string, error) // Version to announce, "" if none
+	ServeBlock(machineID, index, size int) (string, []byte, error)
+	RecordEnd(machineID int) error
+	RecordVersion(machineID int, version string) error
+}
+
+var (
+	ErrReleaseNotFound  = errors.New("firmware release not found")
+	ErrReleaseExists    = errors.New("firmware release already exists")
+	ErrReleaseInUse     = errors.New("firmware release used by a campaign")
+	ErrCampaignNotFound = errors.New("firmware campaign not found")
+	ErrNoUpdate         = errors.New("no firmware update in progress")
+	ErrBlockOutOfRange  = errors.New("firmware block out of range")
+	ErrInvalidImage     = errors.New("invalid firmware image")
+)
+
+// FirmwarePolicy holds the rollout timings
+type FirmwarePolicy struct {
+	MaxAttempts     int           // Offers per machine and campaign before giving up
+	OfferTimeout    time.Duration // Max delay between the offer and the first block
+	DownloadTimeout time.Duration // Max silence between two blocks
+	InstallTimeout  time.Duration // Max delay between /api/endversioncontent and the new version report
+}
+
+var DefaultFirmwarePolicy = FirmwarePolicy{
+	MaxAttempts:     3,
+	OfferTimeout:    time.Hour,
+	DownloadTimeout: 15 * time.Minute,
+	InstallTimeout:  30 * time.Minute,
+}
+
+// firmwareData is the content of the JSON file
+type firmwareData struct {
+	Releases  []*models.FirmwareRelease  `json:"releases"`
+	Campaigns []*models.FirmwareCampaign `json:"campaigns"`
+	Updates   []*models.FirmwareUpdate   `json:"updates"`
+}
+
+// FileFirmwareStore keeps the catalog in a JSON file and the images in a directory
+type FileFirmwareStore struct {
+	mu        sync.Mutex
+	releases  map[string]*models.FirmwareRelease  // version -> release
+	campaigns map[string]*models.FirmwareCampaign // id -> campaign
+	updates   map[string]*models.FirmwareUpdate   // campaignID/machineID -> update
+	policy    FirmwarePolicy
+	filePath  string
+	imageDir  string
+}
+
+func NewFileFirmwareStore(storagePath, imageDir string, policy FirmwarePolicy) *FileFirmwareStore {
+	for _, dir := range []string{filepath.Dir(storagePath), imageDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("Failed to create firmware storage dir: %v", err)
+		}
+	}
+	s := &FileFirmwareStore{
+		releases:  make(map[string]*models.FirmwareRelease),
+		campaigns: make(map[string]*models.FirmwareCampaign),
+		updates:   make(map[string]*models.FirmwareUpdate),
+		policy:    policy,
+		filePath:  storagePath,
+		imageDir:  imageDir,
+	}
+	s.load()
+	return s
+}
+
+func (s *FileFirmwareStore) load() {
+	file, err := os.Open(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open firmware storage file: %v", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	var fd firmwareData
+	if err := json.NewDecoder(file).Decode(&fd); err != nil {
+		log.Printf("Failed to decode firmware storage file: %v", err)
+		return
+	}
+	for _, r := range fd.Releases {
+		s.releases[r.Version] = r
+	}
+	for _, c := range fd.Campaigns {
+		s.campaigns[c.ID] = c
+	}
+	for _, u := range fd.Updates {
+		s.updates[updateKey(u.CampaignID, u.MachineID)] = u
+	}
+	log.Printf("Loaded %d firmware releases, %d campaigns.", len(s.releases), len(s.campaigns))
+}
+
+func (s *FileFirmwareStore) save() {
+	fd := firmwareData{
+		Releases:  make([]*models.FirmwareRelease, 0, len(s.releases)),
+		Campaigns: s.sortedCampaigns(),
+		Updates:   make([]*models.FirmwareUpdate, 0, len(s.updates)),
+	}
+	for _, r := range s.releases {
+		fd.Releases = append(fd.Releases, r)
+	}
+	for _, u := range s.updates {
+		fd.Updates = append(fd.Updates, u)
+	}
+
+	b, err := json.Marshal(fd)
+	if err != nil {
+		log.Printf("Failed to encode firmware storage file: %v", err)
+		return
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write firmware storage file: %v", err)
+	}
+}
+
+func updateKey(campaignID string, machineID int) string {
+	return fmt.Sprintf("%s/%d", campaignID, machineID)
+}
+
+// sortedCampaigns returns the campaigns oldest first; the caller holds the lock
+func (s *FileFirmwareStore) sortedCampaigns() []*models.FirmwareCampaign {
+	list := make([]*models.FirmwareCampaign, 0, len(s.campaigns))
+	for _, c := range s.campaigns {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+func (s *FileFirmwareStore) imagePath(version string) string {
+	return filepath.Join(s.imageDir, version+".bin")
+}
+
+func (s *FileFirmwareStore) GetReleases() ([]*models.FirmwareRelease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*models.FirmwareRelease, 0, len(s.releases))
+	for _, r := range s.releases {
+		c := *r
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := models.ParseFirmwareVersion(list[i].Version)
+		b, _ := models.ParseFirmwareVersion(list[j].Version)
+		return a > b
+	})
+	return list, nil
+}
+
+func (s *FileFirmwareStore) GetRelease(version string) (*models.FirmwareRelease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.releases[version]
+	if !ok {
+		return nil, ErrReleaseNotFound
+	}
+	c := *r
+	return &c, nil
+}
+
+// AddRelease writes the image and fills in its size and checksum. If
+// rel.SHA256 is set, the upload must match it.
+func (s *FileFirmwareStore) AddRelease(rel *models.FirmwareRelease, content io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.releases[rel.Version]; ok {
+		return ErrReleaseExists
+	}
+
+	tmp, err := os.CreateTemp(s.imageDir, "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), content)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return fmt.Errorf("%w: empty file", ErrInvalidImage)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if rel.SHA256 != "" && rel.SHA256 != sum {
+		return fmt.Errorf("%w: checksum mismatch, got %s", ErrInvalidImage, sum)
+	}
+	if err := os.Rename(tmp.Name(), s.imagePath(rel.Version)); err != nil {
+		return err
+	}
+
+	rel.Size = size
+	rel.SHA256 = sum
+	c := *rel
+	s.releases[rel.Version] = &c
+	s.save()
+	return nil
+}
+
+func (s *FileFirmwareStore) DeleteRelease(version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.releases[version]; !ok {
+		return ErrReleaseNotFound
+	}
+	for _, c := range s.campaigns {
+		if c.TargetVersion == version && (c.Status == models.CampaignActive || c.Status == models.CampaignPaused) {
+			return ErrReleaseInUse
+		}
+	}
+	if err := os.Remove(s.imagePath(version)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(s.releases, version)
+	s.save()
+	return nil
+}
+
+// readBlock returns block index of an image, each block being size bytes (the last one may be shorter)
+func (s *FileFirmwareStore) readBlock(rel *models.FirmwareRelease, index, size int) ([]byte, error) {
+	offset := int64(index) * int64(size)
+	if index < 0 || size <= 0 || offset >= rel.Size {
+		return nil, ErrBlockOutOfRange
+	}
+	file, err := os.Open(s.imagePath(rel.Version))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, min(int64(size), rel.Size-offset))
+	if _, err := file.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *FileFirmwareStore) GetCampaigns() ([]*models.FirmwareCampaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.sortedCampaigns()
+	for i, c := range list {
+		list[i] = copyCampaign(c)
+	}
+	return list, nil
+}
+
+func (s *FileFirmwareStore) GetCampaign(id string) (*models.FirmwareCampaignProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.campaigns[id]
+	if !ok {
+		return nil, ErrCampaignNotFound
+	}
+	if s.expire(time.Now()) {
+		s.save()
+	}
+
+	p := &models.FirmwareCampaignProgress{
+		FirmwareCampaign: copyCampaign(c),
+		Counts:           map[string]int{},
+	}
+	for _, u := range s.updates {
+		if u.CampaignID == id {
+			uc := *u
+			p.Machines = append(p.Machines, &uc)
+			p.Counts[u.Status]++
+		}
+	}
+	sort.Slice(p.Machines, func(i, j int) bool { return p.Machines[i].MachineID < p.Machines[j].MachineID })
+	return p, nil
+}
+
+func (s *FileFirmwareStore) SaveCampaign(c *models.FirmwareCampaign) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.releases[c.TargetVersion]; !ok {
+		return ErrReleaseNotFound
+	}
+	if c.ID == "" {
+		c.ID = newUUID()
+	}
+	if c.Status == "" {
+		c.Status = models.CampaignDraft
+	}
+	s.campaigns[c.ID] = copyCampaign(c)
+	s.save()
+	return nil
+}
+
+func (s *FileFirmwareStore) SetCampaignStatus(id, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.campaigns[id]
+	if !ok {
+		return ErrCampaignNotFound
+	}
+	c.Status = status
+	c.UpdatedAt = time.Now()
+	s.save()
+	return nil
+}
+
+// targets reports whether a campaign includes a machine: listed serial, shared
+// tag, or stable hash bucket below the rollout percentage
+func targets(c *models.FirmwareCampaign, m *models.Machine) bool {
+	if slices.Contains(c.Serials, m.NoSerie) {
+		return true
+	}
+	for _, tag := range m.Tags {
+		if slices.Contains(c.Tags, tag) {
+			return true
+		}
+	}
+	if c.Percentage <= 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(c.ID + "/" + m.NoSerie))
+	return int(h.Sum32()%100) < c.Percentage
+}
+
+func (s *FileFirmwareStore) OfferUpdate(m *models.Machine) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	changed := s.expire(now)
+	defer func() {
+		if changed {
+			s.save()
+		}
+	}()
+
+	for _, c := range s.sortedCampaigns() {
+		if c.Status != models.CampaignActive || !targets(c, m) {
+			continue
+		}
+		if _, ok := s.releases[c.TargetVersion]; !ok || !models.IsNewerFirmware(c.TargetVersion, m.Version) {
+			continue
+		}
+
+		// The first active campaign targeting the machine owns it
+		key := updateKey(c.ID, m.ID)
+		u, ok := s.updates[key]
+		switch {
+		case !ok:
+			u = &models.FirmwareUpdate{
+				CampaignID:    c.ID,
+				MachineID:     m.ID,
+				NoSerie:       m.NoSerie,
+				FromVersion:   m.Version,
+				TargetVersion: c.TargetVersion,
+				Status:        models.UpdateOffered,
+				Attempts:      1,
+				OfferedAt:     now,
+				UpdatedAt:     now,
+			}
+			s.updates[key] = u
+			changed = true
+		case u.Status == models.UpdateFailed:
+			if u.Attempts >= s.policy.MaxAttempts {
+				return "", nil
+			}
+			u.Attempts++
+			u.Status = models.UpdateOffered
+			u.BlocksServed = 0
+			u.Error = ""
+			u.FromVersion = m.Version
+			u.OfferedAt, u.UpdatedAt = now, now
+			changed = true
+		case u.Status == models.UpdateSucceeded:
+			// Reported the target once, then went back: do not loop
+			return "", nil
+		}
+		return u.TargetVersion, nil
+	}
+	return "", nil
+}
+
+// current returns the update in progress for a machine; the caller holds the lock
+func (s *FileFirmwareStore) current(machineID int) *models.FirmwareUpdate {
+	var latest *models.FirmwareUpdate
+	for _, u := range s.updates {
+		if u.MachineID != machineID {
+			continue
+		}
+		switch u.Status {
+		case models.UpdateOffered, models.UpdateDownloading, models.UpdateInstalling:
+			if latest == nil || u.OfferedAt.After(latest.OfferedAt) {
+				latest = u
+			}
+		}
+	}
+	return latest
+}
+
+// ServeBlock reads a block of the update offered to a machine and records the
+// download progress. It returns the version the block belongs to.
+func (s *FileFirmwareStore) ServeBlock(machineID, index, size int) (string, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := s.expire(time.Now())
+	defer func() {
+		if changed {
+			s.save()
+		}
+	}()
+
+	u := s.current(machineID)
+	if u == nil {
+		return "", nil, ErrNoUpdate
+	}
+	rel, ok := s.releases[u.TargetVersion]
+	if !ok {
+		return "", nil, ErrReleaseNotFound
+	}
+	block, err := s.readBlock(rel, index, size)
+	if err != nil {
+		return u.TargetVersion, nil, err
+	}
+
+	if index == 0 || u.Status == models.UpdateOffered {
+		u.BlocksServed = 0
+		// Not saved on every block: the counter is informative and a restart
+		// only resets progress, which the download timeout handles.
+		changed = true
+	}
+	u.Status = models.UpdateDownloading
+	u.BlocksServed = max(u.BlocksServed, index+1)
+	u.UpdatedAt = time.Now()
+	return u.TargetVersion, block, nil
+}
+
+func (s *FileFirmwareStore) RecordEnd(machineID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := s.expire(time.Now())
+	u := s.current(machineID)
+	if u == nil || u.Status != models.UpdateDownloading {
+		if changed {
+			s.save()
+		}
+		return ErrNoUpdate
+	}
+	u.Status = models.UpdateInstalling
+	u.UpdatedAt = time.Now()
+	s.save()
+	return nil
+}
+
+// RecordVersion closes the update of a machine that reports its target version
+func (s *FileFirmwareStore) RecordVersion(machineID int, version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.current(machineID)
+	if u == nil || u.TargetVersion != version {
+		return nil
+	}
+	now := time.Now()
+	u.Status = models.UpdateSucceeded
+	u.UpdatedAt = now
+	u.CompletedAt = &now
+	s.save()
+	log.Printf("[FIRMWARE] Machine %d updated to %s (campaign %s)", machineID, version, u.CampaignID)
+	return nil
+}
+
+// expire fails stalled updates and pauses campaigns over their failure budget.
+// The caller holds the lock; it reports whether anything changed.
+func (s *FileFirmwareStore) expire(now time.Time) bool {
+	changed := false
+	for _, u := range s.updates {
+		reason := ""
+		switch {
+		case u.Status == models.UpdateOffered && now.Sub(u.UpdatedAt) > s.policy.OfferTimeout:
+			reason = "offer not downloaded"
+		case u.Status == models.UpdateDownloading && now.Sub(u.UpdatedAt) > s.policy.DownloadTimeout:
+			reason = fmt.Sprintf("download interrupted after %d blocks", u.BlocksServed)
+		case u.Status == models.UpdateInstalling && now.Sub(u.UpdatedAt) > s.policy.InstallTimeout:
+			reason = "target version not reported after install"
+		}
+		if reason == "" {
+			continue
+		}
+		u.Status = models.UpdateFailed
+		u.Error = reason
+		u.UpdatedAt = now
+		changed = true
+		log.Printf("[FIRMWARE] Update of machine %d to %s failed: %s", u.MachineID, u.TargetVersion, reason)
+	}
+	if !changed {
+		return false
+	}
+
+	failures := map[string]int{}
+	for _, u := range s.updates {
+		if u.Status == models.UpdateFailed {
+			failures[u.CampaignID]++
+		}
+	}
+	for _, c := range s.campaigns {
+		if c.Status == models.CampaignActive && c.MaxFailures > 0 && failures[c.ID] >= c.MaxFailures {
+			c.Status = models.CampaignPaused
+			c.UpdatedAt = now
+			log.Printf("[FIRMWARE] Campaign %s paused after %d failures", c.Name, failures[c.ID])
+		}
+	}
+	return true
+}
+
+func copyCampaign(c *models.FirmwareCampaign) *models.FirmwareCampaign {
+	cc := *c
+	cc.Tags = slices.Clone(c.Tags)
+	cc.Serials = slices.Clone(c.Serials)
+	return &cc
+}
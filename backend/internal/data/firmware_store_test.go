@@ -0,0 +1,150 @@
+package data
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func newTestFirmwareStore(t *testing.T) *FileFirmwareStore {
+	dir := t.TempDir()
+	return NewFileFirmwareStore(filepath.Join(dir, "firmware.json"), filepath.Join(dir, "firmware"), DefaultFirmwarePolicy)
+}
+
+func TestFirmwareTargets(t *testing.T) {
+	m := &models.Machine{ID: 1, NoSerie: "ES-0001", Tags: []string{"pilot"}}
+	tests := []struct {
+		name     string
+		campaign models.FirmwareCampaign
+		want     bool
+	}{
+		{"listed serial", models.FirmwareCampaign{ID: "c1", Serials: []string{"ES-0002", "ES-0001"}}, true},
+		{"other serials", models.FirmwareCampaign{ID: "c1", Serials: []string{"ES-0002"}}, false},
+		{"shared tag", models.FirmwareCampaign{ID: "c1", Tags: []string{"beta", "pilot"}}, true},
+		{"other tags", models.FirmwareCampaign{ID: "c1", Tags: []string{"beta"}}, false},
+		{"no rollout", models.FirmwareCampaign{ID: "c1"}, false},
+		{"whole fleet", models.FirmwareCampaign{ID: "c1", Percentage: 100}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targets(&tt.campaign, m); got != tt.want {
+				t.Errorf("targets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirmwareTargetsPercentageBuckets(t *testing.T) {
+	const fleet = 1000
+	for _, pct := range []int{10, 50, 90} {
+		c := &models.FirmwareCampaign{ID: "rollout", Percentage: pct}
+		wider := &models.FirmwareCampaign{ID: "rollout", Percentage: pct + 5}
+		n := 0
+		for i := 0; i < fleet; i++ {
+			m := &models.Machine{NoSerie: fmt.Sprintf("ES-%04d", i)}
+			if !targets(c, m) {
+				continue
+			}
+			n++
+			// A machine keeps its bucket as the rollout widens
+			if !targets(wider, m) {
+				t.Errorf("%s left the rollout going from %d%% to %d%%", m.NoSerie, pct, pct+5)
+			}
+		}
+		if want := fleet * pct / 100; n < want-fleet/20 || n > want+fleet/20 {
+			t.Errorf("%d%% rollout targets %d machines of %d, want about %d", pct, n, fleet, want)
+		}
+	}
+}
+
+func TestFirmwareExpire(t *testing.T) {
+	p := DefaultFirmwarePolicy
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		status string
+		idle   time.Duration
+		want   string
+	}{
+		{"fresh offer", models.UpdateOffered, p.OfferTimeout - time.Minute, models.UpdateOffered},
+		{"stale offer", models.UpdateOffered, p.OfferTimeout + time.Minute, models.UpdateFailed},
+		{"download in progress", models.UpdateDownloading, p.DownloadTimeout - time.Minute, models.UpdateDownloading},
+		{"download interrupted", models.UpdateDownloading, p.DownloadTimeout + time.Minute, models.UpdateFailed},
+		{"installing", models.UpdateInstalling, p.InstallTimeout - time.Minute, models.UpdateInstalling},
+		{"version not reported", models.UpdateInstalling, p.InstallTimeout + time.Minute, models.UpdateFailed},
+		{"succeeded long ago", models.UpdateSucceeded, 24 * time.Hour, models.UpdateSucceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestFirmwareStore(t)
+			u := &models.FirmwareUpdate{CampaignID: "c1", MachineID: 1, Status: tt.status, UpdatedAt: now.Add(-tt.idle)}
+			s.updates[updateKey(u.CampaignID, u.MachineID)] = u
+
+			changed := s.expire(now)
+			if u.Status != tt.want || changed != (tt.want != tt.status) {
+				t.Errorf("expire() = %v, status %s, want %s", changed, u.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirmwareExpirePausesCampaign(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	stale := now.Add(-2 * DefaultFirmwarePolicy.DownloadTimeout)
+	tests := []struct {
+		name        string
+		maxFailures int
+		failures    int
+		want        string
+	}{
+		{"under the budget", 3, 2, models.CampaignActive},
+		{"budget reached", 3, 3, models.CampaignPaused},
+		{"no budget", 0, 5, models.CampaignActive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestFirmwareStore(t)
+			c := &models.FirmwareCampaign{ID: "c1", Status: models.CampaignActive, MaxFailures: tt.maxFailures}
+			s.campaigns[c.ID] = c
+			for i := 0; i < tt.failures; i++ {
+				// One failure already recorded, the others stall now
+				status := models.UpdateDownloading
+				if i == 0 {
+					status = models.UpdateFailed
+				}
+				s.updates[updateKey(c.ID, i)] = &models.FirmwareUpdate{CampaignID: c.ID, MachineID: i, Status: status, UpdatedAt: stale}
+			}
+
+			s.expire(now)
+			if c.Status != tt.want {
+				t.Errorf("campaign status = %s, want %s", c.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestFirmwareOfferRetriesStaleOffers(t *testing.T) {
+	s := newTestFirmwareStore(t)
+	s.releases["V2"] = &models.FirmwareRelease{Version: "V2"}
+	c := &models.FirmwareCampaign{ID: "c1", TargetVersion: "V2", Serials: []string{"ES-0001"}, Status: models.CampaignActive}
+	s.campaigns[c.ID] = c
+	m := &models.Machine{ID: 1, NoSerie: "ES-0001", Version: "V1"}
+
+	for attempt := 1; attempt <= s.policy.MaxAttempts; attempt++ {
+		if v, err := s.OfferUpdate(m); err != nil || v != "V2" {
+			t.Fatalf("attempt %d: OfferUpdate() = %q, %v", attempt, v, err)
+		}
+		u := s.updates[updateKey(c.ID, m.ID)]
+		if u.Attempts != attempt {
+			t.Fatalf("attempts = %d, want %d", u.Attempts, attempt)
+		}
+		// The machine never asks for the first block
+		u.UpdatedAt = u.UpdatedAt.Add(-2 * s.policy.OfferTimeout)
+	}
+	if v, err := s.OfferUpdate(m); err != nil || v != "" {
+		t.Errorf("OfferUpdate() past MaxAttempts = %q, %v", v, err)
+	}
+}
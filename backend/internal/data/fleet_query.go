@@ -0,0 +1,253 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// ErrInvalidFleetQuery is returned for an unknown sort field or a cursor that
+// was not issued for this sort
+var ErrInvalidFleetQuery = errors.New("invalid fleet query")
+
+// sortTimeLayout has a fixed width, so that times compare as strings
+const sortTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// fleetCursor is the position after the last item of a page: pages are read
+// by keyset, so records added or removed meanwhile do not shift them
+type fleetCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"` // Sort value of the last item, see machineSortValue
+	Key   string `json:"k"` // Padded machine ID or hostname of the last item, breaks ties
+}
+
+func (c fleetCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseFleetQuery returns the sort field of q, fields[0] by default, and its
+// cursor, nil for the first page
+func parseFleetQuery(q models.FleetQuery, fields []string) (string, *fleetCursor, error) {
+	field := q.Sort
+	if field == "" {
+		field = fields[0]
+	}
+	known := false
+	for _, f := range fields {
+		known = known || f == field
+	}
+	if !known {
+		return "", nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidFleetQuery, field)
+	}
+	if q.Cursor == "" {
+		return field, nil, nil
+	}
+
+	var c fleetCursor
+	b, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err == nil && c.Sort != field {
+		err = errors.New("issued for another sort")
+	}
+	if err == nil {
+		_, err = cursorValue(field, c.Value)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: bad cursor: %v", ErrInvalidFleetQuery, err)
+	}
+	return field, &c, nil
+}
+
+// padID formats a machine ID so that IDs compare as strings
+func padID(id int) string {
+	return fmt.Sprintf("%020d", id)
+}
+
+// sortTime formats t so that times compare as strings
+func sortTime(t time.Time) string {
+	return t.UTC().Format(sortTimeLayout)
+}
+
+// cursorValue is the SQL argument of a cursor value of field
+func cursorValue(field, v string) (interface{}, error) {
+	switch field {
+	case models.FleetSortID:
+		return strconv.Atoi(v)
+	case models.FleetSortLastSeen:
+		return time.Parse(sortTimeLayout, v)
+	}
+	return v, nil
+}
+
+func machineSortValue(d *models.MachineDetail, field string) string {
+	switch field {
+	case models.FleetSortSerial:
+		return d.NoSerie
+	case models.FleetSortLastSeen:
+		return sortTime(d.LastSeen)
+	case models.FleetSortVersion:
+		return d.Version
+	case models.FleetSortIP:
+		return d.IP
+	case models.FleetSortCountry:
+		return d.Country
+	}
+	return padID(d.ID)
+}
+
+func gatewaySortValue(gw *models.GatewayStatus, field string) string {
+	switch field {
+	case models.FleetSortLastSeen:
+		return sortTime(gw.LastSeen)
+	case models.FleetSortIP:
+		return gw.IP
+	case models.FleetSortCountry:
+		return gw.Country
+	}
+	return gw.Hostname
+}
+
+// matchesFleet applies the filters of q common to machines and gateways;
+// texts are the fields searched
+func matchesFleet(q models.FleetQuery, lastSeen time.Time, country string, texts ...string) bool {
+	if q.Country != "" && !strings.EqualFold(country, q.Country) {
+		return false
+	}
+	if !q.SeenAfter.IsZero() && !lastSeen.After(q.SeenAfter) {
+		return false
+	}
+	if !q.SeenBefore.IsZero() && lastSeen.After(q.SeenBefore) {
+		return false
+	}
+	if q.Search == "" {
+		return true
+	}
+	search := strings.ToLower(q.Search)
+	for _, t := range texts {
+		if strings.Contains(strings.ToLower(t), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// fleetEntry is a record of the file store being paged
+type fleetEntry struct {
+	value, key string
+	index      int
+}
+
+// pageFleet sorts entries and returns the page after cursor, with the cursor
+// of the next page
+func pageFleet(entries []fleetEntry, field string, q models.FleetQuery, cursor *fleetCursor) ([]fleetEntry, string) {
+	less := func(a, b fleetEntry) bool {
+		if a.value != b.value {
+			return a.value < b.value
+		}
+		return a.key < b.key
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if q.Desc {
+			return less(entries[j], entries[i])
+		}
+		return less(entries[i], entries[j])
+	})
+
+	start := 0
+	if cursor != nil {
+		last := fleetEntry{value: cursor.Value, key: cursor.Key}
+		start = sort.Search(len(entries), func(i int) bool {
+			if q.Desc {
+				return less(entries[i], last)
+			}
+			return less(last, entries[i])
+		})
+	}
+	page := entries[start:]
+	if q.Limit <= 0 || len(page) <= q.Limit {
+		return page, ""
+	}
+	page = page[:q.Limit]
+	last := page[len(page)-1]
+	return page, fleetCursor{Sort: field, Value: last.value, Key: last.key}.encode()
+}
+
+// likePattern matches s anywhere with ILIKE
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s) + "%"
+}
+
+// sqlFilter builds a WHERE clause with numbered arguments
+type sqlFilter struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg adds v and returns its placeholder
+func (f *sqlFilter) arg(v interface{}) string {
+	f.args = append(f.args, v)
+	return "$" + strconv.Itoa(len(f.args))
+}
+
+func (f *sqlFilter) where(cond string) {
+	f.conds = append(f.conds, cond)
+}
+
+func (f *sqlFilter) clause() string {
+	if len(f.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.conds, " AND ")
+}
+
+// applyFleetQuery adds to f the filters of q common to machines and gateways.
+// lastSeen, country and texts are the SQL expressions of the fields.
+func (f *sqlFilter) applyFleetQuery(q models.FleetQuery, lastSeen, country string, texts ...string) {
+	if q.Search != "" {
+		p := f.arg(likePattern(q.Search))
+		conds := make([]string, 0, len(texts))
+		for _, t := range texts {
+			conds = append(conds, t+" ILIKE "+p)
+		}
+		f.where("(" + strings.Join(conds, " OR ") + ")")
+	}
+	if q.Country != "" {
+		f.where("LOWER(" + country + ") = LOWER(" + f.arg(q.Country) + ")")
+	}
+	if !q.SeenAfter.IsZero() {
+		f.where(lastSeen + " > " + f.arg(q.SeenAfter))
+	}
+	if !q.SeenBefore.IsZero() {
+		f.where(lastSeen + " <= " + f.arg(q.SeenBefore))
+	}
+}
+
+// keyset adds the condition of the rows after cursor and returns the ORDER
+// BY and LIMIT clauses. sortExpr and keyExpr are the SQL expressions of the
+// sort field and the tie-break; string expressions use the C collation, to
+// sort as pageFleet and as the cursor values compare.
+func (f *sqlFilter) keyset(q models.FleetQuery, field string, cursor *fleetCursor, sortExpr, keyExpr string, key interface{}) string {
+	dir, op := "ASC", ">"
+	if q.Desc {
+		dir, op = "DESC", "<"
+	}
+	if cursor != nil {
+		v, _ := cursorValue(field, cursor.Value) // Checked by parseFleetQuery
+		f.where("(" + sortExpr + ", " + keyExpr + ") " + op + " (" + f.arg(v) + ", " + f.arg(key) + ")")
+	}
+	order := " ORDER BY " + sortExpr + " " + dir + ", " + keyExpr + " " + dir
+	if q.Limit > 0 {
+		order += " LIMIT " + strconv.Itoa(q.Limit+1) // One more tells whether a page follows
+	}
+	return order
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// GatewayIdentityStore holds the enrolled gateways and their token hashes
+type GatewayIdentityStore interface {
+	// CreateGatewayIdentity fails with ErrGatewayHostnameTaken if an active
+	// identity already has the hostname
+	CreateGatewayIdentity(id *models.GatewayIdentity) error
+	GetGatewayIdentity(id string) (*models.GatewayIdentity, error)
+	// GetGatewayIdentityByToken and GetGatewayIdentityByHostname only return
+	// active identities, nil, nil when there is none
+	GetGatewayIdentityByToken(tokenHash string) (*models.GatewayIdentity, error)
+	GetGatewayIdentityByHostname(hostname string) (*models.GatewayIdentity, error)
+	GetGatewayIdentities() ([]*models.GatewayIdentity, error)
+	// RotateGatewayToken sets a new token; the current one stays valid for grace
+	RotateGatewayToken(id, tokenHash, tokenPrefix string, grace time.Duration) error
+	RevokeGatewayIdentity(id string) error
+	TouchGatewayIdentity(id, ip string) error
+}
+
+var (
+	ErrGatewayIdentityNotFound = errors.New("gateway identity not found")
+	ErrGatewayHostnameTaken    = errors.New("gateway hostname already enrolled")
+	ErrGatewayIdentityRevoked  = errors.New("gateway identity revoked")
+)
+
+// gatewayTokenPrefix marks gateway tokens, e.g. in logs or config files
+const gatewayTokenPrefix = "gwt_"
+
+// NewGatewayToken returns a random bearer token, its hash and its display prefix
+func NewGatewayToken() (token, hash, prefix string) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	token = gatewayTokenPrefix + hex.EncodeToString(b[:])
+	return token, HashGatewayToken(token), token[:len(gatewayTokenPrefix)+8]
+}
+
+// HashGatewayToken is the stored form of a token. Tokens are random, a fast
+// hash is enough and allows lookups by hash.
+func HashGatewayToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// gatewayIdentityRecord is the file format: hashes are not part of the API view
+type gatewayIdentityRecord struct {
+	*models.GatewayIdentity
+	TokenHash         string `json:"token_hash"`
+	PreviousTokenHash string `json:"previous_token_hash,omitempty"`
+}
+
+// FileGatewayIdentityStore keeps the identities in memory and persists them to a JSON file
+type FileGatewayIdentityStore struct {
+	mu       sync.Mutex
+	byID     map[string]*models.GatewayIdentity
+	filePath string
+}
+
+func NewFileGatewayIdentityStore(storagePath string) *FileGatewayIdentityStore {
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		log.Printf("Failed to create gateway identity storage dir: %v", err)
+	}
+	s := &FileGatewayIdentityStore{
+		byID:     make(map[string]*models.GatewayIdentity),
+		filePath: storagePath,
+	}
+	s.load()
+	return s
+}
+
+func (s *FileGatewayIdentityStore) load() {
+	b, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open gateway identity storage file: %v", err)
+		}
+		return
+	}
+
+	var records []gatewayIdentityRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		log.Printf("Failed to decode gateway identity storage file: %v", err)
+		return
+	}
+	for _, rec := range records {
+		id := rec.GatewayIdentity
+		if id == nil || id.ID == "" {
+			continue
+		}
+		id.TokenHash = rec.TokenHash
+		id.PreviousTokenHash = rec.PreviousTokenHash
+		s.byID[id.ID] = id
+	}
+	log.Printf("Loaded %d gateway identities.", len(s.byID))
+}
+
+// save writes the file; the caller holds the lock
+func (s *FileGatewayIdentityStore) save() error {
+	records := make([]gatewayIdentityRecord, 0, len(s.byID))
+	for _, id := range s.sorted() {
+		records = append(records, gatewayIdentityRecord{
+			GatewayIdentity:   id,
+			TokenHash:         id.TokenHash,
+			PreviousTokenHash: id.PreviousTokenHash,
+		})
+	}
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write gateway identity storage file: %v", err)
+		return err
+	}
+	return nil
+}
+
+// sorted returns the identities oldest first; the caller holds the lock
+func (s *FileGatewayIdentityStore) sorted() []*models.GatewayIdentity {
+	list := make([]*models.GatewayIdentity, 0, len(s.byID))
+	for _, id := range s.byID {
+		list = append(list, id)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// active returns the active identity of hostname; the caller holds the lock
+func (s *FileGatewayIdentityStore) active(hostname string) *models.GatewayIdentity {
+	for _, id := range s.byID {
+		if id.Status == models.GatewayIdentityActive && id.Hostname == hostname {
+			return id
+		}
+	}
+	return nil
+}
+
+func (s *FileGatewayIdentityStore) CreateGatewayIdentity(id *models.GatewayIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active(id.Hostname) != nil {
+		return ErrGatewayHostnameTaken
+	}
+	if id.ID == "" {
+		id.ID = newUUID()
+	}
+	id.Status = models.GatewayIdentityActive
+	c := *id
+	s.byID[c.ID] = &c
+	if err := s.save(); err != nil {
+		delete(s.byID, c.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *FileGatewayIdentityStore) GetGatewayIdentity(id string) (*models.GatewayIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.byID[id]
+	if !ok {
+		return nil, ErrGatewayIdentityNotFound
+	}
+	c := *identity
+	return &c, nil
+}
+
+func (s *FileGatewayIdentityStore) GetGatewayIdentityByToken(tokenHash string) (*models.GatewayIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range s.byID {
+		if id.Status != models.GatewayIdentityActive {
+			continue
+		}
+		if id.TokenHash == tokenHash ||
+			(id.PreviousTokenHash == tokenHash && id.PreviousUntil != nil && now.Before(*id.PreviousUntil)) {
+			c := *id
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *FileGatewayIdentityStore) GetGatewayIdentityByHostname(hostname string) (*models.GatewayIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.active(hostname)
+	if id == nil {
+		return nil, nil
+	}
+	c := *id
+	return &c, nil
+}
+
+func (s *FileGatewayIdentityStore) GetGatewayIdentities() ([]*models.GatewayIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.sorted()
+	for i, id := range list {
+		c := *id
+		list[i] = &c
+	}
+	return list, nil
+}
+
+func (s *FileGatewayIdentityStore) RotateGatewayToken(id, tokenHash, tokenPrefix string, grace time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.byID[id]
+	if !ok {
+		return ErrGatewayIdentityNotFound
+	}
+	if identity.Status != models.GatewayIdentityActive {
+		return ErrGatewayIdentityRevoked
+	}
+	now := time.Now()
+	previous := *identity
+	if grace > 0 {
+		until := now.Add(grace)
+		identity.PreviousTokenHash = identity.TokenHash
+		identity.PreviousUntil = &until
+	} else {
+		identity.PreviousTokenHash = ""
+		identity.PreviousUntil = nil
+	}
+	identity.TokenHash = tokenHash
+	identity.TokenPrefix = tokenPrefix
+	identity.RotatedAt = &now
+	if err := s.save(); err != nil {
+		*identity = previous
+		return err
+	}
+	return nil
+}
+
+func (s *FileGatewayIdentityStore) RevokeGatewayIdentity(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.byID[id]
+	if !ok {
+		return ErrGatewayIdentityNotFound
+	}
+	if identity.Status == models.GatewayIdentityRevoked {
+		return ErrGatewayIdentityRevoked
+	}
+	now := time.Now()
+	previous := *identity
+	identity.Status = models.GatewayIdentityRevoked
+	identity.RevokedAt = &now
+	identity.TokenHash = ""
+	identity.PreviousTokenHash = ""
+	identity.PreviousUntil = nil
+	if err := s.save(); err != nil {
+		*identity = previous
+		return err
+	}
+	return nil
+}
+
+func (s *FileGatewayIdentityStore) TouchGatewayIdentity(id, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.byID[id]
+	if !ok {
+		return ErrGatewayIdentityNotFound
+	}
+	now := time.Now()
+	persist := identity.LastSeen == nil || now.Sub(*identity.LastSeen) > touchPersistInterval || identity.LastIP != ip
+	identity.LastIP = ip
+	identity.LastSeen = &now
+	if persist {
+		return s.save()
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package data
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// GatewayMetricsStore keeps the history of the metrics pushed to POST /api/infos:
+// raw points, rolled up into 5-minute and hourly averages. Gateways are
+// identified by GatewayStatus.Key(), the ID of enrolled ones.
+type GatewayMetricsStore interface {
+	EnsureTableExists() error
+	RecordGatewayMetrics(key string, p models.GatewayMetricsPoint) error
+	// QueryGatewayMetrics returns the points of one resolution, oldest first.
+	// Past maxTelemetryPoints, only the newest are returned.
+	QueryGatewayMetrics(q models.GatewayMetricsQuery) ([]models.GatewayMetricsPoint, error)
+	// ApplyRetention computes the rollups of complete buckets, then deletes
+	// the points older than the retention of their resolution
+	ApplyRetention(policy models.GatewayMetricsRetention, now time.Time) error
+	// MoveGatewayMetrics gives the points of from since a time to another key,
+	// keeping those of to on a conflict. Returns the number of points moved.
+	MoveGatewayMetrics(from, to string, since time.Time) (int, error)
+}
+
+// AdoptEnrolledGatewayMetrics moves to the ID of each enrolled gateway the
+// history recorded under its hostname since the enrollment: authenticated
+// reports, from the versions that keyed the history by hostname. The points
+// from before the enrollment stay under the hostname, until they expire.
+func AdoptEnrolledGatewayMetrics(store GatewayMetricsStore, identities GatewayIdentityStore) error {
+	list, err := identities.GetGatewayIdentities()
+	if err != nil {
+		return err
+	}
+	for _, id := range list {
+		if id.Status != models.GatewayIdentityActive {
+			continue
+		}
+		n, err := store.MoveGatewayMetrics(id.Hostname, id.ID, id.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("[GATEWAY METRICS] Moved %d points of %s to its ID %s", n, id.Hostname, id.ID)
+		}
+	}
+	return nil
+}
+
+const (
+	gatewayRollup5m     = 5 * time.Minute
+	gatewayRollupHourly = time.Hour
+	// Buckets before the last run are computed again for late pushes
+	gatewayRollupOverlap = 10 * time.Minute
+)
+
+// RunGatewayMetricsRetention applies the policy every interval. Blocking, run it in a goroutine.
+func RunGatewayMetricsRetention(store GatewayMetricsStore, policy models.GatewayMetricsRetention, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := store.ApplyRetention(policy, time.Now()); err != nil {
+			log.Printf("[GATEWAY METRICS] Retention failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// rollupStart is where a run starts computing buckets: the previous run, or
+// the oldest raw point kept on the first run
+func rollupStart(last time.Time, policy models.GatewayMetricsRetention, now time.Time) time.Time {
+	if last.IsZero() {
+		if policy.RawRetention <= 0 {
+			return time.Time{}
+		}
+		return now.Add(-policy.RawRetention)
+	}
+	return last.Add(-gatewayRollupOverlap)
+}
+
+// GatewayTrendOf summarizes the points of a gateway (oldest first): averages
+// and disk growth, fitted by least squares
+func GatewayTrendOf(gw *models.GatewayStatus, points []models.GatewayMetricsPoint) models.GatewayTrend {
+	latest := gw.MetricsPoint()
+	t := models.GatewayTrend{
+		Hostname:    gw.Hostname,
+		LastSeen:    gw.LastSeen,
+		CPU:         latest.CPU,
+		Memory:      latest.Memory,
+		Disk:        latest.Disk,
+		ClientCount: gw.ClientCount,
+	}
+	if len(points) == 0 {
+		return t
+	}
+
+	var weight, cpu float64
+	for _, p := range points {
+		w := float64(max(p.Samples, 1))
+		cpu += p.CPU * w
+		weight += w
+	}
+	t.AvgCPU = cpu / weight
+
+	if len(points) < 2 {
+		return t
+	}
+	origin := points[0].Time
+	var sx, sy, sxx, sxy float64
+	n := float64(len(points))
+	for _, p := range points {
+		x := p.Time.Sub(origin).Hours() / 24
+		sx += x
+		sy += p.Disk
+		sxx += x * x
+		sxy += x * p.Disk
+	}
+	if d := n*sxx - sx*sx; d > 0 {
+		t.DiskGrowthPerDay = (n*sxy - sx*sy) / d
+	}
+	if t.DiskGrowthPerDay > 0 {
+		days := math.Max(0, (100-t.Disk)/t.DiskGrowthPerDay)
+		t.DiskFullInDays = &days
+	}
+	return t
+}
+
+// ---------------------------------------------------------------------
+// Postgres implementation
+// ---------------------------------------------------------------------
+
+type PostgresGatewayMetricsStore struct {
+	db       *sqlx.DB
+	rolledUp time.Time // Last ApplyRetention, only used by its goroutine
+}
+
+func NewPostgresGatewayMetricsStore(db *sqlx.DB) *PostgresGatewayMetricsStore {
+	return &PostgresGatewayMetricsStore{db: db}
+}
+
+func (s *PostgresGatewayMetricsStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS gateway_metrics (
+		gateway_key VARCHAR(255) NOT NULL, -- GatewayStatus.Key()
+		resolution INT NOT NULL, -- Bucket size in seconds, 0 for raw points
+		bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+		cpu DOUBLE PRECISION NOT NULL,
+		memory DOUBLE PRECISION NOT NULL,
+		disk DOUBLE PRECISION NOT NULL,
+		client_count DOUBLE PRECISION NOT NULL,
+		samples INT NOT NULL DEFAULT 1,
+		PRIMARY KEY (gateway_key, resolution, bucket)
+	);
+	DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+		           WHERE table_name = 'gateway_metrics' AND column_name = 'hostname') THEN
+			ALTER TABLE gateway_metrics RENAME COLUMN hostname TO gateway_key;
+		END IF;
+	END $$;
+	CREATE INDEX IF NOT EXISTS idx_gateway_metrics_resolution_bucket ON gateway_metrics(resolution, bucket);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+func (s *PostgresGatewayMetricsStore) RecordGatewayMetrics(key string, p models.GatewayMetricsPoint) error {
+	_, err := s.db.Exec(`
+		INSERT INTO gateway_metrics (gateway_key, resolution, bucket, cpu, memory, disk, client_count, samples)
+		VALUES ($1, 0, $2, $3, $4, $5, $6, 1)
+		ON CONFLICT DO NOTHING`,
+		key, p.Time, p.CPU, p.Memory, p.Disk, p.ClientCount)
+	return err
+}
+
+func (s *PostgresGatewayMetricsStore) QueryGatewayMetrics(q models.GatewayMetricsQuery) ([]models.GatewayMetricsPoint, error) {
+	points := []models.GatewayMetricsPoint{}
+	// The newest points, put back in time order
+	err := s.db.Select(&points, `
+		SELECT * FROM (
+			SELECT bucket, cpu, memory, disk, client_count, samples FROM gateway_metrics
+			WHERE gateway_key = $1 AND resolution = $2 AND bucket >= $3 AND bucket <= $4
+			ORDER BY bucket DESC
+			LIMIT $5
+		) newest
+		ORDER BY bucket`,
+		q.Gateway, int(q.Resolution.Seconds()), q.From, q.To, maxTelemetryPoints)
+	return points, err
+}
+
+func (s *PostgresGatewayMetricsStore) ApplyRetention(policy models.GatewayMetricsRetention, now time.Time) error {
+	start := rollupStart(s.rolledUp, policy, now)
+
+	// Averages weighted by samples, so that hourly buckets average raw points
+	rollup := `
+		INSERT INTO gateway_metrics (gateway_key, resolution, bucket, cpu, memory, disk, client_count, samples)
+		SELECT gateway_key, $2::int, to_timestamp(floor(extract(epoch FROM bucket) / $2) * $2) AS b,
+			SUM(cpu * samples) / SUM(samples), SUM(memory * samples) / SUM(samples),
+			SUM(disk * samples) / SUM(samples), SUM(client_count * samples) / SUM(samples),
+			SUM(samples)
+		FROM gateway_metrics
+		WHERE resolution = $1 AND bucket >= $3 AND bucket < $4
+		GROUP BY gateway_key, b
+		ON CONFLICT (gateway_key, resolution, bucket) DO UPDATE SET
+			cpu = EXCLUDED.cpu,
+			memory = EXCLUDED.memory,
+			disk = EXCLUDED.disk,
+			client_count = EXCLUDED.client_count,
+			samples = EXCLUDED.samples`
+	if _, err := s.db.Exec(rollup, 0, int(gatewayRollup5m.Seconds()), start.Truncate(gatewayRollup5m), now.Truncate(gatewayRollup5m)); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(rollup, int(gatewayRollup5m.Seconds()), int(gatewayRollupHourly.Seconds()), start.Truncate(gatewayRollupHourly), now.Truncate(gatewayRollupHourly)); err != nil {
+		return err
+	}
+	s.rolledUp = now
+
+	retention := map[time.Duration]time.Duration{
+		0:                   policy.RawRetention,
+		gatewayRollup5m:     policy.FiveMinRetention,
+		gatewayRollupHourly: policy.HourlyRetention,
+	}
+	for res, keep := range retention {
+		if keep <= 0 {
+			continue
+		}
+		res, err := s.db.Exec(`DELETE FROM gateway_metrics WHERE resolution = $1 AND bucket < $2`, int(res.Seconds()), now.Add(-keep))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("[GATEWAY METRICS] Deleted %d expired points", n)
+		}
+	}
+	return nil
+}
+
+func (s *PostgresGatewayMetricsStore) MoveGatewayMetrics(from, to string, since time.Time) (int, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO gateway_metrics (gateway_key, resolution, bucket, cpu, memory, disk, client_count, samples)
+		SELECT $2, resolution, bucket, cpu, memory, disk, client_count, samples FROM gateway_metrics
+		WHERE gateway_key = $1 AND bucket >= $3
+		ON CONFLICT DO NOTHING`, from, to, since); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM gateway_metrics WHERE gateway_key = $1 AND bucket >= $2`, from, since)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), tx.Commit()
+}
+
+// ---------------------------------------------------------------------
+// In-memory implementation (used when no database is configured)
+// ---------------------------------------------------------------------
+
+type MemoryGatewayMetricsStore struct {
+	mu       sync.RWMutex
+	series   map[string]map[time.Duration][]models.GatewayMetricsPoint // key -> resolution -> points (oldest first)
+	rolledUp time.Time
+}
+
+func NewMemoryGatewayMetricsStore() *MemoryGatewayMetricsStore {
+	return &MemoryGatewayMetricsStore{series: make(map[string]map[time.Duration][]models.GatewayMetricsPoint)}
+}
+
+func (s *MemoryGatewayMetricsStore) EnsureTableExists() error {
+	return nil
+}
+
+func (s *MemoryGatewayMetricsStore) RecordGatewayMetrics(key string, p models.GatewayMetricsPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byRes, ok := s.series[key]
+	if !ok {
+		byRes = make(map[time.Duration][]models.GatewayMetricsPoint)
+		s.series[key] = byRes
+	}
+	p.Samples = 1
+	raw := byRes[0]
+	raw = append(raw, p)
+	// Pushes normally arrive in order: only sort when one did not
+	if n := len(raw); n > 1 && raw[n-1].Time.Before(raw[n-2].Time) {
+		sort.SliceStable(raw, func(i, j int) bool { return raw[i].Time.Before(raw[j].Time) })
+	}
+	byRes[0] = raw
+	return nil
+}
+
+func (s *MemoryGatewayMetricsStore) QueryGatewayMetrics(q models.GatewayMetricsQuery) ([]models.GatewayMetricsPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	points := []models.GatewayMetricsPoint{}
+	for _, p := range s.series[q.Gateway][q.Resolution] {
+		if p.Time.Before(q.From) || p.Time.After(q.To) {
+			continue
+		}
+		points = append(points, p)
+	}
+	// Same as Postgres: the newest points
+	if n := len(points); n > maxTelemetryPoints {
+		points = points[n-maxTelemetryPoints:]
+	}
+	return points, nil
+}
+
+func (s *MemoryGatewayMetricsStore) ApplyRetention(policy models.GatewayMetricsRetention, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := rollupStart(s.rolledUp, policy, now)
+	for _, byRes := range s.series {
+		byRes[gatewayRollup5m] = mergeRollup(byRes[gatewayRollup5m], byRes[0],
+			start.Truncate(gatewayRollup5m), now.Truncate(gatewayRollup5m), gatewayRollup5m)
+		byRes[gatewayRollupHourly] = mergeRollup(byRes[gatewayRollupHourly], byRes[gatewayRollup5m],
+			start.Truncate(gatewayRollupHourly), now.Truncate(gatewayRollupHourly), gatewayRollupHourly)
+
+		for res, keep := range map[time.Duration]time.Duration{
+			0:                   policy.RawRetention,
+			gatewayRollup5m:     policy.FiveMinRetention,
+			gatewayRollupHourly: policy.HourlyRetention,
+		} {
+			if keep <= 0 {
+				continue
+			}
+			points := byRes[res]
+			i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(now.Add(-keep)) })
+			byRes[res] = append([]models.GatewayMetricsPoint(nil), points[i:]...)
+		}
+	}
+	s.rolledUp = now
+	return nil
+}
+
+func (s *MemoryGatewayMetricsStore) MoveGatewayMetrics(from, to string, since time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.series[from]
+	if !ok || from == to {
+		return 0, nil
+	}
+	dst, ok := s.series[to]
+	if !ok {
+		dst = make(map[time.Duration][]models.GatewayMetricsPoint)
+		s.series[to] = dst
+	}
+	moved := 0
+	for res, points := range src {
+		i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(since) })
+		if i == len(points) {
+			continue
+		}
+		taken := make(map[int64]bool, len(dst[res]))
+		for _, p := range dst[res] {
+			taken[p.Time.UnixNano()] = true
+		}
+		merged := dst[res]
+		for _, p := range points[i:] {
+			if !taken[p.Time.UnixNano()] {
+				merged = append(merged, p)
+			}
+			moved++
+		}
+		sort.SliceStable(merged, func(a, b int) bool { return merged[a].Time.Before(merged[b].Time) })
+		dst[res] = merged
+		src[res] = append([]models.GatewayMetricsPoint(nil), points[:i]...)
+	}
+	return moved, nil
+}
+
+// mergeRollup replaces the buckets of dst in [from, to) by the weighted
+// averages of the src points in that range. Both are sorted by time.
+func mergeRollup(dst, src []models.GatewayMetricsPoint, from, to time.Time, res time.Duration) []models.GatewayMetricsPoint {
+	var buckets []models.GatewayMetricsPoint
+	for _, p := range src {
+		if p.Time.Before(from) || !p.Time.Before(to) {
+			continue
+		}
+		b := p.Time.Truncate(res)
+		w := float64(max(p.Samples, 1))
+		if len(buckets) == 0 || !buckets[len(buckets)-1].Time.Equal(b) {
+			buckets = append(buckets, models.GatewayMetricsPoint{Time: b})
+		}
+		last := &buckets[len(buckets)-1]
+		// Sums for now, divided below
+		last.CPU += p.CPU * w
+		last.Memory += p.Memory * w
+		last.Disk += p.Disk * w
+		last.ClientCount += p.ClientCount * w
+		last.Samples += int(w)
+	}
+	for i := range buckets {
+		n := float64(buckets[i].Samples)
+		buckets[i].CPU /= n
+		buckets[i].Memory /= n
+		buckets[i].Disk /= n
+		buckets[i].ClientCount /= n
+	}
+
+	out := make([]models.GatewayMetricsPoint, 0, len(dst)+len(buckets))
+	for _, p := range dst {
+		if p.Time.Before(from) {
+			out = append(out, p)
+		}
+	}
+	out = append(out, buckets...)
+	for _, p := range dst {
+		if !p.Time.Before(to) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
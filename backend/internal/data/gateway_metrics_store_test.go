@@ -0,0 +1,55 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func TestMemoryGatewayMetricsStoreKeepsNewestPastCap(t *testing.T) {
+	s := NewMemoryGatewayMetricsStore()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := maxTelemetryPoints + 10
+	for i := 0; i < n; i++ {
+		s.RecordGatewayMetrics("gw-1", models.GatewayMetricsPoint{Time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	points, err := s.QueryGatewayMetrics(models.GatewayMetricsQuery{
+		Gateway: "gw-1", From: start, To: start.Add(time.Duration(n) * time.Second),
+	})
+	if err != nil || len(points) != maxTelemetryPoints {
+		t.Fatalf("QueryGatewayMetrics() = %d points, %v", len(points), err)
+	}
+	if want := start.Add(10 * time.Second); !points[0].Time.Equal(want) {
+		t.Errorf("first point at %s, want %s", points[0].Time, want)
+	}
+	if want := start.Add(time.Duration(n-1) * time.Second); !points[len(points)-1].Time.Equal(want) {
+		t.Errorf("last point at %s, want %s", points[len(points)-1].Time, want)
+	}
+}
+
+func TestMemoryGatewayMetricsStoreMovesHistorySince(t *testing.T) {
+	s := NewMemoryGatewayMetricsStore()
+	enrolled := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := -2; i < 3; i++ {
+		s.RecordGatewayMetrics("gw-1", models.GatewayMetricsPoint{Time: enrolled.Add(time.Duration(i) * time.Minute), CPU: 1})
+	}
+	// Already recorded under the ID: kept on a conflict
+	s.RecordGatewayMetrics("id-1", models.GatewayMetricsPoint{Time: enrolled.Add(2 * time.Minute), CPU: 2})
+
+	n, err := s.MoveGatewayMetrics("gw-1", "id-1", enrolled)
+	if err != nil || n != 3 {
+		t.Fatalf("MoveGatewayMetrics() = %d, %v", n, err)
+	}
+	q := models.GatewayMetricsQuery{From: enrolled.Add(-time.Hour), To: enrolled.Add(time.Hour)}
+	q.Gateway = "id-1"
+	moved, _ := s.QueryGatewayMetrics(q)
+	if len(moved) != 3 || !moved[0].Time.Equal(enrolled) || moved[2].CPU != 2 {
+		t.Errorf("history of the ID = %+v", moved)
+	}
+	q.Gateway = "gw-1"
+	if before, _ := s.QueryGatewayMetrics(q); len(before) != 2 {
+		t.Errorf("%d points left under the hostname, want the 2 before the enrollment", len(before))
+	}
+}
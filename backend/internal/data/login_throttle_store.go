@@ -0,0 +1,368 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// LoginThrottleKey is the store key of an account (normalized e-mail) or an IP
+func LoginThrottleKey(kind, value string) string {
+	return kind + ":" + value
+}
+
+// LoginThrottleStore keeps the failed login counters and lockouts. Kept in
+// the database, they are shared by every instance of the backend.
+type LoginThrottleStore interface {
+	EnsureTableExists() error
+	// GetLoginThrottle returns the state of key, nil if it has no failure
+	GetLoginThrottle(key string) (*models.LoginThrottle, error)
+	// RecordLoginFailure counts a failure of key under policy and returns
+	// the new state; locked is true when this failure started a lockout
+	RecordLoginFailure(key string, policy models.LoginThrottlePolicy, now time.Time) (t *models.LoginThrottle, locked bool, err error)
+	// ResetLoginThrottle forgets the failures and lockouts of key
+	ResetLoginThrottle(key string) error
+	// GetLoginLockouts returns the keys locked at now
+	GetLoginLockouts(now time.Time) ([]*models.LoginThrottle, error)
+	// PruneLoginThrottles deletes the keys without failure nor lockout since cutoff
+	PruneLoginThrottles(cutoff time.Time) error
+}
+
+// RunLoginThrottlePruner deletes idle counters every interval. Blocking, run it in a goroutine.
+func RunLoginThrottlePruner(store LoginThrottleStore, retention, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := store.PruneLoginThrottles(time.Now().Add(-retention)); err != nil {
+			log.Printf("[LOGIN THROTTLE] Prune failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// applyLoginFailure counts a failure in t, the same way for every store
+func applyLoginFailure(t *models.LoginThrottle, p models.LoginThrottlePolicy, now time.Time) bool {
+	// A failure slipped in while locked (concurrent request): the lockout stands
+	if t.Locked(now) {
+		t.LastFailureAt = now
+		return false
+	}
+	// A quiet period after the last lockout restarts the backoff
+	if t.LastFailureAt.IsZero() || now.Sub(t.LastFailureAt) > p.Window+p.MaxLockout {
+		t.Lockouts = 0
+		t.Failures = 0
+	}
+	if t.Failures == 0 || now.Sub(t.FirstFailureAt) > p.Window {
+		t.Failures = 0
+		t.FirstFailureAt = now
+	}
+	t.Failures++
+	t.LastFailureAt = now
+	if p.MaxFailures <= 0 || t.Failures < p.MaxFailures {
+		return false
+	}
+	t.Lockouts++
+	until := now.Add(p.LockoutDuration(t.Lockouts))
+	t.LockedUntil = &until
+	t.Failures = 0
+	return true
+}
+
+// ---------------------------------------------------------------------
+// Postgres implementation
+// ---------------------------------------------------------------------
+
+type PostgresLoginThrottleStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresLoginThrottleStore(db *sqlx.DB) *PostgresLoginThrottleStore {
+	return &PostgresLoginThrottleStore{db: db}
+}
+
+func (s *PostgresLoginThrottleStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS login_throttles (
+		key VARCHAR(320) PRIMARY KEY,
+		failures INT NOT NULL DEFAULT 0,
+		first_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		lockouts INT NOT NULL DEFAULT 0,
+		locked_until TIMESTAMP WITH TIME ZONE NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_login_throttles_locked_until ON login_throttles(locked_until);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+const loginThrottleColumns = `key, failures, first_failure_at, last_failure_at, lockouts, locked_until`
+
+func (s *PostgresLoginThrottleStore) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	var t models.LoginThrottle
+	err := s.db.Get(&t, `SELECT `+loginThrottleColumns+` FROM login_throttles WHERE key = $1`, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *PostgresLoginThrottleStore) RecordLoginFailure(key string, policy models.LoginThrottlePolicy, now time.Time) (*models.LoginThrottle, bool, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// The row lock serializes the failures of key across instances
+	if _, err := tx.Exec(`
+		INSERT INTO login_throttles (key, first_failure_at, last_failure_at) VALUES ($1, $2, $2)
+		ON CONFLICT (key) DO NOTHING`, key, now); err != nil {
+		return nil, false, err
+	}
+	var t models.LoginThrottle
+	if err := tx.Get(&t, `SELECT `+loginThrottleColumns+` FROM login_throttles WHERE key = $1 FOR UPDATE`, key); err != nil {
+		return nil, false, err
+	}
+	locked := applyLoginFailure(&t, policy, now)
+	if _, err := tx.NamedExec(`
+		UPDATE login_throttles SET failures = :failures, first_failure_at = :first_failure_at,
+			last_failure_at = :last_failure_at, lockouts = :lockouts, locked_until = :locked_until
+		WHERE key = :key`, &t); err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return &t, locked, nil
+}
+
+func (s *PostgresLoginThrottleStore) ResetLoginThrottle(key string) error {
+	_, err := s.db.Exec(`DELETE FROM login_throttles WHERE key = $1`, key)
+	return err
+}
+
+func (s *PostgresLoginThrottleStore) GetLoginLockouts(now time.Time) ([]*models.LoginThrottle, error) {
+	lockouts := []*models.LoginThrottle{}
+	err := s.db.Select(&lockouts, `SELECT `+loginThrottleColumns+` FROM login_throttles WHERE locked_until > $1 ORDER BY locked_until DESC`, now)
+	return lockouts, err
+}
+
+func (s *PostgresLoginThrottleStore) PruneLoginThrottles(cutoff time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM login_throttles
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $1)`, cutoff)
+	return err
+}
+
+// ---------------------------------------------------------------------
+// In-memory implementation
+// ---------------------------------------------------------------------
+
+// maxLoginThrottles bounds the keys kept in memory, whatever the number of
+// e-mails and IPs an attacker cycles through
+const maxLoginThrottles = 100000
+
+type MemoryLoginThrottleStore struct {
+	mu        sync.Mutex
+	throttles map[string]*models.LoginThrottle
+}
+
+func NewMemoryLoginThrottleStore() *MemoryLoginThrottleStore {
+	return &MemoryLoginThrottleStore{throttles: make(map[string]*models.LoginThrottle)}
+}
+
+// makeRoom frees a slot for a new key when the store is full: the keys that
+// are not locked are dropped first, the lockouts in force are kept. Returns
+// false when every key is locked.
+func (s *MemoryLoginThrottleStore) makeRoom(now time.Time) bool {
+	if len(s.throttles) < maxLoginThrottles {
+		return true
+	}
+	for k, t := range s.throttles {
+		if !t.Locked(now) {
+			delete(s.throttles, k)
+		}
+	}
+	if len(s.throttles) < maxLoginThrottles {
+		log.Printf("[LOGIN THROTTLE] Store full, dropped the counters of unlocked keys")
+		return true
+	}
+	return false
+}
+
+func (s *MemoryLoginThrottleStore) EnsureTableExists() error {
+	return nil
+}
+
+func (s *MemoryLoginThrottleStore) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.throttles[key]
+	if !ok {
+		return nil, nil
+	}
+	out := *t
+	return &out, nil
+}
+
+func (s *MemoryLoginThrottleStore) RecordLoginFailure(key string, policy models.LoginThrottlePolicy, now time.Time) (*models.LoginThrottle, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.throttles[key]
+	if !ok {
+		t = &models.LoginThrottle{Key: key}
+		if s.makeRoom(now) {
+			s.throttles[key] = t
+		}
+	}
+	locked := applyLoginFailure(t, policy, now)
+	out := *t
+	return &out, locked, nil
+}
+
+func (s *MemoryLoginThrottleStore) ResetLoginThrottle(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.throttles, key)
+	return nil
+}
+
+func (s *MemoryLoginThrottleStore) GetLoginLockouts(now time.Time) ([]*models.LoginThrottle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lockouts := []*models.LoginThrottle{}
+	for _, t := range s.throttles {
+		if t.Locked(now) {
+			out := *t
+			lockouts = append(lockouts, &out)
+		}
+	}
+	sort.Slice(lockouts, func(i, j int) bool { return lockouts[i].LockedUntil.After(*lockouts[j].LockedUntil) })
+	return lockouts, nil
+}
+
+func (s *MemoryLoginThrottleStore) PruneLoginThrottles(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, t := range s.throttles {
+		if t.LastFailureAt.Before(cutoff) && (t.LockedUntil == nil || t.LockedUntil.Before(cutoff)) {
+			delete(s.throttles, k)
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// File implementation (used when no database is configured)
+// ---------------------------------------------------------------------
+
+// FileLoginThrottleStore keeps the counters in memory and persists them to a
+// JSON file, so that a restart does not lift the lockouts. The file is
+// written when a lockout starts or a key is reset or pruned: failures below
+// the limit are not worth a write each and may be lost on a crash.
+type FileLoginThrottleStore struct {
+	*MemoryLoginThrottleStore
+	saveMu   sync.Mutex // Orders the writes of concurrent snapshots
+	filePath string
+}
+
+func NewFileLoginThrottleStore(storagePath string) *FileLoginThrottleStore {
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		log.Printf("Failed to create login throttle storage dir: %v", err)
+	}
+	s := &FileLoginThrottleStore{
+		MemoryLoginThrottleStore: NewMemoryLoginThrottleStore(),
+		filePath:                 storagePath,
+	}
+	s.load()
+	return s
+}
+
+func (s *FileLoginThrottleStore) load() {
+	b, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open login throttle storage file: %v", err)
+		}
+		return
+	}
+	var throttles []*models.LoginThrottle
+	if err := json.Unmarshal(b, &throttles); err != nil {
+		log.Printf("Failed to decode login throttle storage file: %v", err)
+		return
+	}
+	for _, t := range throttles {
+		if t != nil && t.Key != "" && len(s.throttles) < maxLoginThrottles {
+			s.throttles[t.Key] = t
+		}
+	}
+	log.Printf("Loaded %d login throttles.", len(s.throttles))
+}
+
+func (s *FileLoginThrottleStore) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	throttles := make([]*models.LoginThrottle, 0, len(s.throttles))
+	for _, t := range s.throttles {
+		out := *t
+		throttles = append(throttles, &out)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(throttles, func(i, j int) bool { return throttles[i].Key < throttles[j].Key })
+	b, err := json.MarshalIndent(throttles, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write login throttle storage file: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *FileLoginThrottleStore) RecordLoginFailure(key string, policy models.LoginThrottlePolicy, now time.Time) (*models.LoginThrottle, bool, error) {
+	t, locked, err := s.MemoryLoginThrottleStore.RecordLoginFailure(key, policy, now)
+	if err != nil || !locked {
+		return t, locked, err
+	}
+	return t, locked, s.save()
+}
+
+// ResetLoginThrottle runs on every successful login: only a known key costs a write
+func (s *FileLoginThrottleStore) ResetLoginThrottle(key string) error {
+	if t, _ := s.GetLoginThrottle(key); t == nil {
+		return nil
+	}
+	if err := s.MemoryLoginThrottleStore.ResetLoginThrottle(key); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *FileLoginThrottleStore) PruneLoginThrottles(cutoff time.Time) error {
+	if err := s.MemoryLoginThrottleStore.PruneLoginThrottles(cutoff); err != nil {
+		return err
+	}
+	return s.save()
+}
@@ -0,0 +1,35 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func TestFileLoginThrottleStoreKeepsLockouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "login_throttles.json")
+	now := time.Now()
+	policy := models.LoginThrottlePolicy{MaxFailures: 2, Window: time.Minute, Lockout: time.Hour, MaxLockout: time.Hour}
+
+	s := NewFileLoginThrottleStore(path)
+	key := LoginThrottleKey(models.LoginThrottleAccount, "alice@example.com")
+	s.RecordLoginFailure(key, policy, now)
+	if _, locked, err := s.RecordLoginFailure(key, policy, now); err != nil || !locked {
+		t.Fatalf("RecordLoginFailure() locked = %v, %v", locked, err)
+	}
+
+	// A restart does not lift the lockout
+	lockouts, err := NewFileLoginThrottleStore(path).GetLoginLockouts(now)
+	if err != nil || len(lockouts) != 1 || lockouts[0].Key != key {
+		t.Fatalf("GetLoginLockouts() after reload = %v, %v", lockouts, err)
+	}
+
+	if err := s.ResetLoginThrottle(key); err != nil {
+		t.Fatal(err)
+	}
+	if lockouts, _ := NewFileLoginThrottleStore(path).GetLoginLockouts(now); len(lockouts) != 0 {
+		t.Errorf("reset not persisted: %v", lockouts)
+	}
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PersistenceOptions controls how MemoryStore writes machines.json
+type PersistenceOptions struct {
+	// FlushInterval batches changes: the file is written at most once per
+	// interval, and at Close. 0 writes on every change.
+	FlushInterval time.Duration
+	// Backups is the number of rotated snapshots kept next to the file
+	// (machines.json.1 is the newest), taken at most once per BackupInterval
+	Backups        int
+	BackupInterval time.Duration
+	// Sealer seals the credentials of files written before the keys were
+	// hashed, for the reveal; nil keeps none
+	Sealer *CredentialSealer
+}
+
+var DefaultPersistenceOptions = PersistenceOptions{
+	FlushInterval:  5 * time.Second,
+	Backups:        5,
+	BackupInterval: time.Hour,
+}
+
+// writeFileAtomic replaces path with data: temporary file in the same
+// directory, fsync, rename, then fsync of the directory. A crash leaves
+// either the old or the new content, never a truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// rotateBackups shifts path.1 .. path.(n-1) up by one and writes data as path.1
+func rotateBackups(path string, n int, data []byte) error {
+	if n <= 0 {
+		return nil
+	}
+	os.Remove(fmt.Sprintf("%s.%d", path, n))
+	for i := n - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return writeFileAtomic(path+".1", data)
+}
@@ -0,0 +1,217 @@
+package data
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// PresenceTracker derives the state of machines and gateways from their
+// LastSeen and records the transitions. Transition times are computed from
+// LastSeen, so they do not depend on how often Sweep runs.
+type PresenceTracker struct {
+	Store   Store
+	History PresenceStore
+	Policy  models.PresencePolicy
+
+	mu     sync.Mutex
+	last   map[string]map[string]models.PresenceTransition // kind -> resource -> latest, loaded on first sweep
+	loaded bool
+}
+
+func NewPresenceTracker(store Store, history PresenceStore, policy models.PresencePolicy) *PresenceTracker {
+	return &PresenceTracker{Store: store, History: history, Policy: policy}
+}
+
+// RunPresenceTracker sweeps every interval and applies the retention once an
+// hour. Blocking, run it in a goroutine.
+func RunPresenceTracker(t *PresenceTracker, every, retention time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	var lastRetention time.Time
+	for {
+		now := time.Now()
+		if err := t.Sweep(now); err != nil {
+			log.Printf("[PRESENCE] Sweep failed: %v", err)
+		}
+		if retention > 0 && now.Sub(lastRetention) >= time.Hour {
+			if err := t.History.ApplyRetention(now.Add(-retention)); err != nil {
+				log.Printf("[PRESENCE] Retention failed: %v", err)
+			}
+			lastRetention = now
+		}
+		<-ticker.C
+	}
+}
+
+// Sweep records the transitions since the previous sweep
+func (t *PresenceTracker) Sweep(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.loaded {
+		t.last = make(map[string]map[string]models.PresenceTransition)
+		for _, kind := range []string{models.PresenceMachine, models.PresenceGateway} {
+			last, err := t.History.LastTransitions(kind)
+			if err != nil {
+				return err
+			}
+			t.last[kind] = last
+		}
+		t.loaded = true
+	}
+
+	seen, err := t.lastSeen()
+	if err != nil {
+		return err
+	}
+	var transitions []models.PresenceTransition
+	for kind, byID := range seen {
+		for id, lastSeen := range byID {
+			transitions = append(transitions, t.advance(kind, id, lastSeen, now)...)
+		}
+	}
+	if err := t.History.RecordTransitions(transitions); err != nil {
+		return err
+	}
+	for _, tr := range transitions {
+		t.last[tr.Kind][tr.ResourceID] = tr
+	}
+	return nil
+}
+
+// lastSeen returns the LastSeen of every resource, by kind and ID
+func (t *PresenceTracker) lastSeen() (map[string]map[string]time.Time, error) {
+	machines, err := t.Store.GetMachines()
+	if err != nil {
+		return nil, err
+	}
+	gateways, err := t.Store.GetGateways()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]map[string]time.Time{
+		models.PresenceMachine: make(map[string]time.Time, len(machines)),
+		models.PresenceGateway: make(map[string]time.Time, len(gateways)),
+	}
+	for _, m := range machines {
+		seen[models.PresenceMachine][strconv.Itoa(m.ID)] = m.LastSeen
+	}
+	for _, gw := range gateways {
+		seen[models.PresenceGateway][gw.Key()] = gw.LastSeen
+	}
+	return seen, nil
+}
+
+// advance returns the transitions from the recorded state to the current
+// one. A resource that went offline between two sweeps also goes through
+// late, as it did. The caller holds the lock.
+func (t *PresenceTracker) advance(kind, id string, lastSeen, now time.Time) []models.PresenceTransition {
+	prev, known := t.last[kind][id]
+	state, at := t.Policy.State(kind, lastSeen, now)
+	if known && prev.To == state {
+		return nil
+	}
+
+	var out []models.PresenceTransition
+	from := prev.To
+	if from == models.PresenceOnline && state == models.PresenceOffline {
+		lateAt := lastSeen.Add(time.Duration(t.Policy.LateAfter) * t.Policy.Interval(kind))
+		if lateAt.After(prev.At) && lateAt.Before(at) {
+			out = append(out, models.PresenceTransition{Kind: kind, ResourceID: id, From: from, To: models.PresenceLate, At: lateAt})
+			from = models.PresenceLate
+		}
+	}
+	// Never seen, or LastSeen older than the recorded state (clock change)
+	if at.IsZero() || (known && at.Before(prev.At)) {
+		at = now
+	}
+	return append(out, models.PresenceTransition{Kind: kind, ResourceID: id, From: from, To: state, At: at})
+}
+
+// Counts returns the machines and gateways in each state at now
+func (t *PresenceTracker) Counts(now time.Time) (machines, gateways models.PresenceCounts, err error) {
+	seen, err := t.lastSeen()
+	if err != nil {
+		return machines, gateways, err
+	}
+	count := func(c *models.PresenceCounts, kind string) {
+		for _, lastSeen := range seen[kind] {
+			switch state, _ := t.Policy.State(kind, lastSeen, now); state {
+			case models.PresenceOnline:
+				c.Online++
+			case models.PresenceLate:
+				c.Late++
+			default:
+				c.Offline++
+			}
+		}
+	}
+	count(&machines, models.PresenceMachine)
+	count(&gateways, models.PresenceGateway)
+	return machines, gateways, nil
+}
+
+// PresenceOf computes the presence of a resource over [from, now] from its
+// transitions (oldest first, as returned by QueryTransitions). The current
+// state comes from lastSeen, so it does not wait for the next sweep.
+func (t *PresenceTracker) PresenceOf(kind string, lastSeen time.Time, transitions []models.PresenceTransition, from, now time.Time) *models.Presence {
+	state, at := t.Policy.State(kind, lastSeen, now)
+	p := &models.Presence{State: state, Window: now.Sub(from).String()}
+	if n := len(transitions); n > 0 && transitions[n-1].To == state {
+		since := transitions[n-1].At
+		p.Since = &since
+	} else if !at.IsZero() {
+		p.Since = &at
+	}
+
+	timeline := transitions
+	if n := len(timeline); n == 0 || timeline[n-1].To != state {
+		if !at.IsZero() && (n == 0 || at.After(timeline[n-1].At)) {
+			timeline = append(timeline[:n:n], models.PresenceTransition{To: state, At: at})
+		}
+	}
+
+	var observed, up time.Duration
+	for i, tr := range timeline {
+		if !tr.At.Before(from) {
+			p.Transitions++
+		}
+		start := tr.At
+		if start.Before(from) {
+			start = from
+		}
+		end := now
+		if i+1 < len(timeline) {
+			end = timeline[i+1].At
+		}
+		if end.After(start) {
+			observed += end.Sub(start)
+			if models.IsUp(tr.To) {
+				up += end.Sub(start)
+			}
+		}
+	}
+	if observed > 0 {
+		availability := float64(up) / float64(observed) * 100
+		p.Availability = &availability
+	}
+
+	// Current uptime: back to the last transition out of offline
+	if models.IsUp(state) {
+		for i := len(timeline) - 1; i >= 0; i-- {
+			if !models.IsUp(timeline[i].To) {
+				break
+			}
+			upSince := timeline[i].At
+			p.UpSince = &upSince
+		}
+		if p.UpSince != nil {
+			p.Uptime = now.Sub(*p.UpSince).Seconds()
+		}
+	}
+	return p
+}
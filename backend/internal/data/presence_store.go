@@ -0,0 +1,219 @@
+package data
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// PresenceStore keeps the state transitions of machines and gateways
+type PresenceStore interface {
+	EnsureTableExists() error
+	RecordTransitions(transitions []models.PresenceTransition) error
+	// LastTransitions returns the latest transition of every resource of kind
+	LastTransitions(kind string) (map[string]models.PresenceTransition, error)
+	// QueryTransitions returns the transitions in [from, to] of one resource
+	// ("" for all of kind), plus the last one before from that gives the state
+	// at the start of the window. Sorted by resource, then time.
+	QueryTransitions(kind, resourceID string, from, to time.Time) ([]models.PresenceTransition, error)
+	// ApplyRetention deletes the transitions before cutoff, except the latest
+	// of each resource: it is the current state
+	ApplyRetention(cutoff time.Time) error
+}
+
+// ---------------------------------------------------------------------
+// Postgres implementation
+// ---------------------------------------------------------------------
+
+type PostgresPresenceStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresPresenceStore(db *sqlx.DB) *PostgresPresenceStore {
+	return &PostgresPresenceStore{db: db}
+}
+
+func (s *PostgresPresenceStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS presence_transitions (
+		id BIGSERIAL PRIMARY KEY,
+		kind VARCHAR(16) NOT NULL,
+		resource_id VARCHAR(255) NOT NULL,
+		from_state VARCHAR(16) NOT NULL DEFAULT '',
+		to_state VARCHAR(16) NOT NULL,
+		at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_presence_transitions_resource_at ON presence_transitions(kind, resource_id, at);
+	CREATE INDEX IF NOT EXISTS idx_presence_transitions_at ON presence_transitions(at);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+func (s *PostgresPresenceStore) RecordTransitions(transitions []models.PresenceTransition) error {
+	if len(transitions) == 0 {
+		return nil
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range transitions {
+		if _, err := tx.NamedExec(`
+			INSERT INTO presence_transitions (kind, resource_id, from_state, to_state, at)
+			VALUES (:kind, :resource_id, :from_state, :to_state, :at)`, t); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresPresenceStore) LastTransitions(kind string) (map[string]models.PresenceTransition, error) {
+	var rows []models.PresenceTransition
+	err := s.db.Select(&rows, `
+		SELECT DISTINCT ON (resource_id) kind, resource_id, from_state, to_state, at
+		FROM presence_transitions WHERE kind = $1
+		ORDER BY resource_id, at DESC, id DESC`, kind)
+	if err != nil {
+		return nil, err
+	}
+	last := make(map[string]models.PresenceTransition, len(rows))
+	for _, t := range rows {
+		last[t.ResourceID] = t
+	}
+	return last, nil
+}
+
+func (s *PostgresPresenceStore) QueryTransitions(kind, resourceID string, from, to time.Time) ([]models.PresenceTransition, error) {
+	transitions := []models.PresenceTransition{}
+	err := s.db.Select(&transitions, `
+		SELECT kind, resource_id, from_state, to_state, at FROM (
+			SELECT DISTINCT ON (resource_id) id, kind, resource_id, from_state, to_state, at
+			FROM presence_transitions
+			WHERE kind = $1 AND ($2 = '' OR resource_id = $2) AND at < $3
+			ORDER BY resource_id, at DESC, id DESC
+		) AS initial
+		UNION ALL
+		SELECT kind, resource_id, from_state, to_state, at
+		FROM presence_transitions
+		WHERE kind = $1 AND ($2 = '' OR resource_id = $2) AND at >= $3 AND at <= $4
+		ORDER BY resource_id, at`,
+		kind, resourceID, from, to)
+	return transitions, err
+}
+
+func (s *PostgresPresenceStore) ApplyRetention(cutoff time.Time) error {
+	_, err := s.db.Exec(`
+		DELETE FROM presence_transitions t
+		WHERE t.at < $1 AND EXISTS (
+			SELECT 1 FROM presence_transitions n
+			WHERE n.kind = t.kind AND n.resource_id = t.resource_id AND n.at > t.at
+		)`, cutoff)
+	return err
+}
+
+// ---------------------------------------------------------------------
+// In-memory implementation (used when no database is configured)
+// ---------------------------------------------------------------------
+
+type MemoryPresenceStore struct {
+	mu      sync.RWMutex
+	history map[string]map[string][]models.PresenceTransition // kind -> resource -> transitions (oldest first)
+}
+
+func NewMemoryPresenceStore() *MemoryPresenceStore {
+	return &MemoryPresenceStore{history: make(map[string]map[string][]models.PresenceTransition)}
+}
+
+func (s *MemoryPresenceStore) EnsureTableExists() error {
+	return nil
+}
+
+func (s *MemoryPresenceStore) RecordTransitions(transitions []models.PresenceTransition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range transitions {
+		byID, ok := s.history[t.Kind]
+		if !ok {
+			byID = make(map[string][]models.PresenceTransition)
+			s.history[t.Kind] = byID
+		}
+		list := append(byID[t.ResourceID], t)
+		sort.SliceStable(list, func(i, j int) bool { return list[i].At.Before(list[j].At) })
+		byID[t.ResourceID] = list
+	}
+	return nil
+}
+
+func (s *MemoryPresenceStore) LastTransitions(kind string) (map[string]models.PresenceTransition, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	last := make(map[string]models.PresenceTransition)
+	for id, list := range s.history[kind] {
+		if len(list) > 0 {
+			last[id] = list[len(list)-1]
+		}
+	}
+	return last, nil
+}
+
+func (s *MemoryPresenceStore) QueryTransitions(kind, resourceID string, from, to time.Time) ([]models.PresenceTransition, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.history[kind]))
+	for id := range s.history[kind] {
+		if resourceID == "" || id == resourceID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	transitions := []models.PresenceTransition{}
+	for _, id := range ids {
+		var initial *models.PresenceTransition
+		for i, t := range s.history[kind][id] {
+			if t.At.Before(from) {
+				initial = &s.history[kind][id][i]
+				continue
+			}
+			if initial != nil {
+				transitions = append(transitions, *initial)
+				initial = nil
+			}
+			if t.At.After(to) {
+				break
+			}
+			transitions = append(transitions, t)
+		}
+		if initial != nil {
+			transitions = append(transitions, *initial)
+		}
+	}
+	return transitions, nil
+}
+
+func (s *MemoryPresenceStore) ApplyRetention(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, byID := range s.history {
+		for id, list := range byID {
+			i := sort.Search(len(list), func(i int) bool { return !list[i].At.Before(cutoff) })
+			if i == len(list) {
+				i = len(list) - 1 // Keep the current state
+			}
+			if i > 0 {
+				byID[id] = append([]models.PresenceTransition(nil), list[i:]...)
+			}
+		}
+	}
+	return nil
+}
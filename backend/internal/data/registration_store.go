@@ -0,0 +1,246 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/events"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// RegistrationStore is the review queue of unknown machines (quarantine policy)
+type RegistrationStore interface {
+	// GetRegistrationByKey returns nil, nil when the key was never queued
+	GetRegistrationByKey(key string) (*models.PendingRegistration, error)
+	AddRegistration(reg *models.PendingRegistration) error
+	TouchRegistration(id, ip string) error
+	GetRegistrations(status string) ([]*models.PendingRegistration, error) // "" for all
+	GetRegistration(id string) (*models.PendingRegistration, error)
+	ResolveRegistration(id, status, reviewer string, machineID int) error
+	DeleteRegistration(id string) error
+}
+
+var (
+	ErrRegistrationNotFound  = errors.New("registration not found")
+	ErrRegistrationQueueFull = errors.New("registration queue is full")
+)
+
+// touchPersistInterval limits disk writes when a queued machine keeps polling
+const touchPersistInterval = time.Minute
+
+// registrationRecord is the file format: the key and the sealed credential
+// are not part of the API view
+type registrationRecord struct {
+	*models.PendingRegistration
+	HashedPkey       string `json:"hashed_pkey"`
+	SealedCredential string `json:"sealed_credential,omitempty"`
+}
+
+// FileRegistrationStore keeps the queue in memory and persists it to a JSON file
+type FileRegistrationStore struct {
+	mu       sync.Mutex
+	byID     map[string]*models.PendingRegistration
+	byKey    map[string]*models.PendingRegistration
+	maxOpen  int // Pending entries allowed at once
+	filePath string
+
+	Events *events.Bus // Publishes new entries, nil to disable
+}
+
+// NewFileRegistrationStore loads the queue; sealer seals the credentials of
+// files written before the keys were hashed (nil keeps none)
+func NewFileRegistrationStore(storagePath string, maxPending int, sealer *CredentialSealer) *FileRegistrationStore {
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		log.Printf("Failed to create registration storage dir: %v", err)
+	}
+	s := &FileRegistrationStore{
+		byID:     make(map[string]*models.PendingRegistration),
+		byKey:    make(map[string]*models.PendingRegistration),
+		maxOpen:  maxPending,
+		filePath: storagePath,
+	}
+	s.load(sealer)
+	return s
+}
+
+func (s *FileRegistrationStore) load(sealer *CredentialSealer) {
+	file, err := os.Open(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open registration storage file: %v", err)
+		}
+		return
+	}
+	defer file.Close()
+
+	var records []registrationRecord
+	if err := json.NewDecoder(file).Decode(&records); err != nil {
+		log.Printf("Failed to decode registration storage file: %v", err)
+		return
+	}
+	rekeyed := 0
+	for _, rec := range records {
+		reg := rec.PendingRegistration
+		if reg == nil || rec.HashedPkey == "" {
+			continue
+		}
+		reg.HashedPkey, reg.SealedCredential = rec.HashedPkey, rec.SealedCredential
+		// Files written before the keys were hashed hold the credentials in clear
+		if !isMachineKey(reg.HashedPkey) {
+			reg.SealedCredential = sealer.Seal(reg.HashedPkey)
+			reg.HashedPkey = MachineKey(reg.HashedPkey)
+			reg.KeyPrefix = reg.HashedPkey[:8]
+			rekeyed++
+		}
+		s.byID[reg.ID] = reg
+		s.byKey[reg.HashedPkey] = reg
+	}
+	log.Printf("Loaded %d machine registrations.", len(s.byID))
+	if rekeyed > 0 {
+		log.Printf("[REGISTRATION] Replaced the credentials of %d registrations by their hash", rekeyed)
+		s.save()
+	}
+}
+
+func (s *FileRegistrationStore) save() {
+	records := make([]registrationRecord, 0, len(s.byID))
+	for _, reg := range s.sorted("") {
+		records = append(records, registrationRecord{PendingRegistration: reg, HashedPkey: reg.HashedPkey, SealedCredential: reg.SealedCredential})
+	}
+
+	b, err := json.Marshal(records)
+	if err != nil {
+		log.Printf("Failed to encode registration storage file: %v", err)
+		return
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write registration storage file: %v", err)
+	}
+}
+
+// sorted returns the entries oldest first; the caller holds the lock
+func (s *FileRegistrationStore) sorted(status string) []*models.PendingRegistration {
+	list := make([]*models.PendingRegistration, 0, len(s.byID))
+	for _, reg := range s.byID {
+		if status == "" || reg.Status == status {
+			list = append(list, reg)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].FirstSeen.Before(list[j].FirstSeen) })
+	return list
+}
+
+func (s *FileRegistrationStore) GetRegistrationByKey(key string) (*models.PendingRegistration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.byKey[key]
+	if !ok {
+		return nil, nil
+	}
+	c := *reg
+	return &c, nil
+}
+
+func (s *FileRegistrationStore) AddRegistration(reg *models.PendingRegistration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.byKey[reg.HashedPkey]; ok {
+		*reg = *existing
+		return nil
+	}
+	if s.maxOpen > 0 && len(s.sorted(models.RegistrationPending)) >= s.maxOpen {
+		return ErrRegistrationQueueFull
+	}
+	if reg.ID == "" {
+		reg.ID = newUUID()
+	}
+	c := *reg
+	s.byID[c.ID] = &c
+	s.byKey[c.HashedPkey] = &c
+	s.save()
+	queued := c
+	s.Events.Publish(events.Event{Type: events.MachineRegistration, Data: &queued})
+	return nil
+}
+
+func (s *FileRegistrationStore) TouchRegistration(id, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.byID[id]
+	if !ok {
+		return ErrRegistrationNotFound
+	}
+	now := time.Now()
+	persist := now.Sub(reg.LastSeen) > touchPersistInterval || reg.LastIP != ip
+	reg.Attempts++
+	reg.LastIP = ip
+	reg.LastSeen = now
+	if persist {
+		s.save()
+	}
+	return nil
+}
+
+func (s *FileRegistrationStore) GetRegistrations(status string) ([]*models.PendingRegistration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := s.sorted(status)
+	for i, reg := range list {
+		c := *reg
+		list[i] = &c
+	}
+	return list, nil
+}
+
+func (s *FileRegistrationStore) GetRegistration(id string) (*models.PendingRegistration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.byID[id]
+	if !ok {
+		return nil, ErrRegistrationNotFound
+	}
+	c := *reg
+	return &c, nil
+}
+
+func (s *FileRegistrationStore) ResolveRegistration(id, status, reviewer string, machineID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.byID[id]
+	if !ok {
+		return ErrRegistrationNotFound
+	}
+	now := time.Now()
+	reg.Status = status
+	reg.MachineID = machineID
+	reg.ReviewedBy = reviewer
+	reg.ReviewedAt = &now
+	s.save()
+	return nil
+}
+
+func (s *FileRegistrationStore) DeleteRegistration(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reg, ok := s.byID[id]
+	if !ok {
+		return ErrRegistrationNotFound
+	}
+	delete(s.byID, id)
+	delete(s.byKey, reg.HashedPkey)
+	s.save()
+	return nil
+}
@@ -0,0 +1,419 @@
+package data
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrRefreshTokenInvalid: unknown token, session closed or expired, or a
+	// token rotated less than the grace period ago (concurrent refreshes)
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused: a rotated token was presented again, the
+	// session was revoked
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// refreshTokenPrefix marks refresh tokens, e.g. in logs
+const refreshTokenPrefix = "rt_"
+
+// NewRefreshToken returns a random refresh token and its hash
+func NewRefreshToken() (token, hash string) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	token = refreshTokenPrefix + hex.EncodeToString(b[:])
+	return token, HashRefreshToken(token)
+}
+
+// HashRefreshToken is the stored form of a refresh token, see HashGatewayToken
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSessionID returns a random session ID
+func NewSessionID() string {
+	return newUUID()
+}
+
+// SessionStore keeps the login sessions and the hashes of their refresh
+// tokens. Rotated tokens are kept until the session is pruned, to detect
+// their reuse.
+type SessionStore interface {
+	EnsureTableExists() error
+	CreateSession(s *models.Session, refreshHash string) error
+	GetSession(id string) (*models.Session, error)
+	// SessionByRefreshToken returns the session of a current or rotated token
+	SessionByRefreshToken(hash string) (*models.Session, error)
+	// RotateRefreshToken replaces the token hash by next and extends the
+	// session to expiresAt. A token rotated more than grace ago revokes the
+	// session: ErrRefreshTokenReused, returned with the session.
+	RotateRefreshToken(hash, next string, now, expiresAt time.Time, grace time.Duration) (*models.Session, error)
+	RevokeSession(id, reason string, now time.Time) error
+	// SetSessionTwoFactor flags a session whose user just proved a TOTP code
+	SetSessionTwoFactor(id string) error
+	// RevokeUserSessions revokes the open sessions of a user and returns their number
+	RevokeUserSessions(userID int, reason string, now time.Time) (int, error)
+	// GetUserSessions returns the active sessions of a user, latest first
+	GetUserSessions(userID int, now time.Time) ([]*models.Session, error)
+	// PruneSessions deletes the sessions expired or revoked before cutoff
+	PruneSessions(cutoff time.Time) error
+}
+
+// RunSessionPruner deletes old sessions every interval. Blocking, run it in a goroutine.
+func RunSessionPruner(store SessionStore, retention, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := store.PruneSessions(time.Now().Add(-retention)); err != nil {
+			log.Printf("[SESSIONS] Prune failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// ---------------------------------------------------------------------
+// Postgres implementation
+// ---------------------------------------------------------------------
+
+type PostgresSessionStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresSessionStore(db *sqlx.DB) *PostgresSessionStore {
+	return &PostgresSessionStore{db: db}
+}
+
+func (s *PostgresSessionStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS user_sessions (
+		id VARCHAR(64) PRIMARY KEY,
+		user_id INT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		last_used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		revoked_at TIMESTAMP WITH TIME ZONE NULL,
+		revoked_reason VARCHAR(32) NOT NULL DEFAULT '',
+		ip VARCHAR(64) NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id);
+	ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS two_factor BOOLEAN NOT NULL DEFAULT FALSE;
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		hash VARCHAR(64) PRIMARY KEY,
+		session_id VARCHAR(64) NOT NULL REFERENCES user_sessions(id) ON DELETE CASCADE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		used_at TIMESTAMP WITH TIME ZONE NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens(session_id);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+const sessionColumns = `id, user_id, created_at, last_used_at, expires_at, revoked_at, revoked_reason, ip, user_agent, two_factor`
+
+func (s *PostgresSessionStore) CreateSession(session *models.Session, refreshHash string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.NamedExec(`
+		INSERT INTO user_sessions (id, user_id, created_at, last_used_at, expires_at, ip, user_agent, two_factor)
+		VALUES (:id, :user_id, :created_at, :last_used_at, :expires_at, :ip, :user_agent, :two_factor)`, session); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_tokens (hash, session_id, created_at) VALUES ($1, $2, $3)`,
+		refreshHash, session.ID, session.CreatedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresSessionStore) GetSession(id string) (*models.Session, error) {
+	var session models.Session
+	err := s.db.Get(&session, `SELECT `+sessionColumns+` FROM user_sessions WHERE id = $1`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *PostgresSessionStore) SessionByRefreshToken(hash string) (*models.Session, error) {
+	var session models.Session
+	err := s.db.Get(&session, `
+		SELECT `+sessionColumns+` FROM user_sessions
+		WHERE id = (SELECT session_id FROM refresh_tokens WHERE hash = $1)`, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *PostgresSessionStore) RotateRefreshToken(hash, next string, now, expiresAt time.Time, grace time.Duration) (*models.Session, error) {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Rows are locked: two refreshes with the same token are serialized
+	var token struct {
+		SessionID string     `db:"session_id"`
+		UsedAt    *time.Time `db:"used_at"`
+	}
+	err = tx.Get(&token, `SELECT session_id, used_at FROM refresh_tokens WHERE hash = $1 FOR UPDATE`, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	var session models.Session
+	if err := tx.Get(&session, `SELECT `+sessionColumns+` FROM user_sessions WHERE id = $1 FOR UPDATE`, token.SessionID); err != nil {
+		return nil, err
+	}
+	if !session.Active(now) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if token.UsedAt != nil {
+		if now.Sub(*token.UsedAt) < grace {
+			return nil, ErrRefreshTokenInvalid
+		}
+		if _, err := tx.Exec(`UPDATE user_sessions SET revoked_at = $1, revoked_reason = $2 WHERE id = $3`,
+			now, models.SessionTokenReuse, session.ID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		session.RevokedAt, session.RevokedReason = &now, models.SessionTokenReuse
+		return &session, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = $1 WHERE hash = $2`, now, hash); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`INSERT INTO refresh_tokens (hash, session_id, created_at) VALUES ($1, $2, $3)`, next, session.ID, now); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE user_sessions SET last_used_at = $1, expires_at = $2 WHERE id = $3`, now, expiresAt, session.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	session.LastUsedAt, session.ExpiresAt = now, expiresAt
+	return &session, nil
+}
+
+func (s *PostgresSessionStore) RevokeSession(id, reason string, now time.Time) error {
+	_, err := s.db.Exec(`UPDATE user_sessions SET revoked_at = $1, revoked_reason = $2 WHERE id = $3 AND revoked_at IS NULL`, now, reason, id)
+	return err
+}
+
+func (s *PostgresSessionStore) SetSessionTwoFactor(id string) error {
+	_, err := s.db.Exec(`UPDATE user_sessions SET two_factor = TRUE WHERE id = $1`, id)
+	return err
+}
+
+func (s *PostgresSessionStore) RevokeUserSessions(userID int, reason string, now time.Time) (int, error) {
+	res, err := s.db.Exec(`
+		UPDATE user_sessions SET revoked_at = $1, revoked_reason = $2
+		WHERE user_id = $3 AND revoked_at IS NULL AND expires_at > $1`, now, reason, userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *PostgresSessionStore) GetUserSessions(userID int, now time.Time) ([]*models.Session, error) {
+	sessions := []*models.Session{}
+	err := s.db.Select(&sessions, `
+		SELECT `+sessionColumns+` FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC`, userID, now)
+	return sessions, err
+}
+
+func (s *PostgresSessionStore) PruneSessions(cutoff time.Time) error {
+	_, err := s.db.Exec(`DELETE FROM user_sessions WHERE expires_at < $1 OR revoked_at < $1`, cutoff)
+	return err
+}
+
+// ---------------------------------------------------------------------
+// In-memory implementation (used when no database is configured)
+// ---------------------------------------------------------------------
+
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*models.Session
+	tokens   map[string]*memoryRefreshToken // hash -> token
+}
+
+type memoryRefreshToken struct {
+	sessionID string
+	usedAt    *time.Time
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*models.Session),
+		tokens:   make(map[string]*memoryRefreshToken),
+	}
+}
+
+func (s *MemorySessionStore) EnsureTableExists() error {
+	return nil
+}
+
+func (s *MemorySessionStore) CreateSession(session *models.Session, refreshHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *session
+	s.sessions[session.ID] = &stored
+	s.tokens[refreshHash] = &memoryRefreshToken{sessionID: session.ID}
+	return nil
+}
+
+func (s *MemorySessionStore) GetSession(id string) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	out := *session
+	return &out, nil
+}
+
+func (s *MemorySessionStore) SessionByRefreshToken(hash string) (*models.Session, error) {
+	s.mu.Lock()
+	token, ok := s.tokens[hash]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return s.GetSession(token.sessionID)
+}
+
+func (s *MemorySessionStore) RotateRefreshToken(hash, next string, now, expiresAt time.Time, grace time.Duration) (*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, ErrRefreshTokenInvalid
+	}
+	session, ok := s.sessions[token.sessionID]
+	if !ok || !session.Active(now) {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if token.usedAt != nil {
+		if now.Sub(*token.usedAt) < grace {
+			return nil, ErrRefreshTokenInvalid
+		}
+		session.RevokedAt, session.RevokedReason = &now, models.SessionTokenReuse
+		out := *session
+		return &out, ErrRefreshTokenReused
+	}
+
+	token.usedAt = &now
+	s.tokens[next] = &memoryRefreshToken{sessionID: session.ID}
+	session.LastUsedAt, session.ExpiresAt = now, expiresAt
+	out := *session
+	return &out, nil
+}
+
+func (s *MemorySessionStore) RevokeSession(id, reason string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok && session.RevokedAt == nil {
+		session.RevokedAt, session.RevokedReason = &now, reason
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) SetSessionTwoFactor(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[id]; ok {
+		session.TwoFactor = true
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) RevokeUserSessions(userID int, reason string, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.Active(now) {
+			session.RevokedAt, session.RevokedReason = &now, reason
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemorySessionStore) GetUserSessions(userID int, now time.Time) ([]*models.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := []*models.Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.Active(now) {
+			out := *session
+			sessions = append(sessions, &out)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return sessions, nil
+}
+
+func (s *MemorySessionStore) PruneSessions(cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(cutoff) || (session.RevokedAt != nil && session.RevokedAt.Before(cutoff)) {
+			delete(s.sessions, id)
+		}
+	}
+	for hash, token := range s.tokens {
+		if _, ok := s.sessions[token.sessionID]; !ok {
+			delete(s.tokens, hash)
+		}
+	}
+	return nil
+}
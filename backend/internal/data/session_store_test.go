@@ -0,0 +1,114 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+const sessionGrace = 30 * time.Second
+
+// newTestSession opens a session of user 1 at t0 and returns its first refresh token hash
+func newTestSession(t *testing.T, s SessionStore, t0 time.Time) (*models.Session, string) {
+	t.Helper()
+	_, hash := NewRefreshToken()
+	session := &models.Session{ID: NewSessionID(), UserID: 1, CreatedAt: t0, LastUsedAt: t0, ExpiresAt: t0.Add(time.Hour)}
+	if err := s.CreateSession(session, hash); err != nil {
+		t.Fatal(err)
+	}
+	return session, hash
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// present returns the hash to present, given the first token and its replacement
+		present    func(first, second string) string
+		at         time.Duration
+		revoke     bool // Session revoked before the refresh
+		wantErr    error
+		wantActive bool
+	}{
+		{"current token", func(_, second string) string { return second }, time.Minute, false, nil, true},
+		{"rotated token within grace (concurrent refresh)", func(first, _ string) string { return first }, 10 * time.Second, false, ErrRefreshTokenInvalid, true},
+		{"rotated token after grace (stolen)", func(first, _ string) string { return first }, time.Minute, false, ErrRefreshTokenReused, false},
+		{"unknown token", func(_, _ string) string { return HashRefreshToken("rt_unknown") }, time.Minute, false, ErrRefreshTokenInvalid, true},
+		{"expired session", func(_, second string) string { return second }, 2 * time.Hour, false, ErrRefreshTokenInvalid, false},
+		{"revoked session", func(_, second string) string { return second }, time.Minute, true, ErrRefreshTokenInvalid, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemorySessionStore()
+			session, first := newTestSession(t, s, t0)
+			_, second := NewRefreshToken()
+			if _, err := s.RotateRefreshToken(first, second, t0, t0.Add(time.Hour), sessionGrace); err != nil {
+				t.Fatalf("first rotation: %v", err)
+			}
+			if tt.revoke {
+				s.RevokeSession(session.ID, models.SessionLogout, t0)
+			}
+
+			now := t0.Add(tt.at)
+			_, next := NewRefreshToken()
+			_, err := s.RotateRefreshToken(tt.present(first, second), next, now, now.Add(time.Hour), sessionGrace)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RotateRefreshToken() error = %v, want %v", err, tt.wantErr)
+			}
+			got, _ := s.GetSession(session.ID)
+			if got.Active(now) != tt.wantActive {
+				t.Errorf("session active = %v, want %v", got.Active(now), tt.wantActive)
+			}
+			if tt.wantErr == ErrRefreshTokenReused && got.RevokedReason != models.SessionTokenReuse {
+				t.Errorf("RevokedReason = %q, want %q", got.RevokedReason, models.SessionTokenReuse)
+			}
+		})
+	}
+}
+
+func TestRotateRefreshTokenExtendsSession(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewMemorySessionStore()
+	session, first := newTestSession(t, s, t0)
+
+	// Each token is used once, the chain goes on
+	hash := first
+	for i := 1; i <= 3; i++ {
+		now := t0.Add(time.Duration(i) * 50 * time.Minute)
+		_, next := NewRefreshToken()
+		got, err := s.RotateRefreshToken(hash, next, now, now.Add(time.Hour), sessionGrace)
+		if err != nil {
+			t.Fatalf("rotation %d: %v", i, err)
+		}
+		if !got.ExpiresAt.Equal(now.Add(time.Hour)) || !got.LastUsedAt.Equal(now) {
+			t.Errorf("rotation %d: session not extended: %+v", i, got)
+		}
+		hash = next
+	}
+	if got, _ := s.SessionByRefreshToken(first); got == nil || got.ID != session.ID {
+		t.Error("rotated token no longer leads to its session")
+	}
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewMemorySessionStore()
+	a, _ := newTestSession(t, s, t0)
+	newTestSession(t, s, t0)
+	s.RevokeSession(a.ID, models.SessionLogout, t0)
+	other := &models.Session{ID: NewSessionID(), UserID: 2, ExpiresAt: t0.Add(time.Hour)}
+	s.CreateSession(other, HashRefreshToken("rt_other"))
+
+	n, err := s.RevokeUserSessions(1, models.SessionLogoutAll, t0.Add(time.Minute))
+	if err != nil || n != 1 {
+		t.Fatalf("RevokeUserSessions() = %d, %v, want 1 (already revoked not counted)", n, err)
+	}
+	if got, _ := s.GetSession(a.ID); got.RevokedReason != models.SessionLogout {
+		t.Errorf("first revocation overwritten: %q", got.RevokedReason)
+	}
+	if sessions, _ := s.GetUserSessions(2, t0.Add(time.Minute)); len(sessions) != 1 {
+		t.Errorf("sessions of another user revoked")
+	}
+}
@@ -2,33 +2,55 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/essensys-hub/essensys-support-site/backend/internal/events"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/geo"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
-	"github.com/jmoiron/sqlx"
 )
 
 // Store defines the data access interface
 type Store interface {
-    // Auth
-    GetMachineByHashedPkey(hashedPkey string) (*models.Machine, error)
+    // Auth. Machines are keyed by the MachineKey of their credential.
+    GetMachineByHashedPkey(key string) (*models.Machine, error)
+    GetMachineByID(id int) (*models.Machine, error)
     
     // Data Capture
     SaveClientData(clientID string, data []models.ExchangeKeyValue) error
+    GetClientData(clientID string) ([]models.ExchangeKeyValue, error) // Last snapshot, nil if none
     
     // Admin
-    GetStats() (*models.AdminStatsResponse, error)
+    GetStats() (*models.AdminStatsResponse, error) // Totals only; the connected counts come from the presence tracker
     GetMachines() ([]*models.MachineDetail, error)
     GetGateways() ([]*models.GatewayStatus, error) // Added
-    UpdateMachineStatus(hashedPkey, ip, rawAuth, rawDecoded string)
-    RegisterUnknownMachine(hashedPkey string) (*models.Machine, error)
+    // QueryMachines and QueryGateways filter, sort and page the lists, see
+    // models.FleetQuery; an unknown sort or a bad cursor is ErrInvalidFleetQuery
+    QueryMachines(q models.FleetQuery) (*models.MachinePage, error)
+    QueryGateways(q models.FleetQuery) (*models.GatewayPage, error)
+    // UpdateMachineStatus records a connection: IP, outcome and credential
+    // fingerprint. userLen is the length of the Basic Auth username.
+    UpdateMachineStatus(key, ip, outcome string, userLen int)
+    // RegisterUnknownMachine creates an inactive machine; sealed is its
+    // credential for the reveal, see CredentialSealer ("" to keep none)
+    RegisterUnknownMachine(key, sealed string) (*models.Machine, error)
     SaveGateway(gw *models.GatewayStatus) error // Added
+    SetMachineVersion(machineID int, version string) error
+    SetMachineTags(machineID int, tags []string) error
+
+    // Machine lifecycle (admin)
+    SetMachineActive(machineID int, active bool) error
+    SetMachineAlarm(machineID int, allowed bool) error
+    RenameMachine(machineID int, noSerie string) error
+    DeleteMachine(machineID int) error
     
     // Newsletter
     AddSubscriber(email string) error
@@ -42,6 +64,11 @@ type Store interface {
     DeleteNewsletter(id string) error
 }
 
+var (
+    ErrMachineNotFound = errors.New("machine not found")
+    ErrSerialTaken     = errors.New("serial number already in use")
+)
+
 // PersistenceData wraps the data we want to save
 type PersistenceData struct {
     Machines    map[string]*models.Machine       `json:"machines"`
@@ -49,21 +76,39 @@ type PersistenceData struct {
     Gateways    map[string]*models.GatewayStatus `json:"gateways"` // Added
     Subscribers []models.Subscriber              `json:"subscribers"`
     Newsletters []models.Newsletter              `json:"newsletters"`
+    NextID      int                              `json:"next_machine_id,omitempty"` // IDs are never reused
+    Credentials map[string]string                `json:"credentials,omitempty"`     // Machine key -> sealed credential
 }
 
 // MemoryStore implementation with File Persistence
 type MemoryStore struct {
     mu          sync.RWMutex
-    machines    map[string]*models.Machine // hashedPkey -> Machine
+    machines    map[string]*models.Machine // MachineKey -> Machine
     data        map[string][]models.ExchangeKeyValue // clientID -> last data
-    details     map[string]*models.MachineDetail // hashedPkey -> Connection Details
+    details     map[string]*models.MachineDetail // MachineKey -> Connection Details
     gateways    map[string]*models.GatewayStatus // hostname -> GatewayStatus (Added)
     subscribers []models.Subscriber
     newsletters map[string]models.Newsletter // id -> Newsletter
+    nextID      int
     filePath    string
+
+    Geo    *geo.Resolver // Locates new IPs, nil to disable
+    Events *events.Bus   // Publishes heartbeats, registrations, geo updates and gateway reports, nil to disable
+
+    // Write-behind persistence: changes bump version, flushes write the file
+    opts       PersistenceOptions
+    version    uint64        // Guarded by mu
+    flushed    atomic.Uint64 // Version of the last written snapshot
+    flushMu    sync.Mutex    // Serializes file writes, taken after mu
+    lastBackup time.Time
+    stop       chan struct{}
+    done       chan struct{}
+    closeOnce  sync.Once
 }
 
-func NewMemoryStore(storagePath string) *MemoryStore {
+// NewMemoryStore loads storagePath and, with a FlushInterval, starts the
+// background flusher: call Close at shutdown to write the last changes.
+func NewMemoryStore(storagePath string, opts PersistenceOptions) *MemoryStore {
     // Ensure directory exists
     dir := filepath.Dir(storagePath)
     if err := os.MkdirAll(dir, 0755); err != nil {
@@ -77,9 +122,17 @@ func NewMemoryStore(storagePath string) *MemoryStore {
         gateways:    make(map[string]*models.GatewayStatus), // Init
         newsletters: make(map[string]models.Newsletter),
         filePath:    storagePath,
+        opts:        opts,
+        stop:        make(chan struct{}),
+        done:        make(chan struct{}),
     }
     
     store.load()
+    if opts.FlushInterval > 0 {
+        go store.run()
+    } else {
+        close(store.done)
+    }
     return store
 }
 
@@ -101,6 +154,8 @@ func (s *MemoryStore) load() {
         return
     }
 
+    // Files written before the keys were hashed hold the credentials in clear
+    rekeyed := pd.restoreKeys(s.opts.Sealer)
     s.machines = pd.Machines
     s.details = pd.Details
     // Load Gateways with check
@@ -110,17 +165,67 @@ func (s *MemoryStore) load() {
         s.gateways = make(map[string]*models.GatewayStatus)
     }
     s.subscribers = pd.Subscribers
+
+    // Files written before the lifecycle API have no counter, and details
+    // without the status flags
+    s.nextID = pd.NextID
+    for key, m := range s.machines {
+        if m.ID >= s.nextID {
+            s.nextID = m.ID + 1
+        }
+        if detail, ok := s.details[key]; ok {
+            detail.IsActive = m.IsActive
+            detail.AutoriseAlarme = m.AutoriseAlarme
+        }
+    }
+    
+    // Locations stored before the country was kept apart
+    for _, detail := range s.details {
+        if detail.Country == "" && detail.GeoLocation != "" {
+            detail.Country = geo.CountryFromLabel(detail.GeoLocation)
+        }
+    }
+    for _, gw := range s.gateways {
+        if gw.Country == "" && gw.GeoLocation != "" {
+            gw.Country = geo.CountryFromLabel(gw.GeoLocation)
+        }
+    }
     
     // Load Newsletters list into map
     s.newsletters = make(map[string]models.Newsletter)
     for _, n := range pd.Newsletters {
         s.newsletters[n.ID] = n
     }
+
+    // One-time migration: files written before credential hygiene hold
+    // user:pass in clear. Last, so that the rewrite holds the whole state.
+    scrubbed := scrubCredentials(s.details)
+    if scrubbed > 0 {
+        log.Printf("[STORE] Scrubbed plaintext credentials of %d machines", scrubbed)
+    }
+    if rekeyed > 0 {
+        log.Printf("[STORE] Replaced the credentials of %d machines by their hash", rekeyed)
+    }
+    if scrubbed > 0 || rekeyed > 0 {
+        s.markDirty()
+    }
     
     log.Printf("Loaded %d machines, %d gateways, %d subscribers, %d newsletters.", len(s.machines), len(s.gateways), len(s.subscribers), len(s.newsletters))
 }
 
-func (s *MemoryStore) save() {
+// markDirty records a change; the caller holds the write lock. Without a
+// FlushInterval the file is written at once.
+func (s *MemoryStore) markDirty() {
+    s.version++
+    if s.opts.FlushInterval <= 0 {
+        if err := s.writeSnapshot(s.version, s.snapshot()); err != nil {
+            log.Printf("Failed to write storage file: %v", err)
+        }
+    }
+}
+
+// snapshot encodes the persisted state; the caller holds the lock
+func (s *MemoryStore) snapshot() []byte {
     // Convert newsletters map to slice
     nlList := make([]models.Newsletter, 0, len(s.newsletters))
     for _, n := range s.newsletters {
@@ -133,18 +238,78 @@ func (s *MemoryStore) save() {
         Gateways:    s.gateways, // Save Gateways
         Subscribers: s.subscribers,
         Newsletters: nlList,
+        NextID:      s.nextID,
+        Credentials: sealedCredentials(s.machines),
     }
 
-    file, err := os.Create(s.filePath)
+    b, err := json.Marshal(pd)
     if err != nil {
-        log.Printf("Failed to create storage file: %v", err)
-        return
+        // Plain structs and maps: cannot happen
+        log.Printf("Failed to encode storage file: %v", err)
     }
-    defer file.Close()
+    return b
+}
 
-    if err := json.NewEncoder(file).Encode(pd); err != nil {
-        log.Printf("Failed to encode storage file: %v", err)
+// writeSnapshot writes the snapshot of version gen, unless a newer one was
+// written meanwhile, and rotates the backups when one is due
+func (s *MemoryStore) writeSnapshot(gen uint64, b []byte) error {
+    s.flushMu.Lock()
+    defer s.flushMu.Unlock()
+
+    if b == nil || gen <= s.flushed.Load() {
+        return nil
+    }
+    if err := writeFileAtomic(s.filePath, b); err != nil {
+        return err
+    }
+    s.flushed.Store(gen)
+
+    if s.opts.Backups > 0 && time.Since(s.lastBackup) >= s.opts.BackupInterval {
+        if err := rotateBackups(s.filePath, s.opts.Backups, b); err != nil {
+            log.Printf("Failed to rotate storage backups: %v", err)
+        } else {
+            s.lastBackup = time.Now()
+        }
     }
+    return nil
+}
+
+// Flush writes pending changes. The state is encoded under the read lock;
+// the file is written without holding it.
+func (s *MemoryStore) Flush() error {
+    s.mu.RLock()
+    gen := s.version
+    if gen <= s.flushed.Load() {
+        s.mu.RUnlock()
+        return nil
+    }
+    b := s.snapshot()
+    s.mu.RUnlock()
+    return s.writeSnapshot(gen, b)
+}
+
+func (s *MemoryStore) run() {
+    defer close(s.done)
+    ticker := time.NewTicker(s.opts.FlushInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            if err := s.Flush(); err != nil {
+                log.Printf("Failed to write storage file: %v", err)
+            }
+        case <-s.stop:
+            return
+        }
+    }
+}
+
+// Close stops the background flusher and writes the last changes
+func (s *MemoryStore) Close() error {
+    s.closeOnce.Do(func() { close(s.stop) })
+    <-s.done
+    return s.Flush()
 }
 
 // Pre-load some test data
@@ -155,10 +320,15 @@ func (s *MemoryStore) AddTestMachine(m *models.Machine) {
     if _, ok := s.machines[m.HashedPkey]; !ok {
         s.machines[m.HashedPkey] = m
         s.details[m.HashedPkey] = &models.MachineDetail{
-            ID:      m.ID,
-            NoSerie: m.NoSerie,
+            ID:             m.ID,
+            NoSerie:        m.NoSerie,
+            IsActive:       m.IsActive,
+            AutoriseAlarme: m.AutoriseAlarme,
+        }
+        if m.ID >= s.nextID {
+            s.nextID = m.ID + 1
         }
-        s.save()
+        s.markDirty()
     }
 }
 
@@ -167,102 +337,280 @@ func (s *MemoryStore) GetMachineByHashedPkey(hashedPkey string) (*models.Machine
     defer s.mu.RUnlock()
     
     if m, ok := s.machines[hashedPkey]; ok {
-        return m, nil
+        return copyMachine(m), nil
     }
     return nil, fmt.Errorf("machine not found")
 }
 
-func (s *MemoryStore) RegisterUnknownMachine(hashedPkey string) (*models.Machine, error) {
+// copyMachine detaches m from the store: the setters write the stored
+// machines under the lock, callers read theirs without it
+func copyMachine(m *models.Machine) *models.Machine {
+    out := *m
+    out.Tags = slices.Clone(m.Tags)
+    return &out
+}
+
+func (s *MemoryStore) GetMachineByID(id int) (*models.Machine, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    for _, m := range s.machines {
+        if m.ID == id {
+            return copyMachine(m), nil
+        }
+    }
+    return nil, ErrMachineNotFound
+}
+
+func (s *MemoryStore) RegisterUnknownMachine(key, sealed string) (*models.Machine, error) {
     s.mu.Lock()
     defer s.mu.Unlock()
 
-    if m, ok := s.machines[hashedPkey]; ok {
-        return m, nil
+    if m, ok := s.machines[key]; ok {
+        return copyMachine(m), nil
+    }
+    if !isMachineKey(key) {
+        return nil, fmt.Errorf("malformed key")
     }
 
-    newID := len(s.machines) + 1
-    noSerie := fmt.Sprintf("UNKNOWN-%s", hashedPkey[:8])
+    newID := max(s.nextID, 1)
+    s.nextID = newID + 1
+    noSerie := fmt.Sprintf("UNKNOWN-%s", key[:8])
 
     m := &models.Machine{
-        ID:         newID,
-        NoSerie:    noSerie,
-        IsActive:   false,
-        HashedPkey: hashedPkey,
+        ID:               newID,
+        NoSerie:          noSerie,
+        IsActive:         false,
+        HashedPkey:       key,
+        SealedCredential: sealed,
     }
 
-    s.machines[hashedPkey] = m
-    s.details[hashedPkey] = &models.MachineDetail{
+    s.machines[key] = m
+    s.details[key] = &models.MachineDetail{
         ID:      m.ID,
         NoSerie: m.NoSerie,
         IP:      "-",
         LastSeen: time.Now(),
     }
     
-    s.save()
+    s.markDirty()
     log.Printf("[STORE] Registered Unknown Machine: %s", noSerie)
-    return m, nil
+    s.Events.Publish(events.Event{Type: events.MachineRegistered, MachineID: m.ID, Data: copyMachine(m)})
+    return copyMachine(m), nil
 }
 
-func (s *MemoryStore) UpdateMachineStatus(hashedPkey, ip, rawAuth, rawDecoded string) {
+func (s *MemoryStore) UpdateMachineStatus(hashedPkey, ip, outcome string, userLen int) {
     s.mu.Lock()
     defer s.mu.Unlock()
     
     if detail, ok := s.details[hashedPkey]; ok {
-        triggerGeo := (detail.IP != ip && ip != "" && ip != "127.0.0.1") || (detail.GeoLocation == "" && ip != "" && ip != "127.0.0.1")
+        triggerGeo := geo.PublicIP(ip) != nil && (detail.IP != ip || detail.GeoLocation == "")
 
         detail.IP = ip
-        detail.RawAuth = rawAuth
-        detail.RawDecoded = rawDecoded
+        detail.CredentialFingerprint = CredentialFingerprint(hashedPkey)
+        detail.CredentialSplit = userLen
+        detail.LastAuthOutcome = outcome
         detail.LastSeen = time.Now()
         
-        s.save() // Persist updates (IP/LastSeen)
+        s.markDirty() // Persist updates (IP/LastSeen)
+        s.Events.Publish(events.Event{Type: events.MachineHeartbeat, MachineID: detail.ID, Data: &models.MachineHeartbeat{
+            ID: detail.ID, NoSerie: detail.NoSerie, IP: ip, LastSeen: detail.LastSeen, Outcome: outcome,
+        }})
         
         if triggerGeo {
-            go s.fetchGeoLocation(hashedPkey, ip, false)
+            s.locate(hashedPkey, ip, false)
         }
     }
 }
 
-// fetchGeoLocation updates geo info for machine (isGateway=false) or gateway (isGateway=true)
-func (s *MemoryStore) fetchGeoLocation(key, ip string, isGateway bool) {
-    time.Sleep(1 * time.Second) 
-    
-    url := fmt.Sprintf("http://ip-api.com/json/%s", ip)
-    resp, err := http.Get(url)
-    if err != nil {
-        log.Printf("Geo Fetch Error: %v", err)
-        return
-    }
-    defer resp.Body.Close()
-    
-    var geo models.GeoAPIResponse
-    if err := json.NewDecoder(resp.Body).Decode(&geo); err != nil {
-        log.Printf("Geo Decode Error: %v", err)
-        return
+// scrubCredentials replaces the plaintext credentials of details by their
+// fingerprint and returns the number of details changed
+func scrubCredentials(details map[string]*models.MachineDetail) int {
+    n := 0
+    for hashedPkey, detail := range details {
+        if detail.RawAuth == "" && detail.RawDecoded == "" {
+            continue
+        }
+        if user, _, found := strings.Cut(detail.RawDecoded, ":"); found {
+            detail.CredentialSplit = len(user)
+        }
+        detail.CredentialFingerprint = CredentialFingerprint(hashedPkey)
+        detail.RawAuth = ""
+        detail.RawDecoded = ""
+        n++
     }
-    
-    if geo.Status == "success" {
-        location := fmt.Sprintf("%s, %s (%s)", geo.City, geo.Country, geo.ISP)
-        
+    return n
+}
+
+// locate resolves ip in the background and updates the machine
+// (isGateway=false) or gateway (isGateway=true) if it still has this IP
+func (s *MemoryStore) locate(key, ip string, isGateway bool) {
+    s.Geo.Resolve(ip, func(loc *geo.Location) {
         s.mu.Lock()
+        defer s.mu.Unlock()
+
+        label := loc.Label()
+        name := key
+        event := events.Event{Type: events.GatewayGeo, Data: &models.GeoUpdate{IP: ip, GeoLocation: label, Country: loc.Country, Lat: loc.Lat, Lon: loc.Lon}}
         if isGateway {
-            if gw, ok := s.gateways[key]; ok {
-                gw.GeoLocation = location
-                gw.Lat = geo.Lat
-                gw.Lon = geo.Lon
-                s.save()
+            gw, ok := s.gateways[key]
+            if !ok || gw.IP != ip {
+                return
             }
+            name = gw.Hostname // The key of an enrolled gateway is its ID
+            event.Gateway = gw.Hostname
+            gw.GeoLocation = label
+            gw.Country = loc.Country
+            gw.Lat = loc.Lat
+            gw.Lon = loc.Lon
         } else {
-            if detail, ok := s.details[key]; ok {
-                detail.GeoLocation = location
-                detail.Lat = geo.Lat
-                detail.Lon = geo.Lon
-                s.save()
+            detail, ok := s.details[key]
+            if !ok || detail.IP != ip {
+                return
             }
+            detail.GeoLocation = label
+            detail.Country = loc.Country
+            detail.Lat = loc.Lat
+            detail.Lon = loc.Lon
+            name = detail.NoSerie // Not the key: it is the credential
+            event.Type, event.Gateway, event.MachineID = events.MachineGeo, "", detail.ID
         }
-        s.mu.Unlock()
-        log.Printf("Geo Update for %s (%s): %s", key, ip, location)
+        s.markDirty()
+        s.Events.Publish(event)
+        log.Printf("Geo Update for %s (%s): %s", name, ip, label)
+    })
+}
+
+// SetMachineVersion records the firmware version reported by a machine
+func (s *MemoryStore) SetMachineVersion(machineID int, version string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for key, m := range s.machines {
+        if m.ID != machineID {
+            continue
+        }
+        if m.Version == version {
+            return nil
+        }
+        log.Printf("[STORE] Machine %s firmware version: %q -> %q", m.NoSerie, m.Version, version)
+        m.Version = version
+        m.DateModification = time.Now()
+        if detail, ok := s.details[key]; ok {
+            detail.Version = version
+        }
+        s.markDirty()
+        return nil
+    }
+    return ErrMachineNotFound
+}
+
+func (s *MemoryStore) SetMachineTags(machineID int, tags []string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for key, m := range s.machines {
+        if m.ID != machineID {
+            continue
+        }
+        m.Tags = tags
+        m.DateModification = time.Now()
+        if detail, ok := s.details[key]; ok {
+            detail.Tags = tags
+        }
+        s.markDirty()
+        return nil
     }
+    return ErrMachineNotFound
+}
+
+// findMachine returns the storage key of a machine; the caller holds the lock
+func (s *MemoryStore) findMachine(machineID int) (string, *models.Machine) {
+    for key, m := range s.machines {
+        if m.ID == machineID {
+            return key, m
+        }
+    }
+    return "", nil
+}
+
+func (s *MemoryStore) SetMachineActive(machineID int, active bool) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key, m := s.findMachine(machineID)
+    if m == nil {
+        return ErrMachineNotFound
+    }
+    m.IsActive = active
+    m.DateModification = time.Now()
+    if detail, ok := s.details[key]; ok {
+        detail.IsActive = active
+    }
+    s.markDirty()
+    return nil
+}
+
+func (s *MemoryStore) SetMachineAlarm(machineID int, allowed bool) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key, m := s.findMachine(machineID)
+    if m == nil {
+        return ErrMachineNotFound
+    }
+    m.AutoriseAlarme = allowed
+    m.DateModification = time.Now()
+    if detail, ok := s.details[key]; ok {
+        detail.AutoriseAlarme = allowed
+    }
+    s.markDirty()
+    return nil
+}
+
+// RenameMachine changes the serial, which is also the client ID of the legacy
+// protocol: the last snapshot follows the machine.
+func (s *MemoryStore) RenameMachine(machineID int, noSerie string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key, m := s.findMachine(machineID)
+    if m == nil {
+        return ErrMachineNotFound
+    }
+    for _, other := range s.machines {
+        if other.ID != machineID && other.NoSerie == noSerie {
+            return ErrSerialTaken
+        }
+    }
+    if snapshot, ok := s.data[m.NoSerie]; ok {
+        delete(s.data, m.NoSerie)
+        s.data[noSerie] = snapshot
+    }
+    m.NoSerie = noSerie
+    m.DateModification = time.Now()
+    if detail, ok := s.details[key]; ok {
+        detail.NoSerie = noSerie
+    }
+    s.markDirty()
+    return nil
+}
+
+// DeleteMachine forgets a machine. If it connects again it is registered as a
+// new unknown machine, with a new ID.
+func (s *MemoryStore) DeleteMachine(machineID int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key, m := s.findMachine(machineID)
+    if m == nil {
+        return ErrMachineNotFound
+    }
+    delete(s.machines, key)
+    delete(s.details, key)
+    delete(s.data, m.NoSerie)
+    s.markDirty()
+    return nil
 }
 
 func (s *MemoryStore) SaveClientData(clientID string, data []models.ExchangeKeyValue) error {
@@ -274,39 +622,62 @@ func (s *MemoryStore) SaveClientData(clientID string, data []models.ExchangeKeyV
     return nil
 }
 
+func (s *MemoryStore) GetClientData(clientID string) ([]models.ExchangeKeyValue, error) {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    snapshot, ok := s.data[clientID]
+    if !ok {
+        return nil, nil
+    }
+    list := make([]models.ExchangeKeyValue, len(snapshot))
+    copy(list, snapshot)
+    return list, nil
+}
+
 func (s *MemoryStore) SaveGateway(gw *models.GatewayStatus) error {
     s.mu.Lock()
     defer s.mu.Unlock()
     
     // Check if existing to handle Geo trigger
-    existing, exists := s.gateways[gw.Hostname]
+    key := gw.Key()
+    existing, exists := s.gateways[key]
+    public := geo.PublicIP(gw.IP) != nil
     triggerGeo := false
     
     if exists {
         // preserve old geo if ip same, or trigger new if ip changed
-        if gw.IP != existing.IP && gw.IP != "" && gw.IP != "127.0.0.1" {
+        if gw.IP != existing.IP && public {
             triggerGeo = true
         } else {
             // Keep existing geo data
             gw.GeoLocation = existing.GeoLocation
+            gw.Country = existing.Country
             gw.Lat = existing.Lat
             gw.Lon = existing.Lon
         }
         // If geo was missing
-        if gw.GeoLocation == "" && gw.IP != "" && gw.IP != "127.0.0.1" {
+        if gw.GeoLocation == "" && public {
             triggerGeo = true
         }
     } else {
-         if gw.IP != "" && gw.IP != "127.0.0.1" {
-            triggerGeo = true
-         }
+        triggerGeo = public
     }
     
-    s.gateways[gw.Hostname] = gw
-    s.save()
+    // One entry per hostname: the report replaces the one under another key
+    // (reported before the enrollment, or by a revoked identity)
+    for k, other := range s.gateways {
+        if k != key && other.Hostname == gw.Hostname {
+            delete(s.gateways, k)
+        }
+    }
+    s.gateways[key] = gw
+    s.markDirty()
+    report := *gw
+    s.Events.Publish(events.Event{Type: events.GatewayReport, Gateway: gw.Hostname, Data: &report})
     
     if triggerGeo {
-        go s.fetchGeoLocation(gw.Hostname, gw.IP, true)
+        s.locate(key, gw.IP, true)
     }
     
     return nil
@@ -317,9 +688,8 @@ func (s *MemoryStore) GetStats() (*models.AdminStatsResponse, error) {
     defer s.mu.RUnlock()
     
     return &models.AdminStatsResponse{
-        ConnectedClients: len(s.data),
-        TotalMachines:    len(s.machines),
-        TotalGateways:    len(s.gateways),
+        TotalMachines: len(s.machines),
+        TotalGateways: len(s.gateways),
     }, nil
 }
 
@@ -329,7 +699,8 @@ func (s *MemoryStore) GetMachines() ([]*models.MachineDetail, error) {
     
     list := make([]*models.MachineDetail, 0, len(s.details))
     for _, d := range s.details {
-        list = append(list, d)
+        out := *d
+        list = append(list, &out)
     }
     return list, nil
 }
@@ -340,11 +711,77 @@ func (s *MemoryStore) GetGateways() ([]*models.GatewayStatus, error) {
     
     list := make([]*models.GatewayStatus, 0, len(s.gateways))
     for _, g := range s.gateways {
-        list = append(list, g)
+        out := *g
+        list = append(list, &out)
     }
     return list, nil
 }
 
+func (s *MemoryStore) QueryMachines(q models.FleetQuery) (*models.MachinePage, error) {
+    field, cursor, err := parseFleetQuery(q, models.MachineSortFields)
+    if err != nil {
+        return nil, err
+    }
+
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    var matches []*models.MachineDetail
+    for _, d := range s.details {
+        if q.Active != nil && d.IsActive != *q.Active {
+            continue
+        }
+        if q.Version != "" && d.Version != q.Version {
+            continue
+        }
+        if !matchesFleet(q, d.LastSeen, d.Country, d.NoSerie, d.IP, d.GeoLocation) {
+            continue
+        }
+        matches = append(matches, d)
+    }
+    entries := make([]fleetEntry, len(matches))
+    for i, d := range matches {
+        entries[i] = fleetEntry{value: machineSortValue(d, field), key: padID(d.ID), index: i}
+    }
+    entries, next := pageFleet(entries, field, q, cursor)
+
+    page := &models.MachinePage{Items: make([]*models.MachineDetail, 0, len(entries)), Total: len(matches), NextCursor: next}
+    for _, e := range entries {
+        d := *matches[e.index]
+        page.Items = append(page.Items, &d)
+    }
+    return page, nil
+}
+
+func (s *MemoryStore) QueryGateways(q models.FleetQuery) (*models.GatewayPage, error) {
+    field, cursor, err := parseFleetQuery(q, models.GatewaySortFields)
+    if err != nil {
+        return nil, err
+    }
+
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+
+    var matches []*models.GatewayStatus
+    for _, gw := range s.gateways {
+        if matchesFleet(q, gw.LastSeen, gw.Country, gw.Hostname, gw.IP, gw.GeoLocation) {
+            matches = append(matches, gw)
+        }
+    }
+    entries := make([]fleetEntry, len(matches))
+    for i, gw := range matches {
+        entries[i] = fleetEntry{value: gatewaySortValue(gw, field), key: gw.Hostname, index: i}
+    }
+    entries, next := pageFleet(entries, field, q, cursor)
+
+    page := &models.GatewayPage{Items: make([]*models.GatewayStatus, 0, len(entries)), Total: len(matches), NextCursor: next}
+    for _, e := range entries {
+        gw := *matches[e.index]
+        page.Items = append(page.Items, &gw)
+    }
+    return page, nil
+}
+
 // AddSubscriber adds a new email to the list
 func (s *MemoryStore) AddSubscriber(email string) error {
     s.mu.Lock()
@@ -362,7 +799,7 @@ func (s *MemoryStore) AddSubscriber(email string) error {
         DateJoined: time.Now(),
     })
     
-    s.save()
+    s.markDirty()
     return nil
 }
 
@@ -387,7 +824,7 @@ func (s *MemoryStore) DeleteSubscriber(email string) error {
     }
     
     s.subscribers = newSubs
-    s.save()
+    s.markDirty()
     return nil
 }
 
@@ -429,7 +866,7 @@ func (s *MemoryStore) SaveNewsletter(n models.Newsletter) error {
     defer s.mu.Unlock()
     
     s.newsletters[n.ID] = n
-    s.save()
+    s.markDirty()
     return nil
 }
 
@@ -442,87 +879,6 @@ func (s *MemoryStore) DeleteNewsletter(id string) error {
     }
     
     delete(s.newsletters, id)
-    s.save()
-    return nil
-}
-
-// DatabaseStore implementation (Future / Production)
-type DatabaseStore struct {
-    db *sqlx.DB
-}
-
-func NewDatabaseStore(db *sqlx.DB) *DatabaseStore {
-    return &DatabaseStore{db: db}
-}
-
-func (s *DatabaseStore) GetMachineByHashedPkey(hashedPkey string) (*models.Machine, error) {
-    var machine models.Machine
-	// Note: in real implementation we should check is_active = true
-	query := `SELECT * FROM es_machine WHERE hashed_pkey = $1 AND is_active = true`
-	err := s.db.Get(&machine, query, hashedPkey)
-	if err != nil {
-		return nil, err
-	}
-	return &machine, nil
-}
-
-func (s *DatabaseStore) RegisterUnknownMachine(hashedPkey string) (*models.Machine, error) {
-    // Stub
-    return nil, fmt.Errorf("not implemented in database store")
-}
-
-func (s *DatabaseStore) UpdateMachineStatus(hashedPkey, ip, rawAuth, rawDecoded string) {
-    // No-op for DB store in passive mode for now
-}
-
-func (s *DatabaseStore) SaveClientData(clientID string, data []models.ExchangeKeyValue) error {
-    // In passive mode, we might just log to Redis or update a 'latest_state' table
-    // For now, simple logging
-    log.Printf("[DB STORE] (Simulated) Saved data for %s", clientID)
-    return nil
-}
-
-func (s *DatabaseStore) SaveGateway(gw *models.GatewayStatus) error {
-    return nil
-}
-
-func (s *DatabaseStore) GetGateways() ([]*models.GatewayStatus, error) {
-    return []*models.GatewayStatus{}, nil
-}
-
-func (s *DatabaseStore) GetStats() (*models.AdminStatsResponse, error) {
-    // Mock implementation for now as we don't have full DB setup in this file context yet
-    return &models.AdminStatsResponse{
-        ConnectedClients: 0,
-        TotalMachines:    0,
-        TotalGateways:    0,
-    }, nil
-}
-
-func (s *DatabaseStore) GetMachines() ([]*models.MachineDetail, error) {
-    return []*models.MachineDetail{}, nil
-}
-
-func (s *DatabaseStore) AddSubscriber(email string) error {
-    return nil
-}
-
-func (s *DatabaseStore) GetSubscribers() ([]models.Subscriber, error) {
-    return []models.Subscriber{}, nil
-}
-
-func (s *DatabaseStore) GetNewsletters() ([]models.Newsletter, error) {
-    return []models.Newsletter{}, nil
-}
-
-func (s *DatabaseStore) GetNewsletter(id string) (*models.Newsletter, error) {
-    return nil, fmt.Errorf("not implemented")
-}
-
-func (s *DatabaseStore) SaveNewsletter(n models.Newsletter) error {
-    return nil
-}
-
-func (s *DatabaseStore) DeleteNewsletter(id string) error {
+    s.markDirty()
     return nil
 }
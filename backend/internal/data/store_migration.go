@@ -0,0 +1,447 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/lib/pq"
+)
+
+// Kinds of entities moved between a PersistenceData file and the database,
+// in migration order
+const (
+	KindMachines    = "machines"
+	KindGateways    = "gateways"
+	KindSubscribers = "subscribers"
+	KindNewsletters = "newsletters"
+)
+
+var MigrationKinds = []string{KindMachines, KindGateways, KindSubscribers, KindNewsletters}
+
+// ReadPersistenceFile loads a machines.json file the way MemoryStore does:
+// keys restored (hashed and sealed with sealer for older files), plaintext
+// credentials scrubbed (in memory only)
+func ReadPersistenceFile(path string, sealer *CredentialSealer) (*PersistenceData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var pd PersistenceData
+	if err := json.NewDecoder(file).Decode(&pd); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if pd.Machines == nil {
+		pd.Machines = make(map[string]*models.Machine)
+	}
+	if pd.Details == nil {
+		pd.Details = make(map[string]*models.MachineDetail)
+	}
+	if pd.Gateways == nil {
+		pd.Gateways = make(map[string]*models.GatewayStatus)
+	}
+	pd.restoreKeys(sealer)
+	scrubCredentials(pd.Details)
+	return &pd, nil
+}
+
+// WritePersistenceFile writes pd atomically, so that path is either the old
+// or the new content
+func WritePersistenceFile(path string, pd *PersistenceData) error {
+	out := *pd
+	out.Credentials = sealedCredentials(pd.Machines)
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// MachineRecord is a machine with its connection details (nil if none)
+type MachineRecord struct {
+	Machine *models.Machine
+	Detail  *models.MachineDetail
+}
+
+// MachineRecords lists the machines of pd by ID
+func MachineRecords(pd *PersistenceData) []MachineRecord {
+	list := make([]MachineRecord, 0, len(pd.Machines))
+	for key, m := range pd.Machines {
+		list = append(list, MachineRecord{Machine: m, Detail: pd.Details[key]})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Machine.ID < list[j].Machine.ID })
+	return list
+}
+
+// SortedGateways lists the gateways of pd by hostname
+func SortedGateways(pd *PersistenceData) []*models.GatewayStatus {
+	list := make([]*models.GatewayStatus, 0, len(pd.Gateways))
+	for _, gw := range pd.Gateways {
+		list = append(list, gw)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Hostname < list[j].Hostname })
+	return list
+}
+
+// SortedNewsletters lists the newsletters of pd by ID
+func SortedNewsletters(pd *PersistenceData) []models.Newsletter {
+	list := make([]models.Newsletter, len(pd.Newsletters))
+	copy(list, pd.Newsletters)
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Digests returns, per kind, a digest of each entity keyed by its identity.
+// Values are normalized the way Postgres stores them (UTC, microseconds), so
+// a file and its import have the same digests.
+func Digests(pd *PersistenceData) map[string]map[string]string {
+	out := map[string]map[string]string{
+		KindMachines:    {},
+		KindGateways:    {},
+		KindSubscribers: {},
+		KindNewsletters: {},
+	}
+	for key, m := range pd.Machines {
+		// Status flags, version and tags of the detail mirror the machine
+		v := struct {
+			ID             int
+			NoSerie        string
+			Version        string
+			AutoriseAlarme bool
+			IsActive       bool
+			DateCreation   time.Time
+			DateModif      time.Time
+			Tags           []string
+			Detail         interface{}
+		}{m.ID, m.NoSerie, m.Version, m.AutoriseAlarme, m.IsActive, normTime(m.DateCreation), normTime(m.DateModification), normTags(m.Tags), nil}
+		if d := pd.Details[key]; d != nil {
+			v.Detail = []interface{}{d.IP, normTime(d.LastSeen), d.CredentialFingerprint, d.CredentialSplit, d.LastAuthOutcome, d.GeoLocation, d.Lat, d.Lon}
+		}
+		out[KindMachines][key] = digest(v)
+	}
+	for host, gw := range pd.Gateways {
+		c := *gw
+		c.LastSeen = normTime(c.LastSeen)
+		out[KindGateways][host] = digest(c)
+	}
+	for _, sub := range pd.Subscribers {
+		out[KindSubscribers][sub.Email] = digest(normTime(sub.DateJoined))
+	}
+	for _, n := range pd.Newsletters {
+		c := n
+		c.CreatedAt = normTime(c.CreatedAt)
+		c.UpdatedAt = normTime(c.UpdatedAt)
+		if c.SentAt != nil {
+			t := normTime(*c.SentAt)
+			c.SentAt = &t
+		}
+		out[KindNewsletters][n.ID] = digest(c)
+	}
+	return out
+}
+
+// SetChecksum combines the digests of one kind, independently of order
+func SetChecksum(digests map[string]string) string {
+	keys := make([]string, 0, len(digests))
+	for k := range digests {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, digests[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func digest(v interface{}) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func normTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func normTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// TablesExist reports whether EnsureTableExists already ran on this database
+func (s *DatabaseStore) TablesExist() (bool, error) {
+	var name sql.NullString
+	if err := s.db.Get(&name, `SELECT to_regclass('es_newsletter')::text`); err != nil {
+		return false, err
+	}
+	return name.Valid, nil
+}
+
+// ImportMachines upserts machines with their file IDs, keyed by MachineKey.
+// A key already stored under another ID is an error: users link to IDs.
+func (s *DatabaseStore) ImportMachines(records []MachineRecord) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rec := range records {
+		m := rec.Machine
+		tags := m.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		var id int
+		err := tx.Get(&id, `
+			INSERT INTO es_machine (id, no_serie, version, hashed_pkey, autorise_alarme, is_active, date_creation, date_modification, tags, sealed_credential)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (hashed_pkey) DO UPDATE SET
+				no_serie = EXCLUDED.no_serie,
+				sealed_credential = COALESCE(NULLIF(EXCLUDED.sealed_credential, ''), es_machine.sealed_credential),
+				version = EXCLUDED.version,
+				autorise_alarme = EXCLUDED.autorise_alarme,
+				is_active = EXCLUDED.is_active,
+				date_creation = EXCLUDED.date_creation,
+				date_modification = EXCLUDED.date_modification,
+				tags = EXCLUDED.tags
+			RETURNING id`,
+			m.ID, m.NoSerie, m.Version, m.HashedPkey, m.AutoriseAlarme, m.IsActive,
+			m.DateCreation, m.DateModification, pq.Array(tags), m.SealedCredential)
+		if err != nil {
+			return fmt.Errorf("machine %d (%s): %w", m.ID, m.NoSerie, err)
+		}
+		if id != m.ID {
+			return fmt.Errorf("machine %s: stored with id %d in the database, %d in the file", m.NoSerie, id, m.ID)
+		}
+
+		d := rec.Detail
+		if d == nil {
+			continue
+		}
+		_, err = tx.Exec(`
+			INSERT INTO es_machine_detail (machine_id, ip, last_seen, credential_fingerprint, credential_split, last_auth_outcome, geo_location, lat, lon, country)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (machine_id) DO UPDATE SET
+				ip = EXCLUDED.ip,
+				last_seen = EXCLUDED.last_seen,
+				credential_fingerprint = EXCLUDED.credential_fingerprint,
+				credential_split = EXCLUDED.credential_split,
+				last_auth_outcome = EXCLUDED.last_auth_outcome,
+				geo_location = EXCLUDED.geo_location,
+				lat = EXCLUDED.lat,
+				lon = EXCLUDED.lon,
+				country = EXCLUDED.country`,
+			m.ID, d.IP, d.LastSeen, d.CredentialFingerprint, d.CredentialSplit, d.LastAuthOutcome, d.GeoLocation, d.Lat, d.Lon, d.Country)
+		if err != nil {
+			return fmt.Errorf("machine %d detail: %w", m.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SyncMachineSequence moves the ID sequence past imported IDs and nextID,
+// so that IDs are never reused
+func (s *DatabaseStore) SyncMachineSequence(nextID int) error {
+	_, err := s.db.Exec(`
+		SELECT setval(pg_get_serial_sequence('es_machine', 'id'),
+			GREATEST((SELECT COALESCE(MAX(id), 0) FROM es_machine), $1 - 1, 1))`, nextID)
+	return err
+}
+
+func (s *DatabaseStore) ImportGateways(gateways []*models.GatewayStatus) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, gw := range gateways {
+		if err := upsertGateway(tx, gw); err != nil {
+			return fmt.Errorf("gateway %s: %w", gw.Hostname, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *DatabaseStore) ImportSubscribers(subs []models.Subscriber) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, sub := range subs {
+		_, err := tx.Exec(`
+			INSERT INTO es_subscriber (email, date_joined) VALUES ($1, $2)
+			ON CONFLICT (email) DO UPDATE SET date_joined = EXCLUDED.date_joined`, sub.Email, sub.DateJoined)
+		if err != nil {
+			return fmt.Errorf("subscriber %s: %w", sub.Email, err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *DatabaseStore) ImportNewsletters(list []models.Newsletter) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, n := range list {
+		if err := saveNewsletter(tx, n); err != nil {
+			return fmt.Errorf("newsletter %s: %w", n.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Export reads the database back into the machines.json format
+func (s *DatabaseStore) Export() (*PersistenceData, error) {
+	pd := &PersistenceData{
+		Machines: make(map[string]*models.Machine),
+		Details:  make(map[string]*models.MachineDetail),
+		Gateways: make(map[string]*models.GatewayStatus),
+	}
+
+	var rows []struct {
+		machineDetailRow
+		HasDetail bool `db:"has_detail"`
+	}
+	err := s.db.Select(&rows, `
+		SELECT `+machineDetailColumns+`,
+			d.machine_id IS NOT NULL AS has_detail
+		FROM es_machine m
+		LEFT JOIN es_machine_detail d ON d.machine_id = m.id
+		ORDER BY m.id`)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		r := &rows[i]
+		m := r.Machine
+		m.Tags = []string(r.Tags)
+		pd.Machines[m.HashedPkey] = &m
+		if r.HasDetail {
+			pd.Details[m.HashedPkey] = r.detail()
+		}
+		if m.ID >= pd.NextID {
+			pd.NextID = m.ID + 1
+		}
+	}
+
+	gateways, err := s.GetGateways()
+	if err != nil {
+		return nil, err
+	}
+	for _, gw := range gateways {
+		pd.Gateways[gw.Key()] = gw
+	}
+	if pd.Subscribers, err = s.GetSubscribers(); err != nil {
+		return nil, err
+	}
+	if pd.Newsletters, err = s.GetNewsletters(); err != nil {
+		return nil, err
+	}
+	return pd, nil
+}
+
+// ClearCredential is a row of es_machine still holding a credential in clear:
+// a hashed_pkey written before the keys were hashed (legacy server, rows
+// imported by hand), or the activation key of the legacy server in pkey
+type ClearCredential struct {
+	ID         int     `db:"id" json:"id"`
+	NoSerie    string  `db:"no_serie" json:"no_serie"`
+	HashedPkey string  `db:"hashed_pkey" json:"hashed_pkey"`
+	Pkey       *string `db:"pkey" json:"pkey"`
+}
+
+// Hashed reports whether hashed_pkey is already a MachineKey: only pkey is left
+func (c *ClearCredential) Hashed() bool {
+	return isMachineKey(c.HashedPkey)
+}
+
+const clearCredentialsWhere = ` WHERE hashed_pkey !~* '^[0-9a-f]{64}$' OR COALESCE(pkey, '') <> ''`
+
+// ClearCredentials lists the rows of es_machine holding a credential in clear
+func (s *DatabaseStore) ClearCredentials() ([]ClearCredential, error) {
+	rows := []ClearCredential{}
+	err := s.db.Select(&rows, `SELECT id, no_serie, hashed_pkey, pkey FROM es_machine`+clearCredentialsWhere+` ORDER BY id`)
+	return rows, err
+}
+
+// CountClearCredentials counts the rows HashCredentialKeys would change
+func (s *DatabaseStore) CountClearCredentials() (int, error) {
+	var n int
+	err := s.db.Get(&n, `SELECT COUNT(*) FROM es_machine`+clearCredentialsWhere)
+	return n, err
+}
+
+// CredentialKeyConflicts returns, by row ID, the machine already stored under
+// the MachineKey of a row to hash: the unique index would refuse the row
+func (s *DatabaseStore) CredentialKeyConflicts(rows []ClearCredential) (map[int]int, error) {
+	byKey := make(map[string]int)
+	var keys []string
+	for _, r := range rows {
+		if !r.Hashed() {
+			key := MachineKey(r.HashedPkey)
+			byKey[key] = r.ID
+			keys = append(keys, key)
+		}
+	}
+	conflicts := make(map[int]int)
+	if len(keys) == 0 {
+		return conflicts, nil
+	}
+	var taken []struct {
+		ID  int    `db:"id"`
+		Key string `db:"hashed_pkey"`
+	}
+	if err := s.db.Select(&taken, `SELECT id, hashed_pkey FROM es_machine WHERE hashed_pkey = ANY($1)`, pq.Array(keys)); err != nil {
+		return nil, err
+	}
+	for _, t := range taken {
+		conflicts[byKey[t.Key]] = t.ID
+	}
+	return conflicts, nil
+}
+
+// HashCredentialKeys replaces, in one transaction, the credentials of rows by
+// their MachineKey, sealed for the reveal with sealer (nil keeps none), and
+// clears pkey. The first failing row cancels all the changes.
+func (s *DatabaseStore) HashCredentialKeys(rows []ClearCredential, sealer *CredentialSealer) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range rows {
+		var res sql.Result
+		if r.Hashed() {
+			res, err = tx.Exec(`UPDATE es_machine SET pkey = NULL WHERE id = $1 AND hashed_pkey = $2`, r.ID, r.HashedPkey)
+		} else {
+			res, err = tx.Exec(`UPDATE es_machine SET hashed_pkey = $2, sealed_credential = $3, pkey = NULL WHERE id = $1 AND hashed_pkey = $4`,
+				r.ID, MachineKey(r.HashedPkey), sealer.Seal(r.HashedPkey), r.HashedPkey)
+		}
+		if err != nil {
+			return fmt.Errorf("machine %d (%s): %w", r.ID, r.NoSerie, err)
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			return fmt.Errorf("machine %d (%s): changed since it was read", r.ID, r.NoSerie)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,251 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard) // The stores log every change
+	os.Exit(m.Run())
+}
+
+// newTestStore returns a store of n machines; versions and serials repeat,
+// so that the keyset has to break ties on the ID
+func newTestStore(t testing.TB, n int, opts PersistenceOptions) *MemoryStore {
+	t.Helper()
+	s := NewMemoryStore(filepath.Join(t.TempDir(), "machines.json"), opts)
+	t.Cleanup(func() { s.Close() })
+	for i := 1; i <= n; i++ {
+		s.AddTestMachine(&models.Machine{
+			ID:         i,
+			NoSerie:    fmt.Sprintf("ESS-%03d", i%7),
+			HashedPkey: MachineKey(fmt.Sprintf("pkey-%06d", i)),
+			IsActive:   i%2 == 0,
+		})
+		s.SetMachineVersion(i, fmt.Sprintf("V%d", i%3))
+	}
+	return s
+}
+
+// walkMachines pages through q and returns the IDs in order
+func walkMachines(t *testing.T, s Store, q models.FleetQuery) []int {
+	t.Helper()
+	var ids []int
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("paging does not end")
+		}
+		p, err := s.QueryMachines(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Limit > 0 && len(p.Items) > q.Limit {
+			t.Fatalf("page of %d items, limit %d", len(p.Items), q.Limit)
+		}
+		for _, d := range p.Items {
+			ids = append(ids, d.ID)
+		}
+		if p.NextCursor == "" {
+			return ids
+		}
+		q.Cursor = p.NextCursor
+	}
+}
+
+func TestQueryMachinesKeysetPaging(t *testing.T) {
+	s := newTestStore(t, 20, PersistenceOptions{})
+	active := true
+	tests := []struct {
+		name string
+		q    models.FleetQuery
+		want int // Machines matching the filters
+	}{
+		{"default sort", models.FleetQuery{}, 20},
+		{"by serial, ties on the ID", models.FleetQuery{Sort: models.FleetSortSerial}, 20},
+		{"by version descending", models.FleetQuery{Sort: models.FleetSortVersion, Desc: true}, 20},
+		{"active only", models.FleetQuery{Active: &active, Sort: models.FleetSortSerial}, 10},
+		{"version filter", models.FleetQuery{Version: "V1"}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := walkMachines(t, s, tt.q)
+			if len(all) != tt.want {
+				t.Fatalf("%d machines without limit, want %d", len(all), tt.want)
+			}
+			for _, limit := range []int{1, 3, 7, 20, 50} {
+				q := tt.q
+				q.Limit = limit
+				paged := walkMachines(t, s, q)
+				if fmt.Sprint(paged) != fmt.Sprint(all) {
+					t.Errorf("limit %d: %v, want %v", limit, paged, all)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryMachinesCursorSurvivesInserts(t *testing.T) {
+	s := newTestStore(t, 10, PersistenceOptions{})
+	q := models.FleetQuery{Limit: 4}
+	first, err := s.QueryMachines(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A machine registered before the cursor does not shift the next page
+	s.AddTestMachine(&models.Machine{ID: 0, NoSerie: "ESS-NEW", HashedPkey: "pkey-new"})
+	q.Cursor = first.NextCursor
+	next, err := s.QueryMachines(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(next.Items) != 4 || next.Items[0].ID != 5 {
+		t.Errorf("next page starts at %d, want 5", next.Items[0].ID)
+	}
+}
+
+func TestQueryMachinesRejectsBadCursor(t *testing.T) {
+	s := newTestStore(t, 5, PersistenceOptions{})
+	p, err := s.QueryMachines(models.FleetQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []models.FleetQuery{
+		{Sort: models.FleetSortSerial, Cursor: p.NextCursor}, // Issued for another sort
+		{Cursor: "not-a-cursor"},
+		{Sort: "pkey"},
+	}
+	for _, q := range tests {
+		if _, err := s.QueryMachines(q); !errors.Is(err, ErrInvalidFleetQuery) {
+			t.Errorf("QueryMachines(%+v) error = %v, want ErrInvalidFleetQuery", q, err)
+		}
+	}
+}
+
+func TestLoadScrubsLegacyCredentialsIntoCompleteSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "machines.json")
+	legacy := `{
+		"machines": {"user:pass": {"id": 41, "no_serie": "ESS-041"}},
+		"details": {"user:pass": {"id": 41, "no_serie": "ESS-041", "raw_auth": "dXNlcjpwYXNz", "raw_decoded": "user:pass"}},
+		"newsletters": [{"id": "nl-1", "subject": "Hello"}],
+		"next_machine_id": 50
+	}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without FlushInterval, the scrub writes the file during the load
+	NewMemoryStore(path, PersistenceOptions{}).Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "user:pass") || strings.Contains(string(b), "dXNlcjpwYXNz") || strings.Contains(string(b), `"raw_decoded"`) {
+		t.Error("plaintext credential still in the file")
+	}
+	s := NewMemoryStore(path, PersistenceOptions{})
+	defer s.Close()
+	if n, _ := s.GetNewsletters(); len(n) != 1 {
+		t.Errorf("%d newsletters after the scrub, want 1", len(n))
+	}
+	m, err := s.RegisterUnknownMachine(MachineKey("another-key"), "")
+	if err != nil || m.ID != 50 {
+		t.Errorf("next machine ID = %v, %v, want 50", m, err)
+	}
+}
+
+// newBenchStore loads a fleet of n active machines from a file, as at startup
+func newBenchStore(b *testing.B, n int, opts PersistenceOptions) (*MemoryStore, []string) {
+	b.Helper()
+	pd := &PersistenceData{
+		Machines: make(map[string]*models.Machine, n),
+		Details:  make(map[string]*models.MachineDetail, n),
+		Gateways: make(map[string]*models.GatewayStatus),
+		NextID:   n + 1,
+	}
+	keys := make([]string, 0, n)
+	now := time.Now()
+	for i := 1; i <= n; i++ {
+		key := MachineKey(fmt.Sprintf("pkey-%06d", i))
+		keys = append(keys, key)
+		serial := fmt.Sprintf("BENCH-%05d", i)
+		pd.Machines[key] = &models.Machine{ID: i, NoSerie: serial, IsActive: true, DateCreation: now, DateModification: now}
+		pd.Details[key] = &models.MachineDetail{ID: i, NoSerie: serial, IP: "127.0.0.1", LastSeen: now, IsActive: true}
+	}
+	path := filepath.Join(b.TempDir(), "machines.json")
+	if err := WritePersistenceFile(path, pd); err != nil {
+		b.Fatal(err)
+	}
+	return NewMemoryStore(path, opts), keys
+}
+
+// BenchmarkUpdateMachineStatus measures the heartbeats of a 10k fleet
+// (UpdateMachineStatus, called on each authenticated legacy request), writing
+// the file on every change and with write-behind persistence:
+//
+//	go test -run '^$' -bench UpdateMachineStatus ./internal/data
+func BenchmarkUpdateMachineStatus(b *testing.B) {
+	modes := []struct {
+		name string
+		opts PersistenceOptions
+	}{
+		{"write-through", PersistenceOptions{}},
+		{"write-behind", PersistenceOptions{FlushInterval: DefaultPersistenceOptions.FlushInterval}},
+	}
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			s, keys := newBenchStore(b, 10000, mode.opts)
+			var worker atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Workers start apart in the fleet; loopback IP: no geolocation
+				n := int(worker.Add(1)) * 1237
+				for pb.Next() {
+					s.UpdateMachineStatus(keys[n%len(keys)], "127.0.0.1", models.AuthOutcomeSuccess, 16)
+					n++
+				}
+			})
+			b.StopTimer()
+
+			start := time.Now()
+			if err := s.Close(); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(time.Since(start).Microseconds())/1000, "final-flush-ms")
+		})
+	}
+}
+
+func TestSaveGatewayKeysEnrolledGatewaysByID(t *testing.T) {
+	s := newTestStore(t, 0, PersistenceOptions{})
+	s.SaveGateway(&models.GatewayStatus{Hostname: "gw-1", CPU: 1})
+	// Enrolled: the report replaces the entry of the hostname
+	s.SaveGateway(&models.GatewayStatus{Hostname: "gw-1", GatewayID: "id-1", CPU: 2})
+	s.SaveGateway(&models.GatewayStatus{Hostname: "gw-2", CPU: 3})
+
+	gateways, _ := s.GetGateways()
+	byHost := make(map[string]*models.GatewayStatus)
+	for _, gw := range gateways {
+		byHost[gw.Hostname] = gw
+	}
+	if len(gateways) != 2 || byHost["gw-1"].GatewayID != "id-1" || byHost["gw-1"].CPU != 2 {
+		t.Fatalf("GetGateways() = %+v, %+v", byHost["gw-1"], byHost["gw-2"])
+	}
+	s.mu.RLock()
+	_, ok := s.gateways["id-1"]
+	s.mu.RUnlock()
+	if !ok {
+		t.Error("enrolled gateway not keyed by its ID")
+	}
+}
@@ -0,0 +1,442 @@
+package data
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// TelemetryStore records every (machine, key, value, timestamp) sample
+// reported through POST /api/mystatus
+type TelemetryStore interface {
+	EnsureTableExists() error
+	RecordSamples(machineID int, at time.Time, values []models.ExchangeKeyValue) error
+	// QueryTelemetry returns the series by key, and true when they were cut
+	// at maxTelemetryPoints: the last keys are then partial or missing
+	QueryTelemetry(q models.TelemetryQuery) ([]models.TelemetrySeries, bool, error)
+	// LatestSamples returns the last recorded value of every key of a machine
+	LatestSamples(machineID int) ([]models.ExchangeKeyValue, error)
+	// ApplyRetention deletes expired samples and thins old ones
+	ApplyRetention(policy models.TelemetryRetention, now time.Time) error
+}
+
+// maxTelemetryPoints caps a single query so one request cannot dump the table
+const maxTelemetryPoints = 50000
+
+// RunTelemetryRetention applies the retention policy every interval. Blocking, run it in a goroutine.
+func RunTelemetryRetention(store TelemetryStore, policy models.TelemetryRetention, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		if err := store.ApplyRetention(policy, time.Now()); err != nil {
+			log.Printf("[TELEMETRY] Retention failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// bucketPoints keeps the last point of each interval bucket. Points must be sorted by time.
+func bucketPoints(points []models.TelemetryPoint, interval time.Duration) []models.TelemetryPoint {
+	if interval <= 0 || len(points) == 0 {
+		return points
+	}
+	out := make([]models.TelemetryPoint, 0, len(points))
+	for i, p := range points {
+		if i+1 < len(points) && points[i+1].Time.Truncate(interval).Equal(p.Time.Truncate(interval)) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------
+// Postgres implementation
+// ---------------------------------------------------------------------
+
+type PostgresTelemetryStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresTelemetryStore(db *sqlx.DB) *PostgresTelemetryStore {
+	return &PostgresTelemetryStore{db: db}
+}
+
+func (s *PostgresTelemetryStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS telemetry_samples (
+		machine_id INT NOT NULL,
+		key INT NOT NULL,
+		value TEXT NOT NULL,
+		recorded_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_telemetry_machine_key_time ON telemetry_samples(machine_id, key, recorded_at);
+	CREATE INDEX IF NOT EXISTS idx_telemetry_recorded_at ON telemetry_samples(recorded_at);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+func (s *PostgresTelemetryStore) RecordSamples(machineID int, at time.Time, values []models.ExchangeKeyValue) error {
+	if len(values) == 0 {
+		return nil
+	}
+	keys := make([]int64, len(values))
+	vals := make([]string, len(values))
+	for i, kv := range values {
+		keys[i] = int64(kv.K)
+		vals[i] = kv.V
+	}
+	query := `
+		INSERT INTO telemetry_samples (machine_id, key, value, recorded_at)
+		SELECT $1, k, v, $4 FROM unnest($2::int[], $3::text[]) AS t(k, v)`
+	_, err := s.db.Exec(query, machineID, pq.Array(keys), pq.Array(vals), at)
+	return err
+}
+
+func (s *PostgresTelemetryStore) QueryTelemetry(q models.TelemetryQuery) ([]models.TelemetrySeries, bool, error) {
+	var rows []struct {
+		Key int `db:"key"`
+		models.TelemetryPoint
+	}
+	query := `
+		SELECT key, value, recorded_at FROM telemetry_samples
+		WHERE machine_id = $1 AND recorded_at >= $2 AND recorded_at <= $3
+		  AND (cardinality($4::int[]) = 0 OR key = ANY($4::int[]))
+		ORDER BY key, recorded_at
+		LIMIT $5`
+	keys := make([]int64, len(q.Keys))
+	for i, k := range q.Keys {
+		keys[i] = int64(k)
+	}
+	// One more row than the cap tells a cut result from one of exactly the cap
+	if err := s.db.Select(&rows, query, q.MachineID, q.From, q.To, pq.Array(keys), maxTelemetryPoints+1); err != nil {
+		return nil, false, err
+	}
+	truncated := len(rows) > maxTelemetryPoints
+	if truncated {
+		rows = rows[:maxTelemetryPoints]
+	}
+
+	series := []models.TelemetrySeries{}
+	for _, r := range rows {
+		if len(series) == 0 || series[len(series)-1].Key != r.Key {
+			series = append(series, models.TelemetrySeries{Key: r.Key})
+		}
+		last := &series[len(series)-1]
+		last.Points = append(last.Points, r.TelemetryPoint)
+	}
+	for i := range series {
+		series[i].Points = bucketPoints(series[i].Points, q.Interval)
+	}
+	return series, truncated, nil
+}
+
+func (s *PostgresTelemetryStore) LatestSamples(machineID int) ([]models.ExchangeKeyValue, error) {
+	values := []models.ExchangeKeyValue{}
+	query := `
+		SELECT DISTINCT ON (key) key AS k, value AS v FROM telemetry_samples
+		WHERE machine_id = $1
+		ORDER BY key, recorded_at DESC`
+	err := s.db.Select(&values, query, machineID)
+	return values, err
+}
+
+func (s *PostgresTelemetryStore) ApplyRetention(policy models.TelemetryRetention, now time.Time) error {
+	if policy.Retention > 0 {
+		res, err := s.db.Exec(`DELETE FROM telemetry_samples WHERE recorded_at < $1`, now.Add(-policy.Retention))
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("[TELEMETRY] Deleted %d expired samples", n)
+		}
+	}
+	if policy.RawRetention > 0 && policy.DownsampleInterval > 0 {
+		// Keep the last sample of each (machine, key, bucket) older than the raw window
+		query := `
+			DELETE FROM telemetry_samples t USING (
+				SELECT ctid, row_number() OVER (
+					PARTITION BY machine_id, key, floor(extract(epoch FROM recorded_at) / $2)
+					ORDER BY recorded_at DESC
+				) AS rn
+				FROM telemetry_samples WHERE recorded_at < $1
+			) d
+			WHERE t.ctid = d.ctid AND d.rn > 1`
+		res, err := s.db.Exec(query, now.Add(-policy.RawRetention), policy.DownsampleInterval.Seconds())
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("[TELEMETRY] Downsampled %d samples", n)
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------
+// In-memory implementation (used when no database is configured)
+// ---------------------------------------------------------------------
+
+// DefaultTelemetryMemoryPoints caps MemoryTelemetryStore when MaxPoints is 0
+const DefaultTelemetryMemoryPoints = 500000
+
+// MemoryTelemetryStore keeps at most MaxPoints samples: past the cap, the
+// oldest samples of the fleet are dropped.
+type MemoryTelemetryStore struct {
+	MaxPoints int
+
+	mu      sync.RWMutex
+	samples map[int]map[int][]models.TelemetryPoint // machineID -> key -> points (oldest first)
+	total   int
+}
+
+func NewMemoryTelemetryStore() *MemoryTelemetryStore {
+	return &MemoryTelemetryStore{samples: make(map[int]map[int][]models.TelemetryPoint)}
+}
+
+func (s *MemoryTelemetryStore) maxPoints() int {
+	if s.MaxPoints > 0 {
+		return s.MaxPoints
+	}
+	return DefaultTelemetryMemoryPoints
+}
+
+// trim drops the oldest samples down to 90% of the cap, so that it runs once
+// per 10% of growth; the caller holds the lock
+func (s *MemoryTelemetryStore) trim() {
+	max := s.maxPoints()
+	if s.total <= max {
+		return
+	}
+	times := make([]time.Time, 0, s.total)
+	for _, byKey := range s.samples {
+		for _, points := range byKey {
+			for _, p := range points {
+				times = append(times, p.Time)
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	cutoff := times[len(times)-(max-max/10)]
+
+	dropped := s.total
+	s.total = 0
+	for machineID, byKey := range s.samples {
+		for k, points := range byKey {
+			i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(cutoff) })
+			if i == len(points) {
+				delete(byKey, k)
+				continue
+			}
+			byKey[k] = append([]models.TelemetryPoint(nil), points[i:]...)
+			s.total += len(byKey[k])
+		}
+		if len(byKey) == 0 {
+			delete(s.samples, machineID)
+		}
+	}
+	log.Printf("[TELEMETRY] Memory cap of %d samples reached: dropped %d samples older than %s",
+		max, dropped-s.total, cutoff.Format(time.RFC3339))
+}
+
+func (s *MemoryTelemetryStore) EnsureTableExists() error {
+	return nil
+}
+
+func (s *MemoryTelemetryStore) RecordSamples(machineID int, at time.Time, values []models.ExchangeKeyValue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byKey, ok := s.samples[machineID]
+	if !ok {
+		byKey = make(map[int][]models.TelemetryPoint)
+		s.samples[machineID] = byKey
+	}
+	for _, kv := range values {
+		byKey[kv.K] = append(byKey[kv.K], models.TelemetryPoint{Time: at, Value: kv.V})
+	}
+	s.total += len(values)
+	s.trim()
+	return nil
+}
+
+func (s *MemoryTelemetryStore) QueryTelemetry(q models.TelemetryQuery) ([]models.TelemetrySeries, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byKey := s.samples[q.MachineID]
+	keys := q.Keys
+	if len(keys) == 0 {
+		for k := range byKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Ints(keys)
+
+	series := []models.TelemetrySeries{}
+	total := 0
+	truncated := false
+	for _, k := range keys {
+		var points []models.TelemetryPoint
+		for _, p := range byKey[k] {
+			if p.Time.Before(q.From) || p.Time.After(q.To) {
+				continue
+			}
+			if total >= maxTelemetryPoints {
+				truncated = true
+				break
+			}
+			points = append(points, p)
+			total++
+		}
+		if len(points) > 0 {
+			series = append(series, models.TelemetrySeries{Key: k, Points: bucketPoints(points, q.Interval)})
+		}
+	}
+	return series, truncated, nil
+}
+
+func (s *MemoryTelemetryStore) LatestSamples(machineID int) ([]models.ExchangeKeyValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := []models.ExchangeKeyValue{}
+	for k, points := range s.samples[machineID] {
+		if len(points) > 0 {
+			values = append(values, models.ExchangeKeyValue{K: k, V: points[len(points)-1].Value})
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].K < values[j].K })
+	return values, nil
+}
+
+func (s *MemoryTelemetryStore) ApplyRetention(policy models.TelemetryRetention, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total = 0
+	for _, byKey := range s.samples {
+		for k, points := range byKey {
+			kept := make([]models.TelemetryPoint, 0, len(points))
+			var old []models.TelemetryPoint
+			for _, p := range points {
+				if policy.Retention > 0 && p.Time.Before(now.Add(-policy.Retention)) {
+					continue
+				}
+				if policy.RawRetention > 0 && p.Time.Before(now.Add(-policy.RawRetention)) {
+					old = append(old, p)
+					continue
+				}
+				kept = append(kept, p)
+			}
+			byKey[k] = append(bucketPoints(old, policy.DownsampleInterval), kept...)
+			s.total += len(byKey[k])
+		}
+	}
+	return nil
+}
+
+// telemetryFileSeries is the file format of FileTelemetryStore
+type telemetryFileSeries struct {
+	MachineID int                     `json:"machine_id"`
+	Key       int                     `json:"key"`
+	Points    []models.TelemetryPoint `json:"points"`
+}
+
+// FileTelemetryStore is MemoryTelemetryStore persisted to a JSON file, for a
+// single instance without database. The file is written after each retention
+// run (RunTelemetryRetention) and at Close: a crash loses the samples since.
+type FileTelemetryStore struct {
+	*MemoryTelemetryStore
+	saveMu   sync.Mutex // Orders the writes of concurrent snapshots
+	filePath string
+}
+
+func NewFileTelemetryStore(storagePath string, maxPoints int) *FileTelemetryStore {
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		log.Printf("Failed to create telemetry storage dir: %v", err)
+	}
+	s := &FileTelemetryStore{
+		MemoryTelemetryStore: NewMemoryTelemetryStore(),
+		filePath:             storagePath,
+	}
+	s.MaxPoints = maxPoints
+	s.load()
+	return s
+}
+
+func (s *FileTelemetryStore) load() {
+	b, err := os.ReadFile(s.filePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to open telemetry storage file: %v", err)
+		}
+		return
+	}
+	var series []telemetryFileSeries
+	if err := json.Unmarshal(b, &series); err != nil {
+		log.Printf("Failed to decode telemetry storage file: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range series {
+		if len(f.Points) == 0 {
+			continue
+		}
+		byKey, ok := s.samples[f.MachineID]
+		if !ok {
+			byKey = make(map[int][]models.TelemetryPoint)
+			s.samples[f.MachineID] = byKey
+		}
+		byKey[f.Key] = f.Points
+		s.total += len(f.Points)
+	}
+	s.trim() // The cap may have been lowered
+	log.Printf("Loaded %d telemetry samples.", s.total)
+}
+
+func (s *FileTelemetryStore) save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.RLock()
+	series := make([]telemetryFileSeries, 0, len(s.samples))
+	for machineID, byKey := range s.samples {
+		for k, points := range byKey {
+			series = append(series, telemetryFileSeries{MachineID: machineID, Key: k, Points: points})
+		}
+	}
+	b, err := json.Marshal(series)
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.filePath, b); err != nil {
+		log.Printf("Failed to write telemetry storage file: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *FileTelemetryStore) ApplyRetention(policy models.TelemetryRetention, now time.Time) error {
+	if err := s.MemoryTelemetryStore.ApplyRetention(policy, now); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+// Close writes the samples recorded since the last retention run
+func (s *FileTelemetryStore) Close() error {
+	return s.save()
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func TestMemoryTelemetryStoreDropsOldestPastCap(t *testing.T) {
+	s := NewMemoryTelemetryStore()
+	s.MaxPoints = 100
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 101; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		s.RecordSamples(1, at, []models.ExchangeKeyValue{{K: 350, V: "1"}})
+	}
+
+	series, _, err := s.QueryTelemetry(models.TelemetryQuery{MachineID: 1, From: start, To: start.Add(time.Hour * 2)})
+	if err != nil || len(series) != 1 {
+		t.Fatalf("QueryTelemetry() = %v, %v", series, err)
+	}
+	points := series[0].Points
+	if len(points) != 90 {
+		t.Fatalf("kept %d points, want 90", len(points))
+	}
+	if want := start.Add(11 * time.Minute); !points[0].Time.Equal(want) {
+		t.Errorf("oldest point kept at %s, want %s", points[0].Time, want)
+	}
+}
+
+func TestMemoryTelemetryStoreFlagsTruncatedQuery(t *testing.T) {
+	s := NewMemoryTelemetryStore()
+	s.MaxPoints = maxTelemetryPoints * 2
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxTelemetryPoints/2+1; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		s.RecordSamples(1, at, []models.ExchangeKeyValue{{K: 350, V: "1"}, {K: 351, V: "2"}})
+	}
+	q := models.TelemetryQuery{MachineID: 1, From: start, To: start.Add(24 * time.Hour)}
+
+	series, truncated, err := s.QueryTelemetry(q)
+	if err != nil || !truncated {
+		t.Fatalf("QueryTelemetry() truncated = %v, %v", truncated, err)
+	}
+	if n := len(series[0].Points) + len(series[1].Points); n != maxTelemetryPoints {
+		t.Errorf("returned %d points, want %d", n, maxTelemetryPoints)
+	}
+
+	q.Keys = []int{350}
+	if _, truncated, _ := s.QueryTelemetry(q); truncated {
+		t.Error("a single key fits and must not be flagged")
+	}
+}
+
+func TestFileTelemetryStoreKeepsSamples(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.json")
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	s := NewFileTelemetryStore(path, 0)
+	s.RecordSamples(7, at, []models.ExchangeKeyValue{{K: 350, V: "21.5"}, {K: 351, V: "on"}})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := NewFileTelemetryStore(path, 0).LatestSamples(7)
+	if err != nil || len(latest) != 2 {
+		t.Fatalf("LatestSamples() after reload = %v, %v", latest, err)
+	}
+	for _, kv := range latest {
+		if kv.K == 350 && kv.V != "21.5" {
+			t.Errorf("key 350 = %q, want 21.5", kv.V)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package data
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes returns models.RecoveryCodeCount random codes, formatted
+// "xxxxx-xxxxx", and their hashes
+func NewRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < models.RecoveryCodeCount; i++ {
+		var b [7]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(err)
+		}
+		c := strings.ToLower(recoveryEncoding.EncodeToString(b[:]))[:10]
+		code := c[:5] + "-" + c[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// HashRecoveryCode is the stored form of a recovery code; case and dashes
+// typed by the user do not matter
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	return HashEmailToken(code)
+}
+
+// TwoFactorStore keeps the TOTP secrets and the hashed recovery codes
+type TwoFactorStore interface {
+	EnsureTableExists() error
+	// GetTwoFactor returns the enrolment of a user, nil if none
+	GetTwoFactor(userID int) (*models.TwoFactor, error)
+	// SetTwoFactorSecret starts an enrolment, replacing a pending one
+	SetTwoFactorSecret(userID int, secret string, now time.Time) error
+	// EnableTwoFactor confirms the enrolment and sets the recovery codes
+	EnableTwoFactor(userID int, counter int64, now time.Time, recoveryHashes []string) error
+	// UseTwoFactorCounter records an accepted code; false if its time step
+	// is not after the last one (replay)
+	UseTwoFactorCounter(userID int, counter int64) (bool, error)
+	// UseRecoveryCode consumes a recovery code; false if unknown or used
+	UseRecoveryCode(userID int, hash string, now time.Time) (bool, error)
+	ReplaceRecoveryCodes(userID int, hashes []string) error
+	// CountRecoveryCodes returns the number of unused recovery codes
+	CountRecoveryCodes(userID int) (int, error)
+	// DeleteTwoFactor removes the enrolment and the recovery codes
+	DeleteTwoFactor(userID int) error
+}
+
+// ---------------------------------------------------------------------
+// Postgres implementation
+// ---------------------------------------------------------------------
+
+type PostgresTwoFactorStore struct {
+	db *sqlx.DB
+}
+
+func NewPostgresTwoFactorStore(db *sqlx.DB) *PostgresTwoFactorStore {
+	return &PostgresTwoFactorStore{db: db}
+}
+
+func (s *PostgresTwoFactorStore) EnsureTableExists() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS user_two_factor (
+		user_id INT PRIMARY KEY,
+		secret VARCHAR(64) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		enabled_at TIMESTAMP WITH TIME ZONE NULL,
+		last_counter BIGINT NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS user_recovery_codes (
+		hash VARCHAR(64) PRIMARY KEY,
+		user_id INT NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_recovery_codes_user_id ON user_recovery_codes(user_id);
+	`
+	_, err := s.db.Exec(schema)
+	return err
+}
+
+func (s *PostgresTwoFactorStore) GetTwoFactor(userID int) (*models.TwoFactor, error) {
+	var tf models.TwoFactor
+	err := s.db.Get(&tf, `SELECT user_id, secret, created_at, enabled_at, last_counter FROM user_two_factor WHERE user_id = $1`, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+func (s *PostgresTwoFactorStore) SetTwoFactorSecret(userID int, secret string, now time.Time) error {
+	_, err := s.db.Exec(`
+		INSERT INTO user_two_factor (user_id, secret, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_counter = 0
+		WHERE user_two_factor.enabled_at IS NULL`, userID, secret, now)
+	return err
+}
+
+func (s *PostgresTwoFactorStore) EnableTwoFactor(userID int, counter int64, now time.Time, recoveryHashes []string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE user_two_factor SET enabled_at = $1, last_counter = $2 WHERE user_id = $3`, now, counter, userID); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresTwoFactorStore) UseTwoFactorCounter(userID int, counter int64) (bool, error) {
+	res, err := s.db.Exec(`UPDATE user_two_factor SET last_counter = $1 WHERE user_id = $2 AND last_counter < $1`, counter, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *PostgresTwoFactorStore) UseRecoveryCode(userID int, hash string, now time.Time) (bool, error) {
+	res, err := s.db.Exec(`UPDATE user_recovery_codes SET used_at = $1 WHERE hash = $2 AND user_id = $3 AND used_at IS NULL`, now, hash, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func replaceRecoveryCodes(tx *sqlx.Tx, userID int, hashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	for _, h := range hashes {
+		if _, err := tx.Exec(`INSERT INTO user_recovery_codes (hash, user_id) VALUES ($1, $2)`, h, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgresTwoFactorStore) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresTwoFactorStore) CountRecoveryCodes(userID int) (int, error) {
+	var n int
+	err := s.db.Get(&n, `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID)
+	return n, err
+}
+
+func (s *PostgresTwoFactorStore) DeleteTwoFactor(userID int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM user_two_factor WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ---------------------------------------------------------------------
+// In-memory implementation (used when no database is configured)
+// ---------------------------------------------------------------------
+
+type MemoryTwoFactorStore struct {
+	mu       sync.Mutex
+	factors  map[int]*models.TwoFactor
+	recovery map[int]map[string]bool // user -> hash -> used
+}
+
+func NewMemoryTwoFactorStore() *MemoryTwoFactorStore {
+	return &MemoryTwoFactorStore{
+		factors:  make(map[int]*models.TwoFactor),
+		recovery: make(map[int]map[string]bool),
+	}
+}
+
+func (s *MemoryTwoFactorStore) EnsureTableExists() error {
+	return nil
+}
+
+func (s *MemoryTwoFactorStore) GetTwoFactor(userID int) (*models.TwoFactor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tf, ok := s.factors[userID]
+	if !ok {
+		return nil, nil
+	}
+	out := *tf
+	return &out, nil
+}
+
+func (s *MemoryTwoFactorStore) SetTwoFactorSecret(userID int, secret string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tf, ok := s.factors[userID]; ok && tf.Enabled() {
+		return nil
+	}
+	s.factors[userID] = &models.TwoFactor{UserID: userID, Secret: secret, CreatedAt: now}
+	return nil
+}
+
+func (s *MemoryTwoFactorStore) EnableTwoFactor(userID int, counter int64, now time.Time, recoveryHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tf, ok := s.factors[userID]; ok {
+		tf.EnabledAt, tf.LastCounter = &now, counter
+	}
+	s.setRecoveryCodes(userID, recoveryHashes)
+	return nil
+}
+
+func (s *MemoryTwoFactorStore) UseTwoFactorCounter(userID int, counter int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tf, ok := s.factors[userID]
+	if !ok || counter <= tf.LastCounter {
+		return false, nil
+	}
+	tf.LastCounter = counter
+	return true, nil
+}
+
+func (s *MemoryTwoFactorStore) UseRecoveryCode(userID int, hash string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recovery[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	s.recovery[userID][hash] = true
+	return true, nil
+}
+
+func (s *MemoryTwoFactorStore) setRecoveryCodes(userID int, hashes []string) {
+	codes := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		codes[h] = false
+	}
+	s.recovery[userID] = codes
+}
+
+func (s *MemoryTwoFactorStore) ReplaceRecoveryCodes(userID int, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.setRecoveryCodes(userID, hashes)
+	return nil
+}
+
+func (s *MemoryTwoFactorStore) CountRecoveryCodes(userID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, used := range s.recovery[userID] {
+		if !used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryTwoFactorStore) DeleteTwoFactor(userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.factors, userID)
+	delete(s.recovery, userID)
+	return nil
+}
@@ -23,6 +23,8 @@ type UserStore interface {
     GetUsersByMachineID(machineID int) ([]*models.User, error)
     HasLocalAdmin(machineID int) (bool, error)
     UpdateUser(userID int, firstName, lastName, passwordHash string) error
+    UpdatePassword(userID int, passwordHash string) error
+    SetEmailVerified(userID int, at time.Time) error
     DeleteUser(userID int) error
     ForbidUser(userID int) error
     UnforbidUser(userID int) error
@@ -60,6 +62,16 @@ func (s *PostgresUserStore) EnsureTableExists() error {
     ALTER TABLE users ADD COLUMN IF NOT EXISTS linked_gateway_id VARCHAR(255);
     ALTER TABLE users ADD COLUMN IF NOT EXISTS linked_armoire_id INT;
     ALTER TABLE users ADD COLUMN IF NOT EXISTS forbidden_at TIMESTAMPTZ NULL;
+
+    -- Accounts created before e-mail verification are considered verified
+    DO $$
+    BEGIN
+        IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                       WHERE table_name = 'users' AND column_name = 'verified_at') THEN
+            ALTER TABLE users ADD COLUMN verified_at TIMESTAMPTZ NULL;
+            UPDATE users SET verified_at = COALESCE(created_at, NOW());
+        END IF;
+    END $$;
     `
     _, err := s.db.Exec(query)
     return err
@@ -67,8 +79,8 @@ func (s *PostgresUserStore) EnsureTableExists() error {
 
 func (s *PostgresUserStore) CreateUser(u *models.User) error {
     query := `
-        INSERT INTO users (email, password_hash, role, first_name, last_name, provider, provider_id, created_at, last_login)
-        VALUES (:email, :password_hash, :role, :first_name, :last_name, :provider, :provider_id, :created_at, :last_login)
+        INSERT INTO users (email, password_hash, role, first_name, last_name, provider, provider_id, created_at, last_login, verified_at)
+        VALUES (:email, :password_hash, :role, :first_name, :last_name, :provider, :provider_id, :created_at, :last_login, :verified_at)
         RETURNING id`
     
     rows, err := s.db.NamedQuery(query, u)
@@ -158,6 +170,18 @@ func (s *PostgresUserStore) UpdateUser(userID int, firstName, lastName, password
     return err
 }
 
+func (s *PostgresUserStore) UpdatePassword(userID int, passwordHash string) error {
+    _, err := s.db.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, passwordHash, userID)
+    return err
+}
+
+// SetEmailVerified records the verification of the e-mail of a user; the
+// first verification is kept
+func (s *PostgresUserStore) SetEmailVerified(userID int, at time.Time) error {
+    _, err := s.db.Exec(`UPDATE users SET verified_at = COALESCE(verified_at, $1) WHERE id = $2`, at, userID)
+    return err
+}
+
 func (s *PostgresUserStore) DeleteUser(userID int) error {
     query := `DELETE FROM users WHERE id = $1`
     _, err := s.db.Exec(query, userID)
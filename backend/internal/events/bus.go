@@ -0,0 +1,126 @@
+// Package events is the in-process bus the stores publish fleet changes to,
+// for the live admin stream (GET /api/admin/events).
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types
+const (
+	MachineHeartbeat    = "machine.heartbeat"    // Authenticated poll of a machine
+	MachineRegistered   = "machine.registered"   // Unknown machine created (inactive)
+	MachineRegistration = "machine.registration" // Unknown key queued for review
+	MachineGeo          = "machine.geo"          // Location of a new machine IP
+	GatewayReport       = "gateway.report"       // POST /api/infos
+	GatewayGeo          = "gateway.geo"
+	Audit               = "audit"
+)
+
+// Event is one change. MachineID and Gateway scope it: subscribers only
+// receive the events of the machines and gateways they can see.
+type Event struct {
+	ID        uint64      `json:"id"`
+	Type      string      `json:"type"`
+	Time      time.Time   `json:"time"`
+	MachineID int         `json:"machine_id,omitempty"`
+	Gateway   string      `json:"gateway,omitempty"`
+	Data      interface{} `json:"data"`
+}
+
+// Subscription receives the events accepted by its filter on C
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	filter  func(Event) bool
+	dropped atomic.Uint64
+	bus     *Bus
+	once    sync.Once
+}
+
+// Dropped returns and resets the number of events lost since the last call
+// because the subscriber did not keep up
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// Close unsubscribes; C is closed
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.c)
+	})
+}
+
+// Bus fans events out to the subscribers. Publishing never blocks: a
+// subscriber whose buffer is full loses the event.
+type Bus struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	nextID atomic.Uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish sends e to the subscribers. A nil bus discards it, so stores
+// without a bus need no check.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	e.ID = b.nextID.Add(1)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events accepted by filter (nil for
+// all), buffered up to buffer events
+func (b *Bus) Subscribe(filter func(Event) bool, buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c, filter: filter, bus: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Close ends every subscription, e.g. at shutdown so streams return
+func (b *Bus) Close() {
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.RUnlock()
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+// Subscribers is the number of open subscriptions
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
@@ -0,0 +1,83 @@
+// Package geo resolves the public IP of machines and gateways to a location
+// shown on the admin map.
+package geo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrNotFound is returned when the provider has no location for an address
+var ErrNotFound = errors.New("location not found")
+
+// Location of an IP address
+type Location struct {
+	City    string
+	Country string
+	ISP     string
+	Lat     float64
+	Lon     float64
+}
+
+// Label is the text stored as GeoLocation, e.g. "Paris, France (Orange)"
+func (l *Location) Label() string {
+	place := l.Country
+	if l.City != "" {
+		place = fmt.Sprintf("%s, %s", l.City, l.Country)
+	}
+	if l.ISP == "" {
+		return place
+	}
+	return fmt.Sprintf("%s (%s)", place, l.ISP)
+}
+
+// CountryFromLabel returns the country of a label written by Label, for
+// locations stored before the country was kept apart
+func CountryFromLabel(label string) string {
+	if i := strings.LastIndex(label, " ("); i >= 0 && strings.HasSuffix(label, ")") {
+		label = label[:i]
+	}
+	if i := strings.LastIndex(label, ", "); i >= 0 {
+		label = label[i+2:]
+	}
+	return label
+}
+
+// GeoLocator looks up one address. Implementations are safe for concurrent use.
+type GeoLocator interface {
+	Lookup(ip net.IP) (*Location, error)
+}
+
+// NoopLocator disables geolocation
+type NoopLocator struct{}
+
+func (NoopLocator) Lookup(ip net.IP) (*Location, error) {
+	return nil, ErrNotFound
+}
+
+// cgnat is the shared address space of carrier-grade NAT (RFC 6598)
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// PublicIP parses ip (a port is ignored) and returns nil for addresses that
+// cannot be located: invalid, loopback, private (RFC 1918, IPv6 ULA),
+// link-local, CGNAT, multicast or unspecified
+func PublicIP(ip string) net.IP {
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil ||
+		parsed.IsLoopback() ||
+		parsed.IsPrivate() ||
+		parsed.IsLinkLocalUnicast() ||
+		parsed.IsLinkLocalMulticast() ||
+		parsed.IsInterfaceLocalMulticast() ||
+		parsed.IsMulticast() ||
+		parsed.IsUnspecified() ||
+		cgnat.Contains(parsed) {
+		return nil
+	}
+	return parsed
+}
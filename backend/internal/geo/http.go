@@ -0,0 +1,146 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// DefaultHTTPURL is the free ip-api.com endpoint (HTTP only, 45 requests/minute)
+const DefaultHTTPURL = "http://ip-api.com/json/%s"
+
+// HTTPLocator queries an ip-api.com compatible service. Results, including
+// misses, are cached for TTL, and requests are spaced to stay under the
+// provider's rate limit.
+type HTTPLocator struct {
+	url        string // fmt pattern, %s is the IP
+	client     *http.Client
+	interval   time.Duration
+	ttl        time.Duration
+	maxEntries int
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	next  time.Time // Earliest start of the next request
+}
+
+type cacheEntry struct {
+	loc     *Location // nil for a miss
+	expires time.Time
+}
+
+func NewHTTPLocator(url string, perMinute int, ttl time.Duration, maxEntries int) *HTTPLocator {
+	if url == "" {
+		url = DefaultHTTPURL
+	}
+	var interval time.Duration
+	if perMinute > 0 {
+		interval = time.Minute / time.Duration(perMinute)
+	}
+	return &HTTPLocator{
+		url:        url,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		interval:   interval,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		cache:      make(map[string]cacheEntry),
+	}
+}
+
+func (l *HTTPLocator) Lookup(ip net.IP) (*Location, error) {
+	key := ip.String()
+	if loc, ok := l.cached(key); ok {
+		if loc == nil {
+			return nil, ErrNotFound
+		}
+		return loc, nil
+	}
+
+	l.wait()
+	loc, err := l.fetch(key)
+	if err != nil && err != ErrNotFound {
+		return nil, err // Transient: not cached
+	}
+	l.store(key, loc)
+	if loc == nil {
+		return nil, ErrNotFound
+	}
+	return loc, nil
+}
+
+func (l *HTTPLocator) fetch(ip string) (*Location, error) {
+	resp, err := l.client.Get(fmt.Sprintf(l.url, ip))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geo provider returned %s", resp.Status)
+	}
+
+	var geo models.GeoAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&geo); err != nil {
+		return nil, err
+	}
+	if geo.Status != "success" {
+		return nil, ErrNotFound
+	}
+	return &Location{City: geo.City, Country: geo.Country, ISP: geo.ISP, Lat: geo.Lat, Lon: geo.Lon}, nil
+}
+
+// wait blocks until the rate limit allows one more request
+func (l *HTTPLocator) wait() {
+	if l.interval <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+func (l *HTTPLocator) cached(ip string) (*Location, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.cache[ip]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.loc, true
+}
+
+func (l *HTTPLocator) store(ip string, loc *Location) {
+	if l.ttl <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.maxEntries > 0 && len(l.cache) >= l.maxEntries {
+		for k, e := range l.cache {
+			if now.After(e.expires) {
+				delete(l.cache, k)
+			}
+		}
+		// Still full: drop arbitrary entries
+		for k := range l.cache {
+			if len(l.cache) < l.maxEntries {
+				break
+			}
+			delete(l.cache, k)
+		}
+	}
+	l.cache[ip] = cacheEntry{loc: loc, expires: now.Add(l.ttl)}
+}
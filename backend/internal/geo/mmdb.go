@@ -0,0 +1,96 @@
+package geo
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MMDBLocator reads a MaxMind DB file (GeoLite2-City, GeoIP2-City, DB-IP
+// City Lite, ASN or ISP databases...). Lookups are offline and need no locking.
+type MMDBLocator struct {
+	db       *maxminddb.Reader
+	language string
+}
+
+// mmdbRecord holds the fields used by Lookup. City databases have the names
+// and the location; ISP and ASN databases use top-level keys, City+ISP ones
+// use traits.
+type mmdbRecord struct {
+	City              mmdbNames `maxminddb:"city"`
+	Country           mmdbNames `maxminddb:"country"`
+	RegisteredCountry mmdbNames `maxminddb:"registered_country"`
+	Location          struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	mmdbISP
+	Traits mmdbISP `maxminddb:"traits"`
+}
+
+type mmdbNames struct {
+	Names map[string]string `maxminddb:"names"`
+}
+
+type mmdbISP struct {
+	ISP          string `maxminddb:"isp"`
+	Organization string `maxminddb:"organization"`
+	ASO          string `maxminddb:"autonomous_system_organization"`
+}
+
+// NewMMDBLocator opens path. language selects the names ("en", "fr"...),
+// falling back to English.
+func NewMMDBLocator(path, language string) (*MMDBLocator, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if language == "" {
+		language = "en"
+	}
+	return &MMDBLocator{db: db, language: language}, nil
+}
+
+// Close releases the database file
+func (l *MMDBLocator) Close() error {
+	return l.db.Close()
+}
+
+func (l *MMDBLocator) Lookup(ip net.IP) (*Location, error) {
+	var record mmdbRecord
+	_, found, err := l.db.LookupNetwork(ip, &record)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	loc := &Location{
+		City:    l.name(record.City),
+		Country: l.name(record.Country),
+		Lat:     record.Location.Latitude,
+		Lon:     record.Location.Longitude,
+	}
+	if loc.Country == "" {
+		loc.Country = l.name(record.RegisteredCountry)
+	}
+	for _, s := range []string{record.ISP, record.Traits.ISP, record.Organization, record.Traits.Organization, record.ASO, record.Traits.ASO} {
+		if s != "" {
+			loc.ISP = s
+			break
+		}
+	}
+	if loc.Country == "" && loc.Lat == 0 && loc.Lon == 0 {
+		return nil, ErrNotFound
+	}
+	return loc, nil
+}
+
+// name returns the localized name of a city or a country
+func (l *MMDBLocator) name(n mmdbNames) string {
+	if s, ok := n.Names[l.language]; ok {
+		return s
+	}
+	return n.Names["en"]
+}
@@ -0,0 +1,84 @@
+package geo
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// The test databases come from github.com/maxmind/MaxMind-DB, see testdata/README.md
+
+func TestMMDBLocatorCity(t *testing.T) {
+	tests := []struct {
+		language string
+		ip       string
+		want     Location
+	}{
+		{"", "81.2.69.142", Location{City: "London", Country: "United Kingdom", Lat: 51.5142, Lon: -0.0931}},
+		{"fr", "81.2.69.142", Location{City: "Londres", Country: "Royaume-Uni", Lat: 51.5142, Lon: -0.0931}},
+		{"fr", "2.125.160.216", Location{City: "Boxford", Country: "Royaume-Uni", Lat: 51.75, Lon: -1.25}}, // No French name: English
+		{"en", "::ffff:89.160.20.115", Location{City: "Linköping", Country: "Sweden", Lat: 58.4167, Lon: 15.6167}},
+		{"en", "2001:218::1", Location{Country: "Japan", Lat: 35.68536, Lon: 139.75309}},
+	}
+	for _, tt := range tests {
+		l, err := NewMMDBLocator("testdata/GeoIP2-City-Test.mmdb", tt.language)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := l.Lookup(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Errorf("Lookup(%s) error: %v", tt.ip, err)
+		} else if *got != tt.want {
+			t.Errorf("Lookup(%s) = %+v, want %+v", tt.ip, *got, tt.want)
+		}
+		l.Close()
+	}
+}
+
+func TestMMDBLocatorNotFound(t *testing.T) {
+	l, err := NewMMDBLocator("testdata/GeoIP2-City-Test.mmdb", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for _, ip := range []string{"192.0.2.1", "2001:db8::1"} {
+		if _, err := l.Lookup(net.ParseIP(ip)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Lookup(%s) error = %v, want ErrNotFound", ip, err)
+		}
+	}
+}
+
+func TestMMDBLocatorISPFromTraits(t *testing.T) {
+	l, err := NewMMDBLocator("testdata/GeoIP2-Enterprise-Test.mmdb", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	got, err := l.Lookup(net.ParseIP("81.2.69.160"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "London, United Kingdom (Andrews & Arnold Ltd)"; got.Label() != want {
+		t.Errorf("Label() = %q, want %q", got.Label(), want)
+	}
+}
+
+func TestMMDBLocatorBrokenFile(t *testing.T) {
+	l, err := NewMMDBLocator("testdata/MaxMind-DB-test-broken-pointers-24.mmdb", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Pointers out of the data section: an error, neither a panic nor a hang
+	for _, ip := range []string{"1.1.1.16", "1.1.1.32"} {
+		if loc, err := l.Lookup(net.ParseIP(ip)); err == nil {
+			t.Errorf("Lookup(%s) = %+v, want an error", ip, loc)
+		}
+	}
+}
+
+func TestMMDBLocatorMissingFile(t *testing.T) {
+	if _, err := NewMMDBLocator("testdata/missing.mmdb", "en"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,79 @@
+package geo
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// Resolver runs lookups on a fixed number of workers. Requests for an address
+// already queued are merged, and requests beyond the queue size are dropped:
+// the next heartbeat asks again.
+type Resolver struct {
+	locator GeoLocator
+	jobs    chan string
+
+	mu      sync.Mutex
+	pending map[string][]func(*Location) // IP -> callbacks
+}
+
+func NewResolver(locator GeoLocator, workers, queueSize int) *Resolver {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	r := &Resolver{
+		locator: locator,
+		jobs:    make(chan string, queueSize),
+		pending: make(map[string][]func(*Location)),
+	}
+	for i := 0; i < workers; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// Resolve looks up ip in the background and calls done with the result, from
+// a worker goroutine. Addresses that cannot be located are ignored, and done
+// is not called on failure.
+func (r *Resolver) Resolve(ip string, done func(*Location)) {
+	if r == nil || PublicIP(ip) == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if callbacks, ok := r.pending[ip]; ok {
+		r.pending[ip] = append(callbacks, done)
+		return
+	}
+	select {
+	case r.jobs <- ip:
+		r.pending[ip] = []func(*Location){done}
+	default:
+		log.Printf("Geo: queue full, skipping %s", ip)
+	}
+}
+
+func (r *Resolver) work() {
+	for ip := range r.jobs {
+		loc, err := r.locator.Lookup(PublicIP(ip))
+
+		r.mu.Lock()
+		callbacks := r.pending[ip]
+		delete(r.pending, ip)
+		r.mu.Unlock()
+
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Printf("Geo Fetch Error for %s: %v", ip, err)
+			}
+			continue
+		}
+		for _, done := range callbacks {
+			done(loc)
+		}
+	}
+}
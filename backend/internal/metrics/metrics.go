@@ -0,0 +1,53 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Metrics of the backend. Gauges read from the store (presence, gateway
+// usage) are registered by the API router, see api.Router.RegisterMetrics.
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "essensys_http_requests_total",
+		Help: "HTTP requests by chi route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "essensys_http_request_duration_seconds",
+		Help:    "HTTP request latency by chi route pattern and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	LegacyAuth = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "essensys_legacy_auth_total",
+		Help: "Basic Auth attempts of the legacy clients by outcome (success, unknown, inactive, malformed).",
+	}, []string{"outcome"})
+
+	NewsletterEmails = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "essensys_newsletter_emails_total",
+		Help: "Newsletter e-mails by result (sent, failed).",
+	}, []string{"result"})
+	NewsletterSends = factory.NewCounter(prometheus.CounterOpts{
+		Name: "essensys_newsletter_sends_total",
+		Help: "Newsletters sent to the subscribers.",
+	})
+
+	AuditWriteFailures = factory.NewCounter(prometheus.CounterOpts{
+		Name: "essensys_audit_write_failures_total",
+		Help: "Audit log entries that could not be written.",
+	})
+)
+
+// Legacy auth outcomes
+const (
+	AuthSuccess   = "success"
+	AuthUnknown   = "unknown"
+	AuthInactive  = "inactive"
+	AuthMalformed = "malformed"
+)
+
+func init() {
+	// Exposed at zero before the first event, so that rate() sees it start
+	for _, outcome := range []string{AuthSuccess, AuthUnknown, AuthInactive, AuthMalformed} {
+		LegacyAuth.WithLabelValues(outcome)
+	}
+	NewsletterEmails.WithLabelValues("sent")
+	NewsletterEmails.WithLabelValues("failed")
+}
@@ -0,0 +1,73 @@
+// Package metrics holds the Prometheus metrics of the backend, served by
+// Handler on GET /metrics with the process and Go runtime metrics.
+package metrics
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default is the registry of the metrics of this package, the process
+// (process_*) and the Go runtime (go_*)
+var Default = prometheus.NewRegistry()
+
+var factory = promauto.With(Default)
+
+func init() {
+	Default.MustRegister(
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewGoCollector(),
+	)
+}
+
+// Handler serves the metrics of Default
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Default, promhttp.HandlerOpts{
+		ErrorLog:      log.Default(),
+		ErrorHandling: promhttp.ContinueOnError, // A failing collector does not hide the others
+	})
+}
+
+// Sample is one value of a metric, with its label values in the order of
+// the metric's labels
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// gaugeFunc is a gauge whose samples are read at scrape time, e.g. from the store
+type gaugeFunc struct {
+	desc    *prometheus.Desc
+	labels  int
+	collect func() ([]Sample, error)
+}
+
+// NewGaugeFunc returns a gauge whose samples are returned by collect on
+// every scrape, to register with Default. A failing collect is logged and
+// the gauge left out.
+func NewGaugeFunc(name, help string, labels []string, collect func() ([]Sample, error)) prometheus.Collector {
+	return &gaugeFunc{desc: prometheus.NewDesc(name, help, labels, nil), labels: len(labels), collect: collect}
+}
+
+func (g *gaugeFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *gaugeFunc) Collect(ch chan<- prometheus.Metric) {
+	samples, err := g.collect()
+	if err != nil {
+		log.Printf("[METRICS] Failed to collect %s: %v", g.desc, err)
+		return
+	}
+	for _, s := range samples {
+		if len(s.Labels) != g.labels {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, s.Value, s.Labels...)
+	}
+}
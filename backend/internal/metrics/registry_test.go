@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandlerExposesRuntimeAndGauges(t *testing.T) {
+	gauges := []prometheus.Collector{
+		NewGaugeFunc("test_gateways", "Gateways.", []string{"state"}, func() ([]Sample, error) {
+			return []Sample{{Labels: []string{"online"}, Value: 2}, {Labels: nil, Value: 9}}, nil
+		}),
+		NewGaugeFunc("test_failing", "Failing.", nil, func() ([]Sample, error) {
+			return nil, errors.New("store down")
+		}),
+	}
+	for _, g := range gauges {
+		Default.MustRegister(g)
+		defer Default.Unregister(g)
+	}
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	for _, want := range []string{
+		`test_gateways{state="online"} 2`,
+		`essensys_legacy_auth_total{outcome="malformed"} 0`,
+		"go_goroutines ",
+		"process_cpu_seconds_total ",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+	if strings.Contains(string(body), "test_failing") || strings.Contains(string(body), " 9\n") {
+		t.Error("failing or malformed samples exposed")
+	}
+}
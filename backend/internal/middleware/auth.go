@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/subtle"
 	"encoding/base64"
+	"errors"
     "fmt"
 	"log"
 	"net/http"
@@ -12,17 +13,28 @@ import (
 
     "github.com/golang-jwt/jwt/v4"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/metrics"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
 )
 
 type contextKey string
 
 const (
-	ClientIDKey contextKey = "clientID"
+	ClientIDKey  contextKey = "clientID"
+	MachineIDKey contextKey = "machineID" // int, only set for authenticated machines
+	MachineKey   contextKey = "machine"   // *models.Machine, only set for authenticated machines
 )
 
 // BasicAuthMiddleware implements the Legacy IoT Authentication Protocol
 // strict=true: Require valid Auth. strict=false: Allow anonymous if headers missing.
+// Unknown machines are registered without limits; see BasicAuthMiddlewareWithGuard.
 func BasicAuthMiddleware(store data.Store, strict bool) func(http.Handler) http.Handler {
+	return BasicAuthMiddlewareWithGuard(store, nil, strict)
+}
+
+// BasicAuthMiddlewareWithGuard is BasicAuthMiddleware with unknown machines
+// admitted through a RegistrationGuard (policy, rate limits, review queue).
+func BasicAuthMiddlewareWithGuard(store data.Store, guard *RegistrationGuard, strict bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			
@@ -42,6 +54,7 @@ func BasicAuthMiddleware(store data.Store, strict bool) func(http.Handler) http.
 			encodedCredentials := authHeader[6:] 
 			decodedBytes, err := base64.StdEncoding.DecodeString(encodedCredentials)
 			if err != nil {
+                metrics.LegacyAuth.WithLabelValues(metrics.AuthMalformed).Inc()
                 if !strict {
                     log.Printf("BasicAuth (Lax): Invalid Base64, proceeding as anonymous")
                     ctx := context.WithValue(r.Context(), ClientIDKey, "anonymous")
@@ -57,6 +70,7 @@ func BasicAuthMiddleware(store data.Store, strict bool) func(http.Handler) http.
 			credentials := string(decodedBytes)
 			parts := strings.SplitN(credentials, ":", 2)
 			if len(parts) != 2 {
+                metrics.LegacyAuth.WithLabelValues(metrics.AuthMalformed).Inc()
                 if !strict {
                     log.Printf("BasicAuth (Lax): Invalid format, proceeding as anonymous")
                     ctx := context.WithValue(r.Context(), ClientIDKey, "anonymous")
@@ -74,23 +88,47 @@ func BasicAuthMiddleware(store data.Store, strict bool) func(http.Handler) http.
 			// 3. Reconstruct Hash
 			hashedPkey := username + password
 
-			// 4. Validate against Store
-			machine, err := store.GetMachineByHashedPkey(hashedPkey)
+			// Reject malformed keys before any store call
+			if !isValidHashedPkey(hashedPkey) {
+                metrics.LegacyAuth.WithLabelValues(metrics.AuthMalformed).Inc()
+                if !strict {
+                    log.Printf("BasicAuth (Lax): Malformed key, proceeding as anonymous")
+                    serveAnonymous(next, w, r)
+                    return
+                }
+				log.Printf("BasicAuth: Malformed key (%d chars)", len(hashedPkey))
+				unauthorized(w)
+				return
+			}
+
+			// 4. Validate against Store, which only knows the hash of the key
+			key := data.MachineKey(hashedPkey)
+			machine, err := store.GetMachineByHashedPkey(key)
 			if err != nil || machine == nil {
-                // AUTO-REGISTER if unknown
-                log.Printf("BasicAuth: Unknown Machine Hash %s... Registering...", hashedPkey[:10])
-                m, errReg := store.RegisterUnknownMachine(hashedPkey)
-                if errReg == nil {
-                    machine = m
+                // Unknown machine: register it, queue it or refuse it
+                metrics.LegacyAuth.WithLabelValues(metrics.AuthUnknown).Inc()
+                log.Printf("BasicAuth: Unknown Machine Hash %s...", key[:10])
+                var errReg error
+                if guard == nil {
+                    machine, errReg = store.RegisterUnknownMachine(key, "")
                 } else {
-                     // Fallback if registration fails (db error)
+                    machine, errReg = guard.admit(store, hashedPkey, r.RemoteAddr)
+                }
+                if errReg != nil {
                      if !strict {
-                         log.Printf("BasicAuth (Lax): Auth failed & Reg failed, proceeding as anonymous")
-                         ctx := context.WithValue(r.Context(), ClientIDKey, "anonymous")
-                         next.ServeHTTP(w, r.WithContext(ctx))
+                         log.Printf("BasicAuth (Lax): Unknown machine not admitted (%v), proceeding as anonymous", errReg)
+                         serveAnonymous(next, w, r)
                          return
                      }
-                     unauthorized(w)
+                     switch {
+                     case errors.Is(errReg, errRegistrationLimited):
+                         http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+                     case errors.Is(errReg, errRegistrationPending):
+                         http.Error(w, "Machine pending approval", http.StatusForbidden)
+                     default:
+                         log.Printf("BasicAuth: Unknown machine not admitted: %v", errReg)
+                         unauthorized(w)
+                     }
                      return
                 }
 			}
@@ -99,10 +137,16 @@ func BasicAuthMiddleware(store data.Store, strict bool) func(http.Handler) http.
             
             // Capture Connection Details (RealIP middleware ensures RemoteAddr is correct)
             // We capture detail BEFORE enforcing IsActive, so Admins can see the attempt/IP
-            store.UpdateMachineStatus(hashedPkey, r.RemoteAddr, encodedCredentials, credentials)
+            // Only a fingerprint of the credentials is kept, never the credentials
+            outcome := models.AuthOutcomeSuccess
+            if !machine.IsActive {
+                outcome = models.AuthOutcomeInactive
+            }
+            store.UpdateMachineStatus(key, r.RemoteAddr, outcome, len(username))
 
             // 5. Check Active Status
             if !machine.IsActive {
+                metrics.LegacyAuth.WithLabelValues(metrics.AuthInactive).Inc()
                 if !strict {
                     // Lax mode: Inactive machine treated as anonymous
                     log.Printf("BasicAuth (Lax): Machine %s is INACTIVE, proceeding as anonymous", machine.NoSerie)
@@ -117,14 +161,22 @@ func BasicAuthMiddleware(store data.Store, strict bool) func(http.Handler) http.
             }
 
 			// Auth Success - Inject Client ID context
+			metrics.LegacyAuth.WithLabelValues(metrics.AuthSuccess).Inc()
 			log.Printf("BasicAuth: Success for machine %s (ID: %d)", machine.NoSerie, machine.ID)
             
 			ctx := context.WithValue(r.Context(), ClientIDKey, machine.NoSerie)
+			ctx = context.WithValue(ctx, MachineIDKey, machine.ID)
+			ctx = context.WithValue(ctx, MachineKey, machine)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+func serveAnonymous(next http.Handler, w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), ClientIDKey, "anonymous")
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 func unauthorized(w http.ResponseWriter) {
 	// MANDATORY for legacy client to retry
 	w.Header().Set("WWW-Authenticate", "Basic") 
@@ -171,6 +223,7 @@ func AdminTokenMiddleware(next http.Handler) http.Handler {
 						// Authorized
 						if sub, ok := claims["sub"].(string); ok {
 							ctx := context.WithValue(r.Context(), "user_email", sub)
+							ctx = withSessionID(ctx, claims)
 							r = r.WithContext(ctx)
 						}
 						next.ServeHTTP(w, r)
@@ -205,6 +258,7 @@ func UserTokenMiddleware(next http.Handler) http.Handler {
             if claims, ok := token.Claims.(jwt.MapClaims); ok {
                 if sub, ok := claims["sub"].(string); ok {
                     ctx := context.WithValue(r.Context(), "user_email", sub)
+                    ctx = withSessionID(ctx, claims)
                     next.ServeHTTP(w, r.WithContext(ctx))
                     return
                 }
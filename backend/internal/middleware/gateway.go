@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// GatewayIdentityKey holds the *models.GatewayIdentity of an authenticated gateway
+const GatewayIdentityKey contextKey = "gatewayIdentity"
+
+var (
+	ErrGatewayUnauthenticated = errors.New("gateway token required")
+	ErrGatewayMismatch        = errors.New("gateway token does not match the hostname")
+	ErrGatewayQuarantined     = errors.New("gateway report quarantined")
+)
+
+// GatewayGuard authenticates the reports of POST /api/infos and applies the
+// policy to the others. Refused reports are kept in a bounded in-memory list
+// in quarantine mode, so that admins see which gateways still need a token.
+type GatewayGuard struct {
+	Policy        string
+	Identities    data.GatewayIdentityStore
+	MaxQuarantine int // Hostnames listed at once, 0 = unlimited
+
+	mu         sync.Mutex
+	quarantine map[string]*models.GatewayQuarantineEntry // hostname -> entry
+}
+
+func NewGatewayGuard(policy string, identities data.GatewayIdentityStore, maxQuarantine int) *GatewayGuard {
+	switch policy {
+	case models.GatewayAuthOpen, models.GatewayAuthQuarantine, models.GatewayAuthEnforce:
+	default:
+		log.Printf("Unknown gateway auth policy %q, using %s", policy, models.GatewayAuthQuarantine)
+		policy = models.GatewayAuthQuarantine
+	}
+	return &GatewayGuard{
+		Policy:        policy,
+		Identities:    identities,
+		MaxQuarantine: maxQuarantine,
+		quarantine:    make(map[string]*models.GatewayQuarantineEntry),
+	}
+}
+
+// GatewayAuthMiddleware resolves "Authorization: Bearer <token>" to the
+// gateway identity. An unknown or revoked token is always refused; a missing
+// one only with the enforce policy, the handler decides for the others since
+// the hostname is in the body.
+func GatewayAuthMiddleware(guard *GatewayGuard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" {
+				if guard.Policy == models.GatewayAuthEnforce {
+					log.Printf("GatewayAuth: Report without token from %s", remoteIP(r.RemoteAddr))
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			hash := data.HashGatewayToken(token)
+			identity, err := guard.Identities.GetGatewayIdentityByToken(hash)
+			if err != nil {
+				log.Printf("GatewayAuth: Lookup failed: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if identity == nil {
+				// Not the token itself: the prefix of a valid one would help guess it
+				log.Printf("GatewayAuth: Invalid token (sha256 %s...) from %s", hash[:12], remoteIP(r.RemoteAddr))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err := guard.Identities.TouchGatewayIdentity(identity.ID, remoteIP(r.RemoteAddr)); err != nil {
+				log.Printf("GatewayAuth: Failed to record last seen of %s: %v", identity.ID, err)
+			}
+
+			ctx := context.WithValue(r.Context(), GatewayIdentityKey, identity)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GatewayIdentityFromContext returns the authenticated gateway, nil for anonymous reports
+func GatewayIdentityFromContext(ctx context.Context) *models.GatewayIdentity {
+	identity, _ := ctx.Value(GatewayIdentityKey).(*models.GatewayIdentity)
+	return identity
+}
+
+// Check decides whether a report for hostname is applied. identity is the
+// token owner, nil without token. A refused report is quarantined
+// (ErrGatewayQuarantined) with the quarantine policy.
+func (g *GatewayGuard) Check(identity *models.GatewayIdentity, hostname, ip string) error {
+	var reason, ownerID string
+	var refusal error
+	switch {
+	case identity != nil && hostname != "" && hostname != identity.Hostname:
+		reason, ownerID, refusal = models.GatewayQuarantineMismatch, identity.ID, ErrGatewayMismatch
+	case identity != nil:
+		g.Release(identity.Hostname)
+		return nil
+	default:
+		enrolled, err := g.Identities.GetGatewayIdentityByHostname(hostname)
+		if err != nil {
+			return err
+		}
+		reserved := enrolled != nil
+		if !reserved {
+			// Nor is a hostname that is the ID of an identity: its store key
+			_, err := g.Identities.GetGatewayIdentity(hostname)
+			switch {
+			case err == nil:
+				reserved = true
+			case !errors.Is(err, data.ErrGatewayIdentityNotFound):
+				return err
+			}
+		}
+		// An enrolled gateway is never overwritten without its token
+		if !reserved && g.Policy == models.GatewayAuthOpen {
+			return nil
+		}
+		reason, refusal = models.GatewayQuarantineNoToken, ErrGatewayUnauthenticated
+	}
+
+	log.Printf("GatewayAuth: Refused report for %q from %s (%s)", hostname, ip, reason)
+	if g.Policy != models.GatewayAuthQuarantine {
+		return refusal
+	}
+	g.hold(hostname, reason, ownerID, ip)
+	return ErrGatewayQuarantined
+}
+
+func (g *GatewayGuard) hold(hostname, reason, gatewayID, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := g.quarantine[hostname]
+	if !ok {
+		if g.MaxQuarantine > 0 && len(g.quarantine) >= g.MaxQuarantine {
+			return
+		}
+		entry = &models.GatewayQuarantineEntry{Hostname: hostname, FirstSeen: now}
+		g.quarantine[hostname] = entry
+	}
+	entry.Reason = reason
+	entry.GatewayID = gatewayID
+	entry.IP = ip
+	entry.LastSeen = now
+	entry.Attempts++
+}
+
+// Release drops hostname from the quarantine: enrolled, or reporting with its token
+func (g *GatewayGuard) Release(hostname string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.quarantine, hostname)
+}
+
+// Quarantined returns the refused reports, most recent first
+func (g *GatewayGuard) Quarantined() []*models.GatewayQuarantineEntry {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	list := make([]*models.GatewayQuarantineEntry, 0, len(g.quarantine))
+	for _, entry := range g.quarantine {
+		c := *entry
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list
+}
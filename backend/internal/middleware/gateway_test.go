@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func TestGatewayGuardReservesEnrolledKeys(t *testing.T) {
+	identities := data.NewFileGatewayIdentityStore(filepath.Join(t.TempDir(), "gateway_identities.json"))
+	identity := &models.GatewayIdentity{Hostname: "gw-1"}
+	if err := identities.CreateGatewayIdentity(identity); err != nil {
+		t.Fatal(err)
+	}
+	g := NewGatewayGuard(models.GatewayAuthOpen, identities, 0)
+
+	if err := g.Check(nil, "gw-2", "203.0.113.7"); err != nil {
+		t.Errorf("report of an unknown gateway: %v", err)
+	}
+	if err := g.Check(nil, "gw-1", "203.0.113.7"); !errors.Is(err, ErrGatewayUnauthenticated) {
+		t.Errorf("report for the enrolled hostname: %v", err)
+	}
+	// The ID is the store key of the enrolled gateway
+	if err := g.Check(nil, identity.ID, "203.0.113.7"); !errors.Is(err, ErrGatewayUnauthenticated) {
+		t.Errorf("report for the ID of an identity: %v", err)
+	}
+	if err := g.Check(identity, "gw-1", "203.0.113.7"); err != nil {
+		t.Errorf("report with the token: %v", err)
+	}
+}
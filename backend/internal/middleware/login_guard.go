@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// LoginGuard throttles the password logins: failures are counted per account
+// and per IP in the store, so that every instance sees the same lockouts.
+// A nil guard lets every attempt through.
+type LoginGuard struct {
+	Throttles data.LoginThrottleStore
+	Account   models.LoginThrottlePolicy
+	IP        models.LoginThrottlePolicy
+}
+
+func NewLoginGuard(throttles data.LoginThrottleStore, account, ip models.LoginThrottlePolicy) *LoginGuard {
+	return &LoginGuard{Throttles: throttles, Account: account, IP: ip}
+}
+
+// accountKey counts the failures of an e-mail whether or not its account
+// exists: the lockout tells nothing about the account
+func accountKey(email string) string {
+	return data.LoginThrottleKey(models.LoginThrottleAccount, strings.ToLower(strings.TrimSpace(email)))
+}
+
+// ipKey counts an IPv6 client by its /64: a single host usually owns the
+// whole prefix and could otherwise spread its attempts over it
+func ipKey(ip string) string {
+	if addr := net.ParseIP(ip); addr != nil && addr.To4() == nil {
+		ip = addr.Mask(net.CIDRMask(64, 128)).String()
+	}
+	return data.LoginThrottleKey(models.LoginThrottleIP, ip)
+}
+
+// Check returns how long the account or the IP stays locked, 0 when a login
+// may be attempted
+func (g *LoginGuard) Check(email, ip string, now time.Time) (time.Duration, error) {
+	if g == nil || g.Throttles == nil {
+		return 0, nil
+	}
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		t, err := g.Throttles.GetLoginThrottle(key)
+		if err != nil {
+			return 0, err
+		}
+		if t.Locked(now) && t.LockedUntil.Sub(now) > wait {
+			wait = t.LockedUntil.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// Failure records a failed login. It returns the state of the account and
+// of the IP when the failure locked them, nil otherwise.
+func (g *LoginGuard) Failure(email, ip string, now time.Time) (account, addr *models.LoginThrottle, err error) {
+	if g == nil || g.Throttles == nil {
+		return nil, nil, nil
+	}
+	t, locked, err := g.Throttles.RecordLoginFailure(accountKey(email), g.Account, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if locked {
+		account = t
+	}
+	t, locked, err = g.Throttles.RecordLoginFailure(ipKey(ip), g.IP, now)
+	if err != nil {
+		return account, nil, err
+	}
+	if locked {
+		addr = t
+	}
+	return account, addr, nil
+}
+
+// UnlockAccount forgets the failures of an account: successful login,
+// password reset or admin unlock
+func (g *LoginGuard) UnlockAccount(email string) error {
+	if g == nil || g.Throttles == nil {
+		return nil
+	}
+	return g.Throttles.ResetLoginThrottle(accountKey(email))
+}
+
+// UnlockIP forgets the failures of an IP
+func (g *LoginGuard) UnlockIP(ip string) error {
+	if g == nil || g.Throttles == nil {
+		return nil
+	}
+	return g.Throttles.ResetLoginThrottle(ipKey(ip))
+}
+
+// Lockouts returns the active lockouts, newest first
+func (g *LoginGuard) Lockouts(now time.Time) ([]*models.LoginLockout, error) {
+	lockouts := []*models.LoginLockout{}
+	if g == nil || g.Throttles == nil {
+		return lockouts, nil
+	}
+	throttles, err := g.Throttles.GetLoginLockouts(now)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range throttles {
+		kind, value, _ := strings.Cut(t.Key, ":")
+		lockouts = append(lockouts, &models.LoginLockout{
+			Kind:        kind,
+			Value:       value,
+			LockedUntil: *t.LockedUntil,
+			Lockouts:    t.Lockouts,
+		})
+	}
+	return lockouts, nil
+}
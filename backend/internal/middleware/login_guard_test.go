@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+var testLoginPolicy = models.LoginThrottlePolicy{MaxFailures: 3, Window: 15 * time.Minute, Lockout: 5 * time.Minute, MaxLockout: 12 * time.Minute}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		n    int
+		want time.Duration
+	}{
+		{1, 5 * time.Minute},
+		{2, 10 * time.Minute},
+		{3, 12 * time.Minute}, // Capped
+		{10, 12 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := testLoginPolicy.LockoutDuration(tt.n); got != tt.want {
+			t.Errorf("LockoutDuration(%d) = %s, want %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLoginGuardBackoff(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewLoginGuard(data.NewMemoryLoginThrottleStore(), testLoginPolicy, models.LoginThrottlePolicy{})
+
+	// fail records n failures from t and returns the lockout of the last one
+	fail := func(t *testing.T, at time.Time, n int) *models.LoginThrottle {
+		t.Helper()
+		var account *models.LoginThrottle
+		for i := 0; i < n; i++ {
+			var err error
+			if account, _, err = g.Failure("Alice@Example.com ", "203.0.113.7", at); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return account
+	}
+
+	steps := []struct {
+		name     string
+		at       time.Duration
+		failures int
+		want     time.Duration // Lockout started by the last failure, 0 = none
+	}{
+		{"below the limit", 0, 2, 0},
+		{"third failure locks", time.Minute, 1, 5 * time.Minute},
+		{"second lockout doubles", 7 * time.Minute, 3, 10 * time.Minute},
+		{"third lockout is capped", 18 * time.Minute, 3, 12 * time.Minute},
+		{"quiet period restarts the backoff", 18*time.Minute + 12*time.Minute + 28*time.Minute, 3, 5 * time.Minute},
+	}
+	for _, s := range steps {
+		at := t0.Add(s.at)
+		account := fail(t, at, s.failures)
+		switch {
+		case s.want == 0 && account != nil:
+			t.Errorf("%s: locked until %s", s.name, account.LockedUntil)
+		case s.want != 0 && account == nil:
+			t.Errorf("%s: not locked", s.name)
+		case s.want != 0 && !account.LockedUntil.Equal(at.Add(s.want)):
+			t.Errorf("%s: locked until %s, want %s", s.name, account.LockedUntil, at.Add(s.want))
+		}
+	}
+
+	// The account key ignores case and spaces; the lockout holds a correct password too
+	if wait, _ := g.Check("alice@example.com", "198.51.100.1", t0.Add(59*time.Minute)); wait != 4*time.Minute {
+		t.Errorf("Check() = %s, want 4m0s", wait)
+	}
+	if err := g.UnlockAccount("ALICE@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := g.Check("alice@example.com", "198.51.100.1", t0.Add(59*time.Minute)); wait != 0 {
+		t.Errorf("Check() after unlock = %s, want 0", wait)
+	}
+}
+
+func TestLoginGuardPerIP(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := NewLoginGuard(data.NewMemoryLoginThrottleStore(), models.LoginThrottlePolicy{}, testLoginPolicy)
+
+	// One host of an IPv6 /64 spreads its attempts over several addresses and e-mails
+	ips := []string{"2001:db8:1:2::1", "2001:db8:1:2::2", "2001:db8:1:2:ffff::3"}
+	var addr *models.LoginThrottle
+	for i, ip := range ips {
+		_, addr, _ = g.Failure("user"+string(rune('a'+i))+"@example.com", ip, t0)
+	}
+	if addr == nil {
+		t.Fatal("the /64 is not locked")
+	}
+	if wait, _ := g.Check("other@example.com", "2001:db8:1:2::42", t0); wait != 5*time.Minute {
+		t.Errorf("Check() from the same /64 = %s, want 5m0s", wait)
+	}
+	if wait, _ := g.Check("other@example.com", "2001:db8:1:3::1", t0); wait != 0 {
+		t.Errorf("Check() from another /64 = %s, want 0", wait)
+	}
+	if wait, _ := g.Check("other@example.com", "203.0.113.7", t0); wait != 0 {
+		t.Errorf("Check() from an IPv4 = %s, want 0", wait)
+	}
+}
+
+func TestNilLoginGuard(t *testing.T) {
+	var g *LoginGuard
+	if wait, err := g.Check("a@example.com", "203.0.113.7", time.Now()); wait != 0 || err != nil {
+		t.Errorf("Check() = %s, %v", wait, err)
+	}
+	if account, addr, err := g.Failure("a@example.com", "203.0.113.7", time.Now()); account != nil || addr != nil || err != nil {
+		t.Errorf("Failure() = %v, %v, %v", account, addr, err)
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a sliding-window limit of events per key (IP, e-mail...)
+type RateLimiter struct {
+	Limit  int // Events per key and window, 0 = unlimited
+	Window time.Duration
+
+	mu        sync.Mutex
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{Limit: limit, Window: window, hits: make(map[string][]time.Time)}
+}
+
+// Allow records an event for key if it fits in the limit
+func (l *RateLimiter) Allow(key string) bool {
+	if l == nil || l.Limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	hits := l.recent(key, now)
+	if len(hits) >= l.Limit {
+		return false
+	}
+	l.hits[key] = append(hits, now)
+	return true
+}
+
+// Count returns the events of key in the current window
+func (l *RateLimiter) Count(key string) int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.recent(key, time.Now()))
+}
+
+// Full tells whether the next event of key would be refused, without recording it
+func (l *RateLimiter) Full(key string) bool {
+	return l != nil && l.Limit > 0 && l.Count(key) >= l.Limit
+}
+
+// recent returns the events of key within the window. The caller holds the lock.
+func (l *RateLimiter) recent(key string, now time.Time) []time.Time {
+	cutoff := now.Add(-l.Window)
+	// Drop idle keys once per window so the map does not grow with each key seen
+	if now.Sub(l.lastSweep) > l.Window {
+		for k, hits := range l.hits {
+			if len(hits) == 0 || hits[len(hits)-1].Before(cutoff) {
+				delete(l.hits, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	hits := trimBefore(l.hits[key], cutoff)
+	if len(hits) == 0 {
+		delete(l.hits, key)
+	} else {
+		l.hits[key] = hits
+	}
+	return hits
+}
+
+// trimBefore drops the sorted timestamps older than cutoff
+func trimBefore(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && hits[i].Before(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
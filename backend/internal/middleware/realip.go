@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies (nginx...) allowed to tell the
+// address of the client they relay. A direct client cannot forge its address
+// with X-Forwarded-For: the header is only read when the peer is one of them.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies reads a list of IPs or CIDRs, e.g. "127.0.0.1,10.0.0.0/8"
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", v)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", v)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusted(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client of r. Behind trusted proxies, it
+// is the last X-Forwarded-For hop that is not itself a trusted proxy: the
+// entries on its left come from the client and prove nothing.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	peer := remoteIP(r.RemoteAddr)
+	ip := net.ParseIP(peer)
+	if ip == nil || !p.trusted(ip) {
+		return peer
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		client = hop.String()
+		if !p.trusted(hop) {
+			return client
+		}
+	}
+	if len(hops) == 0 {
+		if hop := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); hop != nil {
+			return hop.String()
+		}
+	}
+	return client
+}
+
+// RealIP sets RemoteAddr to the client address, as resolved by ClientIP, for
+// the handlers and the logs after it
+func (p TrustedProxies) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = p.ClientIP(r)
+		next.ServeHTTP(w, r)
+	})
+}
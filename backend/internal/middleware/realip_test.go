@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1", "::1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"direct client forging the header", "203.0.113.7:5000", []string{"198.51.100.1"}, "", "203.0.113.7"},
+		{"behind nginx", "127.0.0.1:40000", []string{"203.0.113.7"}, "", "203.0.113.7"},
+		{"forged entry on the left", "127.0.0.1:40000", []string{"198.51.100.1, 203.0.113.7"}, "", "203.0.113.7"},
+		{"chain of trusted proxies", "127.0.0.1:40000", []string{"198.51.100.1, 203.0.113.7, 10.1.2.3"}, "", "203.0.113.7"},
+		{"several headers", "127.0.0.1:40000", []string{"198.51.100.1", "203.0.113.7"}, "", "203.0.113.7"},
+		{"garbage hop", "127.0.0.1:40000", []string{"203.0.113.7, not-an-ip"}, "", "127.0.0.1"},
+		{"only X-Real-IP", "[::1]:40000", nil, "2001:db8::1", "2001:db8::1"},
+		{"no header from proxy", "127.0.0.1:40000", nil, "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, h := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := proxies.ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"nginx"}); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+// Legacy keys are the 16-char username and 16-char password, both hex
+const hashedPkeyLen = 32
+
+var (
+	errRegistrationClosed  = errors.New("registration closed")
+	errRegistrationPending = errors.New("registration pending review")
+	errRegistrationLimited = errors.New("registration rate limit exceeded")
+)
+
+// isValidHashedPkey rejects keys that cannot come from a legacy client
+func isValidHashedPkey(key string) bool {
+	if len(key) != hashedPkeyLen {
+		return false
+	}
+	for _, c := range key {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// RegistrationGuard decides what happens to unknown credentials: the policy,
+// a per-IP and a global sliding-window rate limit on new registrations, and
+// the review queue used in quarantine mode.
+type RegistrationGuard struct {
+	Policy  string
+	Pending data.RegistrationStore // Required for the quarantine policy
+	Sealer  *data.CredentialSealer // Keeps the credentials for the reveal, nil = none
+
+	mu     sync.Mutex   // Checks both limits before recording in either
+	perIP  *RateLimiter // New registrations per IP
+	global *RateLimiter // New registrations, keyed by ""
+}
+
+func NewRegistrationGuard(policy string, pending data.RegistrationStore, perIP, global int, window time.Duration) *RegistrationGuard {
+	switch policy {
+	case models.RegistrationOpen, models.RegistrationQuarantine, models.RegistrationClosed:
+	default:
+		log.Printf("Unknown registration policy %q, using %s", policy, models.RegistrationOpen)
+		policy = models.RegistrationOpen
+	}
+	if policy == models.RegistrationQuarantine && pending == nil {
+		log.Printf("No registration queue configured, using %s policy", models.RegistrationClosed)
+		policy = models.RegistrationClosed
+	}
+	return &RegistrationGuard{
+		Policy:  policy,
+		Pending: pending,
+		perIP:   NewRateLimiter(perIP, window),
+		global:  NewRateLimiter(global, window),
+	}
+}
+
+// admit handles an unknown, well-formed credential. It returns the new machine
+// in open mode; otherwise the request is refused with one of the
+// errRegistration* errors.
+func (g *RegistrationGuard) admit(store data.Store, hashedPkey, remoteAddr string) (*models.Machine, error) {
+	ip := remoteIP(remoteAddr)
+	key := data.MachineKey(hashedPkey)
+
+	switch g.Policy {
+	case models.RegistrationClosed:
+		return nil, errRegistrationClosed
+
+	case models.RegistrationQuarantine:
+		reg, err := g.Pending.GetRegistrationByKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if reg != nil && reg.Status == models.RegistrationApproved {
+			// Approved, then the machine was deleted: review it again
+			if err := g.Pending.DeleteRegistration(reg.ID); err != nil {
+				log.Printf("[REGISTRATION] Failed to delete approved registration %s: %v", reg.ID, err)
+			}
+			reg = nil
+		}
+		if reg != nil {
+			// Already queued (or rejected): not a new registration, no rate limit
+			if err := g.Pending.TouchRegistration(reg.ID, ip); err != nil {
+				log.Printf("[REGISTRATION] Failed to record attempt of queued machine %s...: %v", reg.KeyPrefix, err)
+			}
+			return nil, errRegistrationPending
+		}
+		if !g.allow(ip) {
+			return nil, errRegistrationLimited
+		}
+		now := time.Now()
+		reg = &models.PendingRegistration{
+			HashedPkey:       key,
+			SealedCredential: g.Sealer.Seal(hashedPkey),
+			KeyPrefix:        key[:8],
+			Status:           models.RegistrationPending,
+			FirstIP:          ip,
+			LastIP:           ip,
+			FirstSeen:        now,
+			LastSeen:         now,
+			Attempts:         1,
+		}
+		if err := g.Pending.AddRegistration(reg); err != nil {
+			return nil, err
+		}
+		log.Printf("[REGISTRATION] Queued unknown machine %s... from %s", reg.KeyPrefix, ip)
+		return nil, errRegistrationPending
+
+	default: // open
+		if !g.allow(ip) {
+			return nil, errRegistrationLimited
+		}
+		return store.RegisterUnknownMachine(key, g.Sealer.Seal(hashedPkey))
+	}
+}
+
+// allow records a registration attempt if it fits in both rate limits; a
+// refused attempt counts against neither
+func (g *RegistrationGuard) allow(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.global.Full("") || g.perIP.Full(ip) {
+		log.Printf("[REGISTRATION] Rate limit reached for %s (%d from IP, %d total)", ip, g.perIP.Count(ip), g.global.Count(""))
+		return false
+	}
+	g.perIP.Allow(ip)
+	g.global.Allow("")
+	return true
+}
+
+// remoteIP strips the port of RemoteAddr, if any
+func remoteIP(remoteAddr string) string {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
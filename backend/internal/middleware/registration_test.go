@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+)
+
+func TestRegistrationGuardRateLimits(t *testing.T) {
+	g := NewRegistrationGuard(models.RegistrationOpen, nil, 2, 3, time.Hour)
+
+	for i, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.1", true},
+		{"203.0.113.1", true},
+		{"203.0.113.1", false}, // Per-IP limit
+		{"203.0.113.2", true},
+		{"203.0.113.3", false}, // Global limit
+		{"203.0.113.2", false},
+	} {
+		if got := g.allow(tt.ip); got != tt.want {
+			t.Errorf("attempt %d from %s: allow() = %v, want %v", i, tt.ip, got, tt.want)
+		}
+	}
+	// Refused attempts count against neither limit
+	if n := g.perIP.Count("203.0.113.3"); n != 0 {
+		t.Errorf("refused IP holds %d hits, want 0", n)
+	}
+	if n := g.global.Count(""); n != 3 {
+		t.Errorf("global count = %d, want 3", n)
+	}
+}
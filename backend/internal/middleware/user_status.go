@@ -2,15 +2,33 @@ package middleware
 
 import (
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/essensys-hub/essensys-support-site/backend/internal/data"
 	"github.com/essensys-hub/essensys-support-site/backend/internal/models"
+	"github.com/golang-jwt/jwt/v4"
 )
 
 type ActiveUserStore interface {
 	GetUserByEmail(email string) (*models.User, error)
 }
 
+// SessionLookup finds the session of an access token ("sid" claim)
+type SessionLookup interface {
+	GetSession(id string) (*models.Session, error)
+}
+
+// withSessionID copies the "sid" claim of an access token into the context
+func withSessionID(ctx context.Context, claims jwt.MapClaims) context.Context {
+	if sid, ok := claims["sid"].(string); ok && sid != "" {
+		return context.WithValue(ctx, "session_id", sid)
+	}
+	return ctx
+}
+
 func enforceActiveUser(w http.ResponseWriter, users ActiveUserStore, email string) (*models.User, bool) {
 	if users == nil || email == "" {
 		return nil, true
@@ -31,11 +49,46 @@ func enforceActiveUser(w http.ResponseWriter, users ActiveUserStore, email strin
 	return user, true
 }
 
-func UserTokenMiddlewareWithStore(store ActiveUserStore) func(http.Handler) http.Handler {
+// enforceSession refuses the access tokens of a revoked or expired session,
+// and the tokens issued without a session. It returns the session, nil when
+// not checked.
+func enforceSession(w http.ResponseWriter, r *http.Request, sessions SessionLookup, user *models.User) (*models.Session, bool) {
+	if sessions == nil || user == nil {
+		return nil, true
+	}
+	sid, _ := r.Context().Value("session_id").(string)
+	if sid == "" {
+		http.Error(w, "Session expired", http.StatusUnauthorized)
+		return nil, false
+	}
+	session, err := sessions.GetSession(sid)
+	if errors.Is(err, data.ErrSessionNotFound) {
+		http.Error(w, "Session expired", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("[AUTH] Failed to get session: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if session.UserID != user.ID || !session.Active(time.Now()) {
+		http.Error(w, "Session expired", http.StatusUnauthorized)
+		return nil, false
+	}
+	return session, true
+}
+
+// UserTokenMiddlewareWithStore is UserTokenMiddleware for active users with
+// an open session (sessions nil: not checked)
+func UserTokenMiddlewareWithStore(store ActiveUserStore, sessions SessionLookup) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return UserTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			email, _ := r.Context().Value("user_email").(string)
-			if _, ok := enforceActiveUser(w, store, email); !ok {
+			user, ok := enforceActiveUser(w, store, email)
+			if !ok {
+				return
+			}
+			if _, ok := enforceSession(w, r, sessions, user); !ok {
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -43,7 +96,10 @@ func UserTokenMiddlewareWithStore(store ActiveUserStore) func(http.Handler) http
 	}
 }
 
-func AdminTokenMiddlewareWithStore(store ActiveUserStore) func(http.Handler) http.Handler {
+// AdminTokenMiddlewareWithStore is AdminTokenMiddleware with the role read
+// from the store, not from the token, and an open session. twoFactorPolicy
+// (TWO_FACTOR_POLICY) may require the session to have passed 2FA.
+func AdminTokenMiddlewareWithStore(store ActiveUserStore, sessions SessionLookup, twoFactorPolicy string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return AdminTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			email, _ := r.Context().Value("user_email").(string)
@@ -55,9 +111,51 @@ func AdminTokenMiddlewareWithStore(store ActiveUserStore) func(http.Handler) htt
 			if !ok {
 				return
 			}
+			session, ok := enforceSession(w, r, sessions, user)
+			if !ok {
+				return
+			}
+			if user != nil && user.Role != models.RoleAdminGlobal && user.Role != models.RoleAdminLocal && user.Role != models.RoleSupport {
+				http.Error(w, "Forbidden: Insufficient Permissions", http.StatusForbidden)
+				return
+			}
+			if user != nil && !models.IsUserVerified(user) {
+				models.WriteEmailNotVerified(w)
+				return
+			}
+			if session != nil && !session.TwoFactor && models.IsTwoFactorRequired(twoFactorPolicy, user.Role) {
+				models.WriteTwoFactorRequired(w)
+				return
+			}
 			ctx := context.WithValue(r.Context(), "user_email", email)
-			ctx = context.WithValue(ctx, "user_role", user.Role)
+			if user != nil {
+				ctx = context.WithValue(ctx, "user_role", user.Role)
+			}
 			next.ServeHTTP(w, r.WithContext(ctx))
 		}))
 	}
 }
+
+// RequireVerifiedEmail restricts routes behind UserTokenMiddlewareWithStore
+// to users who verified their e-mail address
+func RequireVerifiedEmail(store ActiveUserStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			email, _ := r.Context().Value("user_email").(string)
+			if store == nil || email == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			user, err := store.GetUserByEmail(email)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if user != nil && !models.IsUserVerified(user) {
+				models.WriteEmailNotVerified(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Action lifecycle states
+const (
+	ActionPending      = "pending"      // Queued, never served to the machine
+	ActionDelivered    = "delivered"    // Served by GET /api/myactions, waiting for /api/done
+	ActionAcknowledged = "acknowledged" // Machine called POST /api/done/{guid}
+	ActionConfirmed    = "confirmed"    // Values observed in a later /api/mystatus payload
+	ActionExpired      = "expired"      // TTL elapsed before acknowledgement
+	ActionCancelled    = "cancelled"    // Removed by an admin before acknowledgement
+)
+
+// MachineAction is a queued write of Table d'Echange values for one machine
+type MachineAction struct {
+	GUID        string             `json:"guid"`
+	NoSerie     string             `json:"no_serie"`
+	Params      []ExchangeKeyValue `json:"params"`
+	Status      string             `json:"status"`
+	Attempts    int                `json:"attempts"` // Number of times served by /api/myactions
+	CreatedBy   string             `json:"created_by"`
+	CreatedAt   time.Time          `json:"created_at"`
+	ExpiresAt   time.Time          `json:"expires_at"`
+	DeliveredAt *time.Time         `json:"delivered_at,omitempty"`
+	AckedAt     *time.Time         `json:"acked_at,omitempty"`
+	ConfirmedAt *time.Time         `json:"confirmed_at,omitempty"`
+}
+
+// IsOpen reports whether the action can still be served to the machine
+func (a *MachineAction) IsOpen() bool {
+	return a.Status == ActionPending || a.Status == ActionDelivered
+}
+
+// LegacyAction is one entry of the "actions" array expected by the legacy client
+type LegacyAction struct {
+	GUID   string             `json:"guid"`
+	Params []ExchangeKeyValue `json:"params"`
+}
+
+// MyActionsResponse represents the JSON response for GET /api/myactions.
+// An empty queue encodes as "{}", which legacy clients already accept.
+type MyActionsResponse struct {
+	Actions []LegacyAction `json:"actions,omitempty"`
+}
+
+// EnqueueActionRequest is the admin payload to queue a write for a machine
+type EnqueueActionRequest struct {
+	Params     []ExchangeKeyValue `json:"params"`
+	TTLSeconds int                `json:"ttl_seconds"` // Optional, defaults to 24h
+}
@@ -0,0 +1,117 @@
+package models
+
+import "time"
+
+// Alert rule conditions
+const (
+	AlertMachineOffline     = "machine_offline"      // Presence state (offline by default)
+	AlertMachineValue       = "machine_value"        // Last value of a Table d'Echange key
+	AlertGatewayOffline     = "gateway_offline"      // Presence state (offline by default)
+	AlertGatewayServiceDown = "gateway_service_down" // services[x] = false
+	AlertGatewayMetric      = "gateway_metric"       // cpu, memory, disk (%) or client_count
+)
+
+// AlertRuleKinds maps a condition to the kind of resource it watches
+var AlertRuleKinds = map[string]string{
+	AlertMachineOffline:     PresenceMachine,
+	AlertMachineValue:       PresenceMachine,
+	AlertGatewayOffline:     PresenceGateway,
+	AlertGatewayServiceDown: PresenceGateway,
+	AlertGatewayMetric:      PresenceGateway,
+}
+
+// Alert severities
+const (
+	AlertInfo     = "info"
+	AlertWarning  = "warning"
+	AlertCritical = "critical"
+)
+
+// Alert lifecycle
+const (
+	AlertOpen         = "open"
+	AlertAcknowledged = "acknowledged"
+	AlertResolved     = "resolved"
+)
+
+// AlertRule is a condition checked on every machine or gateway (Target
+// empty), or on one of them (Target = machine ID or hostname). A targeted
+// rule may replace a global one for its target (Overrides = global rule ID),
+// e.g. with another threshold or disabled.
+type AlertRule struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	Severity  string `json:"severity"`
+	Enabled   bool   `json:"enabled"`
+	Target    string `json:"target,omitempty"`
+	Overrides string `json:"overrides,omitempty"`
+
+	// Condition parameters
+	State     string  `json:"state,omitempty"`     // *_offline: late or offline (default)
+	Service   string  `json:"service,omitempty"`   // gateway_service_down: one service, empty for any
+	Metric    string  `json:"metric,omitempty"`    // gateway_metric: cpu, memory, disk, client_count
+	Key       int     `json:"key,omitempty"`       // machine_value: Table d'Echange index
+	Operator  string  `json:"operator,omitempty"`  // gt, gte, lt, lte, eq, ne
+	Threshold float64 `json:"threshold,omitempty"` // gateway_metric, numeric machine_value
+	Value     string  `json:"value,omitempty"`     // machine_value compared as text (eq, ne)
+
+	// Flap suppression: the condition must hold ForSeconds before the alert
+	// opens, and be clear for ResolveAfterSeconds before it resolves
+	ForSeconds          int `json:"for_seconds"`
+	ResolveAfterSeconds int `json:"resolve_after_seconds"` // 0: ALERT_RESOLVE_AFTER
+
+	Notify     bool     `json:"notify"`
+	Recipients []string `json:"recipients,omitempty"` // Default: ALERT_EMAILS
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Kind is the kind of resource the rule watches
+func (r *AlertRule) Kind() string {
+	return AlertRuleKinds[r.Condition]
+}
+
+// Alert is one rule firing on one resource. There is at most one unresolved
+// alert per rule and resource.
+type Alert struct {
+	ID         string     `json:"id"`
+	RuleID     string     `json:"rule_id"`
+	RuleName   string     `json:"rule_name"`
+	Severity   string     `json:"severity"`
+	Kind       string     `json:"kind"`        // machine or gateway
+	ResourceID string     `json:"resource_id"` // Machine ID or hostname
+	Resource   string     `json:"resource"`    // Serial or hostname, for display
+	Status     string     `json:"status"`
+	Message    string     `json:"message"`
+	Value      string     `json:"value,omitempty"` // Last observed value
+	OpenedAt   time.Time  `json:"opened_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Flaps      int        `json:"flaps"` // Reopened shortly after resolving
+	AckedBy    string     `json:"acknowledged_by,omitempty"`
+	AckedAt    *time.Time `json:"acknowledged_at,omitempty"`
+	AckNote    string     `json:"acknowledge_note,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy string     `json:"resolved_by,omitempty"` // Empty when the condition cleared
+	NotifiedAt *time.Time `json:"notified_at,omitempty"`
+}
+
+// Active: open or acknowledged
+func (a *Alert) Active() bool {
+	return a.Status != AlertResolved
+}
+
+// AlertFilter selects alerts; empty fields match all. Status "active" matches
+// open and acknowledged alerts.
+type AlertFilter struct {
+	Status     string
+	Kind       string
+	ResourceID string
+	RuleID     string
+}
+
+// AlertNoteRequest is the optional body of acknowledge and resolve
+type AlertNoteRequest struct {
+	Note string `json:"note"`
+}
@@ -19,3 +19,27 @@ func WriteAccountForbidden(w http.ResponseWriter) {
 		"redirect": ForbiddenRedirectPath,
 	})
 }
+
+// IsUserVerified tells whether the user proved they own their e-mail address
+func IsUserVerified(u *User) bool {
+	return u != nil && u.VerifiedAt != nil
+}
+
+// WriteEmailNotVerified refuses a request restricted to verified accounts
+func WriteEmailNotVerified(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error": "email_not_verified",
+	})
+}
+
+// WriteTwoFactorRequired refuses an admin request when the policy requires
+// 2FA and the session was not opened with a second factor
+func WriteTwoFactorRequired(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error": "two_factor_required",
+	})
+}
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// DefaultCollectionKeys is the key set requested when no profile applies
+var DefaultCollectionKeys = []int{363, 349, 350, 351, 352, 353, 11, 920}
+
+// CollectionProfile is a named set of exchange keys requested through
+// GET /api/serverinfos. A profile applies to the machines it lists, to the
+// firmware versions it lists, or to the whole fleet when IsDefault is set.
+type CollectionProfile struct {
+	ID               string     `json:"id"`
+	Name             string     `json:"name"`
+	Description      string     `json:"description"`
+	Keys             []int      `json:"keys"`
+	MachineIDs       []int      `json:"machine_ids"`
+	FirmwareVersions []string   `json:"firmware_versions"`
+	IsDefault        bool       `json:"is_default"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"` // Optional, for temporary diagnostics
+	CreatedBy        string     `json:"created_by"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// IsActive reports whether the profile has not expired
+func (p *CollectionProfile) IsActive(now time.Time) bool {
+	return p.ExpiresAt == nil || now.Before(*p.ExpiresAt)
+}
+
+// CollectionProfileRequest is the admin payload to create or update a profile
+type CollectionProfileRequest struct {
+	Name             string     `json:"name"`
+	Description      string     `json:"description"`
+	Keys             []int      `json:"keys"`
+	MachineIDs       []int      `json:"machine_ids"`
+	FirmwareVersions []string   `json:"firmware_versions"`
+	IsDefault        bool       `json:"is_default"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+// EffectiveCollection explains which keys a machine is asked for and why
+type EffectiveCollection struct {
+	MachineID int                `json:"machine_id"`
+	Version   string             `json:"version"`
+	Source    string             `json:"source"` // "machine", "firmware", "default" or "builtin"
+	Profile   *CollectionProfile `json:"profile,omitempty"`
+	Keys      []int              `json:"keys"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// Purposes of the single-use tokens sent by e-mail
+const (
+	EmailTokenPasswordReset = "password_reset"
+)
+
+// SessionPasswordReset is the Session.RevokedReason of a password reset
+const SessionPasswordReset = "password_reset"
+
+// ForgotPasswordRequest is the body of POST /api/auth/password/forgot
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest is the body of POST /api/auth/password/reset
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// MachineHeartbeat is the data of a machine.heartbeat event
+type MachineHeartbeat struct {
+	ID       int       `json:"id"`
+	NoSerie  string    `json:"no_serie"`
+	IP       string    `json:"ip"`
+	LastSeen time.Time `json:"last_seen"`
+	Outcome  string    `json:"outcome"` // success or inactive
+}
+
+// GeoUpdate is the data of machine.geo and gateway.geo events
+type GeoUpdate struct {
+	IP          string  `json:"ip"`
+	GeoLocation string  `json:"geo_location"`
+	Country     string  `json:"country,omitempty"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
+}
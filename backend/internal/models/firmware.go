@@ -0,0 +1,104 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FirmwareRelease is a firmware image kept in local storage
+type FirmwareRelease struct {
+	Version    string    `json:"version"` // Legacy format, e.g. "V123"
+	Filename   string    `json:"filename"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	Notes      string    `json:"notes"`
+	UploadedBy string    `json:"uploaded_by"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// Campaign states
+const (
+	CampaignDraft     = "draft"
+	CampaignActive    = "active"
+	CampaignPaused    = "paused"
+	CampaignCompleted = "completed"
+	CampaignCancelled = "cancelled"
+)
+
+// FirmwareCampaign rolls a release out to part of the fleet. A machine is
+// targeted when its serial is listed, when it carries one of the tags, or when
+// it falls in the rollout percentage (stable per machine).
+type FirmwareCampaign struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	TargetVersion string    `json:"target_version"`
+	Percentage    int       `json:"percentage"` // 0-100
+	Tags          []string  `json:"tags"`
+	Serials       []string  `json:"serials"`
+	MaxFailures   int       `json:"max_failures"` // Auto-pause threshold, 0 = never
+	Status        string    `json:"status"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// FirmwareCampaignRequest is the admin payload to create or retarget a campaign
+type FirmwareCampaignRequest struct {
+	Name          string   `json:"name"`
+	TargetVersion string   `json:"target_version"`
+	Percentage    int      `json:"percentage"`
+	Tags          []string `json:"tags"`
+	Serials       []string `json:"serials"`
+	MaxFailures   int      `json:"max_failures"`
+}
+
+// Per-machine update states
+const (
+	UpdateOffered     = "offered"     // NewVersion announced in /api/serverinfos
+	UpdateDownloading = "downloading" // Blocks served by /api/getversioncontent
+	UpdateInstalling  = "installing"  // /api/endversioncontent received, waiting for the new version
+	UpdateSucceeded   = "succeeded"   // Machine reported the target version
+	UpdateFailed      = "failed"
+)
+
+// FirmwareUpdate tracks one machine within a campaign
+type FirmwareUpdate struct {
+	CampaignID    string     `json:"campaign_id"`
+	MachineID     int        `json:"machine_id"`
+	NoSerie       string     `json:"no_serie"`
+	FromVersion   string     `json:"from_version"`
+	TargetVersion string     `json:"target_version"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	BlocksServed  int        `json:"blocks_served"`
+	Error         string     `json:"error,omitempty"`
+	OfferedAt     time.Time  `json:"offered_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
+
+// FirmwareCampaignProgress is returned by the campaign admin endpoints
+type FirmwareCampaignProgress struct {
+	*FirmwareCampaign
+	Counts   map[string]int    `json:"counts"` // Update status -> machines
+	Machines []*FirmwareUpdate `json:"machines,omitempty"`
+}
+
+// ParseFirmwareVersion extracts the number of a legacy version string ("V123" -> 123)
+func ParseFirmwareVersion(v string) (int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(strings.ToUpper(v)), "V")
+	n, err := strconv.Atoi(v)
+	return n, err == nil && n >= 0
+}
+
+// IsNewerFirmware reports whether target is a strictly higher version than current.
+// An unknown current version is never upgraded: the client compares numerically.
+func IsNewerFirmware(target, current string) bool {
+	t, ok := ParseFirmwareVersion(target)
+	if !ok {
+		return false
+	}
+	c, ok := ParseFirmwareVersion(current)
+	return ok && t > c
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Sort fields of FleetQuery
+const (
+	FleetSortID       = "id" // Machines only, default
+	FleetSortSerial   = "no_serie"
+	FleetSortHostname = "hostname" // Gateways only, default
+	FleetSortLastSeen = "last_seen"
+	FleetSortVersion  = "version" // Machines only
+	FleetSortIP       = "ip"
+	FleetSortCountry  = "country"
+)
+
+// MachineSortFields and GatewaySortFields are the accepted FleetQuery.Sort values
+var (
+	MachineSortFields = []string{FleetSortID, FleetSortSerial, FleetSortLastSeen, FleetSortVersion, FleetSortIP, FleetSortCountry}
+	GatewaySortFields = []string{FleetSortHostname, FleetSortLastSeen, FleetSortIP, FleetSortCountry}
+)
+
+// FleetQuery filters, sorts and pages the machine and gateway lists.
+// Zero values do not filter.
+type FleetQuery struct {
+	Search     string    // Case-insensitive, in the serial (hostname), IP or location
+	Active     *bool     // Machines only
+	Version    string    // Machines only, firmware version
+	Country    string    // Case-insensitive
+	SeenAfter  time.Time // LastSeen strictly after
+	SeenBefore time.Time // LastSeen at or before
+	Sort       string    // One of MachineSortFields or GatewaySortFields, empty for the default
+	Desc       bool
+	Cursor     string // NextCursor of the previous page
+	Limit      int    // 0 for no limit
+}
+
+// MachinePage is one page of QueryMachines. Total counts every machine
+// matching the filters; NextCursor is empty on the last page.
+type MachinePage struct {
+	Items      []*MachineDetail `json:"items"`
+	Total      int              `json:"total"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// GatewayPage is one page of QueryGateways
+type GatewayPage struct {
+	Items      []*GatewayStatus `json:"items"`
+	Total      int              `json:"total"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
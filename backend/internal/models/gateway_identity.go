@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+// Policies for gateway reports without a valid token (GATEWAY_AUTH_POLICY)
+const (
+	GatewayAuthOpen       = "open"       // Accepted as before, unless the hostname is enrolled
+	GatewayAuthQuarantine = "quarantine" // Not applied, listed for enrollment
+	GatewayAuthEnforce    = "enforce"    // Rejected
+)
+
+// Gateway identity states
+const (
+	GatewayIdentityActive  = "active"
+	GatewayIdentityRevoked = "revoked"
+)
+
+// GatewayIdentity is an enrolled gateway: a stable ID bound to a hostname and
+// authenticated by a bearer token, of which only a hash is kept
+type GatewayIdentity struct {
+	ID          string     `json:"id"`
+	Hostname    string     `json:"hostname"`
+	Status      string     `json:"status"`
+	TokenHash   string     `json:"-"`
+	TokenPrefix string     `json:"token_prefix"` // Enough to tell tokens apart
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RotatedAt   *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	LastIP      string     `json:"last_ip,omitempty"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
+
+	// The token replaced by the last rotation stays valid until PreviousUntil,
+	// so that the gateway can be reconfigured without losing reports
+	PreviousTokenHash string     `json:"-"`
+	PreviousUntil     *time.Time `json:"previous_token_until,omitempty"`
+}
+
+// EnrollGatewayRequest is the body of POST /api/admin/gateways/enrollments
+type EnrollGatewayRequest struct {
+	Hostname string `json:"hostname"`
+}
+
+// GatewayTokenResponse returns a new token. It is shown once and never stored.
+type GatewayTokenResponse struct {
+	Identity *GatewayIdentity `json:"identity"`
+	Token    string           `json:"token"`
+}
+
+// Reasons a gateway report was quarantined
+const (
+	GatewayQuarantineNoToken  = "no_token"
+	GatewayQuarantineMismatch = "hostname_mismatch" // Token of another gateway
+)
+
+// GatewayQuarantineEntry is a refused report, kept for review until the
+// hostname is enrolled
+type GatewayQuarantineEntry struct {
+	Hostname  string    `json:"hostname"`
+	Reason    string    `json:"reason"`
+	GatewayID string    `json:"gateway_id,omitempty"` // Token owner on a mismatch
+	IP        string    `json:"ip"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Attempts  int       `json:"attempts"`
+}
@@ -0,0 +1,106 @@
+package models
+
+import "time"
+
+// Resolutions of the gateway metrics history: raw pushes, then rollups
+const (
+	GatewayResolutionRaw    = "raw"
+	GatewayResolution5m     = "5m"
+	GatewayResolutionHourly = "1h"
+)
+
+// GatewayResolutions maps a resolution name to its bucket size (0 = raw)
+var GatewayResolutions = map[string]time.Duration{
+	GatewayResolutionRaw:    0,
+	GatewayResolution5m:     5 * time.Minute,
+	GatewayResolutionHourly: time.Hour,
+}
+
+// GatewayMetricsPoint is one push to POST /api/infos, or the average of the
+// pushes of a rollup bucket (Samples of them). Percentages are 0-100.
+type GatewayMetricsPoint struct {
+	Time        time.Time `db:"bucket" json:"t"`
+	CPU         float64   `db:"cpu" json:"cpu"`
+	Memory      float64   `db:"memory" json:"memory"`
+	Disk        float64   `db:"disk" json:"disk"`
+	ClientCount float64   `db:"client_count" json:"client_count"`
+	Samples     int       `db:"samples" json:"samples"`
+}
+
+// MetricsPoint extracts the history point of a status. Memory and Disk hold
+// psutil-style maps: "percent", or "used" and "total".
+func (gw *GatewayStatus) MetricsPoint() GatewayMetricsPoint {
+	return GatewayMetricsPoint{
+		Time:        gw.LastSeen,
+		CPU:         gw.CPU,
+		Memory:      usagePercent(gw.Memory),
+		Disk:        usagePercent(gw.Disk),
+		ClientCount: float64(gw.ClientCount),
+		Samples:     1,
+	}
+}
+
+func usagePercent(m map[string]interface{}) float64 {
+	if p, ok := m["percent"].(float64); ok {
+		return p
+	}
+	used, _ := m["used"].(float64)
+	total, _ := m["total"].(float64)
+	if total > 0 {
+		return used / total * 100
+	}
+	return 0
+}
+
+// GatewayMetricsQuery selects the points of one gateway at one resolution
+type GatewayMetricsQuery struct {
+	Gateway    string        // GatewayStatus.Key()
+	Resolution time.Duration // 0 = raw
+	From       time.Time
+	To         time.Time
+}
+
+// GatewayMetricsResponse is returned by GET /api/admin/gateways/{hostname}/metrics
+type GatewayMetricsResponse struct {
+	Hostname   string                `json:"hostname"`
+	Resolution string                `json:"resolution"`
+	From       time.Time             `json:"from"`
+	To         time.Time             `json:"to"`
+	Points     []GatewayMetricsPoint `json:"points"`
+}
+
+// GatewayMetricsRetention: raw points are rolled up into 5-minute and hourly
+// buckets, each resolution is kept for its own duration (0 = forever)
+type GatewayMetricsRetention struct {
+	RawRetention     time.Duration
+	FiveMinRetention time.Duration
+	HourlyRetention  time.Duration
+}
+
+// GatewayTrend is the state and trend of one gateway over the summary window
+type GatewayTrend struct {
+	Hostname    string    `json:"hostname"`
+	LastSeen    time.Time `json:"last_seen"`
+	CPU         float64   `json:"cpu"`
+	Memory      float64   `json:"memory"`
+	Disk        float64   `json:"disk"`
+	ClientCount int       `json:"client_count"`
+	AvgCPU      float64   `json:"avg_cpu"`
+	// Disk usage growth in percentage points per day (least squares), and the
+	// days left before the disk is full at that pace (null if not growing)
+	DiskGrowthPerDay float64  `json:"disk_growth_per_day"`
+	DiskFullInDays   *float64 `json:"disk_full_in_days"`
+}
+
+// GatewayFleetSummary is returned by GET /api/admin/gateways/metrics
+type GatewayFleetSummary struct {
+	Window       string         `json:"window"`
+	Gateways     int            `json:"gateways"`
+	Reporting    int            `json:"reporting"` // Pushed during the window
+	TotalClients int            `json:"total_clients"`
+	AvgCPU       float64        `json:"avg_cpu"`
+	AvgMemory    float64        `json:"avg_memory"`
+	AvgDisk      float64        `json:"avg_disk"`
+	DiskWarnings int            `json:"disk_warnings"` // Disk above the threshold, or full within a week
+	Trends       []GatewayTrend `json:"trends"`        // Soonest full disk first
+}
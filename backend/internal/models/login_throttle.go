@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// Kinds of LoginThrottle keys
+const (
+	LoginThrottleAccount = "account" // Normalized e-mail, whether or not the account exists
+	LoginThrottleIP      = "ip"
+)
+
+// LoginThrottle counts the failed logins of an account or an IP. Past the
+// limit of its policy, the key is locked; each new lockout lasts twice the
+// previous one.
+type LoginThrottle struct {
+	Key            string     `db:"key" json:"key"`           // "account:<email>" or "ip:<address>"
+	Failures       int        `db:"failures" json:"failures"` // Since FirstFailureAt
+	FirstFailureAt time.Time  `db:"first_failure_at" json:"first_failure_at"`
+	LastFailureAt  time.Time  `db:"last_failure_at" json:"last_failure_at"`
+	Lockouts       int        `db:"lockouts" json:"lockouts"` // Consecutive, for the backoff
+	LockedUntil    *time.Time `db:"locked_until" json:"locked_until,omitempty"`
+}
+
+// Locked tells whether the key is locked at now
+func (t *LoginThrottle) Locked(now time.Time) bool {
+	return t != nil && t.LockedUntil != nil && now.Before(*t.LockedUntil)
+}
+
+// LoginThrottlePolicy is the limit applied to one kind of key
+type LoginThrottlePolicy struct {
+	MaxFailures int           // Failures within Window before a lockout, 0 = unlimited
+	Window      time.Duration // Failures older than this are forgotten
+	Lockout     time.Duration // First lockout, doubled for each one after
+	MaxLockout  time.Duration // Cap of the backoff
+}
+
+// LockoutDuration is the length of the n-th consecutive lockout (n from 1)
+func (p LoginThrottlePolicy) LockoutDuration(n int) time.Duration {
+	d := p.Lockout
+	for i := 1; i < n && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+	if p.MaxLockout > 0 && d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+	return d
+}
+
+// LoginLockout is an active lockout, as listed by GET /api/admin/lockouts
+type LoginLockout struct {
+	Kind        string    `json:"kind"`  // LoginThrottleAccount or LoginThrottleIP
+	Value       string    `json:"value"` // E-mail or IP
+	LockedUntil time.Time `json:"locked_until"`
+	Lockouts    int       `json:"lockouts"`
+	UserID      int       `json:"user_id,omitempty"` // Account of the e-mail, if any
+}
@@ -10,12 +10,13 @@ type Machine struct {
 	ID              int       `db:"id" json:"id"`
 	NoSerie         string    `db:"no_serie" json:"no_serie"`
 	Version         string    `db:"version" json:"version,omitempty"`
-	Pkey            string    `db:"pkey" json:"-"`
-	HashedPkey      string    `db:"hashed_pkey" json:"-"`
+	HashedPkey      string    `db:"hashed_pkey" json:"-"` // SHA-256 of the credential (data.MachineKey), never the credential
+	SealedCredential string   `db:"sealed_credential" json:"-"` // Encrypted credential, for the audited reveal
 	AutoriseAlarme  bool      `db:"autorise_alarme" json:"autorise_alarme"`
 	IsActive        bool      `db:"is_active" json:"is_active"`
 	DateCreation    time.Time `db:"date_creation" json:"date_creation"`
 	DateModification time.Time `db:"date_modification" json:"date_modification"`
+	Tags            []string  `db:"-" json:"tags,omitempty"` // Rollout targeting, see FirmwareCampaign
 }
 
 // ============================================================================
@@ -60,17 +61,31 @@ type GatewayStatus struct {
 	ClientCount int                   `json:"client_count"`
     
     // Server-added fields
+    GatewayID   string    `json:"gateway_id,omitempty"` // Enrolled identity, empty for legacy reports
     IP          string    `json:"ip"`
     LastSeen    time.Time `json:"last_seen"`
     GeoLocation string    `json:"geo_location"`
+    Country     string    `json:"country,omitempty"`
     Lat         float64   `json:"lat"`
     Lon         float64   `json:"lon"`
 }
 
+// Key identifies the gateway in the stores and the metrics history: the ID of
+// its enrolled identity, stable, else the hostname it reports
+func (gw *GatewayStatus) Key() string {
+    if gw.GatewayID != "" {
+        return gw.GatewayID
+    }
+    return gw.Hostname
+}
+
 type AdminStatsResponse struct {
-	ConnectedClients int `json:"connected_clients"`
+	ConnectedClients int `json:"connected_clients"` // Machines online or late (with presence tracking)
 	TotalMachines    int `json:"total_machines"`
     TotalGateways    int `json:"total_gateways"`
+	ConnectedGateways int             `json:"connected_gateways"`
+	MachinesPresence  *PresenceCounts `json:"machines_presence,omitempty"`
+	GatewaysPresence  *PresenceCounts `json:"gateways_presence,omitempty"`
 }
 
 type MachineDetail struct {
@@ -78,13 +93,37 @@ type MachineDetail struct {
 	NoSerie    string    `json:"no_serie"`
 	IP         string    `json:"ip"`
 	LastSeen   time.Time `json:"last_seen"`
-	RawAuth    string    `json:"raw_auth"`
-	RawDecoded string    `json:"raw_decoded"` // user:pass
+	Version    string    `json:"version,omitempty"` // Last reported in /api/mystatus
+	Tags       []string  `json:"tags,omitempty"`
+	IsActive   bool      `json:"is_active"`
+	AutoriseAlarme bool  `json:"autorise_alarme"`
+	CredentialFingerprint string `json:"credential_fingerprint,omitempty"` // Hash of the key, never the key itself
+	CredentialSplit       int    `json:"credential_split,omitempty"`       // Username length, to rebuild user:pass on reveal
+	LastAuthOutcome       string `json:"last_auth_outcome,omitempty"`
+	// Deprecated: plaintext credentials of older files, scrubbed when the store loads
+	RawAuth    string    `json:"raw_auth,omitempty"`
+	RawDecoded string    `json:"raw_decoded,omitempty"`
 	GeoLocation string   `json:"geo_location"`
+	Country     string   `json:"country,omitempty"`
 	Lat         float64  `json:"lat"`
 	Lon         float64  `json:"lon"`
 }
 
+// Last authentication outcome of a known machine (MachineDetail.LastAuthOutcome)
+const (
+	AuthOutcomeSuccess  = "success"
+	AuthOutcomeInactive = "inactive"
+)
+
+// CredentialReveal is returned by the audited reveal action (admin_global only)
+type CredentialReveal struct {
+	MachineID  int    `json:"machine_id"`
+	NoSerie    string `json:"no_serie"`
+	Credential string `json:"credential"`
+	RawDecoded string `json:"raw_decoded"` // user:pass
+	RawAuth    string `json:"raw_auth"`    // Base64, as sent by the machine
+}
+
 // GeoAPIResponse for parsing ip-api.com
 type GeoAPIResponse struct {
     Status      string  `json:"status"`
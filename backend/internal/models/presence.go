@@ -0,0 +1,105 @@
+package models
+
+import "time"
+
+// Presence states, derived from LastSeen and the expected polling interval
+const (
+	PresenceOnline  = "online"
+	PresenceLate    = "late" // Missed a few polls, still counted as available
+	PresenceOffline = "offline"
+)
+
+// Kinds of tracked resources. Machines are identified by their ID, gateways
+// by their hostname.
+const (
+	PresenceMachine = "machine"
+	PresenceGateway = "gateway"
+)
+
+// PresencePolicy: a resource is late once it missed LateAfter polls, offline
+// once it missed OfflineAfter polls
+type PresencePolicy struct {
+	MachineInterval time.Duration // Legacy clients poll every 2 s (500 ms with a user)
+	GatewayInterval time.Duration
+	LateAfter       int
+	OfflineAfter    int
+}
+
+// Interval is the expected polling interval of kind
+func (p PresencePolicy) Interval(kind string) time.Duration {
+	if kind == PresenceGateway {
+		return p.GatewayInterval
+	}
+	return p.MachineInterval
+}
+
+// State of a resource of kind last seen at lastSeen, and when it entered it
+func (p PresencePolicy) State(kind string, lastSeen, now time.Time) (string, time.Time) {
+	late := lastSeen.Add(time.Duration(p.LateAfter) * p.Interval(kind))
+	offline := lastSeen.Add(time.Duration(p.OfflineAfter) * p.Interval(kind))
+	switch {
+	case lastSeen.IsZero():
+		return PresenceOffline, time.Time{}
+	case now.Before(late):
+		return PresenceOnline, lastSeen
+	case now.Before(offline):
+		return PresenceLate, late
+	default:
+		return PresenceOffline, offline
+	}
+}
+
+// IsUp: late resources still count as available
+func IsUp(state string) bool {
+	return state == PresenceOnline || state == PresenceLate
+}
+
+// PresenceTransition is a change of state. From is empty for the first state
+// seen of a resource.
+type PresenceTransition struct {
+	Kind       string    `db:"kind" json:"kind"`
+	ResourceID string    `db:"resource_id" json:"resource_id"`
+	From       string    `db:"from_state" json:"from"`
+	To         string    `db:"to_state" json:"to"`
+	At         time.Time `db:"at" json:"at"`
+}
+
+// Presence is the state of a resource and its availability over a window
+type Presence struct {
+	State        string     `json:"state"`
+	Since        *time.Time `json:"since,omitempty"`        // Last transition
+	UpSince      *time.Time `json:"up_since,omitempty"`     // Start of the current uptime, nil when offline
+	Uptime       float64    `json:"uptime_seconds"`         // Current uptime
+	Availability *float64   `json:"availability,omitempty"` // Percentage of the observed window spent up, nil without history
+	Window       string     `json:"window,omitempty"`
+	Transitions  int        `json:"transitions"` // In the window
+}
+
+// MachinePresence is an admin list entry: the machine and its presence
+type MachinePresence struct {
+	*MachineDetail
+	Presence *Presence `json:"presence"`
+}
+
+// GatewayPresence is an admin list entry: the gateway and its presence
+type GatewayPresence struct {
+	*GatewayStatus
+	Presence *Presence `json:"presence"`
+}
+
+// PresenceHistory is returned by GET /api/admin/presence/{kind}/{id}
+type PresenceHistory struct {
+	Kind        string               `json:"kind"`
+	ResourceID  string               `json:"resource_id"`
+	From        time.Time            `json:"from"`
+	To          time.Time            `json:"to"`
+	Presence    *Presence            `json:"presence"`
+	Transitions []PresenceTransition `json:"transitions"`
+}
+
+// PresenceCounts are the resources of a kind in each state
+type PresenceCounts struct {
+	Online  int `json:"online"`
+	Late    int `json:"late"`
+	Offline int `json:"offline"`
+}
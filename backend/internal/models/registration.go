@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Registration policies for unknown machines (REGISTRATION_POLICY)
+const (
+	RegistrationOpen       = "open"       // Registered at once as an inactive machine
+	RegistrationQuarantine = "quarantine" // Queued for review, no machine created
+	RegistrationClosed     = "closed"     // Rejected, nothing stored
+)
+
+// Registration review states
+const (
+	RegistrationPending  = "pending"
+	RegistrationApproved = "approved"
+	RegistrationRejected = "rejected"
+)
+
+// PendingRegistration is an unknown credential seen in quarantine mode
+type PendingRegistration struct {
+	ID               string     `json:"id"`
+	HashedPkey       string     `json:"-"`          // Machine key, see data.MachineKey
+	SealedCredential string     `json:"-"`          // For the reveal once approved
+	KeyPrefix        string     `json:"key_prefix"` // First 8 chars of the key, as in the UNKNOWN-xxxxxxxx serial
+	Status           string     `json:"status"`
+	FirstIP          string     `json:"first_ip"`
+	LastIP           string     `json:"last_ip"`
+	FirstSeen        time.Time  `json:"first_seen"`
+	LastSeen         time.Time  `json:"last_seen"`
+	Attempts         int        `json:"attempts"`
+	MachineID        int        `json:"machine_id,omitempty"` // Set once approved
+	ReviewedBy       string     `json:"reviewed_by,omitempty"`
+	ReviewedAt       *time.Time `json:"reviewed_at,omitempty"`
+}
+
+// ApproveRegistrationRequest optionally names the machine created on approval
+type ApproveRegistrationRequest struct {
+	NoSerie string `json:"no_serie"`
+}
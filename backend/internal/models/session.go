@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Session is a login of a user: its access tokens carry the session ID
+// ("sid") and are refused once it is revoked. Refresh tokens rotate at each
+// use and extend ExpiresAt.
+type Session struct {
+	ID            string     `db:"id" json:"id"`
+	UserID        int        `db:"user_id" json:"user_id"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	LastUsedAt    time.Time  `db:"last_used_at" json:"last_used_at"` // Last refresh
+	ExpiresAt     time.Time  `db:"expires_at" json:"expires_at"`     // Of the current refresh token
+	RevokedAt     *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	RevokedReason string     `db:"revoked_reason" json:"revoked_reason,omitempty"`
+	IP            string     `db:"ip" json:"ip"`
+	UserAgent     string     `db:"user_agent" json:"user_agent"`
+	TwoFactor     bool       `db:"two_factor" json:"two_factor"` // Opened or confirmed with a TOTP code
+	Current       bool       `db:"-" json:"current,omitempty"` // Session of the caller, in GET /api/auth/sessions
+}
+
+// Active tells whether the session still grants access at now
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Session.RevokedReason
+const (
+	SessionLogout      = "logout"
+	SessionLogoutAll   = "logout_all"
+	SessionTokenReuse  = "refresh_token_reuse" // A rotated refresh token was presented again
+	SessionForbidden   = "forbidden"
+	SessionRoleChanged = "role_changed"
+	SessionUserDeleted = "user_deleted"
+)
+
+// AuthTokens is returned by the login and refresh endpoints. ExpiresIn is the
+// lifetime of Token in seconds.
+type AuthTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// RefreshRequest is the body of POST /api/auth/refresh and /api/auth/logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
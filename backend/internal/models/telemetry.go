@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// TelemetryPoint is one recorded value of a Table d'Echange key
+type TelemetryPoint struct {
+	Time  time.Time `db:"recorded_at" json:"t"`
+	Value string    `db:"value" json:"v"`
+}
+
+// TelemetrySeries holds the history of one key, oldest first
+type TelemetrySeries struct {
+	Key    int              `json:"key"`
+	Points []TelemetryPoint `json:"points"`
+}
+
+// TelemetryQuery selects samples of one machine. An empty Keys list means all keys.
+// A non-zero Interval keeps only the last sample of each interval bucket.
+type TelemetryQuery struct {
+	MachineID int
+	Keys      []int
+	From      time.Time
+	To        time.Time
+	Interval  time.Duration
+}
+
+// TelemetryResponse is returned by GET /api/admin/machines/{id}/telemetry
+type TelemetryResponse struct {
+	MachineID int               `json:"machine_id"`
+	NoSerie   string            `json:"no_serie"`
+	From      time.Time         `json:"from"`
+	To        time.Time         `json:"to"`
+	Series    []TelemetrySeries `json:"series"`
+	Truncated bool              `json:"truncated"` // Cut at the point cap: narrow the keys or the window
+}
+
+// TelemetryRetention controls how long samples are kept.
+// Samples older than RawRetention are thinned to one per DownsampleInterval,
+// samples older than Retention are deleted.
+type TelemetryRetention struct {
+	Retention          time.Duration
+	RawRetention       time.Duration
+	DownsampleInterval time.Duration
+}
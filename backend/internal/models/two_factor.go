@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+// TwoFactor is the TOTP enrolment of a user. Until EnabledAt is set, the
+// secret awaits the confirmation code of POST /api/auth/2fa/confirm.
+type TwoFactor struct {
+	UserID      int        `db:"user_id" json:"-"`
+	Secret      string     `db:"secret" json:"-"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	EnabledAt   *time.Time `db:"enabled_at" json:"enabled_at,omitempty"`
+	LastCounter int64      `db:"last_counter" json:"-"` // Time step of the last accepted code, against replays
+}
+
+// Enabled tells whether logins of the user require a code
+func (t *TwoFactor) Enabled() bool {
+	return t != nil && t.EnabledAt != nil
+}
+
+// TWO_FACTOR_POLICY
+const (
+	TwoFactorOptional           = "optional"
+	TwoFactorRequiredPrivileged = "required_privileged" // admin_global, admin_local and support
+)
+
+// RecoveryCodeCount is the number of recovery codes issued at a time
+const RecoveryCodeCount = 10
+
+// SessionTwoFactorReset is the Session.RevokedReason of an admin 2FA reset
+const SessionTwoFactorReset = "two_factor_reset"
+
+// TwoFactorStatus is the answer of GET /api/auth/2fa
+type TwoFactorStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	Required          bool       `json:"required"` // By the policy, for the role of the user
+	SessionVerified   bool       `json:"session_verified"`
+}
+
+// TwoFactorSetup is the answer of POST /api/auth/2fa/setup
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// provisioning URI, for the QR code
+}
+
+// TwoFactorRequest proves the second factor: a TOTP code or a recovery code
+type TwoFactorRequest struct {
+	Challenge    string `json:"challenge,omitempty"` // POST /api/auth/login/2fa only
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// TwoFactorChallenge is the answer of a login with a correct password when
+// 2FA is enabled: the challenge is sent back with the code
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	Challenge         string `json:"challenge"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+// IsTwoFactorRequired tells whether policy (TWO_FACTOR_POLICY) requires 2FA
+// for role
+func IsTwoFactorRequired(policy, role string) bool {
+	return policy == TwoFactorRequiredPrivileged &&
+		(role == RoleAdminGlobal || role == RoleAdminLocal || role == RoleSupport)
+}
@@ -33,6 +33,8 @@ type User struct {
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 	LastLogin    time.Time  `db:"last_login" json:"last_login"`
 	ForbiddenAt  *time.Time `db:"forbidden_at" json:"forbidden_at,omitempty"`
+	VerifiedAt   *time.Time `db:"verified_at" json:"verified_at,omitempty"` // E-mail address proven, nil until then
+	LockedUntil  *time.Time `db:"-" json:"locked_until,omitempty"` // Login lockout, admin user list only
     
     // Linked Devices
     LinkedMachineID *int    `db:"linked_machine_id" json:"linked_machine_id"`
@@ -62,6 +64,7 @@ type UserResponse struct {
 	FirstName string    `json:"first_name"`
 	LastName  string    `json:"last_name"`
 	Provider  string    `json:"provider"`
+	EmailVerified bool  `json:"email_verified"`
     
     // Linked Devices
     LinkedMachineID *int    `json:"linked_machine_id"`
@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 Appendix B, "12345678901234567890"
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// The vectors of RFC 6238 Appendix B (SHA1), truncated to Digits: the 8-digit
+// codes of the RFC end with the 6-digit ones
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, v := range rfcVectors {
+		want := v.code[len(v.code)-Digits:]
+		got, err := Code(rfcSecret, Counter(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", v.unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Counter(now))
+	previous, _ := Code(rfcSecret, Counter(now)-1)
+	stale, _ := Code(rfcSecret, Counter(now)-2)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+		step   int64
+	}{
+		{"current step", rfcSecret, code, true, Counter(now)},
+		{"spaces typed by the user", rfcSecret, code[:3] + " " + code[3:], true, Counter(now)},
+		{"lower-case secret", strings.ToLower(rfcSecret), code, true, Counter(now)},
+		{"previous step within skew", rfcSecret, previous, true, Counter(now) - 1},
+		{"beyond skew", rfcSecret, stale, false, 0},
+		{"wrong length", rfcSecret, code[:5], false, 0},
+		{"invalid secret", "not base32!", code, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now, 1)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("Validate() = %d, %v, want %d, %v", step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, b := GenerateSecret(), GenerateSecret()
+	if a == b {
+		t.Error("two secrets are equal")
+	}
+	if _, err := Code(a, 1); err != nil {
+		t.Errorf("generated secret is not usable: %v", err)
+	}
+}
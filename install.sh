@@ -91,6 +91,7 @@ DB_PASSWORD=essensys_secure_password
 DB_NAME=essensys_db
 JWT_SECRET=changeme_random_secret
 ADMIN_TOKEN=admin-token-secret
+METRICS_TOKEN=changeme
 FRONTEND_URL=https://mon.essensys.fr/
 PORT=8080
 EOF
@@ -0,0 +1,127 @@
+// Session tokens of the logged-in user.
+// The access token ('adminToken') is short-lived: when the API answers 401,
+// fetch exchanges the refresh token ('adminRefreshToken') for new tokens and
+// retries the request once. Tokens live in localStorage, sessionStorage or
+// both, as written by Login.jsx and Admin.jsx.
+
+const TOKEN_KEY = 'adminToken';
+const ROLE_KEY = 'adminRole';
+const REFRESH_KEY = 'adminRefreshToken';
+
+const stores = () => [localStorage, sessionStorage];
+
+export function getAccessToken() {
+  return sessionStorage.getItem(TOKEN_KEY) || localStorage.getItem(TOKEN_KEY);
+}
+
+function getRefreshToken() {
+  return sessionStorage.getItem(REFRESH_KEY) || localStorage.getItem(REFRESH_KEY);
+}
+
+export function storeAuth(storage, token, role, refreshToken) {
+  storage.setItem(TOKEN_KEY, token);
+  if (role) storage.setItem(ROLE_KEY, role);
+  if (refreshToken) storage.setItem(REFRESH_KEY, refreshToken);
+  else storage.removeItem(REFRESH_KEY);
+}
+
+// Replaces the tokens where they are stored, after a refresh
+function updateTokens(token, refreshToken) {
+  stores().forEach((s) => {
+    if (s.getItem(TOKEN_KEY) !== null) {
+      s.setItem(TOKEN_KEY, token);
+      s.setItem(REFRESH_KEY, refreshToken);
+    }
+  });
+}
+
+export function clearAuth() {
+  stores().forEach((s) => {
+    s.removeItem(TOKEN_KEY);
+    s.removeItem(ROLE_KEY);
+    s.removeItem(REFRESH_KEY);
+  });
+  window.dispatchEvent(new Event('auth-change'));
+}
+
+const nativeFetch = window.fetch.bind(window);
+let refreshing = null; // Shared by the requests failing together
+
+function refreshTokens() {
+  if (!refreshing) {
+    const refreshToken = getRefreshToken();
+    refreshing = (async () => {
+      if (!refreshToken) return null;
+      try {
+        const res = await nativeFetch('/api/auth/refresh', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ refresh_token: refreshToken }),
+        });
+        if (res.ok) {
+          const data = await res.json();
+          updateTokens(data.token, data.refresh_token);
+          return data.token;
+        }
+        // Another tab may have rotated the token meanwhile
+        if (getRefreshToken() !== refreshToken) return getAccessToken();
+        if (res.status === 401 || res.status === 403) clearAuth();
+      } catch {
+        // Network error: keep the tokens, the next request retries
+      }
+      return null;
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
+const bearer = (request) => {
+  const header = request.headers.get('Authorization') || '';
+  return header.startsWith('Bearer ') ? header.slice(7) : '';
+};
+
+const skipRefresh = (url) => /\/api\/auth\/(login|refresh|logout)$/.test(new URL(url, window.location.href).pathname);
+
+// installAuthFetch makes fetch refresh the access token of /api requests
+// on 401. Components keep passing the token they hold: the retry uses the
+// current one.
+export function installAuthFetch() {
+  window.fetch = async (input, init) => {
+    const request = new Request(input, init);
+    const token = bearer(request);
+    if (!token || !getRefreshToken() || skipRefresh(request.url)) {
+      return nativeFetch(request);
+    }
+    const retry = request.clone();
+    const res = await nativeFetch(request);
+    if (res.status !== 401) return res;
+
+    const current = getAccessToken();
+    const next = current && current !== token ? current : await refreshTokens();
+    if (!next) return res;
+    const headers = new Headers(retry.headers);
+    headers.set('Authorization', `Bearer ${next}`);
+    return nativeFetch(new Request(retry, { headers }));
+  };
+}
+
+// logout revokes the session on the server, then drops the tokens
+export async function logout() {
+  const token = getAccessToken();
+  const refreshToken = getRefreshToken();
+  try {
+    await nativeFetch('/api/auth/logout', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        ...(token ? { Authorization: `Bearer ${token}` } : {}),
+      },
+      body: JSON.stringify({ refresh_token: refreshToken || '' }),
+    });
+  } catch {
+    // Offline: the session expires by itself
+  }
+  clearAuth();
+}
@@ -1,6 +1,7 @@
 import React from 'react';
 import { Link, Outlet } from 'react-router-dom';
 import './Layout.css';
+import { logout } from '../auth.js';
 import logo from '../assets/logosml.png';
 
 const Layout = () => {
@@ -81,12 +82,8 @@ const Layout = () => {
                                 <Link to="/admin" className="nav-btn-login">Dashboard</Link>
                             )}
                             <button
-                                onClick={() => {
-                                    localStorage.removeItem('adminToken');
-                                    localStorage.removeItem('adminRole');
-                                    sessionStorage.removeItem('adminToken');
-                                    sessionStorage.removeItem('adminRole');
-                                    window.dispatchEvent(new Event('auth-change'));
+                                onClick={async () => {
+                                    await logout();
                                     window.location.reload();
                                 }}
                                 className="nav-btn-logout"
@@ -6,6 +6,7 @@ import UserManager from './UserManager';
 import LinkRequestsPanel from './LinkRequestsPanel';
 import SyncCloud from './SyncCloud';
 import Catalog from './Catalog';
+import { logout, storeAuth } from '../auth.js';
 import './Catalog.css';
 import { MapContainer, TileLayer, Marker, Popup } from 'react-leaflet';
 import 'leaflet/dist/leaflet.css';
@@ -57,6 +58,7 @@ const Admin = () => {
         const queryParams = new URLSearchParams(window.location.search);
         const urlToken = hashParams.get('token') || queryParams.get('token');
         const urlRole = hashParams.get('role') || queryParams.get('role');
+        const urlRefreshToken = hashParams.get('refresh_token');
 
         if (urlToken) {
             // Social Login defaults to LocalStorage currently in handlers, but we can default frontend to session if preferable.
@@ -65,8 +67,7 @@ const Admin = () => {
             // Let's assume Social Login implies "Remember Me" for now or use session.
             // The handlers currently redirect with token in query param.
             // We can default to sessionStorage for safety.
-            sessionStorage.setItem('adminToken', urlToken);
-            if (urlRole) sessionStorage.setItem('adminRole', urlRole);
+            storeAuth(sessionStorage, urlToken, urlRole, urlRefreshToken);
 
             setToken(urlToken);
             // Clear URL
@@ -106,6 +107,11 @@ const Admin = () => {
                     window.location.href = data.redirect;
                     return;
                 }
+                // TWO_FACTOR_POLICY: enrol (or log in again with the code) from the profile
+                if (data.error === 'two_factor_required') {
+                    window.location.href = '/profile';
+                    return;
+                }
             }
             if (res.ok) {
                 const data = await res.json();
@@ -193,12 +199,8 @@ const Admin = () => {
 
 
 
-    const handleLogout = () => {
-        localStorage.removeItem('adminToken');
-        localStorage.removeItem('adminRole');
-        sessionStorage.removeItem('adminToken');
-        sessionStorage.removeItem('adminRole');
-        window.dispatchEvent(new Event('auth-change'));
+    const handleLogout = async () => {
+        await logout();
         setToken('');
         setIsAuthenticated(false);
         setStats(null);
@@ -398,9 +400,9 @@ const Admin = () => {
                                             <thead>
                                                 <tr>
                                                     <th>Machine ID</th>
-                                                    <th>User / Pass</th>
+                                                    <th>Empreinte</th>
                                                     <th>IP / Location</th>
-                                                    <th>Raw Auth (Base64)</th>
+                                                    <th>Dernière auth</th>
                                                     <th>Last Seen</th>
                                                 </tr>
                                             </thead>
@@ -408,14 +410,12 @@ const Admin = () => {
                                                 {machines.map(m => (
                                                     <tr key={m.id}>
                                                         <td>{m.no_serie}</td>
-                                                        <td className="mono">{m.raw_decoded || '-'}</td>
+                                                        <td className="mono">{m.credential_fingerprint || '-'}</td>
                                                         <td>
                                                             <div>{m.ip || '-'}</div>
                                                             <div style={{ fontSize: '0.8em', color: '#6b7280' }}>{m.geo_location || ''}</div>
                                                         </td>
-                                                        <td className="mono" style={{ fontSize: '0.8em', maxWidth: '150px', overflow: 'hidden', textOverflow: 'ellipsis' }}>
-                                                            {m.raw_auth || '-'}
-                                                        </td>
+                                                        <td>{m.last_auth_outcome || '-'}</td>
                                                         <td>{m.last_seen ? new Date(m.last_seen).toLocaleString() : '-'}</td>
                                                     </tr>
                                                 ))}
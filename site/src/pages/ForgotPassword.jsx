@@ -0,0 +1,80 @@
+import React, { useState } from 'react';
+import { Link } from 'react-router-dom';
+import './Auth.css';
+
+const ForgotPassword = () => {
+    const [email, setEmail] = useState('');
+    const [error, setError] = useState('');
+    const [sent, setSent] = useState(false);
+    const [loading, setLoading] = useState(false);
+
+    const handleSubmit = async (e) => {
+        e.preventDefault();
+        setError('');
+        setLoading(true);
+
+        try {
+            const res = await fetch('/api/auth/password/forgot', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ email }),
+            });
+            if (res.ok) {
+                setSent(true);
+            } else if (res.status === 429) {
+                setError('Trop de demandes. Réessayez plus tard.');
+            } else {
+                setError((await res.text()) || 'Demande impossible');
+            }
+        } catch (err) {
+            setError('Connection error. Please try again.');
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    return (
+        <div className="auth-container">
+            <div className="auth-card" style={{ maxWidth: '480px' }}>
+                <div className="auth-header">
+                    <h2>Mot de passe oublié</h2>
+                    <p>Recevez un lien pour choisir un nouveau mot de passe</p>
+                </div>
+
+                {error && <div className="error-msg">{error}</div>}
+
+                {sent ? (
+                    <p>
+                        Si un compte existe pour <strong>{email}</strong>, un email contenant un lien de
+                        réinitialisation vient de lui être envoyé. Le lien est valable une heure.
+                    </p>
+                ) : (
+                    <form className="auth-form" onSubmit={handleSubmit}>
+                        <div className="form-group">
+                            <label>Email</label>
+                            <input
+                                type="email"
+                                className="auth-input"
+                                placeholder="exemple@email.com"
+                                value={email}
+                                onChange={(e) => setEmail(e.target.value)}
+                                required
+                                autoComplete="username"
+                            />
+                        </div>
+
+                        <button type="submit" className="auth-btn btn-primary" disabled={loading}>
+                            {loading ? 'Envoi...' : 'Envoyer le lien'}
+                        </button>
+                    </form>
+                )}
+
+                <div className="auth-footer">
+                    <Link to="/login" className="auth-link">Retour à la connexion</Link>
+                </div>
+            </div>
+        </div>
+    );
+};
+
+export default ForgotPassword;
@@ -1,17 +1,16 @@
 import React, { useState } from 'react';
 import { useNavigate, Link, useSearchParams } from 'react-router-dom';
 import './Auth.css';
+import { storeAuth } from '../auth.js';
 import logo from '../assets/logosml.png';
 import fondImage from '../assets/fond-inprogress.png';
 
 /** OAuth cloud — désactivé temporairement (réactiver quand les providers sont prêts). */
 const OAUTH_PROVIDERS_ENABLED = false;
 
-const persistAuth = (token, role) => {
-    localStorage.setItem('adminToken', token);
-    localStorage.setItem('adminRole', role);
-    sessionStorage.setItem('adminToken', token);
-    sessionStorage.setItem('adminRole', role);
+const persistAuth = (token, role, refreshToken) => {
+    storeAuth(localStorage, token, role, refreshToken);
+    storeAuth(sessionStorage, token, role, refreshToken);
 };
 
 const Login = () => {
@@ -23,6 +22,23 @@ const Login = () => {
     const [error, setError] = useState('');
     const [loading, setLoading] = useState(false);
     const [rememberMe, setRememberMe] = useState(false);
+    // Second step when 2FA is enabled; Google/Apple send it in the fragment
+    const [challenge, setChallenge] = useState(
+        () => new URLSearchParams(window.location.hash.slice(1)).get('challenge') || ''
+    );
+    const [code, setCode] = useState('');
+    const [useRecovery, setUseRecovery] = useState(false);
+
+    const completeLogin = (data) => {
+        persistAuth(data.token, data.user.role, data.refresh_token);
+        window.dispatchEvent(new Event('auth-change'));
+
+        if (returnTo.startsWith('/')) {
+            window.location.href = returnTo;
+        } else {
+            navigate(returnTo);
+        }
+    };
 
     const handleSubmit = async (e) => {
         e.preventDefault();
@@ -38,17 +54,15 @@ const Login = () => {
 
             const data = await res.json().catch(() => ({}));
 
-            if (res.ok) {
-                persistAuth(data.token, data.user.role);
-                window.dispatchEvent(new Event('auth-change'));
-
-                if (returnTo.startsWith('/')) {
-                    window.location.href = returnTo;
-                } else {
-                    navigate(returnTo);
-                }
+            if (res.ok && data.two_factor_required) {
+                setChallenge(data.challenge);
+            } else if (res.ok) {
+                completeLogin(data);
             } else if (data.error === 'account_forbidden' && data.redirect) {
                 window.location.href = data.redirect;
+            } else if (data.error === 'too_many_attempts') {
+                const minutes = Math.ceil((data.retry_after || 60) / 60);
+                setError(`Trop de tentatives échouées. Réessayez dans ${minutes} minute${minutes > 1 ? 's' : ''} ou réinitialisez votre mot de passe.`);
             } else {
                 setError(data.message || data.error || 'Login failed');
             }
@@ -59,6 +73,96 @@ const Login = () => {
         }
     };
 
+    const handleTwoFactor = async (e) => {
+        e.preventDefault();
+        setError('');
+        setLoading(true);
+
+        try {
+            const res = await fetch('/api/auth/login/2fa', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(useRecovery ? { challenge, recovery_code: code } : { challenge, code }),
+            });
+            if (res.ok) {
+                window.history.replaceState(null, '', window.location.pathname + window.location.search);
+                completeLogin(await res.json());
+                return;
+            }
+            const text = await res.text().catch(() => '');
+            let data = {};
+            try { data = JSON.parse(text); } catch { /* plain text error */ }
+            if (data.error === 'account_forbidden' && data.redirect) {
+                window.location.href = data.redirect;
+            } else if (res.status === 429) {
+                setError('Trop de tentatives. Réessayez dans quelques minutes.');
+            } else if (text.includes('challenge')) {
+                // Expired (5 min) or 2FA reset meanwhile: back to the password
+                setChallenge('');
+                setCode('');
+                setError('La vérification a expiré, reconnectez-vous.');
+            } else {
+                setError('Code invalide.');
+            }
+        } catch (err) {
+            setError('Connection error. Please try again.');
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    if (challenge) {
+        return (
+            <div className="auth-container">
+                <div className="auth-card">
+                    <div className="auth-header">
+                        <img src={logo} alt="mon Essensys" className="auth-card-logo" />
+                        <h1>Double authentification</h1>
+                        <p>
+                            {useRecovery
+                                ? 'Saisissez un de vos codes de secours.'
+                                : 'Saisissez le code à 6 chiffres de votre application d\u2019authentification.'}
+                        </p>
+                    </div>
+
+                    {error && <div className="error-msg">{error}</div>}
+
+                    <form className="auth-form" onSubmit={handleTwoFactor}>
+                        <div className="form-group">
+                            <label htmlFor="login-code">{useRecovery ? 'Code de secours' : 'Code'}</label>
+                            <input
+                                id="login-code"
+                                type="text"
+                                className="auth-input"
+                                inputMode={useRecovery ? 'text' : 'numeric'}
+                                autoComplete="one-time-code"
+                                placeholder={useRecovery ? 'xxxxx-xxxxx' : '123456'}
+                                value={code}
+                                onChange={(e) => setCode(e.target.value)}
+                                required
+                                autoFocus
+                            />
+                        </div>
+                        <button type="submit" className="auth-btn btn-primary" disabled={loading}>
+                            {loading ? 'Vérification...' : 'Valider'}
+                        </button>
+                    </form>
+
+                    <div className="auth-footer">
+                        <button
+                            type="button"
+                            className="auth-link"
+                            style={{ background: 'none', border: 'none', cursor: 'pointer' }}
+                            onClick={() => { setUseRecovery(!useRecovery); setCode(''); setError(''); }}
+                        >
+                            {useRecovery ? 'Utiliser un code de l\u2019application' : 'Utiliser un code de secours'}
+                        </button>
+                    </div>
+                </div>
+            </div>
+        );
+    }
+
     return (
         <div
             className="auth-login-root auth-login-aurora"
@@ -104,6 +208,10 @@ const Login = () => {
                                 />
                             </div>
 
+                            <div className="auth-footer" style={{ marginTop: 0, textAlign: 'right' }}>
+                                <Link to="/forgot-password" className="auth-link">Mot de passe oublié ?</Link>
+                            </div>
+
                             <label className="auth-remember" htmlFor="rememberMe">
                                 <input
                                     type="checkbox"
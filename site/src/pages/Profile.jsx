@@ -1,5 +1,6 @@
 import React, { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
+import { clearAuth } from '../auth.js';
 import './Auth.css'; // Reuse Auth styles for consistency
 
 const Profile = () => {
@@ -12,6 +13,10 @@ const Profile = () => {
     const [logs, setLogs] = useState([]); // User's own logs
     const [message, setMessage] = useState('');
     const [error, setError] = useState('');
+    const [twoFactor, setTwoFactor] = useState(null); // GET /api/auth/2fa
+    const [tfSetup, setTfSetup] = useState(null); // Secret being enrolled
+    const [tfCode, setTfCode] = useState('');
+    const [recoveryCodes, setRecoveryCodes] = useState([]); // Shown once
 
     useEffect(() => {
         if (!token) {
@@ -21,6 +26,7 @@ const Profile = () => {
         fetchProfile();
         fetchNearby();
         fetchMyLogs();
+        fetchTwoFactor();
     }, [token, navigate]);
 
     const fetchProfile = async () => {
@@ -41,6 +47,111 @@ const Profile = () => {
         }
     };
 
+    const resendVerification = async () => {
+        try {
+            const res = await fetch('/api/auth/verify-email/resend', {
+                method: 'POST',
+                headers: { 'Authorization': `Bearer ${token}` }
+            });
+            if (res.ok) {
+                setMessage(`Lien de vérification envoyé à ${profile.email}`);
+            } else if (res.status === 429) {
+                setError('Trop de demandes. Réessayez plus tard.');
+            } else {
+                setError('Échec envoi du lien');
+            }
+        } catch (err) {
+            setError('Erreur réseau');
+        }
+    };
+
+    const fetchTwoFactor = async () => {
+        try {
+            const res = await fetch('/api/auth/2fa', {
+                headers: { 'Authorization': `Bearer ${token}` }
+            });
+            if (res.ok) {
+                setTwoFactor(await res.json());
+            }
+        } catch (err) {
+            console.error("Failed to fetch 2FA status");
+        }
+    };
+
+    // POST /api/auth/2fa/{action} with the code typed in the 2FA section
+    const postTwoFactor = async (action, body) => {
+        setMessage('');
+        setError('');
+        const res = await fetch(`/api/auth/2fa/${action}`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'Authorization': `Bearer ${token}`
+            },
+            body: JSON.stringify(body || {})
+        });
+        if (res.status === 429) {
+            setError('Trop de tentatives. Réessayez dans quelques minutes.');
+        } else if (res.status === 400) {
+            setError('Code invalide.');
+        } else if (!res.ok) {
+            setError(`Erreur: ${await res.text()}`);
+        }
+        return res;
+    };
+
+    // Codes of the app, or a recovery code (xxxxx-xxxxx)
+    const codeBody = () => (tfCode.includes('-') ? { recovery_code: tfCode } : { code: tfCode });
+
+    const handleTwoFactorSetup = async () => {
+        try {
+            const res = await postTwoFactor('setup');
+            if (res.ok) {
+                setTfSetup(await res.json());
+                setTfCode('');
+            }
+        } catch (err) { setError('Erreur réseau'); }
+    };
+
+    const handleTwoFactorConfirm = async () => {
+        try {
+            const res = await postTwoFactor('confirm', { code: tfCode });
+            if (res.ok) {
+                const data = await res.json();
+                setRecoveryCodes(data.recovery_codes);
+                setTfSetup(null);
+                setTfCode('');
+                setMessage('Double authentification activée.');
+                fetchTwoFactor();
+            }
+        } catch (err) { setError('Erreur réseau'); }
+    };
+
+    const handleRecoveryCodes = async () => {
+        try {
+            const res = await postTwoFactor('recovery-codes', codeBody());
+            if (res.ok) {
+                const data = await res.json();
+                setRecoveryCodes(data.recovery_codes);
+                setTfCode('');
+                fetchTwoFactor();
+            }
+        } catch (err) { setError('Erreur réseau'); }
+    };
+
+    const handleTwoFactorDisable = async () => {
+        if (!window.confirm("Désactiver la double authentification ?")) return;
+        try {
+            const res = await postTwoFactor('disable', codeBody());
+            if (res.ok) {
+                setRecoveryCodes([]);
+                setTfCode('');
+                setMessage('Double authentification désactivée.');
+                fetchTwoFactor();
+            }
+        } catch (err) { setError('Erreur réseau'); }
+    };
+
     const fetchNearby = async () => {
         try {
             const res = await fetch('/api/devices/nearby', {
@@ -114,6 +225,14 @@ const Profile = () => {
                         <h4 style={{ marginTop: 0 }}>Informations</h4>
                         <p><strong>Nom:</strong> {profile.first_name} {profile.last_name}</p>
                         <p><strong>Email:</strong> {profile.email}</p>
+                        {!profile.email_verified && (
+                            <p className="error-message">
+                                Adresse email non vérifiée : l&apos;association d&apos;appareils et l&apos;administration sont désactivées.{' '}
+                                <button type="button" className="auth-link" onClick={resendVerification}>
+                                    Renvoyer le lien
+                                </button>
+                            </p>
+                        )}
                         <p><strong>Rôle:</strong> {profile.role}</p>
                     </div>
                 </div>
@@ -228,6 +347,93 @@ const Profile = () => {
 
                 <hr style={{ borderColor: 'rgba(255,255,255,0.1)', margin: '20px 0' }} />
 
+                <h3 style={{ textAlign: 'left' }}>Double authentification</h3>
+                {twoFactor && (
+                    <div style={{ textAlign: 'left', marginBottom: '20px' }}>
+                        {twoFactor.required && !twoFactor.enabled && (
+                            <div className="error-msg">
+                                La double authentification est obligatoire pour votre rôle : activez-la pour accéder à l&apos;administration.
+                            </div>
+                        )}
+                        {twoFactor.required && twoFactor.enabled && !twoFactor.session_verified && (
+                            <div className="error-msg">
+                                Cette session a été ouverte sans code : reconnectez-vous pour accéder à l&apos;administration.
+                            </div>
+                        )}
+
+                        {recoveryCodes.length > 0 && (
+                            <div style={{ background: '#222', padding: '15px', borderRadius: '4px', marginBottom: '15px' }}>
+                                <p>Codes de secours, à conserver en lieu sûr : chacun remplace une fois un code de l&apos;application. Ils ne seront plus affichés.</p>
+                                <pre style={{ columns: 2, fontFamily: 'monospace' }}>{recoveryCodes.join('\n')}</pre>
+                            </div>
+                        )}
+
+                        {!twoFactor.enabled && !tfSetup && (
+                            <>
+                                <p>Protégez votre compte avec un code à 6 chiffres d&apos;une application d&apos;authentification (Google Authenticator, Authy…) en plus du mot de passe.</p>
+                                <button onClick={handleTwoFactorSetup} className="auth-btn" style={{ background: '#555' }}>
+                                    Activer la double authentification
+                                </button>
+                            </>
+                        )}
+
+                        {!twoFactor.enabled && tfSetup && (
+                            <>
+                                <p>
+                                    Ajoutez ce compte dans votre application : <a href={tfSetup.uri} className="auth-link">ouvrir dans l&apos;application</a>, ou saisissez la clé :
+                                </p>
+                                <pre style={{ fontFamily: 'monospace', wordBreak: 'break-all', whiteSpace: 'pre-wrap' }}>{tfSetup.secret}</pre>
+                                <div className="form-group">
+                                    <label htmlFor="tf-code">Code affiché par l&apos;application</label>
+                                    <input
+                                        id="tf-code"
+                                        type="text"
+                                        inputMode="numeric"
+                                        autoComplete="one-time-code"
+                                        value={tfCode}
+                                        onChange={(e) => setTfCode(e.target.value)}
+                                        style={{ width: '100%', padding: '10px', background: '#333', border: '1px solid #555', color: 'white', borderRadius: '4px' }}
+                                    />
+                                </div>
+                                <button onClick={handleTwoFactorConfirm} className="auth-btn" disabled={!tfCode}>
+                                    Confirmer
+                                </button>
+                            </>
+                        )}
+
+                        {twoFactor.enabled && (
+                            <>
+                                <p>
+                                    Activée le {new Date(twoFactor.enabled_at).toLocaleDateString()} — {twoFactor.recovery_codes_left} code(s) de secours restant(s).
+                                </p>
+                                <div className="form-group">
+                                    <label htmlFor="tf-code">Code de l&apos;application ou code de secours</label>
+                                    <input
+                                        id="tf-code"
+                                        type="text"
+                                        autoComplete="one-time-code"
+                                        value={tfCode}
+                                        onChange={(e) => setTfCode(e.target.value)}
+                                        style={{ width: '100%', padding: '10px', background: '#333', border: '1px solid #555', color: 'white', borderRadius: '4px' }}
+                                    />
+                                </div>
+                                <div style={{ display: 'flex', gap: '10px', flexWrap: 'wrap' }}>
+                                    <button onClick={handleRecoveryCodes} className="auth-btn" style={{ background: '#555', flex: 1 }} disabled={!tfCode}>
+                                        Nouveaux codes de secours
+                                    </button>
+                                    {!twoFactor.required && (
+                                        <button onClick={handleTwoFactorDisable} className="auth-btn" style={{ background: '#990000', flex: 1 }} disabled={!tfCode}>
+                                            Désactiver
+                                        </button>
+                                    )}
+                                </div>
+                            </>
+                        )}
+                    </div>
+                )}
+
+                <hr style={{ borderColor: 'rgba(255,255,255,0.1)', margin: '20px 0' }} />
+
                 <h3 style={{ textAlign: 'left' }}>Gestion des données (RGPD)</h3>
                 <div style={{ display: 'flex', gap: '10px', flexWrap: 'wrap' }}>
                     <button
@@ -268,7 +474,7 @@ const Profile = () => {
                                     });
                                     if (res.ok) {
                                         alert("Compte supprimé.");
-                                        localStorage.removeItem('adminToken');
+                                        clearAuth();
                                         navigate('/');
                                     } else {
                                         setError('Erreur suppression');
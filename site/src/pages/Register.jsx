@@ -42,7 +42,8 @@ const Register = () => {
             });
 
             if (res.ok) {
-                // Success
+                // Success: the account is restricted until the e-mail is verified
+                alert('Compte créé. Confirmez votre adresse avec le lien reçu par email.');
                 navigate('/login');
             } else {
                 const data = await res.json(); // Usually backend returns generic text or json
@@ -0,0 +1,98 @@
+import React, { useState } from 'react';
+import { useNavigate, Link } from 'react-router-dom';
+import './Auth.css';
+
+const ResetPassword = () => {
+    const navigate = useNavigate();
+    // Token is delivered in the fragment (#token=...) so it is not sent to the
+    // server, logged, or leaked via Referer
+    const [token] = useState(() => new URLSearchParams(window.location.hash.replace(/^#/, '')).get('token') || '');
+    const [password, setPassword] = useState('');
+    const [confirmPassword, setConfirmPassword] = useState('');
+    const [error, setError] = useState('');
+    const [loading, setLoading] = useState(false);
+
+    const handleSubmit = async (e) => {
+        e.preventDefault();
+        setError('');
+
+        if (password !== confirmPassword) {
+            setError("Les mots de passe ne correspondent pas");
+            return;
+        }
+
+        setLoading(true);
+        try {
+            const res = await fetch('/api/auth/password/reset', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ token, password }),
+            });
+            if (res.ok) {
+                window.history.replaceState({}, document.title, window.location.pathname);
+                navigate('/login');
+            } else if (res.status === 429) {
+                setError('Trop de tentatives. Réessayez plus tard.');
+            } else {
+                setError((await res.text()) || 'Réinitialisation impossible');
+            }
+        } catch (err) {
+            setError('Connection error. Please try again.');
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    return (
+        <div className="auth-container">
+            <div className="auth-card" style={{ maxWidth: '480px' }}>
+                <div className="auth-header">
+                    <h2>Nouveau mot de passe</h2>
+                    <p>Toutes vos sessions ouvertes seront déconnectées</p>
+                </div>
+
+                {!token && <div className="error-msg">Lien invalide : demandez un nouveau lien.</div>}
+                {error && <div className="error-msg">{error}</div>}
+
+                <form className="auth-form" onSubmit={handleSubmit}>
+                    <div className="form-group">
+                        <label>Mot de passe</label>
+                        <input
+                            type="password"
+                            className="auth-input"
+                            placeholder="Minimum 8 caractères"
+                            value={password}
+                            onChange={(e) => setPassword(e.target.value)}
+                            minLength={8}
+                            required
+                            autoComplete="new-password"
+                        />
+                    </div>
+
+                    <div className="form-group">
+                        <label>Confirmer le mot de passe</label>
+                        <input
+                            type="password"
+                            className="auth-input"
+                            placeholder="Répétez le mot de passe"
+                            value={confirmPassword}
+                            onChange={(e) => setConfirmPassword(e.target.value)}
+                            required
+                            autoComplete="new-password"
+                        />
+                    </div>
+
+                    <button type="submit" className="auth-btn btn-primary" disabled={loading || !token}>
+                        {loading ? 'Enregistrement...' : 'Changer le mot de passe'}
+                    </button>
+                </form>
+
+                <div className="auth-footer">
+                    <Link to="/forgot-password" className="auth-link">Demander un nouveau lien</Link>
+                </div>
+            </div>
+        </div>
+    );
+};
+
+export default ResetPassword;
@@ -370,6 +370,78 @@ const UserManager = ({ token }) => {
         }
     };
 
+    const handleResendVerification = async (user) => {
+        try {
+            const res = await fetch(`/api/admin/users/${user.id}/verification`, {
+                method: 'POST',
+                headers: { Authorization: `Bearer ${token}` },
+            });
+            if (res.ok) {
+                alert(`Lien de vérification envoyé à ${user.email}`);
+            } else {
+                alert(await res.text() || 'Échec envoi du lien');
+            }
+        } catch {
+            alert('Erreur réseau');
+        }
+    };
+
+    const handleVerifyUser = async (user) => {
+        if (!window.confirm(`Marquer l'email de ${user.email} comme vérifié sans le lien ?`)) {
+            return;
+        }
+        try {
+            const res = await fetch(`/api/admin/users/${user.id}/verify`, {
+                method: 'POST',
+                headers: { Authorization: `Bearer ${token}` },
+            });
+            if (res.ok || res.status === 204) {
+                fetchUsers();
+            } else {
+                alert(await res.text() || 'Échec vérification');
+            }
+        } catch {
+            alert('Erreur réseau');
+        }
+    };
+
+    const handleResetTwoFactor = async (user) => {
+        if (!window.confirm(`Réinitialiser la double authentification de ${user.email} ? Ses sessions seront fermées.`)) {
+            return;
+        }
+        try {
+            const res = await fetch(`/api/admin/users/${user.id}/2fa/reset`, {
+                method: 'POST',
+                headers: { Authorization: `Bearer ${token}` },
+            });
+            if (res.ok || res.status === 204) {
+                alert(`Double authentification de ${user.email} réinitialisée`);
+            } else if (res.status === 409) {
+                alert(`${user.email} n'a pas activé la double authentification`);
+            } else {
+                alert(await res.text() || 'Échec réinitialisation');
+            }
+        } catch {
+            alert('Erreur réseau');
+        }
+    };
+
+    const handleUnlockUser = async (user) => {
+        try {
+            const res = await fetch(`/api/admin/users/${user.id}/unlock`, {
+                method: 'POST',
+                headers: { Authorization: `Bearer ${token}` },
+            });
+            if (res.ok || res.status === 204) {
+                fetchUsers();
+            } else {
+                alert(await res.text() || 'Échec déblocage');
+            }
+        } catch {
+            alert('Erreur réseau');
+        }
+    };
+
     const handleDeleteUser = async (user) => {
         const confirmEmail = window.prompt(`Supprimer définitivement ${user.email} ? Saisissez l'email pour confirmer :`);
         if (confirmEmail !== user.email) {
@@ -485,6 +557,18 @@ const UserManager = ({ token }) => {
                                             {u.forbidden_at && (
                                                 <span className="device-warning" style={{ marginLeft: '8px' }}>Interdit</span>
                                             )}
+                                            {!u.verified_at && (
+                                                <span className="device-warning" style={{ marginLeft: '8px' }}>Email non vérifié</span>
+                                            )}
+                                            {u.locked_until && (
+                                                <span
+                                                    className="device-warning"
+                                                    style={{ marginLeft: '8px' }}
+                                                    title={`Jusqu'au ${new Date(u.locked_until).toLocaleString()}`}
+                                                >
+                                                    Bloqué
+                                                </span>
+                                            )}
                                         </td>
                                         <td>{u.first_name} {u.last_name}</td>
                                         <td>
@@ -561,6 +645,26 @@ const UserManager = ({ token }) => {
                                             )}
                                             {canModerateUser(u, adminRole) && (
                                                 <>
+                                                    {!u.verified_at && !u.forbidden_at && (
+                                                        <>
+                                                            <button
+                                                                type="button"
+                                                                onClick={() => handleResendVerification(u)}
+                                                                className="catalog-button ghost"
+                                                                style={{ marginLeft: '8px' }}
+                                                            >
+                                                                Renvoyer vérification
+                                                            </button>
+                                                            <button
+                                                                type="button"
+                                                                onClick={() => handleVerifyUser(u)}
+                                                                className="catalog-button ghost"
+                                                                style={{ marginLeft: '8px' }}
+                                                            >
+                                                                Marquer vérifié
+                                                            </button>
+                                                        </>
+                                                    )}
                                                     {u.forbidden_at ? (
                                                         <button
                                                             type="button"
@@ -580,6 +684,24 @@ const UserManager = ({ token }) => {
                                                             Interdire
                                                         </button>
                                                     )}
+                                                    {u.locked_until && (
+                                                        <button
+                                                            type="button"
+                                                            onClick={() => handleUnlockUser(u)}
+                                                            className="catalog-button ghost"
+                                                            style={{ marginLeft: '8px' }}
+                                                        >
+                                                            Débloquer
+                                                        </button>
+                                                    )}
+                                                    <button
+                                                        type="button"
+                                                        onClick={() => handleResetTwoFactor(u)}
+                                                        className="catalog-button ghost"
+                                                        style={{ marginLeft: '8px' }}
+                                                    >
+                                                        Réinitialiser 2FA
+                                                    </button>
                                                     <button
                                                         type="button"
                                                         onClick={() => handleDeleteUser(u)}
@@ -0,0 +1,71 @@
+import React, { useEffect, useRef, useState } from 'react';
+import { Link } from 'react-router-dom';
+import './Auth.css';
+
+const VerifyEmail = () => {
+    const [status, setStatus] = useState('pending'); // pending | verified | error
+    const [error, setError] = useState('');
+    const started = useRef(false); // StrictMode runs effects twice
+
+    useEffect(() => {
+        if (started.current) return;
+        started.current = true;
+
+        // Token is delivered in the fragment (#token=...) so it is not sent to
+        // the server, logged, or leaked via Referer
+        const token = new URLSearchParams(window.location.hash.replace(/^#/, '')).get('token');
+        window.history.replaceState({}, document.title, window.location.pathname);
+        if (!token) {
+            setStatus('error');
+            setError('Lien invalide.');
+            return;
+        }
+
+        (async () => {
+            try {
+                const res = await fetch('/api/auth/verify-email', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ token }),
+                });
+                if (res.ok) {
+                    setStatus('verified');
+                } else {
+                    setStatus('error');
+                    setError((await res.text()) || 'Vérification impossible');
+                }
+            } catch (err) {
+                setStatus('error');
+                setError('Connection error. Please try again.');
+            }
+        })();
+    }, []);
+
+    return (
+        <div className="auth-container">
+            <div className="auth-card" style={{ maxWidth: '480px' }}>
+                <div className="auth-header">
+                    <h2>Vérification de l&apos;email</h2>
+                </div>
+
+                {status === 'pending' && <p>Vérification en cours...</p>}
+                {status === 'verified' && (
+                    <p>
+                        Votre adresse email est vérifiée. Reconnectez-vous pour accéder à toutes les fonctionnalités.
+                    </p>
+                )}
+                {status === 'error' && (
+                    <div className="error-msg">
+                        {error} Demandez un nouveau lien depuis votre profil.
+                    </div>
+                )}
+
+                <div className="auth-footer">
+                    <Link to="/login" className="auth-link">Se connecter</Link>
+                </div>
+            </div>
+        </div>
+    );
+};
+
+export default VerifyEmail;
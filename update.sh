@@ -20,6 +20,10 @@ sudo cp server /opt/essensys/backend/server
 sudo chmod +x /opt/essensys/backend/server
 sudo chown essensys:essensys /opt/essensys/backend/server
 cd ..
+# Table d'Echange catalog (CATALOG_PATH defaults to ../docs/TableReference.json)
+sudo mkdir -p /opt/essensys/docs
+sudo cp docs/TableReference.json /opt/essensys/docs/TableReference.json
+sudo chown -R essensys:essensys /opt/essensys/docs
 
 echo ">>> 3. Building Frontend..."
 cd site